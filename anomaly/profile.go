@@ -0,0 +1,279 @@
+// Package anomaly profiles inbound requests per client and endpoint (body size,
+// element count, header names) and flags requests that deviate from the baseline,
+// so a client release that changes its payloads stands out.
+package anomaly
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics an anomaly can be reported for.
+const (
+	MetricSize     = "size"
+	MetricElements = "elements"
+	MetricHeaders  = "headers"
+)
+
+// maxAnomalies bounds the list of recent anomalies.
+const maxAnomalies = 200
+
+// minRelativeDeviation keeps near-constant metrics (stddev ≈ 0) from flagging
+// trivial differences: a value must also differ from the mean by this fraction.
+const minRelativeDeviation = 0.1
+
+// Sample describes one request.
+type Sample struct {
+	Size     int
+	Elements int
+	Headers  string // pattern from HeaderPattern
+}
+
+// Anomaly is a request that deviated from its client's baseline.
+type Anomaly struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Endpoint  string    `json:"endpoint"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value,omitempty"`
+	Mean      float64   `json:"mean,omitempty"`
+	StdDev    float64   `json:"stdDev,omitempty"`
+	Detail    string    `json:"detail"`
+	RequestID string    `json:"requestId,omitempty"`
+}
+
+// Stat summarises one numeric metric.
+type Stat struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stdDev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// Profile is the baseline of one client on one endpoint.
+type Profile struct {
+	Client         string         `json:"client"`
+	Endpoint       string         `json:"endpoint"`
+	Requests       int            `json:"requests"`
+	Size           Stat           `json:"size"`
+	Elements       Stat           `json:"elements"`
+	HeaderPatterns map[string]int `json:"headerPatterns"`
+	Anomalies      int            `json:"anomalies"`
+}
+
+// running tracks mean and variance incrementally (Welford).
+type running struct {
+	n        int
+	mean, m2 float64
+	min, max float64
+}
+
+func (r *running) add(x float64) {
+	if r.n == 0 || x < r.min {
+		r.min = x
+	}
+	if r.n == 0 || x > r.max {
+		r.max = x
+	}
+	r.n++
+	delta := x - r.mean
+	r.mean += delta / float64(r.n)
+	r.m2 += delta * (x - r.mean)
+}
+
+func (r *running) stddev() float64 {
+	if r.n < 2 {
+		return 0
+	}
+	return math.Sqrt(r.m2 / float64(r.n-1))
+}
+
+func (r *running) stat() Stat {
+	return Stat{Mean: r.mean, StdDev: r.stddev(), Min: r.min, Max: r.max}
+}
+
+type key struct {
+	client   string
+	endpoint string
+}
+
+type profile struct {
+	requests  int
+	size      running
+	elements  running
+	headers   map[string]int
+	anomalies int
+}
+
+// Profiler keeps per-client baselines. It is safe for concurrent use.
+type Profiler struct {
+	warmup    int
+	threshold float64
+
+	mu        sync.Mutex
+	profiles  map[key]*profile
+	anomalies []Anomaly
+}
+
+// New returns a profiler that starts flagging after warmup requests per client and
+// endpoint, for values more than threshold standard deviations from the mean.
+func New(warmup int, threshold float64) *Profiler {
+	return &Profiler{warmup: warmup, threshold: threshold, profiles: make(map[key]*profile)}
+}
+
+// Observe adds a request to the client's baseline and returns the anomalies it shows.
+func (p *Profiler) Observe(client, endpoint, requestID string, s Sample) []Anomaly {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	k := key{client, endpoint}
+	prof, ok := p.profiles[k]
+	if !ok {
+		prof = &profile{headers: make(map[string]int)}
+		p.profiles[k] = prof
+	}
+
+	var found []Anomaly
+	if prof.requests >= p.warmup {
+		now := time.Now()
+		flag := func(metric string, r *running, value int) {
+			x, mean, sd := float64(value), r.mean, r.stddev()
+			if math.Abs(x-mean) <= p.threshold*sd || math.Abs(x-mean) <= minRelativeDeviation*mean {
+				return
+			}
+			found = append(found, Anomaly{
+				Time: now, Client: client, Endpoint: endpoint, Metric: metric,
+				Value: x, Mean: mean, StdDev: sd, RequestID: requestID,
+				Detail: fmt.Sprintf("%s %d against a mean of %.0f (stddev %.1f)", metric, value, mean, sd),
+			})
+		}
+		flag(MetricSize, &prof.size, s.Size)
+		flag(MetricElements, &prof.elements, s.Elements)
+		if _, seen := prof.headers[s.Headers]; !seen {
+			found = append(found, Anomaly{
+				Time: now, Client: client, Endpoint: endpoint, Metric: MetricHeaders, RequestID: requestID,
+				Detail: "new header set: " + headerDiff(mostCommon(prof.headers), s.Headers),
+			})
+		}
+	}
+
+	prof.requests++
+	prof.size.add(float64(s.Size))
+	prof.elements.add(float64(s.Elements))
+	prof.headers[s.Headers]++
+	prof.anomalies += len(found)
+
+	p.anomalies = append(p.anomalies, found...)
+	if over := len(p.anomalies) - maxAnomalies; over > 0 {
+		p.anomalies = slices.Delete(p.anomalies, 0, over)
+	}
+	return found
+}
+
+// Profiles returns every baseline, ordered by client and endpoint.
+func (p *Profiler) Profiles() []Profile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	profiles := make([]Profile, 0, len(p.profiles))
+	for k, prof := range p.profiles {
+		profiles = append(profiles, Profile{
+			Client:         k.client,
+			Endpoint:       k.endpoint,
+			Requests:       prof.requests,
+			Size:           prof.size.stat(),
+			Elements:       prof.elements.stat(),
+			HeaderPatterns: maps.Clone(prof.headers),
+			Anomalies:      prof.anomalies,
+		})
+	}
+	slices.SortFunc(profiles, func(a, b Profile) int {
+		return strings.Compare(a.Client+"\x00"+a.Endpoint, b.Client+"\x00"+b.Endpoint)
+	})
+	return profiles
+}
+
+// Anomalies returns the most recent anomalies, oldest first.
+func (p *Profiler) Anomalies() []Anomaly {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.anomalies)
+}
+
+// Reset discards all baselines and anomalies.
+func (p *Profiler) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clear(p.profiles)
+	p.anomalies = nil
+}
+
+// HeaderPattern returns the sorted, lower-cased header names of h.
+func HeaderPattern(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, strings.ToLower(name))
+	}
+	slices.Sort(names)
+	return strings.Join(names, ",")
+}
+
+// CountElements counts XML start tags, or object keys in a JSON body. It only scans
+// the bytes, so malformed payloads are counted too.
+func CountElements(body []byte) int {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return bytes.Count(trimmed, []byte(`":`))
+	}
+
+	n := 0
+	for i := 0; i < len(trimmed)-1; i++ {
+		if trimmed[i] == '<' && isNameStart(trimmed[i+1]) {
+			n++
+		}
+	}
+	return n
+}
+
+func isNameStart(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func mostCommon(patterns map[string]int) string {
+	best, bestCount := "", -1
+	for pattern, count := range patterns {
+		if count > bestCount || count == bestCount && pattern < best {
+			best, bestCount = pattern, count
+		}
+	}
+	return best
+}
+
+// headerDiff describes the header names added to and removed from baseline.
+func headerDiff(baseline, pattern string) string {
+	before, after := strings.Split(baseline, ","), strings.Split(pattern, ",")
+	var added, removed []string
+	for _, name := range after {
+		if name != "" && !slices.Contains(before, name) {
+			added = append(added, "+"+name)
+		}
+	}
+	for _, name := range before {
+		if name != "" && !slices.Contains(after, name) {
+			removed = append(removed, "-"+name)
+		}
+	}
+	if len(added)+len(removed) == 0 {
+		return pattern
+	}
+	return strings.Join(append(added, removed...), " ")
+}
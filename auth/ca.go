@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"mitz-replicator/identity"
+)
+
+// CertificateAuthority issues client certificates signed by the server's CA.
+type CertificateAuthority struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// ClientCertificate is an issued client certificate with its private key, PEM encoded.
+type ClientCertificate struct {
+	Certificate   string    `json:"certificate"`
+	PrivateKey    string    `json:"privateKey"`
+	CACertificate string    `json:"caCertificate"`
+	Subject       string    `json:"subject"`
+	URA           string    `json:"ura,omitempty"`
+	SerialNumber  string    `json:"serialNumber"`
+	Fingerprint   string    `json:"fingerprint"`
+	NotAfter      time.Time `json:"notAfter"`
+}
+
+// NewCertificateAuthority loads the CA certificate and its private key.
+func NewCertificateAuthority(certPEM, keyPEM []byte) (*CertificateAuthority, error) {
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key pair: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA", cert.Subject)
+	}
+
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CA key does not support signing")
+	}
+
+	return &CertificateAuthority{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		key:     key,
+	}, nil
+}
+
+// Issue creates a client certificate for commonName and organization, valid for
+// validity, with ura in a UZI subjectAltName when set. The key is a fresh P-256 key.
+func (ca *CertificateAuthority) Issue(commonName, organization, ura string, validity time.Duration) (*ClientCertificate, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	subject := pkix.Name{CommonName: commonName}
+	if organization != "" {
+		subject.Organization = []string{organization}
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if ura != "" {
+		san, err := identity.UZISubjectAltName(ura)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode URA: %w", err)
+		}
+		template.ExtraExtensions = []pkix.Extension{san}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode client key: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+	return &ClientCertificate{
+		Certificate:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey:    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		CACertificate: string(ca.certPEM),
+		Subject:       subject.String(),
+		URA:           ura,
+		SerialNumber:  serial.Text(16),
+		Fingerprint:   hex.EncodeToString(sum[:]),
+		NotAfter:      template.NotAfter.UTC(),
+	}, nil
+}
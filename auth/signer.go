@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// Namespaces of the WS-Security header added by SignSOAP.
+const (
+	nsWSSE = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+	nsWSU  = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+)
+
+// x509TokenEncoding is the EncodingType of a base64 BinarySecurityToken.
+const x509TokenEncoding = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary"
+
+// XMLSigner adds enveloped XML-DSig signatures to outbound documents using a server key.
+type XMLSigner struct {
+	ctx  *dsig.SigningContext
+	cert []byte // DER of the signing certificate
+}
+
+// NewXMLSigner creates a signer from a PEM-encoded certificate and private key.
+func NewXMLSigner(certPEM, keyPEM []byte) (*XMLSigner, error) {
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key pair: %w", err)
+	}
+
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("signing key does not support signing")
+	}
+
+	return newXMLSigner(signer, pair.Certificate)
+}
+
+// NewSelfSignedXMLSigner creates a signer with a freshly generated key and a
+// self-signed certificate that nobody trusts, for signatures that must fail
+// certificate validation.
+func NewSelfSignedXMLSigner(commonName string) (*XMLSigner, error) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing certificate: %w", err)
+	}
+
+	return newXMLSigner(key, [][]byte{der})
+}
+
+func newXMLSigner(signer crypto.Signer, chain [][]byte) (*XMLSigner, error) {
+
+	ctx, err := dsig.NewSigningContext(signer, chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing context: %w", err)
+	}
+
+	return &XMLSigner{ctx: ctx, cert: chain[0]}, nil
+}
+
+// Sign returns the document with an enveloped signature appended to its root element.
+func (s *XMLSigner) Sign(xmlBytes []byte) ([]byte, error) {
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(xmlBytes); err != nil {
+		return nil, fmt.Errorf("failed to parse document for signing: %w", err)
+	}
+
+	if doc.Root() == nil {
+		return nil, fmt.Errorf("document has no root element to sign")
+	}
+
+	signed, err := s.ctx.SignEnveloped(doc.Root())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign document: %w", err)
+	}
+
+	doc.SetRoot(signed)
+	return doc.WriteToBytes()
+}
+
+// SOAPSignOptions sets the Timestamp of a SignSOAP signature, and the defects that
+// make it fail verification on purpose.
+type SOAPSignOptions struct {
+	Created      time.Time
+	TTL          time.Duration // Expires = Created + TTL
+	BadDigest    bool          // change the Body after signing
+	BadSignature bool          // corrupt the SignatureValue
+}
+
+// SignSOAP returns the SOAP envelope with a wsse:Security header holding a
+// Timestamp, the certificate as BinarySecurityToken and an exclusive-c14n signature
+// over the Body and the Timestamp: the shape WSSecurityValidator accepts.
+func (s *XMLSigner) SignSOAP(envelope []byte, opts SOAPSignOptions) ([]byte, error) {
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(envelope); err != nil || doc.Root() == nil {
+		return nil, fmt.Errorf("failed to parse SOAP envelope for signing")
+	}
+	root := doc.Root()
+
+	body := findChildByLocalName(root, "Body")
+	if body == nil {
+		return nil, fmt.Errorf("SOAP envelope has no Body to sign")
+	}
+	body.CreateAttr("xmlns:wsu", nsWSU)
+	body.CreateAttr("wsu:Id", "id-body")
+
+	header := findChildByLocalName(root, "Header")
+	if header == nil {
+		header = etree.NewElement("Header")
+		header.Space = body.Space
+		root.InsertChildAt(body.Index(), header)
+	}
+
+	security := header.CreateElement("wsse:Security")
+	security.CreateAttr("xmlns:wsse", nsWSSE)
+	security.CreateAttr("xmlns:wsu", nsWSU)
+
+	timestamp := security.CreateElement("wsu:Timestamp")
+	timestamp.CreateAttr("wsu:Id", "id-timestamp")
+	timestamp.CreateElement("wsu:Created").SetText(opts.Created.UTC().Format(time.RFC3339))
+	timestamp.CreateElement("wsu:Expires").SetText(opts.Created.Add(opts.TTL).UTC().Format(time.RFC3339))
+
+	token := security.CreateElement("wsse:BinarySecurityToken")
+	token.CreateAttr("wsu:Id", "id-token")
+	token.CreateAttr("ValueType", x509TokenType)
+	token.CreateAttr("EncodingType", x509TokenEncoding)
+	token.SetText(base64.StdEncoding.EncodeToString(s.cert))
+
+	signature := security.CreateElement("ds:Signature")
+	signature.CreateAttr("xmlns:ds", dsig.Namespace)
+	signedInfo := signature.CreateElement("ds:SignedInfo")
+	signedInfo.CreateElement("ds:CanonicalizationMethod").CreateAttr("Algorithm", string(dsig.CanonicalXML10ExclusiveAlgorithmId))
+	signedInfo.CreateElement("ds:SignatureMethod").CreateAttr("Algorithm", s.ctx.GetSignatureMethodIdentifier())
+
+	for _, el := range []*etree.Element{body, timestamp} {
+		canonical, err := canonicalize(el, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to canonicalize %s: %w", el.Tag, err)
+		}
+		h := s.ctx.Hash.New()
+		h.Write(canonical)
+
+		ref := signedInfo.CreateElement("ds:Reference")
+		ref.CreateAttr("URI", "#"+el.SelectAttrValue("wsu:Id", ""))
+		ref.CreateElement("ds:Transforms").CreateElement("ds:Transform").CreateAttr("Algorithm", string(dsig.CanonicalXML10ExclusiveAlgorithmId))
+		ref.CreateElement("ds:DigestMethod").CreateAttr("Algorithm", s.ctx.GetDigestAlgorithmIdentifier())
+		ref.CreateElement("ds:DigestValue").SetText(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	}
+
+	canonical, err := canonicalize(signedInfo, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to canonicalize SignedInfo: %w", err)
+	}
+	value, err := s.ctx.SignString(string(canonical))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign SOAP envelope: %w", err)
+	}
+	if opts.BadSignature {
+		value[0] ^= 0xff
+	}
+	signature.CreateElement("ds:SignatureValue").SetText(base64.StdEncoding.EncodeToString(value))
+
+	reference := signature.CreateElement("ds:KeyInfo").CreateElement("wsse:SecurityTokenReference").CreateElement("wsse:Reference")
+	reference.CreateAttr("URI", "#id-token")
+	reference.CreateAttr("ValueType", x509TokenType)
+
+	if opts.BadDigest {
+		// Whitespace is significant to canonicalization, so the Body digest breaks
+		// while the payload still reads the same.
+		body.CreateText("\n")
+	}
+
+	return doc.WriteToBytes()
+}
@@ -0,0 +1,503 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+// WS-Security fault codes (WSS 1.1 SOAP Message Security, section 12).
+const (
+	WSSEUnsupportedSecurityToken = "wsse:UnsupportedSecurityToken"
+	WSSEUnsupportedAlgorithm     = "wsse:UnsupportedAlgorithm"
+	WSSEInvalidSecurity          = "wsse:InvalidSecurity"
+	WSSEInvalidSecurityToken     = "wsse:InvalidSecurityToken"
+	WSSEFailedAuthentication     = "wsse:FailedAuthentication"
+	WSSEFailedCheck              = "wsse:FailedCheck"
+	WSSESecurityTokenUnavailable = "wsse:SecurityTokenUnavailable"
+	WSSEMessageExpired           = "wsse:MessageExpired"
+)
+
+// x509TokenType is the ValueType of an X.509 v3 BinarySecurityToken.
+const x509TokenType = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-x509-token-profile-1.0#X509v3"
+
+// Signature algorithms accepted in SignedInfo.
+var wssSignatureMethods = map[string]x509.SignatureAlgorithm{
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256":   x509.SHA256WithRSA,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512":   x509.SHA512WithRSA,
+	"http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256": x509.ECDSAWithSHA256,
+}
+
+// Digest algorithms accepted in References.
+var wssDigestMethods = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmlenc#sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmlenc#sha512": crypto.SHA512,
+}
+
+// WSSecurityError is a WS-Security validation failure with its wsse fault code.
+type WSSecurityError struct {
+	Code   string
+	Reason string
+}
+
+func (e *WSSecurityError) Error() string {
+
+	return e.Code + ": " + e.Reason
+}
+
+func wssError(code, format string, args ...any) *WSSecurityError {
+
+	return &WSSecurityError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// WSSecurityConfig holds the configuration for WS-Security validation.
+type WSSecurityConfig struct {
+	Enabled      bool
+	TrustedCerts []byte // PEM-encoded certificates or CAs accepted for signatures
+	ClockSkew    time.Duration
+	SAML         *SamlValidator // validates SAML assertion tokens; disabled rejects them
+}
+
+// WSSecurityValidator validates the WS-Security header of SOAP requests: a
+// Timestamp, a BinarySecurityToken or SAML assertion, and a Signature over the Body
+// and the Timestamp made with a trusted certificate.
+type WSSecurityValidator struct {
+	config WSSecurityConfig
+	roots  *x509.CertPool
+}
+
+// NewWSSecurityValidator creates a validator from the given config.
+// Returns an error if no trusted certificate can be parsed.
+func NewWSSecurityValidator(config WSSecurityConfig) (*WSSecurityValidator, error) {
+
+	v := &WSSecurityValidator{config: config, roots: x509.NewCertPool()}
+	if !config.Enabled {
+		return v, nil
+	}
+
+	var count int
+	rest := config.TrustedCerts
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted WS-Security certificate: %w", err)
+		}
+		v.roots.AddCert(cert)
+		count++
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("failed to decode PEM block from trusted WS-Security certificates")
+	}
+
+	return v, nil
+}
+
+// IsEnabled returns whether WS-Security validation is active.
+func (v *WSSecurityValidator) IsEnabled() bool {
+
+	return v != nil && v.config.Enabled
+}
+
+// Validate checks the WS-Security header of a SOAP envelope and returns the envelope
+// it verified, which is what the request must be handled as. With requireExpires a
+// Timestamp without Expires is rejected. Failures are *WSSecurityError values
+// carrying the wsse fault code.
+func (v *WSSecurityValidator) Validate(envelope []byte, requireExpires bool) ([]byte, error) {
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(envelope); err != nil || doc.Root() == nil {
+		return nil, wssError(WSSEInvalidSecurity, "failed to parse SOAP envelope")
+	}
+	root := doc.Root()
+	now := time.Now()
+
+	header, body, err := envelopeParts(root)
+	if err != nil {
+		return nil, err
+	}
+	security := findChildByLocalName(orEmpty(header), "Security")
+	if security == nil {
+		return nil, wssError(WSSEInvalidSecurity, "missing wsse:Security header")
+	}
+
+	// 1. Timestamp
+	timestamp := findChildByLocalName(security, "Timestamp")
+	if timestamp == nil {
+		return nil, wssError(WSSEInvalidSecurity, "missing wsu:Timestamp")
+	}
+	if err := v.checkTimestamp(timestamp, now, requireExpires); err != nil {
+		return nil, err
+	}
+
+	// 2. Security token
+	bst := findChildByLocalName(security, "BinarySecurityToken")
+	assertion := findChildByLocalName(security, "Assertion")
+	switch {
+	case bst != nil:
+		if _, err := v.tokenCertificate(bst, now); err != nil {
+			return nil, err
+		}
+	case assertion != nil:
+		if err := v.checkAssertion(assertion); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, wssError(WSSEInvalidSecurity, "missing BinarySecurityToken or SAML assertion")
+	}
+
+	// 3. Signature over the Body and the Timestamp
+	signature := findChildByLocalName(security, "Signature")
+	if signature == nil {
+		return nil, wssError(WSSEInvalidSecurity, "missing ds:Signature")
+	}
+	if err := v.checkSignature(root, signature, []*etree.Element{body, timestamp}, now); err != nil {
+		return nil, err
+	}
+
+	verified, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, wssError(WSSEInvalidSecurity, "failed to serialize SOAP envelope: %v", err)
+	}
+	return verified, nil
+}
+
+// envelopeParts returns the Header and Body of a SOAP envelope. Envelopes with more
+// than one of either are rejected, so a second, unsigned Body cannot ride along with
+// the signed one.
+func envelopeParts(root *etree.Element) (header, body *etree.Element, err error) {
+
+	if localName(root.Tag) != "Envelope" {
+		return nil, nil, wssError(WSSEInvalidSecurity, "not a SOAP envelope")
+	}
+	for _, child := range root.ChildElements() {
+		switch localName(child.Tag) {
+		case "Header":
+			if header != nil {
+				return nil, nil, wssError(WSSEInvalidSecurity, "SOAP envelope has more than one Header")
+			}
+			header = child
+		case "Body":
+			if body != nil {
+				return nil, nil, wssError(WSSEInvalidSecurity, "SOAP envelope has more than one Body")
+			}
+			body = child
+		}
+	}
+	if body == nil {
+		return nil, nil, wssError(WSSEInvalidSecurity, "missing SOAP Body")
+	}
+
+	return header, body, nil
+}
+
+// checkTimestamp rejects expired timestamps and timestamps created in the future,
+// and with requireExpires timestamps without Expires.
+func (v *WSSecurityValidator) checkTimestamp(timestamp *etree.Element, now time.Time, requireExpires bool) error {
+
+	if created := findChildByLocalName(timestamp, "Created"); created != nil {
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(created.Text()))
+		if err != nil {
+			return wssError(WSSEInvalidSecurity, "invalid Timestamp/Created %q", created.Text())
+		}
+		if now.Add(v.config.ClockSkew).Before(t) {
+			return wssError(WSSEInvalidSecurity, "Timestamp/Created %s is in the future", created.Text())
+		}
+	}
+
+	expires := findChildByLocalName(timestamp, "Expires")
+	if expires == nil && requireExpires {
+		return wssError(WSSEInvalidSecurity, "missing Timestamp/Expires")
+	}
+	if expires != nil {
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(expires.Text()))
+		if err != nil {
+			return wssError(WSSEInvalidSecurity, "invalid Timestamp/Expires %q", expires.Text())
+		}
+		if now.Add(-v.config.ClockSkew).After(t) {
+			return wssError(WSSEMessageExpired, "message expired at %s", expires.Text())
+		}
+	}
+
+	return nil
+}
+
+// tokenCertificate returns the certificate in an X.509 BinarySecurityToken, if it
+// is trusted.
+func (v *WSSecurityValidator) tokenCertificate(bst *etree.Element, now time.Time) (*x509.Certificate, error) {
+
+	if valueType := bst.SelectAttrValue("ValueType", ""); valueType != x509TokenType {
+		return nil, wssError(WSSEUnsupportedSecurityToken, "unsupported BinarySecurityToken ValueType %q", valueType)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(bst.Text()), ""))
+	if err != nil {
+		return nil, wssError(WSSEInvalidSecurityToken, "BinarySecurityToken is not base64")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, wssError(WSSEInvalidSecurityToken, "BinarySecurityToken is not an X.509 certificate: %v", err)
+	}
+
+	return v.trusted(cert, now)
+}
+
+// trusted verifies cert against the trusted certificates.
+func (v *WSSecurityValidator) trusted(cert *x509.Certificate, now time.Time) (*x509.Certificate, error) {
+
+	opts := x509.VerifyOptions{Roots: v.roots, CurrentTime: now, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if _, err := cert.Verify(opts); err != nil {
+		return nil, wssError(WSSEFailedAuthentication, "certificate %q is not trusted: %v", cert.Subject.String(), err)
+	}
+
+	return cert, nil
+}
+
+// checkAssertion validates a SAML assertion token with the SAML validator.
+func (v *WSSecurityValidator) checkAssertion(assertion *etree.Element) error {
+
+	if v.config.SAML == nil || !v.config.SAML.IsEnabled() {
+		return wssError(WSSEUnsupportedSecurityToken, "SAML tokens require SAML validation to be enabled")
+	}
+
+	detached, err := detach(assertion)
+	if err != nil {
+		return wssError(WSSEInvalidSecurityToken, "SAML assertion: %v", err)
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(detached)
+	xmlBytes, err := doc.WriteToBytes()
+	if err != nil {
+		return wssError(WSSEInvalidSecurityToken, "SAML assertion: %v", err)
+	}
+
+	if err := v.config.SAML.validateAssertion(xmlBytes); err != nil {
+		return wssError(WSSEFailedAuthentication, "SAML assertion: %v", err)
+	}
+
+	return nil
+}
+
+// checkSignature verifies a detached signature that must cover every element of
+// required, made with a trusted certificate.
+func (v *WSSecurityValidator) checkSignature(root, signature *etree.Element, required []*etree.Element, now time.Time) error {
+
+	signedInfo := findChildByLocalName(signature, "SignedInfo")
+	if signedInfo == nil {
+		return wssError(WSSEInvalidSecurity, "Signature has no SignedInfo")
+	}
+
+	c14n := findChildByLocalName(signedInfo, "CanonicalizationMethod")
+	if c14n == nil || c14n.SelectAttrValue("Algorithm", "") != string(dsig.CanonicalXML10ExclusiveAlgorithmId) {
+		return wssError(WSSEUnsupportedAlgorithm, "SignedInfo must use exclusive canonicalization")
+	}
+	method := findChildByLocalName(signedInfo, "SignatureMethod")
+	algorithm, ok := wssSignatureMethods[orEmpty(method).SelectAttrValue("Algorithm", "")]
+	if !ok {
+		return wssError(WSSEUnsupportedAlgorithm, "unsupported SignatureMethod")
+	}
+
+	// Every Reference must match its element; the required ones must be among them.
+	covered := make(map[*etree.Element]bool)
+	for _, ref := range signedInfo.ChildElements() {
+		if localName(ref.Tag) != "Reference" {
+			continue
+		}
+		el, err := v.checkReference(root, ref)
+		if err != nil {
+			return err
+		}
+		covered[el] = true
+	}
+	for _, el := range required {
+		if !covered[el] {
+			return wssError(WSSEFailedCheck, "Signature does not cover the %s", localName(el.Tag))
+		}
+	}
+
+	cert, err := v.signingCertificate(root, signature, now)
+	if err != nil {
+		return err
+	}
+
+	canonical, err := canonicalize(signedInfo, prefixList(c14n))
+	if err != nil {
+		return wssError(WSSEFailedCheck, "failed to canonicalize SignedInfo: %v", err)
+	}
+	value := findChildByLocalName(signature, "SignatureValue")
+	if value == nil {
+		return wssError(WSSEInvalidSecurity, "Signature has no SignatureValue")
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value.Text()), ""))
+	if err != nil {
+		return wssError(WSSEInvalidSecurity, "SignatureValue is not base64")
+	}
+	if err := cert.CheckSignature(algorithm, canonical, signatureBytes); err != nil {
+		return wssError(WSSEFailedCheck, "SignatureValue does not verify: %v", err)
+	}
+
+	return nil
+}
+
+// checkReference verifies the digest of the element a Reference points to and
+// returns that element.
+func (v *WSSecurityValidator) checkReference(root, ref *etree.Element) (*etree.Element, error) {
+
+	uri := ref.SelectAttrValue("URI", "")
+	id, ok := strings.CutPrefix(uri, "#")
+	if !ok || id == "" {
+		return nil, wssError(WSSEFailedCheck, "unsupported Reference URI %q", uri)
+	}
+	el, err := elementByID(root, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes string
+	for _, transform := range orEmpty(findChildByLocalName(ref, "Transforms")).ChildElements() {
+		if transform.SelectAttrValue("Algorithm", "") != string(dsig.CanonicalXML10ExclusiveAlgorithmId) {
+			return nil, wssError(WSSEUnsupportedAlgorithm, "unsupported Transform %q in Reference %s", transform.SelectAttrValue("Algorithm", ""), uri)
+		}
+		prefixes = prefixList(transform)
+	}
+
+	digestMethod := findChildByLocalName(ref, "DigestMethod")
+	hash, ok := wssDigestMethods[orEmpty(digestMethod).SelectAttrValue("Algorithm", "")]
+	if !ok {
+		return nil, wssError(WSSEUnsupportedAlgorithm, "unsupported DigestMethod in Reference %s", uri)
+	}
+	expected, err := base64.StdEncoding.DecodeString(strings.TrimSpace(orEmpty(findChildByLocalName(ref, "DigestValue")).Text()))
+	if err != nil {
+		return nil, wssError(WSSEInvalidSecurity, "DigestValue of Reference %s is not base64", uri)
+	}
+
+	canonical, err := canonicalize(el, prefixes)
+	if err != nil {
+		return nil, wssError(WSSEFailedCheck, "failed to canonicalize Reference %s: %v", uri, err)
+	}
+	h := hash.New()
+	h.Write(canonical)
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return nil, wssError(WSSEFailedCheck, "digest of Reference %s does not match", uri)
+	}
+
+	return el, nil
+}
+
+// signingCertificate resolves the Signature's KeyInfo: a SecurityTokenReference to a
+// BinarySecurityToken, or an embedded X509Certificate.
+func (v *WSSecurityValidator) signingCertificate(root, signature *etree.Element, now time.Time) (*x509.Certificate, error) {
+
+	keyInfo := findChildByLocalName(signature, "KeyInfo")
+	if keyInfo == nil {
+		return nil, wssError(WSSESecurityTokenUnavailable, "Signature has no KeyInfo")
+	}
+
+	if str := findChildByLocalName(keyInfo, "SecurityTokenReference"); str != nil {
+		ref := findChildByLocalName(str, "Reference")
+		id, ok := strings.CutPrefix(orEmpty(ref).SelectAttrValue("URI", ""), "#")
+		if !ok {
+			return nil, wssError(WSSESecurityTokenUnavailable, "SecurityTokenReference must reference a BinarySecurityToken")
+		}
+		bst, err := elementByID(root, id)
+		if err != nil || localName(bst.Tag) != "BinarySecurityToken" {
+			return nil, wssError(WSSESecurityTokenUnavailable, "referenced token #%s not found", id)
+		}
+		return v.tokenCertificate(bst, now)
+	}
+
+	if certEl := findElementByLocalName(keyInfo, "X509Certificate"); certEl != nil {
+		der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(certEl.Text()), ""))
+		if err != nil {
+			return nil, wssError(WSSEInvalidSecurityToken, "X509Certificate is not base64")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, wssError(WSSEInvalidSecurityToken, "X509Certificate: %v", err)
+		}
+		return v.trusted(cert, now)
+	}
+
+	return nil, wssError(WSSESecurityTokenUnavailable, "KeyInfo has no SecurityTokenReference or X509Certificate")
+}
+
+// elementByID finds the single element with a (wsu:)Id or ID attribute of id.
+// Duplicate IDs are rejected, so a signed element cannot be swapped for another.
+func elementByID(root *etree.Element, id string) (*etree.Element, error) {
+
+	var found *etree.Element
+	var count int
+	var walk func(el *etree.Element)
+	walk = func(el *etree.Element) {
+		for _, attr := range el.Attr {
+			if (attr.Key == "Id" || attr.Key == "ID") && attr.Value == id {
+				found = el
+				count++
+				break
+			}
+		}
+		for _, child := range el.ChildElements() {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	switch count {
+	case 0:
+		return nil, wssError(WSSEFailedCheck, "no element with Id %q", id)
+	case 1:
+		return found, nil
+	default:
+		return nil, wssError(WSSEFailedCheck, "Id %q is used by %d elements", id, count)
+	}
+}
+
+// canonicalize returns the exclusive canonical form of el, with the namespaces it
+// uses from its ancestors.
+func canonicalize(el *etree.Element, prefixes string) ([]byte, error) {
+
+	detached, err := detach(el)
+	if err != nil {
+		return nil, err
+	}
+	return dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList(prefixes).Canonicalize(detached)
+}
+
+// detach returns a copy of el that declares the namespaces in scope from its ancestors.
+func detach(el *etree.Element) (*etree.Element, error) {
+
+	ctx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+	return etreeutils.NSDetatch(ctx, el)
+}
+
+// prefixList returns the InclusiveNamespaces PrefixList of a canonicalization method
+// or transform.
+func prefixList(method *etree.Element) string {
+
+	return orEmpty(findChildByLocalName(method, "InclusiveNamespaces")).SelectAttrValue("PrefixList", "")
+}
+
+// orEmpty lets lookups continue on an element that was not found.
+func orEmpty(el *etree.Element) *etree.Element {
+
+	if el == nil {
+		return etree.NewElement("")
+	}
+	return el
+}
@@ -0,0 +1,321 @@
+// Package bsnpool hands out synthetic test BSNs from named pools. Test runs reserve
+// BSNs for a limited time; reservations never overlap, so CI pipelines sharing a
+// replicator do not work on each other's patients.
+package bsnpool
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPool is the built-in pool of the valid BSNs in the lower half of the 999
+// test range. The upper half holds imported patients, and its 999999* block the BSNs
+// the built-in rules answer with counted locations.
+const DefaultPool = "default"
+
+// Bounds of the 999 test range, outside which no BSN belongs to a fictitious person.
+const (
+	TestRangeStart = 999000000
+	TestRangeEnd   = 999999999
+)
+
+const defaultRange = "999000000-999499999"
+
+// Errors returned by Reserve.
+var (
+	ErrUnknownPool = errors.New("unknown BSN pool")
+	ErrExhausted   = errors.New("not enough free BSNs in pool")
+	ErrDuplicateID = errors.New("reservation ID already in use")
+)
+
+// Valid reports whether bsn is nine digits and passes the BSN eleven test.
+func Valid(bsn string) bool {
+	if len(bsn) != 9 || strings.Trim(bsn, "0123456789") != "" {
+		return false
+	}
+	sum := 0
+	for i := range 8 {
+		sum += int(bsn[i]-'0') * (9 - i)
+	}
+	sum -= int(bsn[8] - '0')
+	return sum%11 == 0
+}
+
+// pool is the candidate sequence of one named pool.
+type pool struct {
+	start, end int      // range pools
+	bsns       []string // list pools
+	size       int      // valid BSNs in the pool
+	cursor     int      // next candidate offset; reservations continue where the last stopped
+}
+
+// parsePool parses a pool spec: a range within the 999 test range
+// ("999100000-999199999") or a space-separated list of BSNs. Numbers failing the
+// eleven test are skipped in ranges and rejected in lists.
+func parsePool(spec string) (*pool, error) {
+	if lo, hi, ok := strings.Cut(spec, "-"); ok {
+		start, errStart := strconv.Atoi(strings.TrimSpace(lo))
+		end, errEnd := strconv.Atoi(strings.TrimSpace(hi))
+		if errStart != nil || errEnd != nil || len(strings.TrimSpace(lo)) != 9 || len(strings.TrimSpace(hi)) != 9 || end < start {
+			return nil, fmt.Errorf("invalid range %q (expected nine-digit bounds, e.g. 999100000-999199999)", spec)
+		}
+		if start < TestRangeStart || end > TestRangeEnd {
+			return nil, fmt.Errorf("range %q is outside the test range %d-%d", spec, TestRangeStart, TestRangeEnd)
+		}
+		p := &pool{start: start, end: end}
+		for n := start; n <= end; n++ {
+			if Valid(strconv.Itoa(n)) {
+				p.size++
+			}
+		}
+		if p.size == 0 {
+			return nil, fmt.Errorf("range %q contains no valid BSN", spec)
+		}
+		return p, nil
+	}
+
+	p := &pool{}
+	for _, bsn := range strings.Fields(spec) {
+		if !Valid(bsn) {
+			return nil, fmt.Errorf("%q is not a valid BSN", bsn)
+		}
+		if !slices.Contains(p.bsns, bsn) {
+			p.bsns = append(p.bsns, bsn)
+		}
+	}
+	if len(p.bsns) == 0 {
+		return nil, fmt.Errorf("empty pool")
+	}
+	p.size = len(p.bsns)
+	return p, nil
+}
+
+// contains reports whether bsn is one of the pool's BSNs.
+func (p *pool) contains(bsn string) bool {
+	if p.bsns != nil {
+		return slices.Contains(p.bsns, bsn)
+	}
+	n, err := strconv.Atoi(bsn)
+	return err == nil && n >= p.start && n <= p.end && Valid(bsn)
+}
+
+// length returns the number of candidates, valid or not.
+func (p *pool) length() int {
+	if p.bsns != nil {
+		return len(p.bsns)
+	}
+	return p.end - p.start + 1
+}
+
+// candidate returns the candidate at offset i, and whether it is a valid BSN.
+func (p *pool) candidate(i int) (string, bool) {
+	if p.bsns != nil {
+		return p.bsns[i], true
+	}
+	bsn := strconv.Itoa(p.start + i)
+	return bsn, Valid(bsn)
+}
+
+// Validate checks pool specs by name, as accepted by New.
+func Validate(specs map[string]string) error {
+	for _, name := range slices.Sorted(maps.Keys(specs)) {
+		if _, err := parsePool(specs[name]); err != nil {
+			return fmt.Errorf("pool %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Reservation is a set of BSNs held by one test run until it expires or is released.
+type Reservation struct {
+	ID      string    `json:"id"`
+	Pool    string    `json:"pool"`
+	Owner   string    `json:"owner,omitempty"`
+	BSNs    []string  `json:"bsns"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// PoolStatus summarises a pool.
+type PoolStatus struct {
+	Name     string `json:"name"`
+	Spec     string `json:"spec"`
+	Size     int    `json:"size"`
+	Reserved int    `json:"reserved"`
+}
+
+// Manager holds the pools and the active reservations.
+type Manager struct {
+	mu           sync.Mutex
+	specs        map[string]string
+	pools        map[string]*pool
+	reservations map[string]*Reservation
+	reserved     map[string]string // BSN → reservation ID
+}
+
+// New returns a manager for the default pool and the configured pools (name → spec).
+// A configured "default" pool replaces the built-in one.
+func New(specs map[string]string) (*Manager, error) {
+	m := &Manager{
+		specs:        map[string]string{DefaultPool: defaultRange},
+		pools:        make(map[string]*pool),
+		reservations: make(map[string]*Reservation),
+		reserved:     make(map[string]string),
+	}
+	maps.Copy(m.specs, specs)
+	for name, spec := range m.specs {
+		p, err := parsePool(spec)
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: %w", name, err)
+		}
+		m.pools[name] = p
+	}
+	return m, nil
+}
+
+// Reserve reserves count free BSNs from the named pool for ttl. A BSN is free when no
+// unexpired reservation holds it, whichever pool that reservation came from.
+func (m *Manager) Reserve(id, poolName, owner string, count int, ttl time.Duration, now time.Time) (Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.pools[poolName]
+	if !ok {
+		return Reservation{}, fmt.Errorf("%w %q", ErrUnknownPool, poolName)
+	}
+	m.expireLocked(now)
+	if _, taken := m.reservations[id]; taken {
+		return Reservation{}, fmt.Errorf("%w: %s", ErrDuplicateID, id)
+	}
+
+	var bsns []string
+	n, from := p.length(), p.cursor
+	for i := 0; i < n && len(bsns) < count; i++ {
+		offset := (from + i) % n
+		bsn, valid := p.candidate(offset)
+		if _, taken := m.reserved[bsn]; !valid || taken {
+			continue
+		}
+		bsns = append(bsns, bsn)
+		p.cursor = (offset + 1) % n
+	}
+	if len(bsns) < count {
+		return Reservation{}, fmt.Errorf("%w %q: %d requested, %d free", ErrExhausted, poolName, count, len(bsns))
+	}
+
+	r := &Reservation{ID: id, Pool: poolName, Owner: owner, BSNs: bsns, Created: now, Expires: now.Add(ttl)}
+	m.reservations[id] = r
+	for _, bsn := range bsns {
+		m.reserved[bsn] = id
+	}
+	return *r, nil
+}
+
+// Extend moves the expiry of an active reservation to now + ttl.
+func (m *Manager) Extend(id string, ttl time.Duration, now time.Time) (Reservation, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireLocked(now)
+	r, ok := m.reservations[id]
+	if !ok {
+		return Reservation{}, false
+	}
+	r.Expires = now.Add(ttl)
+	return *r, true
+}
+
+// Release ends a reservation early, freeing its BSNs.
+func (m *Manager) Release(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.reservations[id]
+	if ok {
+		m.deleteLocked(r)
+	}
+	return ok
+}
+
+// Reservation returns an active reservation.
+func (m *Manager) Reservation(id string, now time.Time) (Reservation, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireLocked(now)
+	r, ok := m.reservations[id]
+	if !ok {
+		return Reservation{}, false
+	}
+	return *r, true
+}
+
+// Reservations returns the active reservations, oldest first.
+func (m *Manager) Reservations(now time.Time) []Reservation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireLocked(now)
+	list := make([]Reservation, 0, len(m.reservations))
+	for _, r := range m.reservations {
+		list = append(list, *r)
+	}
+	slices.SortFunc(list, func(a, b Reservation) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return list
+}
+
+// Contains reports whether bsn belongs to any pool, reserved or not.
+func (m *Manager) Contains(bsn string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.pools {
+		if p.contains(bsn) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pools returns every pool with its number of reserved BSNs, ordered by name.
+func (m *Manager) Pools(now time.Time) []PoolStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expireLocked(now)
+	reserved := make(map[string]int)
+	for _, r := range m.reservations {
+		reserved[r.Pool] += len(r.BSNs)
+	}
+	var list []PoolStatus
+	for _, name := range slices.Sorted(maps.Keys(m.pools)) {
+		list = append(list, PoolStatus{Name: name, Spec: m.specs[name], Size: m.pools[name].size, Reserved: reserved[name]})
+	}
+	return list
+}
+
+func (m *Manager) expireLocked(now time.Time) {
+	for _, r := range m.reservations {
+		if !now.Before(r.Expires) {
+			m.deleteLocked(r)
+		}
+	}
+}
+
+func (m *Manager) deleteLocked(r *Reservation) {
+	delete(m.reservations, r.ID)
+	for _, bsn := range r.BSNs {
+		delete(m.reserved, bsn)
+	}
+}
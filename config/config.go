@@ -0,0 +1,666 @@
+// Package config loads the replicator configuration from an optional YAML or
+// JSON file, applies environment variable overrides and validates the result.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+
+	"mitz-replicator/bsnpool"
+	"mitz-replicator/identity"
+	"mitz-replicator/parser"
+	"mitz-replicator/provider"
+	"mitz-replicator/ratelimit"
+	"mitz-replicator/rules"
+)
+
+// Config is the complete replicator configuration. Every field can be set in the
+// config file (YAML key in the struct tag) or through the environment variable
+// listed in its comment; the environment wins.
+type Config struct {
+	Environment       string                    `yaml:"environment"`     // ENVIRONMENT: deployment name, e.g. test or acceptance
+	InsecureLabMode   bool                      `yaml:"insecureLabMode"` // INSECURE_LAB_MODE: allow the settings listed by InsecureSettings
+	Server            ServerConfig              `yaml:"server"`
+	Connections       ConnectionsConfig         `yaml:"connections"`
+	SAML              SAMLConfig                `yaml:"saml"`
+	WSSecurity        WSSecurityConfig          `yaml:"wsSecurity"`
+	Store             StoreConfig               `yaml:"store"`
+	Subscriptions     SubscriptionsConfig       `yaml:"subscriptions"`
+	XCPDLocations     XCPDLocationsConfig       `yaml:"xcpdLocations"`
+	XCPDDevices       XCPDDevicesConfig         `yaml:"xcpdDevices"`
+	Parsing           ParsingConfig             `yaml:"parsing"`
+	ContentTypes      ContentTypesConfig        `yaml:"contentTypes"`
+	Concurrency       ConcurrencyConfig         `yaml:"concurrency"`
+	Latency           LatencyConfig             `yaml:"latency"`
+	Streaming         StreamingConfig           `yaml:"streaming"`
+	Chaos             ChaosConfig               `yaml:"chaos"`
+	RateLimits        RateLimitsConfig          `yaml:"rateLimits"`
+	Schedule          ScheduleConfig            `yaml:"schedule"`
+	HeaderHygiene     string                    `yaml:"headerHygiene"`     // HEADER_HYGIENE: off or strict
+	SOAPActions       string                    `yaml:"soapActions"`       // SOAP_ACTIONS: strict (reject unexpected SOAP actions) or lenient (log them)
+	RequestTypes      string                    `yaml:"requestTypes"`      // REQUEST_CONTENT_TYPES: off or strict (415 for request Content-Types the endpoint cannot parse)
+	MaxCategories     int                       `yaml:"maxCategories"`     // MAX_CATEGORIES: gegevenscategorieën per XACML request (default the Mitz limit, 0 = unlimited)
+	MaxInflatedMB     int                       `yaml:"maxInflatedMB"`     // GZIP_MAX_INFLATED_MB: largest gzip request body once inflated, in MiB
+	CutoverPhase      int                       `yaml:"cutoverPhase"`      // CUTOVER_PHASE: 0 (off), 1 (SOAP only), 2 (SOAP and FHIR), 3 (FHIR only)
+	ReadOnly          bool                      `yaml:"readOnly"`          // READ_ONLY: reject write operations (demo environments)
+	OTVTestcases      string                    `yaml:"otvTestcases"`      // OTV_TESTCASES: tag (log and record X-OTV-Testcase) or enforce (also reject mismatching requests)
+	DeterministicSeed string                    `yaml:"deterministicSeed"` // DETERMINISTIC_SEED: reproducible IDs and timestamps (empty = random)
+	FHIRVersion       string                    `yaml:"fhirVersion"`       // FHIR_VERSION: R4 or R4B (topic-based Subscriptions)
+	ExportDelay       int                       `yaml:"exportDelay"`       // EXPORT_DELAY_SECONDS: seconds a bulk $export stays in progress
+	Signing           SigningConfig             `yaml:"signing"`
+	Outbound          OutboundConfig            `yaml:"outbound"`
+	Notifications     NotificationsConfig       `yaml:"notifications"`
+	Artifacts         ArtifactsConfig           `yaml:"artifacts"`
+	Admin             AdminConfig               `yaml:"admin"`
+	Debug             DebugConfig               `yaml:"debug"`
+	Registry          RegistryConfig            `yaml:"registry"`
+	BSNPools          BSNPoolsConfig            `yaml:"bsnPools"`
+	Templates         TemplatesConfig           `yaml:"templates"`
+	Decisions         DecisionsConfig           `yaml:"decisions"`
+	Anomalies         AnomaliesConfig           `yaml:"anomalies"`
+	MagicBSNs         map[string]rules.MagicBSN `yaml:"magicBsns"`    // MAGIC_BSNS: "<built-in BSN>=<replacement>,..." (descriptions file only)
+	DeceasedBSNs      map[string]string         `yaml:"deceasedBsns"` // DECEASED_BSNS: "<bsn>=<date of death>,..." (empty date = unknown)
+	Rules             []rules.Rule              `yaml:"rules"`        // file only; evaluated before the built-in BSN table
+	Identities        []identity.Mapping        `yaml:"identities"`   // file only; client certificate → URA
+	Providers         []provider.Organization   `yaml:"providers"`    // file only; organisation register next to the built-in custodians
+}
+
+// ServerConfig configures the listener.
+type ServerConfig struct {
+	Port     string `yaml:"port"`     // PORT
+	Listen   string `yaml:"listen"`   // LISTEN: "unix:///var/run/mitz.sock" serves plain HTTP on a socket (empty = HTTPS on PORT)
+	Cert     string `yaml:"cert"`     // SERVER_CERT
+	Key      string `yaml:"key"`      // SERVER_KEY
+	CACert   string `yaml:"caCert"`   // CA_CERT
+	CAKey    string `yaml:"caKey"`    // CA_KEY: key of CA_CERT, enables POST /admin/certificates (empty = off)
+	MTLS     bool   `yaml:"mtls"`     // MTLS_ENABLED: shorthand for MTLS_MODE=require
+	MTLSMode string `yaml:"mtlsMode"` // MTLS_MODE: off, request (verify a client certificate when presented) or require; empty follows MTLS_ENABLED
+
+	TrustedProxies []string `yaml:"trustedProxies"` // TRUSTED_PROXIES: IPs or CIDRs whose X-Forwarded-* headers are honoured
+}
+
+// ConnectionsConfig tunes how the listener handles client connections.
+type ConnectionsConfig struct {
+	HTTP2                    bool `yaml:"http2"`                    // HTTP2_ENABLED: offer HTTP/2 (h2 over TLS, h2c on a Unix socket)
+	MaxConcurrentStreams     int  `yaml:"maxConcurrentStreams"`     // HTTP2_MAX_CONCURRENT_STREAMS: streams per HTTP/2 connection
+	KeepAlives               bool `yaml:"keepAlives"`               // KEEP_ALIVES_ENABLED: reuse connections (false = close after each response)
+	IdleTimeoutSeconds       int  `yaml:"idleTimeoutSeconds"`       // IDLE_TIMEOUT_SECONDS: close idle connections (0 = never)
+	ReadHeaderTimeoutSeconds int  `yaml:"readHeaderTimeoutSeconds"` // READ_HEADER_TIMEOUT_SECONDS: time to send the request headers (0 = no limit)
+}
+
+// ClientCertMode returns how the listener asks for client certificates: off, request
+// or require. MTLS_MODE wins over MTLS_ENABLED.
+func (s ServerConfig) ClientCertMode() string {
+	switch {
+	case s.MTLSMode != "":
+		return s.MTLSMode
+	case s.MTLS:
+		return "require"
+	default:
+		return "off"
+	}
+}
+
+// unixScheme prefixes a Unix domain socket path in LISTEN.
+const unixScheme = "unix://"
+
+// UnixSocket returns the socket path when the server listens on a Unix domain
+// socket. TLS is then left to the sidecar in front of it.
+func (s ServerConfig) UnixSocket() (string, bool) {
+	path, ok := strings.CutPrefix(s.Listen, unixScheme)
+	return path, ok && path != ""
+}
+
+// ProxyPrefixes returns TrustedProxies as prefixes; a bare IP is a single-address prefix.
+func (s ServerConfig) ProxyPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, proxy := range s.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// SAMLConfig configures SAML assertion validation on the FHIR endpoints.
+type SAMLConfig struct {
+	Enabled          bool   `yaml:"enabled"`          // SAML_VALIDATION_ENABLED
+	SigningCert      string `yaml:"signingCert"`      // SAML_SIGNING_CERT
+	ExpectedIssuer   string `yaml:"expectedIssuer"`   // SAML_EXPECTED_ISSUER
+	ClockSkewSeconds int    `yaml:"clockSkewSeconds"` // SAML_CLOCK_SKEW_SECONDS
+}
+
+// WSSecurityConfig configures WS-Security validation on the SOAP endpoints.
+type WSSecurityConfig struct {
+	Enabled          bool   `yaml:"enabled"`          // WSSECURITY_ENABLED
+	TrustedCerts     string `yaml:"trustedCerts"`     // WSSECURITY_TRUSTED_CERTS: PEM certificates or CAs accepted for signatures
+	ClockSkewSeconds int    `yaml:"clockSkewSeconds"` // WSSECURITY_CLOCK_SKEW_SECONDS
+}
+
+// StoreConfig selects the state store backend.
+type StoreConfig struct {
+	Driver             string `yaml:"driver"`             // STORE_DRIVER: memory, file or sqlite
+	DSN                string `yaml:"dsn"`                // STORE_DSN
+	SessionIsolation   bool   `yaml:"sessionIsolation"`   // SESSION_ISOLATION
+	SessionIdleSeconds *int   `yaml:"sessionIdleSeconds"` // SESSION_IDLE_SECONDS: close session stores unused this long (0 = never; unset: see SessionIdle)
+	MaxRequests        int    `yaml:"maxRequests"`        // STORE_MAX_REQUESTS: captured requests kept per store, oldest dropped first (0 = unlimited)
+	MaxEvents          int    `yaml:"maxEvents"`          // STORE_MAX_EVENTS: events kept per store, oldest dropped first (0 = unlimited)
+}
+
+// defaultSessionIdle is the idle timeout of session stores that can be reopened.
+const defaultSessionIdle = time.Hour
+
+// SessionIdle returns how long a session store may go unused before it is closed (0 =
+// never). Unless SESSION_IDLE_SECONDS is set, memory-backed sessions are never
+// closed: closing one drops its state.
+func (s StoreConfig) SessionIdle() time.Duration {
+	switch {
+	case s.SessionIdleSeconds != nil:
+		return time.Duration(*s.SessionIdleSeconds) * time.Second
+	case s.Driver == "memory":
+		return 0
+	default:
+		return defaultSessionIdle
+	}
+}
+
+// XCPDLocationsConfig configures XCPD locations generated from the organisation register.
+type XCPDLocationsConfig struct {
+	Source   string `yaml:"source"`   // XCPD_LOCATIONS: fixed (built-in location sets) or register (generated per BSN)
+	Max      int    `yaml:"max"`      // XCPD_MAX_LOCATIONS: most locations per generated answer
+	PageSize int    `yaml:"pageSize"` // XCPD_PAGE_SIZE: locations per response, the rest via query continuation (0 = all at once)
+
+	// XCPD_CUSTODIANS: URAs of the first and second custodian of the built-in
+	// location sets, looked up in the provider register.
+	Custodians []string `yaml:"custodians"`
+}
+
+// XCPDDevicesConfig lists the device ids registered for XCPD, as Mitz checks them
+// when a client is onboarded. Empty lists accept any device.
+type XCPDDevicesConfig struct {
+	Senders   []string `yaml:"senders"`   // XCPD_SENDERS: sender/device/id/@root values (URAs or OIDs)
+	Receivers []string `yaml:"receivers"` // XCPD_RECEIVERS: receiver/device/id/@root values, e.g. the Mitz OID
+}
+
+// SubscriptionsConfig bounds subscription registration.
+type SubscriptionsConfig struct {
+	Quota int `yaml:"quota"` // SUBSCRIPTION_QUOTA (0 = unlimited)
+}
+
+// ParsingConfig sets request parsing strictness: lenient, structure, namespaces or strict.
+type ParsingConfig struct {
+	Strictness string            `yaml:"strictness"` // PARSE_STRICTNESS
+	Clients    map[string]string `yaml:"clients"`    // PARSE_STRICTNESS_CLIENTS: "<ura or session>=<strictness>,..."
+	Validation string            `yaml:"validation"` // VALIDATION_MODE: lenient or strict (reject requests missing required content)
+}
+
+// ContentTypesConfig overrides the response Content-Type strings.
+type ContentTypesConfig struct {
+	SOAP string `yaml:"soap"` // SOAP_CONTENT_TYPE
+	FHIR string `yaml:"fhir"` // FHIR_CONTENT_TYPE
+}
+
+// ConcurrencyConfig configures the per-endpoint worker pool simulation.
+type ConcurrencyConfig struct {
+	Limits         map[string]int `yaml:"limits"`         // CONCURRENCY_LIMITS: "xacml=5,xcpd=2"
+	Mode           string         `yaml:"mode"`           // CONCURRENCY_MODE: queue or reject
+	QueueTimeoutMs int            `yaml:"queueTimeoutMs"` // CONCURRENCY_QUEUE_TIMEOUT_MS
+}
+
+// LatencyConfig configures injected response delays per route.
+type LatencyConfig struct {
+	Endpoints   map[string]string `yaml:"endpoints"`   // LATENCY_ENDPOINTS: "xacml=200-800,fhir=100" (milliseconds)
+	Header      bool              `yaml:"header"`      // LATENCY_HEADER: honour X-Mitz-Delay request headers
+	HeaderMaxMs int               `yaml:"headerMaxMs"` // LATENCY_HEADER_MAX_MS: longest delay X-Mitz-Delay may ask for
+	HangMaxMs   int               `yaml:"hangMaxMs"`   // HANG_MAX_MS: longest a rule with hang holds a request
+	BudgetMs    int               `yaml:"budgetMs"`    // LATENCY_BUDGET_MS: end-to-end budget per request and notification chain (0 = off)
+	BudgetMode  string            `yaml:"budgetMode"`  // LATENCY_BUDGET_MODE: flag (report) or enforce (fail past the budget)
+}
+
+// RateLimitsConfig configures token-bucket rate limiting per client and route.
+type RateLimitsConfig struct {
+	Limits map[string]string `yaml:"limits"` // RATE_LIMITS: "xacml=20/1s,fhir=600/m" (requests per period, also the burst)
+	Header string            `yaml:"header"` // RATE_LIMIT_HEADER: key clients on this header instead of the certificate URA
+}
+
+// StreamingConfig configures chunked response streaming and bandwidth limits.
+type StreamingConfig struct {
+	Endpoints       map[string]string `yaml:"endpoints"`       // STREAM_ENDPOINTS: "xacml=64/200" (chunk bytes / interval ms)
+	Bandwidth       map[string]int    `yaml:"bandwidth"`       // BANDWIDTH_ENDPOINTS: bytes per second per route, "fhir=2048"
+	ClientBandwidth map[string]int    `yaml:"clientBandwidth"` // BANDWIDTH_CLIENTS: bytes per second per client URA or IP, "12345678=512"
+}
+
+// ChaosConfig configures probabilistic fault injection.
+type ChaosConfig struct {
+	Rate   int      `yaml:"rate"`   // CHAOS_RATE: percentage of requests that fail (0 = off)
+	Routes []string `yaml:"routes"` // CHAOS_ROUTES: xacml, xcpd and/or fhir (empty = all)
+	Kinds  []string `yaml:"kinds"`  // CHAOS_KINDS: fault and/or empty
+	Status int      `yaml:"status"` // CHAOS_STATUS: HTTP status of injected faults
+}
+
+// ScheduleConfig configures time-of-day unavailability windows.
+type ScheduleConfig struct {
+	Windows  []string `yaml:"windows"`  // SCHEDULE_WINDOWS: comma-separated
+	Timezone string   `yaml:"timezone"` // SCHEDULE_TIMEZONE
+}
+
+// SigningConfig configures XML-DSig signing of outbound documents.
+type SigningConfig struct {
+	Notifications         bool   `yaml:"notifications"`         // SIGN_NOTIFICATIONS
+	SubscriptionResponses bool   `yaml:"subscriptionResponses"` // SIGN_SUBSCRIPTION_RESPONSES
+	Responses             bool   `yaml:"responses"`             // SIGN_RESPONSES: XACML and XCPD responses
+	Cert                  string `yaml:"cert"`                  // SIGNING_CERT (default: server.cert)
+	Key                   string `yaml:"key"`                   // SIGNING_KEY (default: server.key)
+}
+
+// OutboundConfig configures TLS and proxying for outbound HTTP calls.
+type OutboundConfig struct {
+	ClientCert         string   `yaml:"clientCert"`         // OUTBOUND_CLIENT_CERT
+	ClientKey          string   `yaml:"clientKey"`          // OUTBOUND_CLIENT_KEY
+	CACert             string   `yaml:"caCert"`             // OUTBOUND_CA_CERT: comma-separated PEM bundles
+	InsecureSkipVerify bool     `yaml:"insecureSkipVerify"` // OUTBOUND_INSECURE_SKIP_VERIFY
+	Proxy              string   `yaml:"proxy"`              // OUTBOUND_PROXY (default: HTTP_PROXY/HTTPS_PROXY)
+	NoProxy            []string `yaml:"noProxy"`            // OUTBOUND_NO_PROXY: comma-separated
+}
+
+// NotificationsConfig configures rest-hook notification delivery.
+type NotificationsConfig struct {
+	Enabled           bool `yaml:"enabled"`           // NOTIFY_ENABLED
+	TimeoutSeconds    int  `yaml:"timeoutSeconds"`    // NOTIFY_TIMEOUT_SECONDS
+	QueueSize         int  `yaml:"queueSize"`         // NOTIFY_QUEUE_SIZE
+	MaxAttempts       int  `yaml:"maxAttempts"`       // NOTIFY_MAX_ATTEMPTS
+	InitialBackoffMs  int  `yaml:"initialBackoffMs"`  // NOTIFY_INITIAL_BACKOFF_MS
+	MaxBackoffMs      int  `yaml:"maxBackoffMs"`      // NOTIFY_MAX_BACKOFF_MS
+	AckTimeoutSeconds int  `yaml:"ackTimeoutSeconds"` // NOTIFY_ACK_TIMEOUT_SECONDS: require acknowledgment (0 = a 2xx is enough)
+}
+
+// ArtifactsConfig configures the /artifacts file server.
+type ArtifactsConfig struct {
+	Dir string `yaml:"dir"` // ARTIFACTS_DIR
+}
+
+// DecisionsConfig configures table-driven XACML decisions.
+type DecisionsConfig struct {
+	Matrix string `yaml:"matrix"` // DECISION_MATRIX: .csv or .json (BSN, category) → decision table
+	Plugin string `yaml:"plugin"` // DECISION_PLUGIN: Go plugin (.so) exporting a decision.Decider
+}
+
+// AnomaliesConfig configures per-client request profiling.
+type AnomaliesConfig struct {
+	Enabled   bool `yaml:"enabled"`   // ANOMALY_DETECTION
+	Warmup    int  `yaml:"warmup"`    // ANOMALY_WARMUP: requests per client and endpoint before flagging
+	Threshold int  `yaml:"threshold"` // ANOMALY_THRESHOLD: standard deviations from the mean
+}
+
+// AdminConfig protects the /admin endpoints.
+type AdminConfig struct {
+	Token string `yaml:"token"` // ADMIN_TOKEN: required as "Authorization: Bearer <token>" (empty = open)
+	Pprof bool   `yaml:"pprof"` // ADMIN_PPROF: serve net/http/pprof under /admin/debug/pprof
+}
+
+// DebugConfig enables diagnostics aimed at client-side performance work.
+type DebugConfig struct {
+	Timing bool `yaml:"timing"` // DEBUG_TIMING: Server-Timing header with the time per request phase
+}
+
+// RegistryConfig configures registration with a central test-environment registry.
+type RegistryConfig struct {
+	URL             string   `yaml:"url"`             // REGISTRY_URL: POST endpoint of the registry (empty = off)
+	Token           string   `yaml:"token"`           // REGISTRY_TOKEN: bearer token for the registry
+	IntervalSeconds int      `yaml:"intervalSeconds"` // REGISTRY_INTERVAL_SECONDS: time between heartbeats
+	InstanceID      string   `yaml:"instanceId"`      // REGISTRY_INSTANCE_ID (default: hostname:port)
+	InstanceURL     string   `yaml:"instanceUrl"`     // REGISTRY_INSTANCE_URL (default: https://hostname:port)
+	Tenants         []string `yaml:"tenants"`         // REGISTRY_TENANTS: comma-separated
+}
+
+// BSNPoolsConfig configures the pools test runs reserve synthetic BSNs from.
+type BSNPoolsConfig struct {
+	Pools      map[string]string `yaml:"pools"`      // BSN_POOLS: "ci=999100000-999199999,smoke=999911120 999911132"
+	TTLSeconds int               `yaml:"ttlSeconds"` // BSN_RESERVATION_TTL_SECONDS: default reservation lifetime
+}
+
+// TemplatesConfig configures filesystem overrides of the embedded response templates.
+type TemplatesConfig struct {
+	Dir          string   `yaml:"dir"`          // TEMPLATE_DIR
+	Overlays     []string `yaml:"overlays"`     // TEMPLATE_OVERLAYS: directories layered over TEMPLATE_DIR, in order
+	Version      string   `yaml:"version"`      // MITZ_VERSION: default template set (mitz-<version>/); X-Mitz-Version overrides
+	WatchSeconds int      `yaml:"watchSeconds"` // TEMPLATE_WATCH_SECONDS (0 = no reload on change)
+}
+
+// DefaultMaxCategories is the Mitz limit on gegevenscategorieën per gesloten
+// autorisatievraag.
+const DefaultMaxCategories = 10
+
+// Default returns the configuration used when nothing is configured.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:   "8443",
+			Cert:   "certs/server.crt",
+			Key:    "certs/server.key",
+			CACert: "certs/ca.crt",
+		},
+		Connections: ConnectionsConfig{
+			HTTP2:                    true,
+			MaxConcurrentStreams:     250,
+			KeepAlives:               true,
+			IdleTimeoutSeconds:       120,
+			ReadHeaderTimeoutSeconds: 10,
+		},
+		SAML: SAMLConfig{
+			SigningCert:      "certs/client.crt",
+			ClockSkewSeconds: 5,
+		},
+		WSSecurity: WSSecurityConfig{
+			TrustedCerts:     "certs/ca.crt",
+			ClockSkewSeconds: 5,
+		},
+		Store: StoreConfig{
+			Driver:           "memory",
+			SessionIsolation: true,
+			MaxRequests:      1000,
+			MaxEvents:        10000,
+		},
+		Parsing:       ParsingConfig{Strictness: "lenient", Validation: "lenient"},
+		XCPDLocations: XCPDLocationsConfig{Source: "fixed", Max: 3, Custodians: []string{"90000001", "90000002"}},
+		Concurrency:   ConcurrencyConfig{Mode: "queue"},
+		Latency:       LatencyConfig{HeaderMaxMs: 60000, HangMaxMs: 300000, BudgetMode: "flag"},
+		Chaos:         ChaosConfig{Kinds: []string{"fault", "empty"}, Status: 500},
+		HeaderHygiene: "off",
+		SOAPActions:   "strict",
+		RequestTypes:  "off",
+		OTVTestcases:  "tag",
+		FHIRVersion:   "R4",
+		Templates:     TemplatesConfig{WatchSeconds: 2},
+		Anomalies:     AnomaliesConfig{Enabled: true, Warmup: 20, Threshold: 3},
+		Registry:      RegistryConfig{IntervalSeconds: 60},
+		BSNPools:      BSNPoolsConfig{TTLSeconds: 3600},
+		MaxCategories: DefaultMaxCategories,
+		MaxInflatedMB: 64,
+		Notifications: NotificationsConfig{
+			Enabled:          true,
+			TimeoutSeconds:   10,
+			QueueSize:        100,
+			MaxAttempts:      5,
+			InitialBackoffMs: 1000,
+			MaxBackoffMs:     60000,
+		},
+	}
+}
+
+// Load builds the configuration: defaults, then the file at path (if non-empty),
+// then environment overrides. The result is validated.
+func Load(path string) (Config, error) {
+	return LoadWith(path, os.LookupEnv)
+}
+
+// LoadWith is Load with the environment variables read through lookup.
+func LoadWith(path string, lookup func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.UnmarshalWithOptions(data, &cfg, yaml.DisallowUnknownField()); err != nil {
+			return cfg, fmt.Errorf("invalid config file %s:\n%s", path, yaml.FormatError(err, false, true))
+		}
+	}
+
+	if err := cfg.applyEnv(lookup); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// acceptanceEnvironment is the environment that never starts with insecure settings.
+const acceptanceEnvironment = "acceptance"
+
+// InsecureSettings lists the settings that weaken the replicator's security, as
+// VARIABLE=value.
+func (c Config) InsecureSettings() []string {
+	var settings []string
+	if _, unix := c.Server.UnixSocket(); !unix {
+		switch mode := c.Server.ClientCertMode(); {
+		case c.Server.MTLSMode != "" && mode != "require":
+			settings = append(settings, "MTLS_MODE="+mode)
+		case mode == "off":
+			settings = append(settings, "MTLS_ENABLED=false")
+		}
+	}
+	if !c.SAML.Enabled {
+		settings = append(settings, "SAML_VALIDATION_ENABLED=false")
+	}
+	if !c.WSSecurity.Enabled {
+		settings = append(settings, "WSSECURITY_ENABLED=false")
+	}
+	if c.Admin.Token == "" {
+		settings = append(settings, "ADMIN_TOKEN=")
+	}
+	if c.Outbound.InsecureSkipVerify {
+		settings = append(settings, "OUTBOUND_INSECURE_SKIP_VERIFY=true")
+	}
+	if c.Server.CAKey != "" {
+		settings = append(settings, "CA_KEY="+c.Server.CAKey)
+	}
+	return settings
+}
+
+// LabMode reports whether INSECURE_LAB_MODE allows insecure settings; a valid
+// configuration has none without it.
+func (c Config) LabMode() bool {
+	return c.InsecureLabMode
+}
+
+// Validate checks value ranges and enumerations, reporting every problem at once.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, env, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s (%s): %s", field, env, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "server.port", "PORT", "must be a TCP port, got %q", c.Server.Port)
+	if c.Server.Listen != "" {
+		_, unix := c.Server.UnixSocket()
+		check(unix, "server.listen", "LISTEN", "must be unix:///<socket path>, got %q", c.Server.Listen)
+		check(c.Server.ClientCertMode() == "off", "server.mtls", "MTLS_ENABLED/MTLS_MODE", "cannot be used with a Unix socket listener; let the sidecar verify client certificates")
+	}
+	check(oneOf(c.Server.MTLSMode, "", "off", "request", "require"), "server.mtlsMode", "MTLS_MODE", "must be off, request or require, got %q", c.Server.MTLSMode)
+	check(!c.Server.MTLS || c.Server.MTLSMode == "" || c.Server.MTLSMode == "require", "server.mtlsMode", "MTLS_MODE", "conflicts with MTLS_ENABLED=true")
+	check(c.Server.CAKey == "" || c.Server.CACert != "", "server.caKey", "CA_KEY", "requires CA_CERT")
+	check(c.Server.CAKey == "" || c.Admin.Token != "", "server.caKey", "CA_KEY", "requires ADMIN_TOKEN, or any client could obtain a trusted certificate")
+	_, err = c.Server.ProxyPrefixes()
+	check(err == nil, "server.trustedProxies", "TRUSTED_PROXIES", "%v", err)
+	check(c.Connections.MaxConcurrentStreams > 0, "connections.maxConcurrentStreams", "HTTP2_MAX_CONCURRENT_STREAMS", "must be positive")
+	check(c.Connections.IdleTimeoutSeconds >= 0, "connections.idleTimeoutSeconds", "IDLE_TIMEOUT_SECONDS", "must not be negative")
+	check(c.Connections.ReadHeaderTimeoutSeconds >= 0, "connections.readHeaderTimeoutSeconds", "READ_HEADER_TIMEOUT_SECONDS", "must not be negative")
+	check(c.SAML.ClockSkewSeconds >= 0, "saml.clockSkewSeconds", "SAML_CLOCK_SKEW_SECONDS", "must not be negative")
+	check(c.WSSecurity.ClockSkewSeconds >= 0, "wsSecurity.clockSkewSeconds", "WSSECURITY_CLOCK_SKEW_SECONDS", "must not be negative")
+	check(!c.WSSecurity.Enabled || c.WSSecurity.TrustedCerts != "", "wsSecurity.trustedCerts", "WSSECURITY_TRUSTED_CERTS", "is required when WS-Security validation is enabled")
+	check(oneOf(c.Store.Driver, "memory", "file", "sqlite"), "store.driver", "STORE_DRIVER",
+		"must be memory, file or sqlite, got %q", c.Store.Driver)
+	check(c.Store.Driver == "memory" || c.Store.DSN != "", "store.dsn", "STORE_DSN",
+		"is required for the %s driver", c.Store.Driver)
+	check(c.Store.MaxRequests >= 0, "store.maxRequests", "STORE_MAX_REQUESTS", "must not be negative")
+	check(c.Store.MaxEvents >= 0, "store.maxEvents", "STORE_MAX_EVENTS", "must not be negative")
+	check(c.Store.SessionIdleSeconds == nil || *c.Store.SessionIdleSeconds >= 0, "store.sessionIdleSeconds", "SESSION_IDLE_SECONDS", "must not be negative")
+	check(c.Subscriptions.Quota >= 0, "subscriptions.quota", "SUBSCRIPTION_QUOTA", "must not be negative")
+	check(oneOf(c.XCPDLocations.Source, "fixed", "register"), "xcpdLocations.source", "XCPD_LOCATIONS", "must be fixed or register, got %q", c.XCPDLocations.Source)
+	check(c.XCPDLocations.Max >= 1, "xcpdLocations.max", "XCPD_MAX_LOCATIONS", "must be at least 1")
+	check(c.XCPDLocations.PageSize >= 0, "xcpdLocations.pageSize", "XCPD_PAGE_SIZE", "must not be negative")
+	check(len(c.XCPDLocations.Custodians) == 2, "xcpdLocations.custodians", "XCPD_CUSTODIANS", "must name two URAs, got %d", len(c.XCPDLocations.Custodians))
+	register := provider.New(c.Providers, c.Identities)
+	for _, ura := range c.XCPDLocations.Custodians {
+		org, ok := register.Lookup(ura)
+		check(ok && org.OID != "", "xcpdLocations.custodians", "XCPD_CUSTODIANS", "%s is not a provider with an OID", ura)
+	}
+	for _, id := range slices.Concat(c.XCPDDevices.Senders, c.XCPDDevices.Receivers) {
+		check(validDeviceID(id), "xcpdDevices", "XCPD_SENDERS/XCPD_RECEIVERS", "%q is not a URA or OID", id)
+	}
+	_, err = parser.ParseStrictness(c.Parsing.Strictness)
+	check(err == nil, "parsing.strictness", "PARSE_STRICTNESS", "must be lenient, structure, namespaces or strict, got %q", c.Parsing.Strictness)
+	for client, level := range c.Parsing.Clients {
+		_, err := parser.ParseStrictness(level)
+		check(err == nil, "parsing.clients."+client, "PARSE_STRICTNESS_CLIENTS", "must be lenient, structure, namespaces or strict, got %q", level)
+	}
+	check(oneOf(c.Parsing.Validation, "lenient", "strict"), "parsing.validation", "VALIDATION_MODE", "must be lenient or strict, got %q", c.Parsing.Validation)
+	check(oneOf(c.Concurrency.Mode, "queue", "reject"), "concurrency.mode", "CONCURRENCY_MODE",
+		"must be queue or reject, got %q", c.Concurrency.Mode)
+	check(c.Concurrency.QueueTimeoutMs >= 0, "concurrency.queueTimeoutMs", "CONCURRENCY_QUEUE_TIMEOUT_MS", "must not be negative")
+	for route, delay := range c.Latency.Endpoints {
+		_, err := rules.ParseDelay(delay)
+		check(oneOf(route, "xacml", "xcpd", "fhir"), "latency.endpoints."+route, "LATENCY_ENDPOINTS", "unknown route (expected xacml, xcpd or fhir)")
+		check(err == nil, "latency.endpoints."+route, "LATENCY_ENDPOINTS", "%v", err)
+	}
+	for route, limit := range c.RateLimits.Limits {
+		_, err := ratelimit.ParseLimit(limit)
+		check(oneOf(route, "xacml", "xcpd", "fhir"), "rateLimits.limits."+route, "RATE_LIMITS", "unknown route (expected xacml, xcpd or fhir)")
+		check(err == nil, "rateLimits.limits."+route, "RATE_LIMITS", "%v", err)
+	}
+	for route, stream := range c.Streaming.Endpoints {
+		_, err := rules.ParseStream(stream)
+		check(oneOf(route, "xacml", "xcpd", "fhir"), "streaming.endpoints."+route, "STREAM_ENDPOINTS", "unknown route (expected xacml, xcpd or fhir)")
+		check(err == nil, "streaming.endpoints."+route, "STREAM_ENDPOINTS", "%v", err)
+	}
+	for route, bps := range c.Streaming.Bandwidth {
+		check(oneOf(route, "xacml", "xcpd", "fhir"), "streaming.bandwidth."+route, "BANDWIDTH_ENDPOINTS", "unknown route (expected xacml, xcpd or fhir)")
+		check(bps > 0, "streaming.bandwidth."+route, "BANDWIDTH_ENDPOINTS", "must be positive")
+	}
+	for client, bps := range c.Streaming.ClientBandwidth {
+		check(bps > 0, "streaming.clientBandwidth."+client, "BANDWIDTH_CLIENTS", "must be positive")
+	}
+	check(c.Latency.HeaderMaxMs > 0, "latency.headerMaxMs", "LATENCY_HEADER_MAX_MS", "must be positive")
+	check(c.Latency.HangMaxMs > 0, "latency.hangMaxMs", "HANG_MAX_MS", "must be positive")
+	check(c.Latency.BudgetMs >= 0, "latency.budgetMs", "LATENCY_BUDGET_MS", "must not be negative")
+	check(oneOf(c.Latency.BudgetMode, "flag", "enforce"), "latency.budgetMode", "LATENCY_BUDGET_MODE", "must be flag or enforce, got %q", c.Latency.BudgetMode)
+	check(c.Chaos.Rate >= 0 && c.Chaos.Rate <= 100, "chaos.rate", "CHAOS_RATE", "must be a percentage between 0 and 100")
+	for _, route := range c.Chaos.Routes {
+		check(oneOf(route, "xacml", "xcpd", "fhir"), "chaos.routes", "CHAOS_ROUTES", "unknown route %q (expected xacml, xcpd or fhir)", route)
+	}
+	for _, kind := range c.Chaos.Kinds {
+		check(oneOf(kind, "fault", "empty"), "chaos.kinds", "CHAOS_KINDS", "unknown kind %q (expected fault or empty)", kind)
+	}
+	check(c.Chaos.Rate == 0 || len(c.Chaos.Kinds) > 0, "chaos.kinds", "CHAOS_KINDS", "must not be empty when chaos.rate is set")
+	check(c.Chaos.Status >= 500 && c.Chaos.Status <= 599, "chaos.status", "CHAOS_STATUS", "must be a 5xx status, got %d", c.Chaos.Status)
+	for name, limit := range c.Concurrency.Limits {
+		check(limit >= 0, "concurrency.limits."+name, "CONCURRENCY_LIMITS", "must not be negative")
+	}
+	if c.Schedule.Timezone != "" {
+		_, err := time.LoadLocation(c.Schedule.Timezone)
+		check(err == nil, "schedule.timezone", "SCHEDULE_TIMEZONE", "unknown time zone %q", c.Schedule.Timezone)
+	}
+	check(oneOf(c.HeaderHygiene, "off", "strict"), "headerHygiene", "HEADER_HYGIENE",
+		"must be off or strict, got %q", c.HeaderHygiene)
+	check(oneOf(c.SOAPActions, "strict", "lenient"), "soapActions", "SOAP_ACTIONS",
+		"must be strict or lenient, got %q", c.SOAPActions)
+	check(oneOf(c.RequestTypes, "off", "strict"), "requestTypes", "REQUEST_CONTENT_TYPES",
+		"must be off or strict, got %q", c.RequestTypes)
+	check(c.MaxCategories >= 0, "maxCategories", "MAX_CATEGORIES", "must not be negative")
+	check(c.MaxInflatedMB > 0, "maxInflatedMB", "GZIP_MAX_INFLATED_MB", "must be positive")
+	check(c.ExportDelay >= 0, "exportDelay", "EXPORT_DELAY_SECONDS", "must not be negative")
+	check(c.CutoverPhase >= 0 && c.CutoverPhase <= 3, "cutoverPhase", "CUTOVER_PHASE",
+		"must be 0 (off), 1, 2 or 3, got %d", c.CutoverPhase)
+	check(oneOf(c.OTVTestcases, "tag", "enforce"), "otvTestcases", "OTV_TESTCASES",
+		"must be tag or enforce, got %q", c.OTVTestcases)
+	check(oneOf(c.FHIRVersion, "R4", "R4B"), "fhirVersion", "FHIR_VERSION",
+		"must be R4 or R4B, got %q", c.FHIRVersion)
+	if c.DeterministicSeed != "" {
+		_, err := strconv.ParseUint(c.DeterministicSeed, 10, 64)
+		check(err == nil, "deterministicSeed", "DETERMINISTIC_SEED", "must be an unsigned integer, got %q", c.DeterministicSeed)
+	}
+	check((c.Outbound.ClientCert == "") == (c.Outbound.ClientKey == ""), "outbound.clientCert/clientKey",
+		"OUTBOUND_CLIENT_CERT/OUTBOUND_CLIENT_KEY", "must be set together")
+	if c.Notifications.Enabled {
+		check(c.Notifications.TimeoutSeconds > 0, "notifications.timeoutSeconds", "NOTIFY_TIMEOUT_SECONDS", "must be positive")
+		check(c.Notifications.QueueSize > 0, "notifications.queueSize", "NOTIFY_QUEUE_SIZE", "must be positive")
+		check(c.Notifications.MaxAttempts > 0, "notifications.maxAttempts", "NOTIFY_MAX_ATTEMPTS", "must be positive")
+		check(c.Notifications.InitialBackoffMs >= 0 && c.Notifications.MaxBackoffMs >= c.Notifications.InitialBackoffMs,
+			"notifications.maxBackoffMs", "NOTIFY_MAX_BACKOFF_MS", "must be at least initialBackoffMs")
+		check(c.Notifications.AckTimeoutSeconds >= 0, "notifications.ackTimeoutSeconds", "NOTIFY_ACK_TIMEOUT_SECONDS", "must not be negative")
+	}
+	if c.Anomalies.Enabled {
+		check(c.Anomalies.Warmup >= 2, "anomalies.warmup", "ANOMALY_WARMUP", "must be at least 2")
+		check(c.Anomalies.Threshold > 0, "anomalies.threshold", "ANOMALY_THRESHOLD", "must be positive")
+	}
+	if c.Registry.URL != "" {
+		u, err := url.Parse(c.Registry.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "registry.url", "REGISTRY_URL",
+			"must be an http(s) URL, got %q", c.Registry.URL)
+		check(c.Registry.IntervalSeconds > 0, "registry.intervalSeconds", "REGISTRY_INTERVAL_SECONDS", "must be positive")
+	}
+	poolsErr := bsnpool.Validate(c.BSNPools.Pools)
+	check(poolsErr == nil, "bsnPools.pools", "BSN_POOLS", "%v", poolsErr)
+	check(c.BSNPools.TTLSeconds > 0, "bsnPools.ttlSeconds", "BSN_RESERVATION_TTL_SECONDS", "must be positive")
+	check(c.Templates.WatchSeconds >= 0, "templates.watchSeconds", "TEMPLATE_WATCH_SECONDS", "must not be negative")
+
+	for _, bsn := range slices.Sorted(maps.Keys(c.DeceasedBSNs)) {
+		check(len(bsn) == 9 && strings.Trim(bsn, "0123456789") == "", "deceasedBsns", "DECEASED_BSNS",
+			"%q is not a nine-digit BSN", bsn)
+		if date := c.DeceasedBSNs[bsn]; date != "" {
+			_, err := time.Parse(time.DateOnly, date)
+			check(err == nil, "deceasedBsns", "DECEASED_BSNS", "%s: invalid date of death %q (expected YYYY-MM-DD)", bsn, date)
+		}
+	}
+	if err := rules.ValidateMagicBSNs(c.MagicBSNs); err != nil {
+		errs = append(errs, fmt.Errorf("magicBsns (MAGIC_BSNS): %w", err))
+	}
+	if insecure := c.InsecureSettings(); strings.EqualFold(c.Environment, acceptanceEnvironment) {
+		check(!c.InsecureLabMode, "insecureLabMode", "INSECURE_LAB_MODE", "cannot be used in the %s environment", c.Environment)
+		check(len(insecure) == 0, "environment", "ENVIRONMENT", "%s refuses insecure settings: %s", c.Environment, strings.Join(insecure, ", "))
+	} else {
+		check(c.InsecureLabMode || len(insecure) == 0, "insecureLabMode", "INSECURE_LAB_MODE",
+			"must be true to use insecure settings: %s", strings.Join(insecure, ", "))
+	}
+	if err := rules.Validate(c.Rules); err != nil {
+		errs = append(errs, fmt.Errorf("rules: %w", err))
+	}
+	if err := identity.Validate(c.Identities); err != nil {
+		errs = append(errs, fmt.Errorf("identities: %w", err))
+	}
+	if err := provider.Validate(c.Providers); err != nil {
+		errs = append(errs, fmt.Errorf("providers: %w", err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func oneOf(value string, allowed ...string) bool {
+	return slices.Contains(allowed, value)
+}
+
+// validDeviceID reports whether id is a URA (digits) or an OID (dot-separated digits).
+func validDeviceID(id string) bool {
+	for part := range strings.SplitSeq(id, ".") {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
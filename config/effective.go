@@ -0,0 +1,32 @@
+package config
+
+import (
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// redacted replaces secrets in printed configurations.
+const redacted = "<redacted>"
+
+// Redacted returns c with its secrets (the admin and registry tokens) replaced, so it
+// can be printed or logged.
+func (c Config) Redacted() Config {
+	if c.Admin.Token != "" {
+		c.Admin.Token = redacted
+	}
+	if c.Registry.Token != "" {
+		c.Registry.Token = redacted
+	}
+	return c
+}
+
+// YAML renders c in the configuration file format Load reads. Settings whose default
+// depends on other settings are written out resolved.
+func (c Config) YAML() ([]byte, error) {
+	if c.Store.SessionIdleSeconds == nil {
+		idle := int(c.Store.SessionIdle() / time.Second)
+		c.Store.SessionIdleSeconds = &idle
+	}
+	return yaml.Marshal(c)
+}
@@ -0,0 +1,277 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"mitz-replicator/rules"
+)
+
+// envReader applies environment overrides, collecting parse errors.
+type envReader struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (r *envReader) string(dst *string, key string) {
+	if v, ok := r.lookup(key); ok {
+		*dst = v
+	}
+}
+
+func (r *envReader) bool(dst *bool, key string) {
+	if v, ok := r.lookup(key); ok {
+		// Matches the historic behaviour: only the literal "true" enables a flag.
+		*dst = v == "true"
+	}
+}
+
+func (r *envReader) int(dst *int, key string) {
+	v, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s: expected an integer, got %q", key, v))
+		return
+	}
+	*dst = n
+}
+
+// optionalInt is int for a setting whose default depends on other settings.
+func (r *envReader) optionalInt(dst **int, key string) {
+	if _, ok := r.lookup(key); !ok {
+		return
+	}
+	var n int
+	r.int(&n, key)
+	*dst = &n
+}
+
+func (r *envReader) list(dst *[]string, key string) {
+	v, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	*dst = nil
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*dst = append(*dst, part)
+		}
+	}
+}
+
+func (r *envReader) limits(dst *map[string]int, key string) {
+	v, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	limits, err := ParseLimits(v)
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s: %w", key, err))
+		return
+	}
+	*dst = limits
+}
+
+func (r *envReader) pairs(dst *map[string]string, key string) {
+	v, ok := r.lookup(key)
+	if !ok {
+		return
+	}
+	*dst = map[string]string{}
+	for _, part := range strings.Split(v, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			if strings.TrimSpace(part) != "" {
+				r.errs = append(r.errs, fmt.Errorf("%s: expected name=value, got %q", key, part))
+			}
+			continue
+		}
+		(*dst)[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+}
+
+// magicBSNs replaces dst with "<built-in>=<bsn>" pairs, keeping descriptions from the file.
+func (r *envReader) magicBSNs(dst *map[string]rules.MagicBSN, key string) {
+	var bsns map[string]string
+	r.pairs(&bsns, key)
+	if bsns == nil {
+		return
+	}
+	magic := make(map[string]rules.MagicBSN, len(bsns))
+	for builtin, bsn := range bsns {
+		magic[builtin] = rules.MagicBSN{BSN: bsn, Description: (*dst)[builtin].Description}
+	}
+	*dst = magic
+}
+
+// applyEnv overrides c with every environment variable that is set.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	r := &envReader{lookup: lookup}
+
+	r.string(&c.Server.Port, "PORT")
+	r.string(&c.Server.Listen, "LISTEN")
+	r.string(&c.Server.Cert, "SERVER_CERT")
+	r.string(&c.Server.Key, "SERVER_KEY")
+	r.string(&c.Server.CACert, "CA_CERT")
+	r.string(&c.Server.CAKey, "CA_KEY")
+	r.bool(&c.Server.MTLS, "MTLS_ENABLED")
+	r.string(&c.Server.MTLSMode, "MTLS_MODE")
+	r.list(&c.Server.TrustedProxies, "TRUSTED_PROXIES")
+
+	r.bool(&c.Connections.HTTP2, "HTTP2_ENABLED")
+	r.int(&c.Connections.MaxConcurrentStreams, "HTTP2_MAX_CONCURRENT_STREAMS")
+	r.bool(&c.Connections.KeepAlives, "KEEP_ALIVES_ENABLED")
+	r.int(&c.Connections.IdleTimeoutSeconds, "IDLE_TIMEOUT_SECONDS")
+	r.int(&c.Connections.ReadHeaderTimeoutSeconds, "READ_HEADER_TIMEOUT_SECONDS")
+
+	r.bool(&c.SAML.Enabled, "SAML_VALIDATION_ENABLED")
+	r.string(&c.SAML.SigningCert, "SAML_SIGNING_CERT")
+	r.string(&c.SAML.ExpectedIssuer, "SAML_EXPECTED_ISSUER")
+	r.int(&c.SAML.ClockSkewSeconds, "SAML_CLOCK_SKEW_SECONDS")
+
+	r.bool(&c.WSSecurity.Enabled, "WSSECURITY_ENABLED")
+	r.string(&c.WSSecurity.TrustedCerts, "WSSECURITY_TRUSTED_CERTS")
+	r.int(&c.WSSecurity.ClockSkewSeconds, "WSSECURITY_CLOCK_SKEW_SECONDS")
+
+	r.string(&c.Store.Driver, "STORE_DRIVER")
+	r.string(&c.Store.DSN, "STORE_DSN")
+	r.int(&c.Store.MaxRequests, "STORE_MAX_REQUESTS")
+	r.int(&c.Store.MaxEvents, "STORE_MAX_EVENTS")
+	r.bool(&c.Store.SessionIsolation, "SESSION_ISOLATION")
+	r.optionalInt(&c.Store.SessionIdleSeconds, "SESSION_IDLE_SECONDS")
+
+	r.int(&c.Subscriptions.Quota, "SUBSCRIPTION_QUOTA")
+
+	r.string(&c.XCPDLocations.Source, "XCPD_LOCATIONS")
+	r.int(&c.XCPDLocations.Max, "XCPD_MAX_LOCATIONS")
+	r.int(&c.XCPDLocations.PageSize, "XCPD_PAGE_SIZE")
+	r.list(&c.XCPDLocations.Custodians, "XCPD_CUSTODIANS")
+	r.list(&c.XCPDDevices.Senders, "XCPD_SENDERS")
+	r.list(&c.XCPDDevices.Receivers, "XCPD_RECEIVERS")
+
+	r.string(&c.Parsing.Strictness, "PARSE_STRICTNESS")
+	r.pairs(&c.Parsing.Clients, "PARSE_STRICTNESS_CLIENTS")
+	r.string(&c.Parsing.Validation, "VALIDATION_MODE")
+
+	r.string(&c.ContentTypes.SOAP, "SOAP_CONTENT_TYPE")
+	r.string(&c.ContentTypes.FHIR, "FHIR_CONTENT_TYPE")
+
+	r.limits(&c.Concurrency.Limits, "CONCURRENCY_LIMITS")
+	r.string(&c.Concurrency.Mode, "CONCURRENCY_MODE")
+	r.int(&c.Concurrency.QueueTimeoutMs, "CONCURRENCY_QUEUE_TIMEOUT_MS")
+
+	r.pairs(&c.Latency.Endpoints, "LATENCY_ENDPOINTS")
+	r.bool(&c.Latency.Header, "LATENCY_HEADER")
+	r.int(&c.Latency.HeaderMaxMs, "LATENCY_HEADER_MAX_MS")
+	r.int(&c.Latency.HangMaxMs, "HANG_MAX_MS")
+	r.int(&c.Latency.BudgetMs, "LATENCY_BUDGET_MS")
+	r.string(&c.Latency.BudgetMode, "LATENCY_BUDGET_MODE")
+
+	r.pairs(&c.Streaming.Endpoints, "STREAM_ENDPOINTS")
+	r.limits(&c.Streaming.Bandwidth, "BANDWIDTH_ENDPOINTS")
+	r.limits(&c.Streaming.ClientBandwidth, "BANDWIDTH_CLIENTS")
+
+	r.pairs(&c.RateLimits.Limits, "RATE_LIMITS")
+	r.string(&c.RateLimits.Header, "RATE_LIMIT_HEADER")
+
+	r.int(&c.Chaos.Rate, "CHAOS_RATE")
+	r.list(&c.Chaos.Routes, "CHAOS_ROUTES")
+	r.list(&c.Chaos.Kinds, "CHAOS_KINDS")
+	r.int(&c.Chaos.Status, "CHAOS_STATUS")
+
+	r.list(&c.Schedule.Windows, "SCHEDULE_WINDOWS")
+	r.string(&c.Schedule.Timezone, "SCHEDULE_TIMEZONE")
+
+	r.string(&c.HeaderHygiene, "HEADER_HYGIENE")
+	r.string(&c.SOAPActions, "SOAP_ACTIONS")
+	r.string(&c.RequestTypes, "REQUEST_CONTENT_TYPES")
+	r.int(&c.MaxCategories, "MAX_CATEGORIES")
+	r.int(&c.MaxInflatedMB, "GZIP_MAX_INFLATED_MB")
+	r.int(&c.ExportDelay, "EXPORT_DELAY_SECONDS")
+	r.int(&c.CutoverPhase, "CUTOVER_PHASE")
+	r.bool(&c.ReadOnly, "READ_ONLY")
+	r.string(&c.Environment, "ENVIRONMENT")
+	r.bool(&c.InsecureLabMode, "INSECURE_LAB_MODE")
+	r.string(&c.OTVTestcases, "OTV_TESTCASES")
+	r.string(&c.FHIRVersion, "FHIR_VERSION")
+	r.string(&c.DeterministicSeed, "DETERMINISTIC_SEED")
+
+	r.bool(&c.Signing.Notifications, "SIGN_NOTIFICATIONS")
+	r.bool(&c.Signing.SubscriptionResponses, "SIGN_SUBSCRIPTION_RESPONSES")
+	r.bool(&c.Signing.Responses, "SIGN_RESPONSES")
+	r.string(&c.Signing.Cert, "SIGNING_CERT")
+	r.string(&c.Signing.Key, "SIGNING_KEY")
+
+	r.string(&c.Outbound.ClientCert, "OUTBOUND_CLIENT_CERT")
+	r.string(&c.Outbound.ClientKey, "OUTBOUND_CLIENT_KEY")
+	r.string(&c.Outbound.CACert, "OUTBOUND_CA_CERT")
+	r.bool(&c.Outbound.InsecureSkipVerify, "OUTBOUND_INSECURE_SKIP_VERIFY")
+	r.string(&c.Outbound.Proxy, "OUTBOUND_PROXY")
+	r.list(&c.Outbound.NoProxy, "OUTBOUND_NO_PROXY")
+
+	r.bool(&c.Notifications.Enabled, "NOTIFY_ENABLED")
+	r.int(&c.Notifications.TimeoutSeconds, "NOTIFY_TIMEOUT_SECONDS")
+	r.int(&c.Notifications.QueueSize, "NOTIFY_QUEUE_SIZE")
+	r.int(&c.Notifications.MaxAttempts, "NOTIFY_MAX_ATTEMPTS")
+	r.int(&c.Notifications.InitialBackoffMs, "NOTIFY_INITIAL_BACKOFF_MS")
+	r.int(&c.Notifications.MaxBackoffMs, "NOTIFY_MAX_BACKOFF_MS")
+	r.int(&c.Notifications.AckTimeoutSeconds, "NOTIFY_ACK_TIMEOUT_SECONDS")
+
+	r.string(&c.Artifacts.Dir, "ARTIFACTS_DIR")
+
+	r.string(&c.Decisions.Matrix, "DECISION_MATRIX")
+	r.string(&c.Decisions.Plugin, "DECISION_PLUGIN")
+	r.magicBSNs(&c.MagicBSNs, "MAGIC_BSNS")
+	r.pairs(&c.DeceasedBSNs, "DECEASED_BSNS")
+
+	r.bool(&c.Anomalies.Enabled, "ANOMALY_DETECTION")
+	r.int(&c.Anomalies.Warmup, "ANOMALY_WARMUP")
+	r.int(&c.Anomalies.Threshold, "ANOMALY_THRESHOLD")
+
+	r.string(&c.Admin.Token, "ADMIN_TOKEN")
+	r.bool(&c.Admin.Pprof, "ADMIN_PPROF")
+
+	r.bool(&c.Debug.Timing, "DEBUG_TIMING")
+
+	r.string(&c.Registry.URL, "REGISTRY_URL")
+	r.string(&c.Registry.Token, "REGISTRY_TOKEN")
+	r.int(&c.Registry.IntervalSeconds, "REGISTRY_INTERVAL_SECONDS")
+	r.string(&c.Registry.InstanceID, "REGISTRY_INSTANCE_ID")
+	r.string(&c.Registry.InstanceURL, "REGISTRY_INSTANCE_URL")
+	r.list(&c.Registry.Tenants, "REGISTRY_TENANTS")
+
+	r.pairs(&c.BSNPools.Pools, "BSN_POOLS")
+	r.int(&c.BSNPools.TTLSeconds, "BSN_RESERVATION_TTL_SECONDS")
+
+	r.string(&c.Templates.Dir, "TEMPLATE_DIR")
+	r.list(&c.Templates.Overlays, "TEMPLATE_OVERLAYS")
+	r.string(&c.Templates.Version, "MITZ_VERSION")
+	r.int(&c.Templates.WatchSeconds, "TEMPLATE_WATCH_SECONDS")
+
+	if len(r.errs) > 0 {
+		return fmt.Errorf("invalid environment:\n%w", errors.Join(r.errs...))
+	}
+	return nil
+}
+
+// ParseLimits parses "xacml=5,xcpd=2" into a map of endpoint → limit.
+func ParseLimits(spec string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit %q for %s", value, name)
+		}
+		limits[strings.TrimSpace(name)] = n
+	}
+	return limits, nil
+}
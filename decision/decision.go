@@ -0,0 +1,98 @@
+// Package decision loads organisation-specific XACML decision logic at runtime, so
+// a hospital with bespoke consent rules can simulate them without forking the
+// replicator. The logic is a Go plugin (go build -buildmode=plugin) exporting a
+// variable named Decider that implements the Decider interface.
+package decision
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"strings"
+)
+
+// Symbol is the name of the variable a plugin exports.
+const Symbol = "Decider"
+
+var decisions = map[string]string{
+	"permit":        "Permit",
+	"deny":          "Deny",
+	"indeterminate": "Indeterminate",
+	"notapplicable": "NotApplicable",
+}
+
+// Request is one requested gegevenscategorie of a gesloten autorisatievraag.
+type Request struct {
+	BSN      string
+	Category string // event code as requested, e.g. "2.16.840.1.113883.2.4.3.111.5.10.1^ggz"
+	URA      string // of the requesting organisation's client certificate; "" when unknown
+	Decision string // the replicator's own decision: Permit, Deny, Indeterminate or NotApplicable
+}
+
+// Decider is the interface a decision plugin implements. Decide returns the decision
+// for the request, or false to keep req.Decision. It is called concurrently.
+type Decider interface {
+	Decide(req Request) (decision string, ok bool)
+}
+
+// Plugin is a loaded decision plugin.
+type Plugin struct {
+	name    string
+	decider Decider
+}
+
+// Load opens the Go plugin at path and looks up its Decider. The plugin must be built
+// with the same Go version and module versions as the replicator; Go cannot unload a
+// plugin, so a rebuilt one is only picked up by a new process.
+func Load(path string) (*Plugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	var d Decider
+	switch s := sym.(type) {
+	case *Decider:
+		d = *s
+	case Decider:
+		d = s
+	}
+	if d == nil {
+		return nil, fmt.Errorf("%s: %s is a %T, which does not implement decision.Decider", path, Symbol, sym)
+	}
+	return New(filepath.Base(path), d), nil
+}
+
+// New wraps a Decider, e.g. one linked into a custom build of the replicator.
+func New(name string, d Decider) *Plugin {
+	return &Plugin{name: name, decider: d}
+}
+
+// Name returns the file name of the plugin.
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// Decide asks the plugin for a decision. The decision is normalised to Permit, Deny,
+// Indeterminate or NotApplicable; anything else, or a panic in the plugin, is an error.
+func (p *Plugin) Decide(req Request) (decision string, ok bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			decision, ok, err = "", false, fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	d, ok := p.decider.Decide(req)
+	if !ok {
+		return "", false, nil
+	}
+	normalized, known := decisions[strings.ToLower(strings.TrimSpace(d))]
+	if !known {
+		return "", false, fmt.Errorf("unknown decision %q (expected Permit, Deny, Indeterminate or NotApplicable)", d)
+	}
+	return normalized, true, nil
+}
@@ -0,0 +1,35 @@
+// Command example is a decision plugin: a hospital policy that only shares mental
+// health data (ggz) with organisations on an allowlist. Build it with
+//
+//	go build -buildmode=plugin -o ggz.so ./decision/example
+//
+// and start the replicator with DECISION_PLUGIN=ggz.so.
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"mitz-replicator/decision"
+)
+
+// trustedURAs may receive ggz data when the patient has not refused it.
+var trustedURAs = []string{"00001111", "12345678"}
+
+type ggzPolicy struct{}
+
+func (ggzPolicy) Decide(req decision.Request) (string, bool) {
+	code := req.Category[strings.LastIndex(req.Category, "^")+1:]
+	if !strings.EqualFold(code, "ggz") || req.Decision != "Permit" {
+		return "", false
+	}
+	if !slices.Contains(trustedURAs, req.URA) {
+		return "Deny", true
+	}
+	return "", false
+}
+
+// Decider is the symbol the replicator looks up.
+var Decider decision.Decider = ggzPolicy{}
+
+func main() {}
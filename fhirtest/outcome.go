@@ -0,0 +1,185 @@
+// Package fhirtest helps Go test suites assert on FHIR OperationOutcome responses
+// (XML or JSON) without string matching.
+package fhirtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Issue is one OperationOutcome.issue.
+type Issue struct {
+	Severity    string
+	Code        string
+	Details     string // details.text
+	Diagnostics string
+	Expression  []string
+}
+
+// OperationOutcome is a parsed FHIR OperationOutcome.
+type OperationOutcome struct {
+	Issues []Issue
+}
+
+// HasIssue reports whether the outcome contains an issue with the given code and
+// severity. An empty severity matches any severity.
+func (o *OperationOutcome) HasIssue(code, severity string) bool {
+	_, ok := o.Issue(code, severity)
+	return ok
+}
+
+// Issue returns the first issue with the given code and severity (empty matches any).
+func (o *OperationOutcome) Issue(code, severity string) (Issue, bool) {
+	for _, issue := range o.Issues {
+		if issue.Code == code && (severity == "" || issue.Severity == severity) {
+			return issue, true
+		}
+	}
+	return Issue{}, false
+}
+
+// HasDiagnostics reports whether any issue's diagnostics contain substr.
+func (o *OperationOutcome) HasDiagnostics(substr string) bool {
+	for _, issue := range o.Issues {
+		if strings.Contains(issue.Diagnostics, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsError reports whether any issue has severity error or fatal.
+func (o *OperationOutcome) IsError() bool {
+	for _, issue := range o.Issues {
+		if issue.Severity == "error" || issue.Severity == "fatal" {
+			return true
+		}
+	}
+	return false
+}
+
+// String summarises the issues for test failure messages.
+func (o *OperationOutcome) String() string {
+	parts := make([]string, len(o.Issues))
+	for i, issue := range o.Issues {
+		parts[i] = fmt.Sprintf("%s/%s: %s", issue.Severity, issue.Code, issue.Diagnostics)
+	}
+	return "OperationOutcome[" + strings.Join(parts, "; ") + "]"
+}
+
+// Parse decodes an OperationOutcome in FHIR XML or JSON format.
+func Parse(body []byte) (*OperationOutcome, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseJSON(trimmed)
+	}
+	return parseXML(trimmed)
+}
+
+// MustParse is Parse for tests: it fails t if body is not an OperationOutcome.
+func MustParse(t testing.TB, body []byte) *OperationOutcome {
+	t.Helper()
+
+	outcome, err := Parse(body)
+	if err != nil {
+		t.Fatalf("fhirtest: %v\nbody: %s", err, body)
+	}
+	return outcome
+}
+
+// AssertIssue fails t unless body is an OperationOutcome with an issue of the given
+// code and severity (empty severity matches any).
+func AssertIssue(t testing.TB, body []byte, code, severity string) Issue {
+	t.Helper()
+
+	outcome := MustParse(t, body)
+	issue, ok := outcome.Issue(code, severity)
+	if !ok {
+		t.Fatalf("fhirtest: no issue with code %q severity %q in %s", code, severity, outcome)
+	}
+	return issue
+}
+
+// --- XML ---
+
+type valueXML struct {
+	Value string `xml:"value,attr"`
+}
+
+type issueXML struct {
+	Severity valueXML `xml:"severity"`
+	Code     valueXML `xml:"code"`
+	Details  struct {
+		Text valueXML `xml:"text"`
+	} `xml:"details"`
+	Diagnostics valueXML   `xml:"diagnostics"`
+	Expression  []valueXML `xml:"expression"`
+}
+
+type operationOutcomeXML struct {
+	XMLName xml.Name   `xml:"OperationOutcome"`
+	Issues  []issueXML `xml:"issue"`
+}
+
+func parseXML(body []byte) (*OperationOutcome, error) {
+	var doc operationOutcomeXML
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("not an OperationOutcome: %w", err)
+	}
+
+	outcome := &OperationOutcome{}
+	for _, in := range doc.Issues {
+		issue := Issue{
+			Severity:    in.Severity.Value,
+			Code:        in.Code.Value,
+			Details:     in.Details.Text.Value,
+			Diagnostics: in.Diagnostics.Value,
+		}
+		for _, expr := range in.Expression {
+			issue.Expression = append(issue.Expression, expr.Value)
+		}
+		outcome.Issues = append(outcome.Issues, issue)
+	}
+	return outcome, nil
+}
+
+// --- JSON ---
+
+type operationOutcomeJSON struct {
+	ResourceType string `json:"resourceType"`
+	Issue        []struct {
+		Severity string `json:"severity"`
+		Code     string `json:"code"`
+		Details  struct {
+			Text string `json:"text"`
+		} `json:"details"`
+		Diagnostics string   `json:"diagnostics"`
+		Expression  []string `json:"expression"`
+	} `json:"issue"`
+}
+
+func parseJSON(body []byte) (*OperationOutcome, error) {
+	var doc operationOutcomeJSON
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("not an OperationOutcome: %w", err)
+	}
+	if doc.ResourceType != "OperationOutcome" {
+		return nil, fmt.Errorf("not an OperationOutcome: resourceType is %q", doc.ResourceType)
+	}
+
+	outcome := &OperationOutcome{}
+	for _, in := range doc.Issue {
+		outcome.Issues = append(outcome.Issues, Issue{
+			Severity:    in.Severity,
+			Code:        in.Code,
+			Details:     in.Details.Text,
+			Diagnostics: in.Diagnostics,
+			Expression:  in.Expression,
+		})
+	}
+	return outcome, nil
+}
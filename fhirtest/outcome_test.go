@@ -0,0 +1,97 @@
+package fhirtest_test
+
+import (
+	"slices"
+	"testing"
+
+	"mitz-replicator/fhirtest"
+)
+
+const outcomeXML = `<?xml version="1.0" encoding="UTF-8"?>
+<OperationOutcome xmlns="http://hl7.org/fhir">
+  <issue>
+    <severity value="error"/>
+    <code value="invalid"/>
+    <details><text value="Invalid BSN"/></details>
+    <diagnostics value="BSN 123 fails the elfproef"/>
+    <expression value="Consent.patient"/>
+  </issue>
+  <issue>
+    <severity value="warning"/>
+    <code value="informational"/>
+  </issue>
+</OperationOutcome>`
+
+const outcomeJSON = `{
+  "resourceType": "OperationOutcome",
+  "issue": [
+    {"severity": "error", "code": "invalid", "details": {"text": "Invalid BSN"},
+     "diagnostics": "BSN 123 fails the elfproef", "expression": ["Consent.patient"]},
+    {"severity": "warning", "code": "informational"}
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	for name, body := range map[string]string{"xml": outcomeXML, "json": outcomeJSON} {
+		t.Run(name, func(t *testing.T) {
+			outcome, err := fhirtest.Parse([]byte(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(outcome.Issues) != 2 {
+				t.Fatalf("got %d issues, want 2: %s", len(outcome.Issues), outcome)
+			}
+			issue, ok := outcome.Issue("invalid", "error")
+			if !ok {
+				t.Fatalf("no error-level invalid issue in %s", outcome)
+			}
+			if issue.Details != "Invalid BSN" || issue.Diagnostics != "BSN 123 fails the elfproef" {
+				t.Errorf("details %q, diagnostics %q", issue.Details, issue.Diagnostics)
+			}
+			if !slices.Equal(issue.Expression, []string{"Consent.patient"}) {
+				t.Errorf("expression %q, want [Consent.patient]", issue.Expression)
+			}
+			if !outcome.IsError() || !outcome.HasDiagnostics("elfproef") {
+				t.Errorf("IsError or HasDiagnostics false for %s", outcome)
+			}
+		})
+	}
+}
+
+func TestHasIssueMismatch(t *testing.T) {
+	outcome := fhirtest.MustParse(t, []byte(outcomeXML))
+
+	tests := []struct {
+		code, severity string
+		want           bool
+	}{
+		{"invalid", "error", true},
+		{"invalid", "", true},
+		{"informational", "warning", true},
+		{"invalid", "warning", false},
+		{"informational", "error", false},
+		{"throttled", "", false},
+	}
+	for _, tt := range tests {
+		if got := outcome.HasIssue(tt.code, tt.severity); got != tt.want {
+			t.Errorf("HasIssue(%q, %q) = %v, want %v", tt.code, tt.severity, got, tt.want)
+		}
+	}
+
+	warnings := fhirtest.MustParse(t, []byte(`{"resourceType": "OperationOutcome", "issue": [{"severity": "warning", "code": "informational"}]}`))
+	if warnings.IsError() {
+		t.Errorf("IsError true for %s", warnings)
+	}
+}
+
+func TestParseRejectsOtherResources(t *testing.T) {
+	for name, body := range map[string]string{
+		"json bundle": `{"resourceType": "Bundle", "type": "searchset"}`,
+		"xml bundle":  `<Bundle xmlns="http://hl7.org/fhir"><type value="searchset"/></Bundle>`,
+		"not xml":     `OperationOutcome`,
+	} {
+		if outcome, err := fhirtest.Parse([]byte(body)); err == nil {
+			t.Errorf("%s: parsed as %s, want an error", name, outcome)
+		}
+	}
+}
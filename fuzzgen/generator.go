@@ -0,0 +1,214 @@
+// Package fuzzgen generates well-formed and mutated XACML, XCPD and FHIR
+// request payloads for exercising the replicator's own parsers.
+package fuzzgen
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+)
+
+// Kind identifies the request format a payload was generated for.
+type Kind string
+
+const (
+	KindXACML            Kind = "xacml"
+	KindXCPD             Kind = "xcpd"
+	KindFhirSubscription Kind = "fhir-subscription"
+	KindFhirBundle       Kind = "fhir-bundle"
+)
+
+// Kinds lists every payload kind in a stable order.
+var Kinds = []Kind{KindXACML, KindXCPD, KindFhirSubscription, KindFhirBundle}
+
+var categories = []string{"huisartsgegevens", "medicatiegegevens", "labuitslagen", "beeldvorming", "opnamegegevens"}
+
+var attrValue = regexp.MustCompile(`(\b(?:value|extension|root)=)"[^"]*"`)
+
+// Generator produces pseudo-random payloads. The same seed yields the same sequence.
+type Generator struct {
+	rng *rand.Rand
+}
+
+// New returns a generator seeded with seed.
+func New(seed uint64) *Generator {
+	return &Generator{rng: rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))}
+}
+
+// Payload returns a valid payload of the given kind, mutated with probability mutateRate.
+func (g *Generator) Payload(kind Kind, mutateRate float64) []byte {
+	var body []byte
+	switch kind {
+	case KindXACML:
+		body = g.XACML()
+	case KindXCPD:
+		body = g.XCPD()
+	case KindFhirSubscription:
+		body = g.FhirSubscription()
+	default:
+		body = g.FhirBundle()
+	}
+
+	if g.rng.Float64() < mutateRate {
+		body = g.Mutate(body)
+	}
+	return body
+}
+
+// XACML returns a well-formed XACMLAuthzDecisionQuery SOAP envelope.
+func (g *Generator) XACML() []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope">
+  <soap:Body>
+    <xacml-samlp:XACMLAuthzDecisionQuery xmlns:xacml-samlp="urn:oasis:names:tc:xacml:3.0:profile:saml2.0:v2:schema:protocol" xmlns:xacml-context="urn:oasis:names:tc:xacml:3.0:core:schema:wd-17">
+      <xacml-context:Request>
+        <xacml-context:Attributes Category="urn:oasis:names:tc:xacml:3.0:attribute-category:resource">
+          <xacml-context:Attribute AttributeId="urn:oasis:names:tc:xacml:2.0:resource:resource-id">
+            <xacml-context:AttributeValue>` + g.bsn() + `</xacml-context:AttributeValue>
+          </xacml-context:Attribute>
+        </xacml-context:Attributes>
+        <xacml-context:Attributes Category="urn:oasis:names:tc:xacml:3.0:attribute-category:action">
+          <xacml-context:Attribute AttributeId="urn:ihe:iti:appc:2016:document-entry:event-code">
+            <xacml-context:AttributeValue>2.16.840.1.113883.2.4.3.111.5.10.1^` + g.category() + `</xacml-context:AttributeValue>
+          </xacml-context:Attribute>
+        </xacml-context:Attributes>
+      </xacml-context:Request>
+    </xacml-samlp:XACMLAuthzDecisionQuery>
+  </soap:Body>
+</soap:Envelope>`)
+}
+
+// XCPD returns a well-formed PRPA_IN201305UV02 SOAP envelope.
+func (g *Generator) XCPD() []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope">
+  <soap:Body>
+    <PRPA_IN201305UV02 xmlns="urn:hl7-org:v3">
+      <acceptAckCode code="AL"/>
+      <sender typeCode="SND"><device classCode="DEV" determinerCode="INSTANCE"><id root="` + g.digits(8) + `"/></device></sender>
+      <controlActProcess classCode="CACT" moodCode="EVN">
+        <queryByParameter><parameterList><livingSubjectId><value root="2.16.840.1.113883.2.4.6.3" extension="` + g.bsn() + `"/></livingSubjectId></parameterList></queryByParameter>
+      </controlActProcess>
+    </PRPA_IN201305UV02>
+  </soap:Body>
+</soap:Envelope>`)
+}
+
+// FhirSubscription returns a well-formed FHIR Subscription resource.
+func (g *Generator) FhirSubscription() []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Subscription xmlns="http://hl7.org/fhir">
+  <status value="requested"/>
+  <criteria value="Consent?_query=otv&amp;patientid=` + g.bsn() + `&amp;providerid=` + g.digits(8) + `&amp;providertype=Z3"/>
+  <channel>
+    <type value="rest-hook"/>
+    <endpoint value="https://localhost:9999/fhir/notificatie"/>
+    <payload value="application/fhir+xml"/>
+  </channel>
+</Subscription>`)
+}
+
+// FhirBundle returns a well-formed FHIR transaction Bundle registering one Consent.
+func (g *Generator) FhirBundle() []byte {
+	var provisions strings.Builder
+	for range g.rng.IntN(3) + 1 {
+		fmt.Fprintf(&provisions, `
+          <provision><code><coding><system value="2.16.840.1.113883.2.4.3.111.5.10.1"/><code value="%s"/></coding></code></provision>`, g.category())
+	}
+
+	decision := "permit"
+	if g.rng.IntN(2) == 0 {
+		decision = "deny"
+	}
+
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Bundle xmlns="http://hl7.org/fhir">
+  <type value="transaction"/>
+  <entry>
+    <resource><Patient><identifier><system value="http://fhir.nl/fhir/NamingSystem/bsn"/><value value="` + g.bsn() + `"/></identifier></Patient></resource>
+    <request><method value="POST"/><url value="Patient"/></request>
+  </entry>
+  <entry>
+    <resource><Organization><identifier><system value="http://fhir.nl/fhir/NamingSystem/ura"/><value value="` + g.digits(8) + `"/></identifier></Organization></resource>
+    <request><method value="POST"/><url value="Organization"/></request>
+  </entry>
+  <entry>
+    <resource>
+      <Consent>
+        <status value="active"/>
+        <provision>
+          <type value="` + decision + `"/>` + provisions.String() + `
+        </provision>
+      </Consent>
+    </resource>
+    <request><method value="POST"/><url value="Consent"/></request>
+  </entry>
+</Bundle>`)
+}
+
+// Mutate applies one to three random structural or byte-level mutations to body.
+func (g *Generator) Mutate(body []byte) []byte {
+	out := bytes.Clone(body)
+	for range g.rng.IntN(3) + 1 {
+		if len(out) == 0 {
+			break
+		}
+		switch g.rng.IntN(7) {
+		case 0: // truncate
+			out = out[:g.rng.IntN(len(out))]
+		case 1: // flip a byte
+			out[g.rng.IntN(len(out))] ^= byte(1 << g.rng.IntN(8))
+		case 2: // insert junk
+			junk := []string{"<", ">", "&", "]]>", "<!--", "\x00", "&bogus;", "<x>", "\xff\xfe"}
+			at := g.rng.IntN(len(out) + 1)
+			out = append(out[:at], append([]byte(junk[g.rng.IntN(len(junk))]), out[at:]...)...)
+		case 3: // drop a line
+			out = g.dropLine(out)
+		case 4: // duplicate a line
+			out = g.duplicateLine(out)
+		case 5: // empty every attribute value
+			out = attrValue.ReplaceAll(out, []byte(`$1""`))
+		case 6: // strip namespaces
+			out = bytes.ReplaceAll(out, []byte("xmlns"), []byte("xmlnz"))
+		}
+	}
+	return out
+}
+
+func (g *Generator) dropLine(body []byte) []byte {
+	lines := bytes.Split(body, []byte("\n"))
+	if len(lines) < 2 {
+		return body
+	}
+	i := g.rng.IntN(len(lines))
+	return bytes.Join(append(lines[:i:i], lines[i+1:]...), []byte("\n"))
+}
+
+func (g *Generator) duplicateLine(body []byte) []byte {
+	lines := bytes.Split(body, []byte("\n"))
+	i := g.rng.IntN(len(lines))
+	dup := append(lines[:i+1:i+1], lines[i:]...)
+	return bytes.Join(dup, []byte("\n"))
+}
+
+// bsn returns a nine-digit BSN, occasionally one of the magic test BSNs.
+func (g *Generator) bsn() string {
+	if g.rng.IntN(4) == 0 {
+		return fmt.Sprintf("00000000%d", g.rng.IntN(10))
+	}
+	return g.digits(9)
+}
+
+func (g *Generator) digits(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('0' + g.rng.IntN(10))
+	}
+	return string(b)
+}
+
+func (g *Generator) category() string {
+	return categories[g.rng.IntN(len(categories))]
+}
@@ -0,0 +1,120 @@
+package fuzzgen
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"mitz-replicator/parser"
+)
+
+// maxSamples bounds how many failing payloads a report keeps per kind.
+const maxSamples = 5
+
+// KindReport summarises the parser outcomes for one payload kind.
+type KindReport struct {
+	Runs         int      `json:"runs"`
+	Parsed       int      `json:"parsed"`
+	MissingBSN   int      `json:"missingBsn"`
+	Schema       int      `json:"schemaViolation"`
+	Unsupported  int      `json:"unsupportedInteraction"`
+	Unclassified int      `json:"unclassified"`
+	Panics       int      `json:"panics"`
+	Samples      []Sample `json:"samples,omitempty"`
+}
+
+// Sample is a payload that panicked or failed with an unclassified error.
+type Sample struct {
+	Error   string `json:"error"`
+	Payload string `json:"payload"`
+}
+
+// Report is the result of a selftest run.
+type Report struct {
+	Seed       uint64               `json:"seed"`
+	Iterations int                  `json:"iterations"`
+	MutateRate float64              `json:"mutateRate"`
+	Duration   string               `json:"duration"`
+	Kinds      map[Kind]*KindReport `json:"kinds"`
+}
+
+// Healthy reports whether no parser panicked and every error was classified.
+func (r Report) Healthy() bool {
+	for _, k := range r.Kinds {
+		if k.Panics > 0 || k.Unclassified > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// parsers maps each payload kind to the parser under test.
+var parsers = map[Kind]func([]byte) error{
+	KindXACML:            func(b []byte) error { _, err := parser.ParseXACMLRequest(b); return err },
+	KindXCPD:             func(b []byte) error { _, err := parser.ParseXCPDRequest(b); return err },
+	KindFhirSubscription: func(b []byte) error { _, err := parser.ParseFhirSubscription(b); return err },
+	KindFhirBundle:       func(b []byte) error { _, err := parser.ParseFhirBundle(b); return err },
+}
+
+// Run feeds iterations generated payloads of every kind through the parsers.
+// A fraction mutateRate of the payloads is mutated before parsing.
+func Run(seed uint64, iterations int, mutateRate float64) Report {
+	start := time.Now()
+	gen := New(seed)
+	report := Report{
+		Seed:       seed,
+		Iterations: iterations,
+		MutateRate: mutateRate,
+		Kinds:      map[Kind]*KindReport{},
+	}
+
+	for _, kind := range Kinds {
+		kr := &KindReport{}
+		report.Kinds[kind] = kr
+		for range iterations {
+			payload := gen.Payload(kind, mutateRate)
+			kr.record(payload, parseSafely(parsers[kind], payload))
+		}
+	}
+
+	report.Duration = time.Since(start).String()
+	return report
+}
+
+// errPanic marks a parser panic recovered by parseSafely.
+var errPanic = errors.New("parser panic")
+
+func parseSafely(parse func([]byte) error, payload []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", errPanic, r)
+		}
+	}()
+	return parse(payload)
+}
+
+func (kr *KindReport) record(payload []byte, err error) {
+	kr.Runs++
+	switch {
+	case err == nil:
+		kr.Parsed++
+		return
+	case errors.Is(err, errPanic):
+		kr.Panics++
+	case errors.Is(err, parser.ErrMissingBSN):
+		kr.MissingBSN++
+		return
+	case errors.Is(err, parser.ErrUnsupportedInteraction):
+		kr.Unsupported++
+		return
+	case errors.Is(err, parser.ErrSchemaViolation):
+		kr.Schema++
+		return
+	default:
+		kr.Unclassified++
+	}
+
+	if len(kr.Samples) < maxSamples {
+		kr.Samples = append(kr.Samples, Sample{Error: err.Error(), Payload: string(payload)})
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+)
+
+// WS-Addressing actions of the responses.
+const (
+	xacmlResponseAction = "urn:oasis:names:tc:xacml:3.0:profile:saml2.0:v2:schema:protocol:XACMLAuthzDecisionQueryResponse"
+	xcpdResponseAction  = "urn:hl7-org:v3:PRPA_IN201306UV02:CrossGatewayPatientDiscovery"
+	wsaFaultAction      = "http://www.w3.org/2005/08/addressing/soap/fault"
+)
+
+// wsaAnonymous is the ReplyTo address of a reply on the HTTP response.
+const wsaAnonymous = "http://www.w3.org/2005/08/addressing/anonymous"
+
+// addressingContextKey holds the request's WS-Addressing headers in the gin context.
+const addressingContextKey = "mitz.addressing"
+
+// WSAddressing is the template data for the WS-Addressing headers of a response.
+type WSAddressing struct {
+	Action    string
+	MessageID string
+	RelatesTo string // the request's MessageID
+	To        string // anonymous when the reply goes back on the HTTP response
+}
+
+// addressingFor returns the WS-Addressing headers answering the request with action,
+// or nil when the request carried none, so clients without WS-Addressing get the
+// responses they always got. The reply always goes back on the HTTP response, so
+// wsa:To is only set when that is where ReplyTo asked for it: the anonymous address,
+// or no ReplyTo at all.
+func addressingFor(c *gin.Context, action string) *WSAddressing {
+	v, ok := c.Get(addressingContextKey)
+	if !ok {
+		return nil
+	}
+	req := v.(*parser.Addressing)
+
+	a := &WSAddressing{
+		Action:    action,
+		MessageID: "urn:uuid:" + newIDFor(c),
+		RelatesTo: xmlEscape(req.MessageID),
+	}
+	if req.ReplyTo == "" || req.ReplyTo == wsaAnonymous {
+		a.To = wsaAnonymous
+	}
+	return a
+}
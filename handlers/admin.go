@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/fuzzgen"
+	"mitz-replicator/mitzexport"
+	"mitz-replicator/notify"
+	"mitz-replicator/storage"
+)
+
+// HandleAdminDeadLetters handles GET /admin/notifications/dead-letters — notifications
+// that exhausted all delivery attempts.
+func HandleAdminDeadLetters(c *gin.Context) {
+	deadLetters := []notify.DeadLetter{}
+	if dispatcher != nil {
+		deadLetters = append(deadLetters, dispatcher.DeadLetters()...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":       len(deadLetters),
+		"deadLetters": deadLetters,
+	})
+}
+
+// HandleAdminUnacked handles GET /admin/notifications/unacked — delivered notifications
+// awaiting the subscriber's acknowledgment.
+func HandleAdminUnacked(c *gin.Context) {
+	unacked := []notify.Unacked{}
+	var stats notify.AckStats
+	if dispatcher != nil {
+		unacked = append(unacked, dispatcher.Unacked()...)
+		stats = dispatcher.AckStats()
+	}
+
+	bySubscription := make(map[string]int)
+	for _, u := range unacked {
+		bySubscription[u.SubscriptionID]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":          len(unacked),
+		"bySubscription": bySubscription,
+		"acknowledged":   stats.Acknowledged,
+		"timedOut":       stats.TimedOut,
+		"unacked":        unacked,
+	})
+}
+
+// HandleAdminReset handles POST /admin/reset — clears all stored state and the
+// admin-set scenario overrides so test suites start from a known baseline without
+// restarting the process. With X-Test-Session only the session's own state is
+// cleared; the state shared by all sessions is left to a reset without the header.
+// Request baselines persist; see HandleAdminAnomaliesReset.
+func HandleAdminReset(c *gin.Context) {
+	session := c.GetHeader(sessionHeader)
+	st := StoreFor(c)
+	var owned func(subscriptionID string) bool
+	if session != "" {
+		subs := map[string]bool{}
+		for _, sub := range st.Subscriptions() {
+			subs[sub.ID] = true
+		}
+		owned = func(id string) bool { return subs[id] }
+	}
+
+	if err := st.Reset(); err != nil {
+		log.Printf("[ADMIN] Reset failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if dispatcher != nil {
+		dispatcher.ClearDeadLetters(owned)
+		dispatcher.ClearUnacked(owned)
+	}
+	clearXCPDQueries(session)
+	clearExportJobs(session)
+	if session == "" {
+		resetDeterministic()
+		clearQuotaOverrides()
+		resetRateLimits()
+		resetDeceased()
+		scenarioMaxCategories.Store(0)
+	}
+
+	log.Printf("[ADMIN] State reset %s", requestRef(c))
+	c.Status(http.StatusNoContent)
+}
+
+// HandleAdminStateExport handles GET /admin/state/export — dumps subscriptions and consents as JSON.
+func HandleAdminStateExport(c *gin.Context) {
+	c.JSON(http.StatusOK, storage.Export(StoreFor(c)))
+}
+
+// HandleAdminStateImport handles POST /admin/state/import[?replace=true] — restores a
+// snapshot produced by the export endpoint, or with format=mitz-export loads the consents
+// of an anonymised Mitz register export. With replace=true the store is reset first.
+func HandleAdminStateImport(c *gin.Context) {
+	var snap storage.Snapshot
+	var imported mitzexport.Result
+	switch format := c.DefaultQuery("format", "snapshot"); format {
+	case "snapshot":
+		if err := c.ShouldBindJSON(&snap); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	case "mitz-export":
+		var err error
+		if imported, err = mitzexport.Read(c.Request.Body, c.Query("bsnRange"), importSkipsBSN, now()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		snap.Consents = imported.Consents
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown format " + strconv.Quote(format) + " (expected snapshot or mitz-export)"})
+		return
+	}
+
+	st := StoreFor(c)
+	if c.Query("replace") == "true" {
+		if err := st.Reset(); err != nil {
+			log.Printf("[ADMIN] Reset before import failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var eventTypes []string
+	if imported.Patients > 0 {
+		eventTypes = importEventTypes(st, imported.Consents)
+	}
+	if err := storage.Import(st, snap); err != nil {
+		log.Printf("[ADMIN] State import failed: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if imported.Patients > 0 {
+		for i, consent := range imported.Consents {
+			recordEvent(st, storage.Event{Type: eventTypes[i], Resource: "Consent/" + consent.ID, BSN: consent.BSN})
+		}
+		log.Printf("[ADMIN] Imported %d consents for %d patients from a Mitz export (BSN %s–%s)", len(snap.Consents), imported.Patients, imported.FirstBSN, imported.LastBSN)
+		c.JSON(http.StatusOK, gin.H{
+			"consents": len(snap.Consents),
+			"patients": imported.Patients,
+			"bsns":     gin.H{"first": imported.FirstBSN, "last": imported.LastBSN},
+		})
+		return
+	}
+	log.Printf("[ADMIN] Imported %d subscriptions, %d consents", len(snap.Subscriptions), len(snap.Consents))
+	c.JSON(http.StatusOK, gin.H{
+		"subscriptions": len(snap.Subscriptions),
+		"consents":      len(snap.Consents),
+	})
+}
+
+// importSkipsBSN reports whether a Mitz export import must pass over bsn: it belongs
+// to a BSN pool, or a rule routes on it.
+func importSkipsBSN(bsn string) bool {
+	return bsnPools.Load().Contains(bsn) || ruleEngine.Load().RoutesBSN(bsn)
+}
+
+// importEventTypes returns, like consentEventType, whether each imported consent
+// creates or updates the consent of its BSN and provider, in one pass over the store.
+func importEventTypes(st storage.Store, consents []storage.Consent) []string {
+	seen := map[[2]string]bool{}
+	for _, existing := range st.Consents() {
+		seen[[2]string{existing.BSN, existing.ProviderID}] = true
+	}
+	types := make([]string, len(consents))
+	for i, consent := range consents {
+		key := [2]string{consent.BSN, consent.ProviderID}
+		types[i] = storage.EventConsentCreated
+		if seen[key] {
+			types[i] = storage.EventConsentUpdated
+		}
+		seen[key] = true
+	}
+	return types
+}
+
+// countKey increments counts[key], bucketing empty keys as "unknown".
+func countKey(counts map[string]int, key string) {
+	if key == "" {
+		key = "unknown"
+	}
+	counts[key]++
+}
+
+// HandleAdminStats handles GET /admin/stats — register composition summary.
+func HandleAdminStats(c *gin.Context) {
+	byStatus := map[string]int{}
+	byCategory := map[string]int{}
+	byProvider := map[string]int{}
+	byTenant := map[string]int{}
+
+	st := StoreFor(c)
+	consents := st.Consents()
+	for _, consent := range consents {
+		countKey(byStatus, consent.Status)
+		countKey(byProvider, consent.ProviderID)
+		countKey(byTenant, consent.Tenant)
+		for _, cat := range consent.Categories {
+			countKey(byCategory, cat)
+		}
+	}
+
+	subsByStatus := map[string]int{}
+	subs := st.Subscriptions()
+	for _, sub := range subs {
+		countKey(subsByStatus, sub.Status)
+	}
+
+	byTestcase := map[string]int{}
+	requests := st.Requests()
+	for _, captured := range requests {
+		countKey(byTestcase, captured.Testcase)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"consents": gin.H{
+			"total":      len(consents),
+			"byStatus":   byStatus,
+			"byCategory": byCategory,
+			"byProvider": byProvider,
+			"byTenant":   byTenant,
+		},
+		"subscriptions": gin.H{
+			"total":    len(subs),
+			"byStatus": subsByStatus,
+		},
+		"requests": gin.H{
+			"total":      len(requests),
+			"byTestcase": byTestcase,
+		},
+	})
+}
+
+// HandleAdminSelftest handles POST /admin/selftest?iterations=&seed=&mutate= — runs
+// generated (and partly mutated) payloads through the request parsers.
+func HandleAdminSelftest(c *gin.Context) {
+	iterations, err := strconv.Atoi(c.DefaultQuery("iterations", "200"))
+	if err != nil || iterations < 1 || iterations > 100000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "iterations must be between 1 and 100000"})
+		return
+	}
+
+	seed := uint64(time.Now().UnixNano())
+	if s := c.Query("seed"); s != "" {
+		if seed, err = strconv.ParseUint(s, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seed must be an unsigned integer"})
+			return
+		}
+	}
+
+	mutateRate, err := strconv.ParseFloat(c.DefaultQuery("mutate", "0.5"), 64)
+	if err != nil || mutateRate < 0 || mutateRate > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mutate must be between 0 and 1"})
+		return
+	}
+
+	report := fuzzgen.Run(seed, iterations, mutateRate)
+	log.Printf("[ADMIN] Selftest seed=%d iterations=%d mutate=%.2f healthy=%t",
+		seed, iterations, mutateRate, report.Healthy())
+
+	c.JSON(http.StatusOK, gin.H{
+		"healthy": report.Healthy(),
+		"report":  report,
+	})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/notify"
+	"mitz-replicator/storage"
+)
+
+// TestAdminResetIsolatesSessions checks that a reset in one test session leaves the
+// state of another session, and the state shared by all sessions, alone.
+func TestAdminResetIsolatesSessions(t *testing.T) {
+	InitStores(storage.NewMemoryStore(storage.Retention{}), func(string) (storage.Store, error) {
+		return storage.NewMemoryStore(storage.Retention{}), nil
+	}, 0)
+	t.Cleanup(func() {
+		CloseSessions()
+		InitStores(nil, nil, 0)
+	})
+
+	// Every notification fails, so each session's subscription gets a dead letter.
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer subscriber.Close()
+	d := notify.NewDispatcher(subscriber.Client(), 10, notify.RetryPolicy{MaxAttempts: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	InitNotifications(d)
+	t.Cleanup(func() { InitNotifications(nil) })
+
+	stores := map[string]storage.Store{}
+	for _, session := range []string{"a", "b"} {
+		st, err := acquireSession(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		releaseSession(session)
+		stores[session] = st
+
+		sub := storage.Subscription{ID: "sub-" + session, Status: "active", Endpoint: subscriber.URL}
+		if err := st.SaveSubscription(sub); err != nil {
+			t.Fatal(err)
+		}
+		d.Enqueue(notify.Notification{ID: "n-" + session, SubscriptionID: sub.ID, Endpoint: sub.Endpoint})
+
+		exportJobsMu.Lock()
+		exportJobs["job-"+session] = &exportJob{session: session}
+		exportJobsMu.Unlock()
+		xcpdQueriesMu.Lock()
+		xcpdQueries[xcpdQueryKey{session: session, root: "query"}] = &xcpdQuery{}
+		xcpdQueriesMu.Unlock()
+	}
+	t.Cleanup(func() {
+		clearExportJobs("b")
+		clearXCPDQueries("b")
+	})
+	for deadline := time.Now().Add(5 * time.Second); len(d.DeadLetters()) < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d dead letters, want 2", len(d.DeadLetters()))
+		}
+	}
+	scenarioMaxCategories.Store(3)
+	t.Cleanup(func() { scenarioMaxCategories.Store(0) })
+
+	router := gin.New()
+	router.Use(SessionScope())
+	router.POST("/admin/reset", HandleAdminReset)
+	reset := func(session string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
+		if session != "" {
+			req.Header.Set(sessionHeader, session)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("reset %q: status %d, want 204", session, w.Code)
+		}
+	}
+
+	reset("a")
+
+	if n := len(stores["a"].Subscriptions()); n != 0 {
+		t.Errorf("session a kept %d subscriptions after its reset", n)
+	}
+	if _, ok := stores["b"].Subscription("sub-b"); !ok {
+		t.Error("session a's reset removed session b's subscription")
+	}
+	if exportJobHeld("job-a") {
+		t.Error("session a's reset kept its export")
+	}
+	if !exportJobHeld("job-b") {
+		t.Error("session a's reset dropped session b's export")
+	}
+	xcpdQueriesMu.Lock()
+	_, queryA := xcpdQueries[xcpdQueryKey{session: "a", root: "query"}]
+	_, queryB := xcpdQueries[xcpdQueryKey{session: "b", root: "query"}]
+	xcpdQueriesMu.Unlock()
+	if queryA || !queryB {
+		t.Errorf("after session a's reset: query of a kept=%v, query of b kept=%v; want false, true", queryA, queryB)
+	}
+	if dl := d.DeadLetters(); len(dl) != 1 || dl[0].SubscriptionID != "sub-b" {
+		t.Errorf("after session a's reset: dead letters %+v, want only sub-b's", dl)
+	}
+	if n := scenarioMaxCategories.Load(); n != 3 {
+		t.Errorf("session a's reset cleared the shared max-categories override (now %d)", n)
+	}
+
+	reset("")
+
+	if n := scenarioMaxCategories.Load(); n != 0 {
+		t.Errorf("a reset without a session kept the max-categories override %d", n)
+	}
+	if dl := d.DeadLetters(); len(dl) != 0 {
+		t.Errorf("a reset without a session kept dead letters %+v", dl)
+	}
+	if _, ok := stores["b"].Subscription("sub-b"); !ok {
+		t.Error("a reset without a session removed session b's subscription")
+	}
+}
+
+// exportJobHeld reports whether the export id is held, whatever its session.
+func exportJobHeld(id string) bool {
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	_, ok := exportJobs[id]
+	return ok
+}
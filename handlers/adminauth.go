@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth returns a middleware that requires "Authorization: Bearer <token>" on
+// the routes it guards. An empty token leaves them open (the historic behaviour).
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		presented, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			log.Printf("[ADMIN] Rejected unauthenticated %s %s from %s", c.Request.Method, c.Request.URL.Path, c.ClientIP())
+			c.Header("WWW-Authenticate", `Bearer realm="mitz-replicator admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "admin token required"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/anomaly"
+)
+
+// profiler holds the per-client request baselines; nil disables profiling.
+var profiler atomic.Pointer[anomaly.Profiler]
+
+// InitAnomalies sets the request profiler. Nil disables anomaly detection.
+func InitAnomalies(p *anomaly.Profiler) {
+	profiler.Store(p)
+}
+
+// RequestProfile returns a middleware that adds every SOAP and FHIR request to its
+// client's baseline and logs requests whose size, element count or header set deviate.
+// Clients are identified by URA, falling back to the remote address.
+func RequestProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := profiler.Load()
+		path := c.Request.URL.Path
+		if p == nil || c.FullPath() == "" || strings.HasPrefix(path, "/admin") || strings.HasPrefix(path, "/artifacts") {
+			c.Next()
+			return
+		}
+
+		body, _ := ReadBody(c)
+
+		client := cmp.Or(clientURA(c), c.ClientIP())
+		endpoint := c.Request.Method + " " + c.FullPath()
+		found := p.Observe(client, endpoint, c.GetHeader("X-Request-Id"), anomaly.Sample{
+			Size:     len(body),
+			Elements: anomaly.CountElements(body),
+			Headers:  anomaly.HeaderPattern(c.Request.Header),
+		})
+		for _, a := range found {
+			log.Printf("[ANOMALY] %s %s: %s %s", a.Client, a.Endpoint, a.Detail, requestRef(c))
+		}
+
+		c.Next()
+	}
+}
+
+// HandleAdminAnomalies handles GET /admin/anomalies — per-client request baselines and
+// the most recent anomalies.
+func HandleAdminAnomalies(c *gin.Context) {
+	p := profiler.Load()
+	if p == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "profiles": []anomaly.Profile{}, "anomalies": []anomaly.Anomaly{}})
+		return
+	}
+
+	anomalies := p.Anomalies()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   true,
+		"profiles":  p.Profiles(),
+		"total":     len(anomalies),
+		"anomalies": anomalies,
+	})
+}
+
+// HandleAdminAnomaliesReset handles DELETE /admin/anomalies — discards the baselines,
+// e.g. after a client release that changed its payloads on purpose.
+func HandleAdminAnomaliesReset(c *gin.Context) {
+	if p := profiler.Load(); p != nil {
+		p.Reset()
+	}
+	log.Printf("[ADMIN] Request baselines reset %s", requestRef(c))
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// artifactSources are searched in order; earlier sources shadow later ones.
+var artifactSources []fs.FS
+
+// InitArtifacts sets the file systems served under /artifacts. Pass an on-disk
+// directory before the embedded set so local schemas and profiles take precedence.
+func InitArtifacts(sources ...fs.FS) {
+	artifactSources = sources
+}
+
+// HandleArtifactIndex handles GET /artifacts — lists the available artifacts.
+func HandleArtifactIndex(c *gin.Context) {
+	var names []string
+	for _, src := range artifactSources {
+		fs.WalkDir(src, ".", func(name string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+			return nil
+		})
+	}
+	slices.Sort(names)
+
+	artifacts := make([]gin.H, len(names))
+	for i, name := range names {
+		artifacts[i] = gin.H{"path": name, "url": "/artifacts/" + name}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"total": len(artifacts), "artifacts": artifacts})
+}
+
+// HandleArtifact handles GET /artifacts/*path — serves one artifact file.
+func HandleArtifact(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("path"), "/")
+	if name == "" {
+		HandleArtifactIndex(c)
+		return
+	}
+	if !fs.ValidPath(name) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	data, err := readArtifact(name)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Data(http.StatusOK, artifactContentType(name), data)
+}
+
+// readArtifact reads name from the first artifact source that has it.
+func readArtifact(name string) ([]byte, error) {
+	for _, src := range artifactSources {
+		data, err := fs.ReadFile(src, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("[ARTIFACTS] Failed to read %s: %v", name, err)
+		}
+		return data, err
+	}
+	return nil, fs.ErrNotExist
+}
+
+// artifactContentType returns the Content-Type for an artifact by file extension.
+func artifactContentType(name string) string {
+	switch path.Ext(name) {
+	case ".xml", ".xsd", ".wsdl", ".sch":
+		return "application/xml; charset=utf-8"
+	case ".json":
+		return "application/json; charset=utf-8"
+	}
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
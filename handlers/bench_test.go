@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// benchSizes are the result and location counts each benchmark renders: a single
+// item, a typical multi-category answer, and a worst case well beyond real traffic.
+var benchSizes = []int{1, 10, 100}
+
+var benchCategories = []string{"huisartsgegevens", "medicatiegegevens", "labuitslagen", "beeldvorming", "opnamegegevens"}
+
+func BenchmarkRenderXACMLResponse(b *testing.B) {
+	loadRepoTemplates(b)
+	for _, n := range benchSizes {
+		results := make([]XACMLResult, n)
+		for i := range results {
+			results[i] = XACMLResult{Decision: "Permit", EventCode: benchCategories[i%len(benchCategories)]}
+		}
+		b.Run(fmt.Sprintf("results=%d", n), func(b *testing.B) {
+			benchRender(b, "xacml_response", XACMLResponseData{Results: results})
+		})
+	}
+}
+
+func BenchmarkRenderXCPDFound(b *testing.B) {
+	loadRepoTemplates(b)
+	for _, n := range benchSizes {
+		locations := make([]XCPDLocation, n)
+		for i := range locations {
+			locations[i] = XCPDLocation{
+				PatientID:    fmt.Sprintf("%09d", i+1),
+				SourceID:     "1.2.3.4.5.6.7",
+				CustodianOID: "urn:oid:2.16.840.1.113883.2.4.6.6",
+				EventCodes:   []string{"huisartsgegevens", "medicatiegegevens"},
+			}
+		}
+		b.Run(fmt.Sprintf("locations=%d", n), func(b *testing.B) {
+			benchRender(b, "xcpd_found", XCPDFoundData{
+				ResponseID:   "00000000-0000-0000-0000-000000000001",
+				Timestamp:    "20260101120000",
+				RequestedBSN: "999999011",
+				Locations:    locations,
+			})
+		})
+	}
+}
+
+// loadRepoTemplates loads the templates of the repository's templates directory as
+// the default set.
+func loadRepoTemplates(tb testing.TB) {
+	tb.Helper()
+
+	paths, err := filepath.Glob("../templates/*.xml")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	layer := TemplateLayer{Name: "templates", Sources: map[string]string{}}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		layer.Sources[strings.TrimSuffix(filepath.Base(path), ".xml")] = string(data)
+	}
+	if err := LoadTemplates("", layer); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func benchRender(b *testing.B, name string, data any) {
+	t := lookupTemplate(nil, name)
+	b.ReportAllocs()
+	for b.Loop() {
+		var buf strings.Builder
+		if err := t.Execute(&buf, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/bsnpool"
+)
+
+// maxReservationSize bounds the BSNs a single reservation may take.
+const maxReservationSize = 1000
+
+var (
+	bsnPools       atomic.Pointer[bsnpool.Manager]
+	reservationTTL atomic.Int64 // default reservation lifetime in seconds
+)
+
+func init() {
+	m, _ := bsnpool.New(nil)
+	bsnPools.Store(m)
+	reservationTTL.Store(3600)
+}
+
+// InitBSNPools sets the BSN pools and the lifetime of reservations that don't ask for one.
+func InitBSNPools(m *bsnpool.Manager, defaultTTL time.Duration) {
+	bsnPools.Store(m)
+	reservationTTL.Store(int64(defaultTTL.Seconds()))
+}
+
+// BSNReservationRequest is the body of POST /admin/bsn/reservations.
+type BSNReservationRequest struct {
+	Pool       string `json:"pool"`       // default "default"
+	Count      int    `json:"count"`      // default 1
+	TTLSeconds int    `json:"ttlSeconds"` // default BSN_RESERVATION_TTL_SECONDS
+	Owner      string `json:"owner"`      // free text, e.g. the CI pipeline
+}
+
+// HandleAdminBSNPools handles GET /admin/bsn/pools — pools with their size and usage.
+func HandleAdminBSNPools(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"pools": bsnPools.Load().Pools(time.Now())})
+}
+
+// HandleAdminBSNReservations handles GET /admin/bsn/reservations — active reservations.
+func HandleAdminBSNReservations(c *gin.Context) {
+	list := bsnPools.Load().Reservations(time.Now())
+	c.JSON(http.StatusOK, gin.H{"total": len(list), "reservations": list})
+}
+
+// HandleAdminBSNReserve handles POST /admin/bsn/reservations — reserve free BSNs.
+func HandleAdminBSNReserve(c *gin.Context) {
+	var req BSNReservationRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+			return
+		}
+	}
+	req.Pool = cmp.Or(req.Pool, bsnpool.DefaultPool)
+	req.Count = cmp.Or(req.Count, 1)
+	if req.Count < 1 || req.Count > maxReservationSize || req.TTLSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "count must be between 1 and 1000, ttlSeconds must not be negative"})
+		return
+	}
+	ttl := time.Duration(cmp.Or(int64(req.TTLSeconds), reservationTTL.Load())) * time.Second
+
+	// A fresh ID per attempt: with DETERMINISTIC_SEED the sequence restarts on reset
+	// while reservations live on.
+	r, err := bsnPools.Load().Reserve(newID(), req.Pool, req.Owner, req.Count, ttl, time.Now())
+	for errors.Is(err, bsnpool.ErrDuplicateID) {
+		r, err = bsnPools.Load().Reserve(newID(), req.Pool, req.Owner, req.Count, ttl, time.Now())
+	}
+	switch {
+	case errors.Is(err, bsnpool.ErrUnknownPool):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, bsnpool.ErrExhausted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("[BSN] Reserved %d BSNs from %s for %s until %s (reservation %s)",
+		len(r.BSNs), r.Pool, cmp.Or(r.Owner, "unnamed owner"), r.Expires.UTC().Format(time.RFC3339), r.ID)
+	c.JSON(http.StatusCreated, r)
+}
+
+// HandleAdminBSNReservation handles GET /admin/bsn/reservations/:id.
+func HandleAdminBSNReservation(c *gin.Context) {
+	r, ok := bsnPools.Load().Reservation(c.Param("id"), time.Now())
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "reservation not found or expired"})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// HandleAdminBSNExtend handles PUT /admin/bsn/reservations/:id — extend a reservation
+// by ttlSeconds from now.
+func HandleAdminBSNExtend(c *gin.Context) {
+	var req struct {
+		TTLSeconds int `json:"ttlSeconds"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil || req.TTLSeconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expected {\"ttlSeconds\": <seconds>}"})
+			return
+		}
+	}
+	ttl := time.Duration(cmp.Or(int64(req.TTLSeconds), reservationTTL.Load())) * time.Second
+
+	r, ok := bsnPools.Load().Extend(c.Param("id"), ttl, time.Now())
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "reservation not found or expired"})
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// HandleAdminBSNRelease handles DELETE /admin/bsn/reservations/:id — free the BSNs.
+func HandleAdminBSNRelease(c *gin.Context) {
+	id := c.Param("id")
+	if !bsnPools.Load().Release(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "reservation not found or expired"})
+		return
+	}
+	log.Printf("[BSN] Released reservation %s", id)
+	c.Status(http.StatusNoContent)
+}
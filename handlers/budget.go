@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/notify"
+)
+
+// budgetHeader reports a request's time against the latency budget.
+const budgetHeader = "X-Mitz-Latency-Budget"
+
+// budgetContextKey holds the request's *requestBudget in the gin context.
+const budgetContextKey = "mitz.budget"
+
+// budgetSettings are the end-to-end latency budget and what happens past it.
+type budgetSettings struct {
+	budget  time.Duration // 0 = off
+	enforce bool          // answer 504 and drop notifications instead of only reporting
+}
+
+var latencyBudget atomic.Pointer[budgetSettings]
+
+func init() {
+	latencyBudget.Store(&budgetSettings{})
+}
+
+// BudgetStats counts requests or notification chains measured against the budget.
+type BudgetStats struct {
+	Measured  int     `json:"measured"`
+	Exceeded  int     `json:"exceeded"`
+	Enforced  int     `json:"enforced"` // answered 504, or notifications dropped
+	SlowestMs float64 `json:"slowestMs"`
+}
+
+func (s *BudgetStats) add(elapsed time.Duration, exceeded, enforced bool) {
+	s.Measured++
+	if exceeded {
+		s.Exceeded++
+	}
+	if enforced {
+		s.Enforced++
+	}
+	s.SlowestMs = max(s.SlowestMs, milliseconds(elapsed))
+}
+
+var budgetStats = struct {
+	mu            sync.Mutex
+	routes        map[string]*BudgetStats
+	notifications BudgetStats
+}{routes: map[string]*BudgetStats{}}
+
+// InitLatencyBudget sets the end-to-end latency budget of requests and of the
+// notification chains they trigger. Zero disables it.
+func InitLatencyBudget(budget time.Duration, enforce bool) {
+	latencyBudget.Store(&budgetSettings{budget: budget, enforce: enforce})
+}
+
+// requestBudget tracks one request against the budget.
+type requestBudget struct {
+	endpoint string
+	start    time.Time
+	settings *budgetSettings
+	expired  bool // answered 504; the handler's late response is dropped
+}
+
+func (b *requestBudget) exceeded() bool {
+	return time.Since(b.start) > b.settings.budget
+}
+
+// LatencyBudget returns a middleware that measures the named route's requests against
+// LATENCY_BUDGET_MS, including injected and rule delays, and reports the time in an
+// X-Mitz-Latency-Budget response header. Requests over budget are logged.
+func LatencyBudget(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings := latencyBudget.Load()
+		if settings.budget == 0 {
+			c.Next()
+			return
+		}
+
+		b := &requestBudget{endpoint: endpoint, start: time.Now(), settings: settings}
+		c.Set(budgetContextKey, b)
+		c.Writer = &budgetWriter{ResponseWriter: c.Writer, budget: b}
+		c.Next()
+
+		elapsed := time.Since(b.start)
+		exceeded := elapsed > settings.budget
+		if exceeded && !b.expired {
+			log.Printf("[BUDGET] %s took %s, over the %s budget %s", endpoint, elapsed.Round(time.Millisecond), settings.budget, requestRef(c))
+		}
+
+		budgetStats.mu.Lock()
+		defer budgetStats.mu.Unlock()
+
+		s, ok := budgetStats.routes[endpoint]
+		if !ok {
+			s = &BudgetStats{}
+			budgetStats.routes[endpoint] = s
+		}
+		s.add(elapsed, exceeded, b.expired)
+	}
+}
+
+func budgetFor(c *gin.Context) *requestBudget {
+	if v, ok := c.Get(budgetContextKey); ok {
+		return v.(*requestBudget)
+	}
+	return nil
+}
+
+// budgetRemaining returns how long request c may still be delayed when the budget is
+// enforced.
+func budgetRemaining(c *gin.Context) (time.Duration, bool) {
+	b := budgetFor(c)
+	if b == nil || !b.settings.enforce || b.expired {
+		return 0, false
+	}
+	return b.settings.budget - time.Since(b.start), true
+}
+
+// budgetExpired reports whether request c was answered 504 for exceeding the budget.
+func budgetExpired(c *gin.Context) bool {
+	b := budgetFor(c)
+	return b != nil && b.expired
+}
+
+// exceedBudget answers 504 once the budget is spent, as a gateway in front of Mitz
+// would, and drops whatever the handler writes afterwards.
+func exceedBudget(c *gin.Context) {
+	b := budgetFor(c)
+	log.Printf("[BUDGET] %s: %s budget spent, answering 504 %s", b.endpoint, b.settings.budget, requestRef(c))
+	abortWithRouteError(c, http.StatusGatewayTimeout, "timeout", "mitz:Timeout",
+		fmt.Sprintf("End-to-end latency budget of %dms exceeded", b.settings.budget.Milliseconds()))
+	b.expired = true
+}
+
+// budgetWriter stamps the X-Mitz-Latency-Budget header and discards the response of a
+// request that was already answered 504.
+type budgetWriter struct {
+	gin.ResponseWriter
+	budget  *requestBudget
+	stamped bool
+}
+
+func (w *budgetWriter) stamp() {
+	if w.stamped || w.Written() {
+		return
+	}
+	w.stamped = true
+	b := w.budget
+	value := fmt.Sprintf("budget=%dms, elapsed=%dms", b.settings.budget.Milliseconds(), time.Since(b.start).Milliseconds())
+	if b.exceeded() {
+		value += ", exceeded"
+	}
+	w.Header().Set(budgetHeader, value)
+}
+
+func (w *budgetWriter) WriteHeader(code int) {
+	if w.budget.expired {
+		return
+	}
+	w.stamp()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *budgetWriter) WriteHeaderNow() {
+	if w.budget.expired {
+		return
+	}
+	w.stamp()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *budgetWriter) Write(data []byte) (int, error) {
+	if w.budget.expired {
+		return len(data), nil
+	}
+	w.stamp()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *budgetWriter) WriteString(s string) (int, error) {
+	if w.budget.expired {
+		return len(s), nil
+	}
+	w.stamp()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// notificationBudget returns when the notification chain triggered by request c
+// started, and the deadline for its deliveries when the budget is enforced. The start
+// is zero without a budget.
+func notificationBudget(c *gin.Context) (start, deadline time.Time) {
+	b := budgetFor(c)
+	if b == nil {
+		return time.Time{}, time.Time{}
+	}
+	if b.settings.enforce {
+		deadline = b.start.Add(b.settings.budget)
+	}
+	return b.start, deadline
+}
+
+// budgetedDone wraps a notification's Done callback to measure the chain from the
+// triggering request to the subscriber's 2xx against the budget.
+func budgetedDone(start time.Time, subID string, done func(error)) func(error) {
+	if start.IsZero() {
+		return done
+	}
+	return func(err error) {
+		dropped := errors.Is(err, notify.ErrBudgetExceeded)
+		if settings := latencyBudget.Load(); settings.budget > 0 && (err == nil || dropped) {
+			elapsed := time.Since(start)
+			exceeded := elapsed > settings.budget
+			if exceeded && !dropped {
+				log.Printf("[BUDGET] Subscription/%s notified %s after the triggering request, over the %s budget",
+					subID, elapsed.Round(time.Millisecond), settings.budget)
+			}
+
+			budgetStats.mu.Lock()
+			budgetStats.notifications.add(elapsed, exceeded || dropped, dropped)
+			budgetStats.mu.Unlock()
+		}
+		done(err)
+	}
+}
+
+// HandleAdminLatencyBudget handles GET /admin/latency-budget — requests per route and
+// notification chains measured against the budget.
+func HandleAdminLatencyBudget(c *gin.Context) {
+	settings := latencyBudget.Load()
+	mode := "flag"
+	if settings.enforce {
+		mode = "enforce"
+	}
+
+	budgetStats.mu.Lock()
+	defer budgetStats.mu.Unlock()
+
+	routes := make(map[string]BudgetStats, len(budgetStats.routes))
+	for endpoint, s := range budgetStats.routes {
+		routes[endpoint] = *s
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"budgetMs":      settings.budget.Milliseconds(),
+		"mode":          mode,
+		"routes":        routes,
+		"notifications": budgetStats.notifications,
+	})
+}
+
+// HandleAdminLatencyBudgetReset handles DELETE /admin/latency-budget — resets the counters.
+func HandleAdminLatencyBudgetReset(c *gin.Context) {
+	budgetStats.mu.Lock()
+	defer budgetStats.mu.Unlock()
+
+	clear(budgetStats.routes)
+	budgetStats.notifications = BudgetStats{}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+)
+
+// FHIR versions the replicator can present (FHIR_VERSION).
+const (
+	fhirR4  = "R4"
+	fhirR4B = "R4B"
+)
+
+// fhirVersionNumbers are the CapabilityStatement.fhirVersion values.
+var fhirVersionNumbers = map[string]string{
+	fhirR4:  "4.0.1",
+	fhirR4B: "4.3.0",
+}
+
+var (
+	fhirVersion     = fhirR4
+	softwareVersion string
+)
+
+// InitFhirVersion sets the FHIR version whose resource shapes the replicator
+// expects and renders, and the software version in its CapabilityStatement.
+func InitFhirVersion(version, software string) {
+	fhirVersion = version
+	softwareVersion = software
+}
+
+// FhirCapabilityStatementData is the template data for fhir_capability_statement.xml.
+type FhirCapabilityStatementData struct {
+	FhirVersion     string // 4.0.1 or 4.3.0
+	R4B             bool
+	Date            string
+	SoftwareVersion string
+	Format          string
+}
+
+// HandleFhirMetadata handles GET /fhir/metadata — the CapabilityStatement for the
+// configured FHIR version.
+func HandleFhirMetadata(c *gin.Context) {
+	data := FhirCapabilityStatementData{
+		FhirVersion:     fhirVersionNumbers[fhirVersion],
+		R4B:             fhirVersion == fhirR4B,
+		Date:            now().Format("2006-01-02"),
+		SoftwareVersion: xmlEscape(softwareVersion),
+		Format:          xmlEscape(fhirMediaType()),
+	}
+
+	var buf bytes.Buffer
+	if err := lookupTemplate(c, "fhir_capability_statement").Execute(&buf, data); err != nil {
+		log.Printf("[FHIR] CapabilityStatement template error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, fhirContentType(c), buf.Bytes())
+}
+
+// subscriptionShapeProblem reports why a Subscription does not have the shape of the
+// configured FHIR version, or returns "": R4B expects topic-based Subscriptions,
+// R4 criteria-based ones.
+func subscriptionShapeProblem(req *parser.FhirSubscriptionRequest) string {
+	switch {
+	case fhirVersion == fhirR4B && req.Topic == "":
+		return "FHIR R4B expects a topic-based Subscription: criteria must name the SubscriptionTopic, with the Consent search in the backport-filter-criteria extension"
+	case fhirVersion == fhirR4 && req.Topic != "":
+		return "Topic-based Subscriptions (backport-filter-criteria) are not supported on FHIR R4; use a Consent search as criteria"
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/auth"
+)
+
+// maxCertificateDays is the longest validity of an issued client certificate.
+const maxCertificateDays = 365
+
+// CertificateRequest is the body of POST /admin/certificates.
+type CertificateRequest struct {
+	URA          string `json:"ura"`
+	CommonName   string `json:"commonName"`
+	Organization string `json:"organization"`
+	ValidDays    int    `json:"validDays"`
+}
+
+var certificateAuthority atomic.Pointer[auth.CertificateAuthority]
+
+// InitCertificateAuthority sets the CA that signs client certificates issued via
+// the admin API; nil disables issuing.
+func InitCertificateAuthority(ca *auth.CertificateAuthority) {
+	certificateAuthority.Store(ca)
+}
+
+// HandleAdminCertificateIssue handles POST /admin/certificates — issues a client
+// certificate signed by the server's CA with the URA in a UZI subjectAltName, and
+// returns it with its private key.
+func HandleAdminCertificateIssue(c *gin.Context) {
+	ca := certificateAuthority.Load()
+	if ca == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "certificate issuing is disabled; set CA_KEY to the key of CA_CERT"})
+		return
+	}
+
+	var req CertificateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if len(req.URA) != 8 || strings.Trim(req.URA, "0123456789") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ura must be 8 digits"})
+		return
+	}
+	req.ValidDays = cmp.Or(req.ValidDays, 30)
+	if req.ValidDays < 1 || req.ValidDays > maxCertificateDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "validDays must be between 1 and 365"})
+		return
+	}
+	req.CommonName = cmp.Or(req.CommonName, "mitz-client-"+req.URA)
+
+	cert, err := ca.Issue(req.CommonName, req.Organization, req.URA, time.Duration(req.ValidDays)*24*time.Hour)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("[CERTS] Issued client certificate %s for URA %s until %s (serial %s)",
+		cert.Subject, req.URA, cert.NotAfter.Format(time.RFC3339), cert.SerialNumber)
+	c.JSON(http.StatusCreated, cert)
+}
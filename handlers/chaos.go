@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of failure chaos mode injects.
+const (
+	ChaosFault = "fault" // SOAP fault or OperationOutcome, with the configured 5xx status
+	ChaosEmpty = "empty" // 200 response without a body
+)
+
+// chaosHeader names the failure injected into a response, so test logs can tell
+// injected failures from real ones.
+const chaosHeader = "X-Mitz-Chaos"
+
+// chaosSettings are the probabilistic fault injection settings.
+type chaosSettings struct {
+	rate   int      // percentage of requests that fail (0 = off)
+	routes []string // routes (xacml, xcpd, fhir) that fail; empty = all
+	kinds  []string // failure kinds to pick from
+	status int      // HTTP status of injected faults
+}
+
+var chaos atomic.Pointer[chaosSettings]
+
+func init() {
+	chaos.Store(&chaosSettings{})
+}
+
+// InitChaos makes rate percent of the requests on routes (all routes when empty) fail
+// with one of kinds, picked at random. Faults are returned with status.
+func InitChaos(rate int, routes, kinds []string, status int) {
+	chaos.Store(&chaosSettings{rate: rate, routes: slices.Clone(routes), kinds: slices.Clone(kinds), status: status})
+}
+
+// Chaos returns a middleware that fails a random share of the requests on the named
+// route, so client retry and error handling can be exercised without special BSNs.
+func Chaos(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings := chaos.Load()
+		if settings.rate == 0 || len(settings.kinds) == 0 || randIntN(c, 100) >= settings.rate {
+			c.Next()
+			return
+		}
+		if len(settings.routes) > 0 && !slices.Contains(settings.routes, endpoint) {
+			c.Next()
+			return
+		}
+
+		kind := settings.kinds[randIntN(c, len(settings.kinds))]
+		log.Printf("[CHAOS] %s: injecting %s %s", endpoint, kind, requestRef(c))
+		c.Header(chaosHeader, kind)
+
+		switch kind {
+		case ChaosEmpty:
+			contentType := soapContentType(c)
+			if isFhirRoute(c) {
+				contentType = fhirContentType(c)
+			}
+			c.Data(http.StatusOK, contentType, nil)
+			c.Abort()
+		default:
+			abortWithRouteError(c, settings.status, "exception", "mitz:InternalError", "Injected failure (chaos mode)")
+		}
+	}
+}
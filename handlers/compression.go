@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipContextKey holds the request's gzipWriter in the gin context.
+const gzipContextKey = "mitz.gzip"
+
+// maxInflatedBytes caps a gzip request body once inflated.
+var maxInflatedBytes atomic.Int64
+
+func init() {
+	maxInflatedBytes.Store(64 << 20)
+}
+
+// InitCompression sets the largest inflated gzip request body
+// (GZIP_MAX_INFLATED_MB) in MiB.
+func InitCompression(maxInflatedMB int) {
+	maxInflatedBytes.Store(int64(maxInflatedMB) << 20)
+}
+
+// Compression returns a middleware that inflates gzip request bodies
+// (Content-Encoding: gzip) and gzips responses for clients that send
+// Accept-Encoding: gzip. Other content codings are rejected with 415.
+func Compression() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodHead && acceptsGzip(c.Request.Header.Values("Accept-Encoding")) {
+			w := &gzipWriter{ResponseWriter: c.Writer}
+			c.Writer = w
+			c.Set(gzipContextKey, w)
+			defer w.close()
+		}
+
+		coding := strings.ToLower(strings.TrimSpace(strings.Join(c.Request.Header.Values("Content-Encoding"), ",")))
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(c.Request.Body)
+			if err != nil {
+				abortBodyError(c, &inflateError{err})
+				return
+			}
+			inflateRequest(c, zr)
+		default:
+			log.Printf("[GZIP] Rejecting %s %s: unsupported Content-Encoding %q %s", c.Request.Method, c.Request.URL.Path, coding, requestRef(c))
+			abortWithRouteError(c, http.StatusUnsupportedMediaType, "not-supported", "mitz:InvalidRequest",
+				"Unsupported Content-Encoding "+strconv.Quote(coding)+"; only gzip is accepted")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// inflateRequest replaces the gzip request body with zr, inflated as it is read,
+// so handlers and the request log see plain XML or JSON without the compressed and
+// the inflated body both being held. Reading fails with an *inflateError on a
+// corrupt body and an *http.MaxBytesError past maxInflatedBytes.
+func inflateRequest(c *gin.Context, zr *gzip.Reader) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, io.NopCloser(inflateReader{zr}), maxInflatedBytes.Load())
+	c.Request.ContentLength = -1
+	c.Request.Header.Del("Content-Length")
+	c.Request.Header.Del("Content-Encoding")
+}
+
+// inflateReader reports gzip errors from the reader underneath as *inflateError.
+type inflateReader struct {
+	zr *gzip.Reader
+}
+
+func (r inflateReader) Read(p []byte) (int, error) {
+	n, err := r.zr.Read(p)
+	if err != nil && err != io.EOF {
+		err = &inflateError{err}
+	}
+	return n, err
+}
+
+// inflateError is a request body that is not valid gzip.
+type inflateError struct {
+	err error
+}
+
+func (e *inflateError) Error() string {
+	return "Request body is not valid gzip: " + e.err.Error()
+}
+
+// ReadBody reads the request body and puts it back for the next reader. A read
+// error is put back too, after the bytes read, so the handler still answers a body
+// that failed to inflate.
+func ReadBody(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	var rest io.Reader = bytes.NewReader(body)
+	if err != nil {
+		rest = io.MultiReader(rest, errorReader{err})
+	}
+	c.Request.Body = io.NopCloser(rest)
+	return body, err
+}
+
+// errorReader fails every read with err.
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// abortBodyError answers a request whose body could not be read: 413 past the
+// inflate limit and 400 for a corrupt gzip body or any other read error.
+func abortBodyError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	var invalid *inflateError
+	switch {
+	case errors.As(err, &tooLarge):
+		log.Printf("[GZIP] Rejecting %s %s: inflated body exceeds %d bytes %s", c.Request.Method, c.Request.URL.Path, tooLarge.Limit, requestRef(c))
+		abortWithRouteError(c, http.StatusRequestEntityTooLarge, "too-costly", "mitz:InvalidRequest",
+			fmt.Sprintf("Inflated request body exceeds %d MiB", tooLarge.Limit>>20))
+	case errors.As(err, &invalid):
+		log.Printf("[GZIP] Rejecting %s %s: invalid gzip body: %v %s", c.Request.Method, c.Request.URL.Path, invalid.err, requestRef(c))
+		abortWithRouteError(c, http.StatusBadRequest, "invalid", "mitz:InvalidRequest", invalid.Error())
+	default:
+		abortWithRouteError(c, http.StatusBadRequest, "invalid", "mitz:InvalidRequest", "Failed to read request body")
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding values allow gzip with a non-zero
+// quality. An explicit gzip entry decides; * only counts when gzip is not listed.
+func acceptsGzip(values []string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			q := 1.0
+			if s, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); found {
+				f, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					continue
+				}
+				q = f
+			}
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "gzip", "x-gzip":
+				gzipQ = max(gzipQ, q)
+			case "*":
+				anyQ = max(anyQ, q)
+			}
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// skipCompression leaves the response of c uncompressed. Rule outcomes that shape
+// the bytes on the wire (streaming, bandwidth, malformed bodies, disconnects) call it
+// so their chunks, lengths and cut-off points apply to what the client receives.
+func skipCompression(c *gin.Context) {
+	v, _ := c.Get(gzipContextKey)
+	if w, ok := v.(*gzipWriter); ok {
+		w.skip = true
+	}
+}
+
+// gzipWriter compresses the response body. Compression starts with the first body
+// write; responses without a body, that already carry a Content-Encoding, or that
+// skipCompression was called for pass through unchanged.
+type gzipWriter struct {
+	gin.ResponseWriter
+	zw      *gzip.Writer
+	decided bool
+	skip    bool // set by skipCompression
+}
+
+func (w *gzipWriter) start() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	status := w.Status()
+	if w.skip || w.Written() || h.Get("Content-Encoding") != "" || status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	w.zw = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	w.start()
+	if w.zw == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	return w.zw.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) Flush() {
+	if w.zw != nil {
+		w.zw.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close writes the gzip trailer once the handlers are done.
+func (w *gzipWriter) close() {
+	if w.zw != nil {
+		w.zw.Close()
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyConfig bounds how many requests an endpoint processes at once.
+type ConcurrencyConfig struct {
+	Limit        int           // maximum in-flight requests (0 = unlimited)
+	Reject       bool          // reject excess requests instead of queueing them
+	QueueTimeout time.Duration // maximum time a queued request waits (0 = until the client gives up)
+}
+
+// ConcurrencyLimit returns a middleware that simulates a worker pool of cfg.Limit
+// workers for the named endpoint. Excess requests either queue (adding latency)
+// or are rejected with 503 and a Retry-After header.
+func ConcurrencyLimit(name string, cfg ConcurrencyConfig) gin.HandlerFunc {
+	if cfg.Limit <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	slots := make(chan struct{}, cfg.Limit)
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		if cfg.Reject {
+			select {
+			case slots <- struct{}{}:
+			default:
+				log.Printf("[CONCURRENCY] %s: all %d workers busy — rejecting %s",
+					name, cfg.Limit, requestRef(c))
+				rejectBusy(c)
+				return
+			}
+		} else {
+			var timeout <-chan time.Time
+			if cfg.QueueTimeout > 0 {
+				timer := time.NewTimer(cfg.QueueTimeout)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+
+			select {
+			case slots <- struct{}{}:
+			case <-timeout:
+				log.Printf("[CONCURRENCY] %s: queue timeout after %s — rejecting %s",
+					name, cfg.QueueTimeout, requestRef(c))
+				rejectBusy(c)
+				return
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			}
+
+			if waited := time.Since(start); waited > time.Millisecond {
+				log.Printf("[CONCURRENCY] %s: queued for %s %s", name, waited, requestRef(c))
+			}
+		}
+
+		markPhase(c, phaseQueue)
+		defer func() { <-slots }()
+		c.Next()
+	}
+}
+
+func rejectBusy(c *gin.Context) {
+	c.Header("Retry-After", "1")
+	abortWithRouteError(c, http.StatusServiceUnavailable, "transient", "mitz:Busy",
+		"Server busy — too many concurrent requests")
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+	"mitz-replicator/storage"
+)
+
+// FhirConsentData is the template data for one Consent in fhir_consent_searchset.xml.
+type FhirConsentData struct {
+	ConsentID   string
+	Status      string
+	BSN         string
+	ProviderID  string
+	Decision    string
+	Categories  []string
+	DateTime    string // registration time
+	PeriodStart string
+	PeriodEnd   string
+}
+
+// FhirConsentSearchsetData is the template data for fhir_consent_searchset.xml.
+type FhirConsentSearchsetData struct {
+	BundleID string
+	Consents []FhirConsentData
+}
+
+// HandleFhirConsentSearch handles GET /fhir/Consent?patientid=&providerid=&category= (also
+// in the Mitz form GET /fhir/Consent?_query=otv&patientid=...) — returns stored consents.
+func HandleFhirConsentSearch(c *gin.Context) {
+	query := c.Query("_query")
+	patientID := c.Query("patientid")
+	providerID := c.Query("providerid")
+	categories := parser.CategoryFilter(c.QueryArray("category"))
+	log.Printf("[FHIR] GET /Consent %s _query=%q patientid=%s providerid=%s category=%v",
+		requestRef(c), query, patientID, providerID, categories)
+
+	if query != "" && query != "otv" {
+		renderFhirError(c, http.StatusBadRequest, "error", "not-supported", "Unsupported _query: "+query)
+		return
+	}
+
+	data := FhirConsentSearchsetData{BundleID: newIDFor(c)}
+	for _, consent := range StoreFor(c).Consents() {
+		if patientID != "" && consent.BSN != patientID {
+			continue
+		}
+		if providerID != "" && consent.ProviderID != providerID {
+			continue
+		}
+		if !matchesCategories(consent, categories) {
+			continue
+		}
+		data.Consents = append(data.Consents, consentData(consent))
+	}
+
+	var buf bytes.Buffer
+	if err := lookupTemplate(c, "fhir_consent_searchset").Execute(&buf, data); err != nil {
+		log.Printf("[FHIR] Consent searchset template error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Data(http.StatusOK, fhirContentType(c), buf.Bytes())
+}
+
+// consentData converts a stored consent into escaped template data.
+func consentData(consent storage.Consent) FhirConsentData {
+	categories := make([]string, len(consent.Categories))
+	for i, cat := range consent.Categories {
+		categories[i] = xmlEscape(cat)
+	}
+
+	return FhirConsentData{
+		ConsentID:   consent.ID,
+		Status:      xmlEscape(consent.Status),
+		BSN:         xmlEscape(consent.BSN),
+		ProviderID:  xmlEscape(consent.ProviderID),
+		Decision:    xmlEscape(consent.Decision),
+		Categories:  categories,
+		DateTime:    consent.Created.Format(time.RFC3339),
+		PeriodStart: formatOptionalTime(consent.EffectiveFrom),
+		PeriodEnd:   formatOptionalTime(consent.EffectiveUntil),
+	}
+}
+
+// formatOptionalTime formats t as a FHIR dateTime, or "" for the zero time.
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// fhirDateTimeLayouts are the FHIR date and dateTime precisions accepted for
+// consent periods.
+var fhirDateTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02", "2006-01", "2006"}
+
+// parseFhirDateTime parses a FHIR date or dateTime. Values without a zone are UTC.
+func parseFhirDateTime(s string) (time.Time, error) {
+	for _, layout := range fhirDateTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (expected a FHIR date or dateTime, e.g. 2019-03-01)", s)
+}
+
+// parseConsentPeriod parses an optional consent period; the end must follow the start.
+func parseConsentPeriod(start, end string) (from, until time.Time, err error) {
+	if start != "" {
+		if from, err = parseFhirDateTime(start); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("period.start: %w", err)
+		}
+	}
+	if end != "" {
+		if until, err = parseFhirDateTime(end); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("period.end: %w", err)
+		}
+	}
+	if !from.IsZero() && !until.IsZero() && !until.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("period.end %s is not after period.start %s", end, start)
+	}
+	return from, until, nil
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// soapMediaTypes maps the request media types of the SOAP endpoints to their SOAP version.
+var soapMediaTypes = map[string]string{
+	"application/soap+xml": "1.2",
+	"text/xml":             "1.1",
+}
+
+// fhirMediaTypes are the request media types the FHIR endpoints parse.
+var fhirMediaTypes = []string{"application/fhir+xml", "application/xml+fhir", "application/xml", "text/xml"}
+
+var contentTypeCheck atomic.Bool
+
+// InitContentTypeCheck sets whether request Content-Types are enforced (strict) or
+// any body is parsed (off).
+func InitContentTypeCheck(strict bool) {
+	contentTypeCheck.Store(strict)
+}
+
+// RequestContentType returns a middleware that, when the check is on, rejects a
+// request to a SOAP or FHIR endpoint (family "soap" or "fhir") whose Content-Type
+// is not one it can parse, with 415 and a fault or OperationOutcome. For SOAP the
+// media type must match the envelope version; a mismatch is a VersionMismatch
+// fault. Must run after SOAPEnvelope.
+func RequestContentType(family string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !contentTypeCheck.Load() {
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader("Content-Type")
+		if header == "" {
+			rejectContentType(c, family, header, "Missing Content-Type")
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			rejectContentType(c, family, header, "Invalid Content-Type "+strconv.Quote(header))
+			return
+		}
+
+		switch family {
+		case "soap":
+			version, ok := soapMediaTypes[mediaType]
+			if !ok {
+				rejectContentType(c, family, header, "Unsupported Content-Type "+mediaType+"; expected application/soap+xml (SOAP 1.2) or text/xml (SOAP 1.1)")
+				return
+			}
+			if envelope := soapEnvelopeVersion(c); version != envelope {
+				log.Printf("[CONTENT-TYPE] Rejecting %s %s: %s with a SOAP %s envelope %s", c.Request.Method, c.Request.URL.Path, mediaType, envelope, requestRef(c))
+				renderSoapFault(c, http.StatusUnsupportedMediaType, FaultData{
+					FaultCode:    "soap:VersionMismatch",
+					FaultSubcode: "mitz:UnsupportedMediaType",
+					FaultReason:  "Content-Type " + mediaType + " is SOAP " + version + ", but the envelope is SOAP " + envelope,
+					FaultDetail:  "RequestId: " + c.GetHeader("X-Request-Id"),
+				})
+				c.Abort()
+				return
+			}
+		case "fhir":
+			if strings.Contains(mediaType, "json") {
+				rejectContentType(c, family, header, "Unsupported Content-Type "+mediaType+"; only FHIR XML (application/fhir+xml) is supported")
+				return
+			}
+			if !slices.Contains(fhirMediaTypes, mediaType) {
+				rejectContentType(c, family, header, "Unsupported Content-Type "+mediaType+"; expected application/fhir+xml")
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// rejectContentType aborts c with 415: a soap:Sender fault or a not-supported OperationOutcome.
+func rejectContentType(c *gin.Context, family, header, reason string) {
+	log.Printf("[CONTENT-TYPE] Rejecting %s %s (%s): Content-Type %q %s", c.Request.Method, c.Request.URL.Path, family, header, requestRef(c))
+	abortWithRouteError(c, http.StatusUnsupportedMediaType, "not-supported", "mitz:UnsupportedMediaType", reason)
+}
+
+// soapEnvelopeVersion returns the SOAP version the response to c uses, which
+// SOAPEnvelope took from the request envelope.
+func soapEnvelopeVersion(c *gin.Context) string {
+	if isSOAP11(c) {
+		return "1.1"
+	}
+	return "1.2"
+}
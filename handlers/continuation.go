@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+)
+
+// xcpdQueryTTL is how long the rest of a paged XCPD answer can be fetched.
+const xcpdQueryTTL = 10 * time.Minute
+
+// XCPDQueryAck is the template data of the queryAck of a paged XCPD answer.
+type XCPDQueryAck struct {
+	QueryRoot      string // the client's queryId, XML-escaped
+	QueryExtension string
+	Total          int
+	Current        int
+	Remaining      int
+}
+
+// xcpdQueryKey identifies a paged answer by the client's queryId within its test
+// session, so parallel test runs reusing a queryId do not continue each other's.
+type xcpdQueryKey struct {
+	session   string
+	root      string
+	extension string
+}
+
+// xcpdQuery is a paged XCPD answer whose remaining locations await continuation.
+type xcpdQuery struct {
+	bsn       string
+	remaining []XCPDLocation
+	total     int
+	expires   time.Time
+}
+
+var xcpdPageSize atomic.Int64
+
+var (
+	xcpdQueriesMu sync.Mutex
+	xcpdQueries   = make(map[xcpdQueryKey]*xcpdQuery)
+)
+
+// InitXCPDPaging sets the most locations per XCPD response; the rest are fetched
+// with QUQI_IN000003UV01 query continuations. Zero returns all locations at once.
+func InitXCPDPaging(pageSize int) {
+	xcpdPageSize.Store(int64(pageSize))
+}
+
+// clearXCPDQueries drops the open continuation queries of session, leaving other
+// sessions' alone.
+func clearXCPDQueries(session string) {
+	xcpdQueriesMu.Lock()
+	defer xcpdQueriesMu.Unlock()
+	maps.DeleteFunc(xcpdQueries, func(key xcpdQueryKey, _ *xcpdQuery) bool { return key.session == session })
+}
+
+// newXCPDQueryKey returns the key of the query with the given queryId in the
+// request's test session.
+func newXCPDQueryKey(c *gin.Context, root, extension string) xcpdQueryKey {
+	return xcpdQueryKey{session: c.GetHeader(sessionHeader), root: root, extension: extension}
+}
+
+// ack returns the queryAck fields identifying the query.
+func (k xcpdQueryKey) ack() XCPDQueryAck {
+	return XCPDQueryAck{QueryRoot: xmlEscape(k.root), QueryExtension: xmlEscape(k.extension)}
+}
+
+// pageXCPDLocations returns the locations of the first response to req, and its
+// queryAck when more remain. The rest are kept for continuation under the queryId
+// of req, replacing an earlier query with the same id; a query without a queryId
+// gets a generated root.
+func pageXCPDLocations(c *gin.Context, req *parser.XCPDRequest, locations []XCPDLocation) ([]XCPDLocation, *XCPDQueryAck) {
+	size := int(xcpdPageSize.Load())
+	if size == 0 || len(locations) <= size {
+		return locations, nil
+	}
+
+	key := newXCPDQueryKey(c, req.QueryRoot, req.QueryExtension)
+	if key.root == "" {
+		key.root, key.extension = newID(), ""
+	}
+	xcpdQueriesMu.Lock()
+	defer xcpdQueriesMu.Unlock()
+	pruneXCPDQueries(time.Now())
+	xcpdQueries[key] = &xcpdQuery{bsn: req.BSN, remaining: locations[size:], total: len(locations), expires: time.Now().Add(xcpdQueryTTL)}
+
+	ack := key.ack()
+	ack.Total, ack.Current, ack.Remaining = len(locations), size, len(locations)-size
+	return locations[:size], &ack
+}
+
+// continueXCPDQuery returns the next quantity locations of a paged answer (the page
+// size when zero), or releases the query when cancel is set. The query is dropped
+// once its last location has been returned.
+func continueXCPDQuery(key xcpdQueryKey, quantity int, cancel bool) (string, []XCPDLocation, *XCPDQueryAck, bool) {
+	xcpdQueriesMu.Lock()
+	defer xcpdQueriesMu.Unlock()
+	pruneXCPDQueries(time.Now())
+
+	q, ok := xcpdQueries[key]
+	if !ok {
+		return "", nil, nil, false
+	}
+	ack := key.ack()
+	ack.Total = q.total
+	if cancel {
+		delete(xcpdQueries, key)
+		return q.bsn, nil, &ack, true
+	}
+
+	n := quantity
+	if n == 0 {
+		n = int(xcpdPageSize.Load())
+	}
+	if n == 0 || n > len(q.remaining) {
+		n = len(q.remaining)
+	}
+	page := q.remaining[:n]
+	q.remaining = q.remaining[n:]
+	if len(q.remaining) == 0 {
+		delete(xcpdQueries, key)
+	}
+
+	ack.Current, ack.Remaining = n, len(q.remaining)
+	return q.bsn, page, &ack, true
+}
+
+// pruneXCPDQueries drops expired queries. Callers hold xcpdQueriesMu.
+func pruneXCPDQueries(now time.Time) {
+	for key, q := range xcpdQueries {
+		if now.After(q.expires) {
+			delete(xcpdQueries, key)
+		}
+	}
+}
+
+// handleXCPDContinuation answers a QUQI_IN000003UV01 query continuation or cancel
+// sent to /xcpd.
+func handleXCPDContinuation(c *gin.Context, body []byte) {
+	req, err := parser.ParseXCPDContinuation(body)
+	markPhase(c, phaseParse)
+	if err != nil {
+		log.Printf("[XCPD] Failed to parse query continuation: %v", err)
+		renderSoapParseFault(c, err)
+		return
+	}
+
+	query := req.QueryRoot
+	if req.QueryExtension != "" {
+		query += "^" + req.QueryExtension
+	}
+	log.Printf("[XCPD] %s continuation query=%s quantity=%d cancel=%t SenderOrg=%s", requestRef(c), query, req.Quantity, req.Cancel, req.SenderOrg)
+
+	bsn, locations, ack, ok := continueXCPDQuery(newXCPDQueryKey(c, req.QueryRoot, req.QueryExtension), req.Quantity, req.Cancel)
+	if !ok {
+		renderSoapFaultWith(c, lookupTemplate(c, "xcpd_fault"), http.StatusBadRequest, FaultData{
+			FaultCode:    "soap:Sender",
+			FaultSubcode: "mitz:UnknownQuery",
+			FaultReason:  "Query " + query + " is unknown, complete or expired",
+			FaultDetail:  "RequestId: " + c.GetHeader("X-Request-Id"),
+		})
+		return
+	}
+
+	writeXCPDFound(c, XCPDFoundData{RequestedBSN: bsn, Locations: locations, Query: ack, Ack: &XCPDAck{
+		TargetRoot:      xmlEscape(req.MessageRoot),
+		TargetExtension: xmlEscape(req.MessageExtension),
+	}})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Migration cutover phases, following the national move of the consent register
+// from SOAP to FHIR. Off leaves every route available.
+const (
+	CutoverOff      = 0
+	CutoverSOAPOnly = 1 // FHIR not available yet
+	CutoverParallel = 2 // SOAP and FHIR both available
+	CutoverFHIROnly = 3 // SOAP answers deprecation faults
+)
+
+// cutoverPhaseNames are the names reported for each phase.
+var cutoverPhaseNames = []string{"off", "soap-only", "parallel", "fhir-only"}
+
+// Default messages returned to clients on a route that is unavailable in the phase.
+const (
+	defaultFHIRUnavailableMessage = "The FHIR consent register is not available yet; use the SOAP endpoints"
+	defaultSOAPDeprecatedMessage  = "The SOAP endpoints have been retired; use the FHIR consent register"
+)
+
+// cutoverState is the active cutover phase.
+type cutoverState struct {
+	Phase   int       `json:"phase"`
+	Name    string    `json:"name"`
+	Message string    `json:"message,omitempty"` // reason returned to clients, empty = phase default
+	Since   time.Time `json:"since"`
+}
+
+// soap reports whether /xacml and /xcpd are available in the phase.
+func (s *cutoverState) soap() bool { return s.Phase != CutoverFHIROnly }
+
+// fhir reports whether /fhir is available in the phase.
+func (s *cutoverState) fhir() bool { return s.Phase != CutoverSOAPOnly }
+
+var cutover atomic.Pointer[cutoverState]
+
+// InitCutover sets the cutover phase the server starts in.
+func InitCutover(phase int) {
+	setCutover(phase, "")
+}
+
+func setCutover(phase int, message string) *cutoverState {
+	s := &cutoverState{Phase: phase, Name: cutoverPhaseNames[phase], Message: message, Since: time.Now().UTC()}
+	cutover.Store(s)
+	return s
+}
+
+// CutoverPhase returns the active cutover phase.
+func CutoverPhase() int {
+	if s := cutover.Load(); s != nil {
+		return s.Phase
+	}
+	return CutoverOff
+}
+
+// Cutover returns a middleware that answers requests to routes the active cutover
+// phase has not opened yet, or has retired: 404 with a not-supported
+// OperationOutcome on /fhir in the soap-only phase, a mitz:Deprecated soap:Sender
+// fault (400, as the SOAP binding wants) on POST /xacml and /xcpd in the fhir-only
+// phase. The HEAD /xacml health check, the WSDLs and /admin are never affected.
+func Cutover() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s := cutover.Load()
+		if s == nil || s.Phase == CutoverOff {
+			c.Next()
+			return
+		}
+
+		path := c.Request.URL.Path
+		switch {
+		case !s.fhir() && strings.HasPrefix(path, "/fhir"):
+			log.Printf("[CUTOVER] %s %s rejected in phase %d (%s) %s", c.Request.Method, path, s.Phase, s.Name, requestRef(c))
+			abortWithRouteError(c, http.StatusNotFound, "not-supported", "", cmp.Or(s.Message, defaultFHIRUnavailableMessage))
+		case !s.soap() && c.Request.Method == http.MethodPost && (strings.HasPrefix(path, "/xacml") || strings.HasPrefix(path, "/xcpd")):
+			log.Printf("[CUTOVER] %s %s rejected in phase %d (%s) %s", c.Request.Method, path, s.Phase, s.Name, requestRef(c))
+			abortWithRouteError(c, http.StatusBadRequest, "not-supported", "mitz:Deprecated", cmp.Or(s.Message, defaultSOAPDeprecatedMessage))
+		default:
+			c.Next()
+		}
+	}
+}
+
+// CutoverRequest is the body of PUT /admin/cutover.
+type CutoverRequest struct {
+	Phase   *int   `json:"phase"`   // 0 (off) to 3 (fhir-only)
+	Message string `json:"message"` // reason returned on unavailable routes (default per phase)
+}
+
+// HandleAdminCutover handles GET /admin/cutover — the active cutover phase.
+func HandleAdminCutover(c *gin.Context) {
+	c.JSON(http.StatusOK, cutoverResponse(cutover.Load()))
+}
+
+// HandleAdminCutoverSet handles PUT /admin/cutover — switch to another phase.
+func HandleAdminCutoverSet(c *gin.Context) {
+	var req CutoverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if req.Phase == nil || *req.Phase < CutoverOff || *req.Phase > CutoverFHIROnly {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "phase must be 0 (off), 1 (soap-only), 2 (parallel) or 3 (fhir-only)"})
+		return
+	}
+
+	previous := CutoverPhase()
+	s := setCutover(*req.Phase, req.Message)
+	log.Printf("[CUTOVER] Phase %d (%s) → %d (%s)", previous, cutoverPhaseNames[previous], s.Phase, s.Name)
+	c.JSON(http.StatusOK, cutoverResponse(s))
+}
+
+// HandleAdminCutoverStop handles DELETE /admin/cutover — leave cutover mode.
+func HandleAdminCutoverStop(c *gin.Context) {
+	if previous := CutoverPhase(); previous != CutoverOff {
+		log.Printf("[CUTOVER] Phase %d (%s) → off", previous, cutoverPhaseNames[previous])
+	}
+	c.JSON(http.StatusOK, cutoverResponse(setCutover(CutoverOff, "")))
+}
+
+func cutoverResponse(s *cutoverState) gin.H {
+	if s == nil {
+		s = &cutoverState{Phase: CutoverOff, Name: cutoverPhaseNames[CutoverOff]}
+	}
+	return gin.H{"cutover": s, "soap": s.soap(), "fhir": s.fhir()}
+}
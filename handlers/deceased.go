@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeceasedPatient is a BSN marked as deceased.
+type DeceasedPatient struct {
+	BSN          string    `json:"bsn"`
+	DeceasedDate string    `json:"deceasedDate,omitempty"` // YYYY-MM-DD; empty = unknown
+	Marked       time.Time `json:"marked"`
+}
+
+var deceased = struct {
+	sync.RWMutex
+	configured map[string]string // restored by POST /admin/reset
+	patients   map[string]DeceasedPatient
+}{patients: make(map[string]DeceasedPatient)}
+
+// InitDeceased marks the configured BSNs (BSN → date of death, "" if unknown) as deceased.
+func InitDeceased(bsns map[string]string) {
+	deceased.Lock()
+	defer deceased.Unlock()
+	deceased.configured = bsns
+	for bsn, date := range bsns {
+		deceased.patients[bsn] = DeceasedPatient{BSN: bsn, DeceasedDate: date, Marked: time.Now().UTC()}
+	}
+}
+
+// resetDeceased drops the markings made through the admin API, keeping the configured ones.
+func resetDeceased() {
+	deceased.Lock()
+	defer deceased.Unlock()
+	for bsn := range deceased.patients {
+		if _, ok := deceased.configured[bsn]; !ok {
+			delete(deceased.patients, bsn)
+		}
+	}
+}
+
+// deceasedPatient returns the deceased marking of bsn, if any.
+func deceasedPatient(bsn string) (DeceasedPatient, bool) {
+	deceased.RLock()
+	defer deceased.RUnlock()
+	p, ok := deceased.patients[bsn]
+	return p, ok
+}
+
+// deceasedMessage is the reason a consent registration for p is rejected.
+func deceasedMessage(p DeceasedPatient) string {
+	msg := "Patient BSN " + p.BSN + " is deceased"
+	if p.DeceasedDate != "" {
+		msg += " (" + p.DeceasedDate + ")"
+	}
+	return msg + ": consent cannot be registered"
+}
+
+// DeceasedRequest is the body of POST /admin/scenarios/deceased.
+type DeceasedRequest struct {
+	BSN          string `json:"bsn" binding:"required"`
+	DeceasedDate string `json:"deceasedDate"`
+}
+
+// HandleAdminDeceased handles GET /admin/scenarios/deceased — the BSNs marked as deceased.
+func HandleAdminDeceased(c *gin.Context) {
+	deceased.RLock()
+	defer deceased.RUnlock()
+	list := make([]DeceasedPatient, 0, len(deceased.patients))
+	for _, bsn := range slices.Sorted(maps.Keys(deceased.patients)) {
+		list = append(list, deceased.patients[bsn])
+	}
+	c.JSON(http.StatusOK, gin.H{"deceased": list})
+}
+
+// HandleAdminDeceasedMark handles POST /admin/scenarios/deceased — marks a BSN as
+// deceased: XACML decisions for it become Deny, XCPD returns no locations and consent
+// registrations are rejected.
+func HandleAdminDeceasedMark(c *gin.Context) {
+	var req DeceasedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.BSN) != 9 || strings.Trim(req.BSN, "0123456789") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bsn: " + strconv.Quote(req.BSN) + " is not a nine-digit BSN"})
+		return
+	}
+	if req.DeceasedDate != "" {
+		if _, err := time.Parse(time.DateOnly, req.DeceasedDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "deceasedDate: invalid date of death " + strconv.Quote(req.DeceasedDate) + " (expected YYYY-MM-DD)"})
+			return
+		}
+	}
+
+	p := DeceasedPatient{BSN: req.BSN, DeceasedDate: req.DeceasedDate, Marked: time.Now().UTC()}
+	deceased.Lock()
+	deceased.patients[p.BSN] = p
+	deceased.Unlock()
+
+	log.Printf("[ADMIN] BSN=%s marked deceased (date of death %s)", p.BSN, cmp.Or(p.DeceasedDate, "unknown"))
+	c.JSON(http.StatusCreated, p)
+}
+
+// HandleAdminDeceasedClear handles DELETE /admin/scenarios/deceased/:bsn — removes the marking.
+func HandleAdminDeceasedClear(c *gin.Context) {
+	bsn := c.Param("bsn")
+	deceased.Lock()
+	_, ok := deceased.patients[bsn]
+	delete(deceased.patients, bsn)
+	deceased.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "BSN " + bsn + " is not marked deceased"})
+		return
+	}
+	log.Printf("[ADMIN] BSN=%s no longer marked deceased", bsn)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+)
+
+// WS-Addressing actions of deferred XCPD messages.
+const (
+	xcpdDeferredAction = "urn:hl7-org:v3:PRPA_IN201306UV02:Deferred:CrossGatewayPatientDiscovery"
+	acceptAckAction    = "urn:hl7-org:v3:MCCI_IN000002UV01"
+)
+
+// xcpdDeferredContextKey holds the request's *xcpdDeferred in the gin context.
+const xcpdDeferredContextKey = "mitz.xcpdDeferred"
+
+// XCPDAcceptAckData is the template data for xcpd_accept_ack.xml.
+type XCPDAcceptAckData struct {
+	Addressing *WSAddressing
+	ResponseID string
+	Timestamp  string
+	Ack        *XCPDAck
+}
+
+// deferredClient posts deferred XCPD answers.
+var deferredClient atomic.Pointer[http.Client]
+
+// InitXCPDDeferred sets the client that posts the answers of deferred XCPD queries
+// to their respondTo endpoint.
+func InitXCPDDeferred(client *http.Client) {
+	deferredClient.Store(client)
+}
+
+// xcpdDeferred is a query with a respondTo endpoint: its answer is posted there, and
+// the request itself gets the accept acknowledgement (MCCI_IN000002UV01).
+type xcpdDeferred struct {
+	req         *parser.XCPDRequest
+	withholdAck bool // a rule leaves out the accept acknowledgement
+}
+
+// deferXCPD answers req, a query with a respondTo endpoint, in deferred mode.
+func deferXCPD(c *gin.Context, req *parser.XCPDRequest) {
+	c.Set(xcpdDeferredContextKey, &xcpdDeferred{req: req})
+}
+
+// deferredXCPD returns the deferred query of c, or nil for a synchronous one.
+func deferredXCPD(c *gin.Context) *xcpdDeferred {
+	v, _ := c.Get(xcpdDeferredContextKey)
+	d, _ := v.(*xcpdDeferred)
+	return d
+}
+
+// xcpdAddressing returns the WS-Addressing headers of an XCPD answer to c. A
+// deferred answer always has them, addressed to respondTo.
+func xcpdAddressing(c *gin.Context) *WSAddressing {
+	d := deferredXCPD(c)
+	if d == nil {
+		return addressingFor(c, xcpdResponseAction)
+	}
+	a := &WSAddressing{Action: xcpdDeferredAction, MessageID: "urn:uuid:" + newIDFor(c), To: xmlEscape(d.req.RespondTo)}
+	if v, ok := c.Get(addressingContextKey); ok {
+		a.RelatesTo = xmlEscape(v.(*parser.Addressing).MessageID)
+	}
+	return a
+}
+
+// writeXCPDAnswer sends the XCPD answer body: on the HTTP response, or for a
+// deferred query to its respondTo endpoint, answering the request itself with the
+// accept acknowledgement its acceptAckCode asks for.
+func writeXCPDAnswer(c *gin.Context, body []byte) {
+	d := deferredXCPD(c)
+	if d == nil {
+		writeSignedSOAP(c, http.StatusOK, body)
+		return
+	}
+
+	signed, err := signSOAP(c, body)
+	if err != nil {
+		log.Printf("[SIGN] %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	postXCPDAnswer(d.req.RespondTo, soapContentType(c), signed, requestRef(c))
+	writeAcceptAck(c, d)
+}
+
+// writeAcceptAck answers a deferred query with an accept acknowledgement (CA) for
+// acceptAckCode AL or none, and with 202 and no body for ER (the query was accepted)
+// and NE, or when a rule withholds the acknowledgement.
+func writeAcceptAck(c *gin.Context, d *xcpdDeferred) {
+	switch {
+	case d.withholdAck:
+		log.Printf("[XCPD] BSN=%s accept acknowledgement withheld (acceptAckCode=%s)", d.req.BSN, d.req.AcceptAckCode)
+		c.Status(http.StatusAccepted)
+		return
+	case d.req.AcceptAckCode == "ER" || d.req.AcceptAckCode == "NE":
+		c.Status(http.StatusAccepted)
+		return
+	}
+
+	data := XCPDAcceptAckData{
+		Addressing: addressingFor(c, acceptAckAction),
+		ResponseID: newIDFor(c),
+		Timestamp:  now().Format("20060102150405"),
+		Ack:        &XCPDAck{TypeCode: "CA", TargetRoot: xmlEscape(d.req.MessageRoot), TargetExtension: xmlEscape(d.req.MessageExtension)},
+	}
+	var buf bytes.Buffer
+	if err := lookupTemplate(c, "xcpd_accept_ack").Execute(&buf, data); err != nil {
+		log.Printf("[XCPD] Accept acknowledgement template error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	writeSignedSOAP(c, http.StatusOK, buf.Bytes())
+}
+
+// postXCPDAnswer posts a deferred answer to endpoint in the background. Failures
+// are logged; the answer is not retried.
+func postXCPDAnswer(endpoint, contentType string, body []byte, ref string) {
+	client := deferredClient.Load()
+	if client == nil {
+		log.Printf("[XCPD] Deferred answer to %s dropped: no outbound client %s", endpoint, ref)
+		return
+	}
+
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[XCPD] Deferred answer to %s failed: %v %s", endpoint, err, ref)
+			return
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Printf("[XCPD] Deferred answer to %s failed: %v %s", endpoint, err, ref)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		log.Printf("[XCPD] Deferred answer delivered to %s: %s %s", endpoint, resp.Status, ref)
+	}()
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"log"
+	"slices"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+)
+
+// ackDetailSystem is the HL7v3 AcknowledgementDetailCode code system.
+const ackDetailSystem = "2.16.840.1.113883.5.1100"
+
+// xcpdDeviceSettings are the device ids XCPD requests may be sent from and to, as
+// registered when a client is onboarded with Mitz. A nil list accepts any device.
+type xcpdDeviceSettings struct {
+	senders   []string
+	receivers []string
+}
+
+var xcpdDevices atomic.Pointer[xcpdDeviceSettings]
+
+func init() {
+	xcpdDevices.Store(&xcpdDeviceSettings{})
+}
+
+// InitXCPDDevices sets the registered sender and receiver device ids (URAs or OIDs)
+// of XCPD requests. An empty list disables the check.
+func InitXCPDDevices(senders, receivers []string) {
+	xcpdDevices.Store(&xcpdDeviceSettings{senders: senders, receivers: receivers})
+}
+
+// checkXCPDDevices answers req with an AE acknowledgement and reports false when its
+// sender or receiver device is not registered.
+func checkXCPDDevices(c *gin.Context, req *parser.XCPDRequest) bool {
+	settings := xcpdDevices.Load()
+	var detail *XCPDAckDetail
+	switch {
+	case len(settings.senders) > 0 && !slices.Contains(settings.senders, req.SenderOrg):
+		log.Printf("[XCPD] BSN=%s rejected: sender device %q is not registered %s", req.BSN, req.SenderOrg, requestRef(c))
+		detail = &XCPDAckDetail{Code: "NS260", Text: "Unknown sender: " + xmlEscape(req.SenderOrg)}
+	case len(settings.receivers) > 0 && !slices.Contains(settings.receivers, req.Receiver):
+		log.Printf("[XCPD] BSN=%s rejected: receiver device %q is not registered %s", req.BSN, req.Receiver, requestRef(c))
+		detail = &XCPDAckDetail{Code: "RTUDEST", Text: "Unknown receiver: " + xmlEscape(req.Receiver)}
+	default:
+		return true
+	}
+
+	detail.System = ackDetailSystem
+	writeXCPDFound(c, XCPDFoundData{RequestedBSN: req.BSN, Ack: &XCPDAck{
+		TypeCode:        "AE",
+		TargetRoot:      xmlEscape(req.MessageRoot),
+		TargetExtension: xmlEscape(req.MessageExtension),
+		Detail:          detail,
+	}})
+	return false
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/rules"
+)
+
+// connContextKey holds the request's client connection in the request context.
+type connContextKey struct{}
+
+// ConnContext records the client connection in the request context, so rule outcomes
+// can drop it. Install it as http.Server.ConnContext.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+// dropConnection makes the response end in an abrupt connection loss of the given
+// kind (rules.DisconnectBeforeHeaders, DisconnectMidResponse or DisconnectReset). The
+// request is still processed; only its response is lost.
+func dropConnection(c *gin.Context, mode string) {
+	conn, ok := c.Request.Context().Value(connContextKey{}).(net.Conn)
+	if !ok {
+		log.Printf("[RULES] Cannot drop connection: no client connection recorded %s", requestRef(c))
+		return
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// Close the socket underneath TLS: a close_notify alert would be a clean shutdown.
+		conn = tlsConn.NetConn()
+	}
+	skipCompression(c)
+	c.Writer = &disconnectWriter{ResponseWriter: c.Writer, conn: conn, mode: mode}
+}
+
+// disconnectWriter closes the client connection instead of (or halfway through)
+// writing the response.
+type disconnectWriter struct {
+	gin.ResponseWriter
+	conn   net.Conn
+	mode   string
+	closed bool
+}
+
+func (w *disconnectWriter) close() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	if tcp, ok := w.conn.(*net.TCPConn); ok && w.mode == rules.DisconnectReset {
+		tcp.SetLinger(0) // discard unsent data and send RST
+	}
+	w.conn.Close()
+}
+
+func (w *disconnectWriter) WriteHeaderNow() {
+	w.close()
+}
+
+func (w *disconnectWriter) Write(data []byte) (int, error) {
+	if w.mode == rules.DisconnectMidResponse && !w.closed {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.ResponseWriter.Write(data[:len(data)/2])
+		w.ResponseWriter.Flush()
+	}
+	w.close()
+	return len(data), nil
+}
+
+func (w *disconnectWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
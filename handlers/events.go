@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/storage"
+)
+
+// recordEvent appends a state change to the store's event log. Failures are logged;
+// the change itself has already happened.
+func recordEvent(st storage.Store, e storage.Event) {
+	e.Time = now()
+	if _, err := st.AppendEvent(e); err != nil {
+		log.Printf("[EVENTS] Failed to record %s %s: %v", e.Type, e.Resource, err)
+	}
+}
+
+// consentEventType returns whether storing consent creates or updates the consent
+// of its BSN and provider. Call it before the consent is saved.
+func consentEventType(st storage.Store, consent storage.Consent) string {
+	for _, existing := range st.Consents() {
+		if existing.BSN == consent.BSN && existing.ProviderID == consent.ProviderID {
+			return storage.EventConsentUpdated
+		}
+	}
+	return storage.EventConsentCreated
+}
+
+// HandleAdminEvents handles GET /admin/events?since=<seq> — the state changes after
+// sequence number since (all when omitted), so tests can assert the order and
+// completeness of effects. Poll with the returned lastSeq to get only new events.
+func HandleAdminEvents(c *gin.Context) {
+	var since int64
+	if v := c.Query("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since must be a non-negative sequence number"})
+			return
+		}
+		since = n
+	}
+
+	events := StoreFor(c).Events(since)
+	lastSeq := since
+	if len(events) > 0 {
+		lastSeq = events[len(events)-1].Seq
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"total":   len(events),
+		"lastSeq": lastSeq,
+		"events":  events,
+	})
+}
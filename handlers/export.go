@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/storage"
+)
+
+// exportJobTTL is how long a bulk export and its files can be fetched.
+const exportJobTTL = time.Hour
+
+// maxExportJobs caps the bulk exports held at once, over all sessions; each holds
+// its files in memory until it expires or is cancelled.
+const maxExportJobs = 16
+
+// ndjsonMediaType is the Content-Type of bulk export files.
+const ndjsonMediaType = "application/fhir+ndjson"
+
+// exportTypes are the resource types a bulk export can hold, in export order.
+var exportTypes = []string{"Consent", "Subscription"}
+
+// exportJob is a bulk export: its NDJSON files per resource type, available from ready on.
+type exportJob struct {
+	session         string // X-Test-Session of the kick-off; only that session sees the job
+	request         string
+	transactionTime time.Time
+	ready           time.Time
+	expires         time.Time
+	files           map[string]*exportFile
+}
+
+type exportFile struct {
+	count int
+	body  []byte
+}
+
+// ExportOutput is one file in the bulk export manifest.
+type ExportOutput struct {
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Count int    `json:"count"`
+}
+
+var exportDelay atomic.Int64
+
+var (
+	exportJobsMu sync.Mutex
+	exportJobs   = make(map[string]*exportJob)
+)
+
+// InitExport sets how long a bulk export stays in progress before its files are
+// ready, so clients polling $export-poll-status see 202 responses first.
+func InitExport(delay time.Duration) {
+	exportDelay.Store(int64(delay))
+}
+
+// clearExportJobs drops the bulk exports of session, leaving other sessions' alone.
+func clearExportJobs(session string) {
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	maps.DeleteFunc(exportJobs, func(_ string, job *exportJob) bool { return job.session == session })
+}
+
+// HandleFhirExport handles GET /fhir/$export — kicks off a bulk export of the stored
+// Consents and Subscriptions (_type, _since, _outputFormat). The files are taken
+// from the store at kick-off and listed by the poll-status URL in Content-Location.
+func HandleFhirExport(c *gin.Context) {
+	log.Printf("[FHIR] GET /$export %s _type=%q _since=%q", requestRef(c), c.Query("_type"), c.Query("_since"))
+
+	if !strings.Contains(c.GetHeader("Prefer"), "respond-async") {
+		renderFhirError(c, http.StatusBadRequest, "error", "invalid", "$export requires the header Prefer: respond-async")
+		return
+	}
+	if format := c.Query("_outputFormat"); format != "" && !slices.Contains([]string{ndjsonMediaType, "application/ndjson", "ndjson"}, format) {
+		renderFhirError(c, http.StatusBadRequest, "error", "not-supported", "Unsupported _outputFormat: "+format+" (only "+ndjsonMediaType+")")
+		return
+	}
+
+	types := exportTypes
+	if v := c.Query("_type"); v != "" {
+		types = strings.Split(v, ",")
+		for _, t := range types {
+			if !slices.Contains(exportTypes, t) {
+				renderFhirError(c, http.StatusBadRequest, "error", "not-supported", "Unsupported _type: "+t+" (expected Consent or Subscription)")
+				return
+			}
+		}
+	}
+
+	var since time.Time
+	if v := c.Query("_since"); v != "" {
+		t, err := parseFhirDateTime(v)
+		if err != nil {
+			renderFhirError(c, http.StatusBadRequest, "error", "invalid", "_since: "+err.Error())
+			return
+		}
+		since = t
+	}
+
+	t0 := now()
+	session := c.GetHeader(sessionHeader)
+	if !exportSlotFree(c, session, t0) {
+		return
+	}
+
+	st := StoreFor(c)
+	job := &exportJob{session: session, request: requestBaseURL(c) + c.Request.URL.RequestURI(), transactionTime: t0, files: make(map[string]*exportFile)}
+	for _, t := range exportTypes {
+		if !slices.Contains(types, t) {
+			continue
+		}
+		file, err := exportNDJSON(st, t, since)
+		if err != nil {
+			log.Printf("[FHIR] $export %s failed: %v", t, err)
+			renderFhirError(c, http.StatusInternalServerError, "fatal", "exception", "Export of "+t+" failed")
+			return
+		}
+		job.files[t] = file
+	}
+	job.ready = t0.Add(time.Duration(exportDelay.Load()))
+	job.expires = job.ready.Add(exportJobTTL)
+
+	exportJobsMu.Lock()
+	if !exportSlotFreeLocked(c, session, t0) {
+		exportJobsMu.Unlock()
+		return
+	}
+	id := newKey(func(id string) bool { _, ok := exportJobs[id]; return ok })
+	exportJobs[id] = job
+	exportJobsMu.Unlock()
+
+	log.Printf("[FHIR] $export %s started: %s", id, exportSummary(job))
+	c.Header("Content-Location", requestBaseURL(c)+"/fhir/$export-poll-status/"+id)
+	c.Status(http.StatusAccepted)
+}
+
+// exportSlotFree reports whether session may start a bulk export at t. It answers
+// 429 with Retry-After and returns false while an export of the session is still in
+// progress, or while maxExportJobs exports are held.
+func exportSlotFree(c *gin.Context, session string, t time.Time) bool {
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	return exportSlotFreeLocked(c, session, t)
+}
+
+// exportSlotFreeLocked is exportSlotFree for callers holding exportJobsMu.
+func exportSlotFreeLocked(c *gin.Context, session string, t time.Time) bool {
+	pruneExportJobs(t)
+	var until time.Time
+	reason := ""
+	for _, job := range exportJobs {
+		if job.session == session && t.Before(job.ready) {
+			until, reason = job.ready, "An export of this session is still in progress"
+			break
+		}
+		if len(exportJobs) >= maxExportJobs && (until.IsZero() || job.expires.Before(until)) {
+			until, reason = job.expires, "Too many exports held; cancel one or wait for one to expire"
+		}
+	}
+	if reason == "" {
+		return true
+	}
+
+	log.Printf("[FHIR] $export refused: %s %s", reason, requestRef(c))
+	c.Header("Retry-After", strconv.Itoa(max(1, int(until.Sub(t).Round(time.Second).Seconds()))))
+	renderFhirError(c, http.StatusTooManyRequests, "error", "throttled", reason)
+	return false
+}
+
+// exportNDJSON renders the stored resources of type t created since since, one R4
+// JSON resource per line.
+func exportNDJSON(st storage.Store, t string, since time.Time) (*exportFile, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	file := &exportFile{}
+
+	switch t {
+	case "Consent":
+		for _, consent := range st.Consents() {
+			if consent.Created.Before(since) {
+				continue
+			}
+			if err := enc.Encode(r4ConsentResource(consent)); err != nil {
+				return nil, err
+			}
+			file.count++
+		}
+	case "Subscription":
+		for _, sub := range st.Subscriptions() {
+			if sub.Created.Before(since) {
+				continue
+			}
+			if err := enc.Encode(r4SubscriptionResource(sub)); err != nil {
+				return nil, err
+			}
+			file.count++
+		}
+	}
+
+	file.body = buf.Bytes()
+	return file, nil
+}
+
+type r4Meta struct {
+	LastUpdated string `json:"lastUpdated"`
+}
+
+type r4Extension struct {
+	URL         string `json:"url"`
+	ValueString string `json:"valueString"`
+}
+
+type r4Element struct {
+	Extension []r4Extension `json:"extension"`
+}
+
+type r4Channel struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+	Payload  string `json:"payload"`
+}
+
+type r4Subscription struct {
+	ResourceType      string     `json:"resourceType"`
+	ID                string     `json:"id"`
+	Meta              r4Meta     `json:"meta"`
+	Status            string     `json:"status"`
+	Reason            string     `json:"reason"`
+	Criteria          string     `json:"criteria"`
+	CriteriaExtension *r4Element `json:"_criteria,omitempty"`
+	Channel           r4Channel  `json:"channel"`
+}
+
+// r4SubscriptionResource converts the stored subscription to an R4 Subscription.
+// Topic-based subscriptions carry their filter in the backport extension, as in
+// the fhir_subscription template.
+func r4SubscriptionResource(sub storage.Subscription) r4Subscription {
+	resource := r4Subscription{
+		ResourceType: "Subscription",
+		ID:           sub.ID,
+		Meta:         r4Meta{LastUpdated: fhirDate(sub.Created)},
+		Status:       sub.Status,
+		Reason:       "OTV consent subscription",
+		Criteria:     sub.Criteria,
+		Channel:      r4Channel{Type: "rest-hook", Endpoint: sub.Endpoint, Payload: sub.PayloadType},
+	}
+	if sub.Topic != "" {
+		resource.Criteria = sub.Topic
+		resource.CriteriaExtension = &r4Element{Extension: []r4Extension{{
+			URL:         "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-filter-criteria",
+			ValueString: sub.Criteria,
+		}}}
+	}
+	return resource
+}
+
+// exportSummary lists the resource counts of job for logging.
+func exportSummary(job *exportJob) string {
+	var parts []string
+	for _, t := range exportTypes {
+		if f, ok := job.files[t]; ok {
+			parts = append(parts, strconv.Itoa(f.count)+" "+t)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// lookupExportJob returns the unexpired export id of session at t.
+func lookupExportJob(id, session string, t time.Time) (*exportJob, bool) {
+	exportJobsMu.Lock()
+	defer exportJobsMu.Unlock()
+	pruneExportJobs(t)
+	job, ok := exportJobs[id]
+	if !ok || job.session != session {
+		return nil, false
+	}
+	return job, true
+}
+
+// pruneExportJobs drops expired exports. Callers hold exportJobsMu.
+func pruneExportJobs(now time.Time) {
+	for id, job := range exportJobs {
+		if now.After(job.expires) {
+			delete(exportJobs, id)
+		}
+	}
+}
+
+// HandleFhirExportStatus handles GET /fhir/$export-poll-status/:id — 202 with
+// X-Progress while the export runs, then 200 with the manifest of NDJSON files.
+func HandleFhirExportStatus(c *gin.Context) {
+	id, t := c.Param("id"), now()
+	job, ok := lookupExportJob(id, c.GetHeader(sessionHeader), t)
+	if !ok {
+		renderFhirError(c, http.StatusNotFound, "error", "not-found", "Export "+id+" is unknown, cancelled or expired")
+		return
+	}
+
+	if wait := job.ready.Sub(t); wait > 0 {
+		c.Header("X-Progress", "in-progress")
+		c.Header("Retry-After", strconv.Itoa(max(1, int(wait.Round(time.Second).Seconds()))))
+		c.Status(http.StatusAccepted)
+		return
+	}
+
+	output := []ExportOutput{}
+	for _, t := range exportTypes {
+		if f, ok := job.files[t]; ok {
+			output = append(output, ExportOutput{Type: t, URL: requestBaseURL(c) + "/fhir/$export-file/" + id + "/" + t, Count: f.count})
+		}
+	}
+	c.Header("Expires", job.expires.UTC().Format(http.TimeFormat))
+	c.JSON(http.StatusOK, gin.H{
+		"transactionTime":     job.transactionTime.Format(time.RFC3339),
+		"request":             job.request,
+		"requiresAccessToken": false,
+		"output":              output,
+		"error":               []ExportOutput{},
+	})
+}
+
+// HandleFhirExportCancel handles DELETE /fhir/$export-poll-status/:id — cancels the
+// export or deletes its files.
+func HandleFhirExportCancel(c *gin.Context) {
+	id := c.Param("id")
+	exportJobsMu.Lock()
+	job, ok := exportJobs[id]
+	ok = ok && job.session == c.GetHeader(sessionHeader)
+	if ok {
+		delete(exportJobs, id)
+	}
+	exportJobsMu.Unlock()
+
+	if !ok {
+		renderFhirError(c, http.StatusNotFound, "error", "not-found", "Export "+id+" is unknown, cancelled or expired")
+		return
+	}
+	log.Printf("[FHIR] $export %s cancelled %s", id, requestRef(c))
+	c.Status(http.StatusAccepted)
+}
+
+// HandleFhirExportFile handles GET /fhir/$export-file/:id/:type — one NDJSON file
+// of a completed export.
+func HandleFhirExportFile(c *gin.Context) {
+	id, t, at := c.Param("id"), c.Param("type"), now()
+	job, ok := lookupExportJob(id, c.GetHeader(sessionHeader), at)
+	if !ok || at.Before(job.ready) {
+		renderFhirError(c, http.StatusNotFound, "error", "not-found", "Export "+id+" is unknown, not complete or expired")
+		return
+	}
+	file, ok := job.files[t]
+	if !ok {
+		renderFhirError(c, http.StatusNotFound, "error", "not-found", "Export "+id+" has no "+t+" file")
+		return
+	}
+	c.Data(http.StatusOK, ndjsonMediaType, file.body)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+)
+
+// isFhirRoute reports whether the request targets one of the FHIR endpoints.
+func isFhirRoute(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/fhir")
+}
+
+// xmlEscape escapes s for inclusion in XML text or attribute values.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// renderSoapFault writes a SOAP 1.2 fault with the given HTTP status.
+func renderSoapFault(c *gin.Context, status int, data FaultData) {
+	renderSoapFaultWith(c, lookupTemplate(c, "xacml_fault"), status, data)
+}
+
+// abortWithRouteError aborts the request with an error shaped for the route:
+// an OperationOutcome on FHIR endpoints, a SOAP fault with the given subcode otherwise.
+func abortWithRouteError(c *gin.Context, status int, fhirCode, soapSubcode, reason string) {
+	if isFhirRoute(c) {
+		renderFhirError(c, status, "error", fhirCode, reason)
+	} else {
+		faultCode := "soap:Receiver"
+		if status < http.StatusInternalServerError {
+			faultCode = "soap:Sender"
+		}
+		renderSoapFault(c, status, FaultData{
+			FaultCode:    faultCode,
+			FaultSubcode: soapSubcode,
+			FaultReason:  reason,
+			FaultDetail:  "RequestId: " + c.GetHeader("X-Request-Id"),
+		})
+	}
+	c.Abort()
+}
+
+// parseErrorSubcode maps a parser error kind to a SOAP fault subcode.
+func parseErrorSubcode(err error) string {
+	switch {
+	case errors.Is(err, parser.ErrMissingBSN):
+		return "mitz:MissingBSN"
+	case errors.Is(err, parser.ErrUnsupportedInteraction):
+		return "mitz:UnsupportedInteraction"
+	case errors.Is(err, parser.ErrMissingAttribute):
+		return "mitz:MissingAttribute"
+	default:
+		return "mitz:InvalidRequest"
+	}
+}
+
+// parseErrorIssueCode maps a parser error kind to a FHIR OperationOutcome issue code.
+func parseErrorIssueCode(err error) string {
+	switch {
+	case errors.Is(err, parser.ErrMissingBSN), errors.Is(err, parser.ErrMissingAttribute):
+		return "required"
+	case errors.Is(err, parser.ErrUnsupportedInteraction):
+		return "not-supported"
+	default:
+		return "structure"
+	}
+}
+
+// renderSoapParseFault writes a 400 SOAP Sender fault describing a parse error. The
+// detail names each missing XACML attribute with its AttributeId and category.
+func renderSoapParseFault(c *gin.Context, err error) {
+	detail := "RequestId: " + c.GetHeader("X-Request-Id")
+	var missing *parser.MissingAttributeError
+	if errors.As(err, &missing) {
+		for _, a := range missing.Attributes {
+			detail += "\nMissingAttribute: " + a.AttributeID + " (" + strings.Join(a.Categories, " or ") + ")"
+		}
+	}
+	renderSoapFault(c, http.StatusBadRequest, FaultData{
+		FaultCode:    "soap:Sender",
+		FaultSubcode: parseErrorSubcode(err),
+		FaultReason:  err.Error(),
+		FaultDetail:  detail,
+	})
+}
@@ -2,40 +2,51 @@ package handlers
 
 import (
 	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
-	"text/template"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 
 	"mitz-replicator/auth"
 	"mitz-replicator/parser"
+	"mitz-replicator/rules"
+	"mitz-replicator/storage"
 )
 
-const fhirContentType = "application/fhir+xml; charset=utf-8"
-
 // --- Template data types ---
 
 // FhirSubscriptionData is the template data for fhir_subscription.xml.
 type FhirSubscriptionData struct {
 	SubscriptionID string
+	Status         string
 	Criteria       string
+	Topic          string // SubscriptionTopic canonical of a topic-based (R4B) Subscription
 	Endpoint       string
 	PayloadType    string
 }
 
+// FhirSubscriptionSearchsetData is the template data for fhir_subscription_searchset.xml.
+type FhirSubscriptionSearchsetData struct {
+	BundleID      string
+	Subscriptions []FhirSubscriptionData
+}
+
 // FhirBundleResponseEntry represents one entry in a Bundle transaction-response.
 type FhirBundleResponseEntry struct {
+	FullURL  string // absolute URL of the created resource
 	Status   string
 	Location string
+	Etag     string
 }
 
 // FhirBundleResponseData is the template data for fhir_bundle_response.xml.
 type FhirBundleResponseData struct {
 	BundleID string
+	SelfURL  string // Bundle.link[self]: the transaction endpoint
 	Entries  []FhirBundleResponseEntry
 }
 
@@ -45,20 +56,21 @@ type FhirProcessingStatusData struct {
 }
 
 // FhirOperationOutcomeData is the template data for fhir_operation_outcome.xml.
+// Severity, Code and Diagnostics repeat the first issue.
 type FhirOperationOutcomeData struct {
 	Severity    string
 	Code        string
 	Diagnostics string
+	Issues      []FhirIssue
 }
 
-// --- Template variables ---
-
-var (
-	fhirSubscriptionTmpl     *template.Template
-	fhirBundleResponseTmpl   *template.Template
-	fhirProcessingStatusTmpl *template.Template
-	fhirOperationOutcomeTmpl *template.Template
-)
+// FhirIssue is one OperationOutcome.issue; Expression is the FHIRPath of the element.
+type FhirIssue struct {
+	Severity    string
+	Code        string
+	Diagnostics string
+	Expression  string
+}
 
 // --- SAML validator ---
 
@@ -70,95 +82,161 @@ func InitSamlValidator(v *auth.SamlValidator) {
 	samlValidator = v
 }
 
-// InitFhirTemplates loads the FHIR response templates.
-func InitFhirTemplates(subscriptionXML, bundleResponseXML, processingStatusXML, operationOutcomeXML string) {
-	fhirSubscriptionTmpl = template.Must(template.New("fhir_subscription").Parse(subscriptionXML))
-	fhirBundleResponseTmpl = template.Must(template.New("fhir_bundle_response").Parse(bundleResponseXML))
-	fhirProcessingStatusTmpl = template.Must(template.New("fhir_processing_status").Parse(processingStatusXML))
-	fhirOperationOutcomeTmpl = template.Must(template.New("fhir_operation_outcome").Parse(operationOutcomeXML))
-}
-
 // HandleFhirSubscriptionCreate handles POST /fhir/Subscription — create consent subscription (OTV-TR-0120).
 func HandleFhirSubscriptionCreate(c *gin.Context) {
 	body, err := c.GetRawData()
 	if err != nil {
 		log.Printf("[FHIR] Failed to read Subscription request body: %v", err)
-		c.Status(http.StatusBadRequest)
+		abortBodyError(c, err)
+		return
+	}
+	if !validateProfile(c, "Subscription", body) {
 		return
 	}
 
-	req, err := parser.ParseFhirSubscription(body)
+	req, err := parser.ParseFhirSubscriptionWith(body, strictnessFor(c))
+	markPhase(c, phaseParse)
 	if err != nil {
 		log.Printf("[FHIR] Failed to parse Subscription: %v", err)
-		renderFhirError(c, http.StatusBadRequest, "invalid", "processing", "Failed to parse Subscription request")
+		renderFhirError(c, http.StatusBadRequest, "error", parseErrorIssueCode(err),
+			fmt.Sprintf("Failed to parse Subscription request: %v", err))
+		return
+	}
+
+	log.Printf("[FHIR] POST /Subscription %s BSN=%s ProviderID=%s Categories=%v", requestRef(c), req.BSN, req.ProviderID, req.Categories)
+	if problem := subscriptionShapeProblem(req); problem != "" {
+		renderFhirError(c, http.StatusBadRequest, "error", "not-supported", problem)
+		return
+	}
+	if err := checkNotificationFormat(notificationFormatFor(req.PayloadType)); err != nil {
+		log.Printf("[FHIR] Subscription rejected: %v %s", err, requestRef(c))
+		renderFhirError(c, http.StatusUnprocessableEntity, "error", "not-supported",
+			"Signed notifications are only sent as XML: channel.payload "+req.PayloadType+" would get unsigned R4 JSON; use application/fhir+xml")
 		return
 	}
 
-	requestID := c.GetHeader("X-Request-Id")
-	log.Printf("[FHIR] POST /Subscription RequestId=%s BSN=%s ProviderID=%s", requestID, req.BSN, req.ProviderID)
+	// Rule-based routing (BSN / URA)
+	outcome, _ := evaluateRules(c, rules.Request{Endpoint: rules.EndpointSubscription, BSN: req.BSN, URA: req.ProviderID})
+	if outcome.FhirError != nil {
+		renderRuleFhirError(c, outcome.FhirError)
+		return
+	}
 
-	// BSN-based routing
-	switch req.BSN {
-	case "000000003":
-		renderFhirError(c, http.StatusBadRequest, "error", "processing", "Patient BSN not found in register")
+	// Success: record and return 202 Accepted with Subscription resource
+	sub, limit, err := saveWithinQuota(StoreFor(c), req.ProviderID, func() storage.Subscription {
+		return storage.Subscription{
+			ID:          newKey(subscriptionExists(StoreFor(c))),
+			BSN:         req.BSN,
+			ProviderID:  req.ProviderID,
+			Criteria:    req.Criteria,
+			Categories:  req.Categories,
+			Topic:       req.Topic,
+			Endpoint:    req.Endpoint,
+			PayloadType: req.PayloadType,
+			Status:      "active",
+			Created:     now(),
+
+			NotificationFormat: notificationFormatFor(req.PayloadType),
+		}
+	})
+	if errors.Is(err, errQuotaExceeded) {
+		log.Printf("[FHIR] Subscription quota exceeded for provider %s (limit %d)", req.ProviderID, limit)
+		renderFhirError(c, http.StatusUnprocessableEntity, "error", "too-costly",
+			fmt.Sprintf("Subscription quota exceeded: provider %s has reached its limit of %d active subscriptions", req.ProviderID, limit))
 		return
-	case "000000004":
-		c.Header("Retry-After", "30")
-		renderFhirError(c, http.StatusTooManyRequests, "error", "throttled", "Rate limit exceeded — retry after 30s")
+	}
+	if err != nil {
+		log.Printf("[FHIR] Failed to store Subscription: %v", err)
+		renderFhirError(c, http.StatusInternalServerError, "fatal", "exception", "Failed to store Subscription")
 		return
-	case "000000005":
-		renderFhirError(c, http.StatusInternalServerError, "fatal", "exception", "Internal server error")
+	}
+	recordEvent(StoreFor(c), storage.Event{Type: storage.EventSubscriptionCreated, Resource: "Subscription/" + sub.ID, BSN: sub.BSN})
+	markPhase(c, phaseStore)
+
+	renderSubscription(c, http.StatusAccepted, sub)
+}
+
+// HandleFhirSubscriptionRead handles GET /fhir/Subscription/:id — read a recorded subscription.
+func HandleFhirSubscriptionRead(c *gin.Context) {
+	subID := c.Param("id")
+	log.Printf("[FHIR] GET /Subscription/%s %s", subID, requestRef(c))
+
+	sub, ok := StoreFor(c).Subscription(subID)
+	if !ok {
+		renderFhirError(c, http.StatusNotFound, "error", "not-found", "Subscription not found")
 		return
 	}
 
-	// Success: return 202 Accepted with Subscription resource
-	data := FhirSubscriptionData{
-		SubscriptionID: uuid.New().String(),
-		Criteria:       req.Criteria,
-		Endpoint:       req.Endpoint,
-		PayloadType:    req.PayloadType,
+	renderSubscription(c, http.StatusOK, sub)
+}
+
+// HandleFhirSubscriptionSearch handles GET /fhir/Subscription?criteria=&status= — search recorded subscriptions.
+func HandleFhirSubscriptionSearch(c *gin.Context) {
+	criteria := c.Query("criteria")
+	status := c.Query("status")
+	log.Printf("[FHIR] GET /Subscription %s criteria=%q status=%q", requestRef(c), criteria, status)
+
+	data := FhirSubscriptionSearchsetData{BundleID: newIDFor(c)}
+	for _, sub := range StoreFor(c).Subscriptions() {
+		if criteria != "" && !strings.Contains(sub.Criteria, criteria) {
+			continue
+		}
+		if status != "" && sub.Status != status {
+			continue
+		}
+		data.Subscriptions = append(data.Subscriptions, subscriptionData(sub))
 	}
 
 	var buf bytes.Buffer
-	if err := fhirSubscriptionTmpl.Execute(&buf, data); err != nil {
-		log.Printf("[FHIR] Subscription template error: %v", err)
+	if err := lookupTemplate(c, "fhir_subscription_searchset").Execute(&buf, data); err != nil {
+		log.Printf("[FHIR] Subscription searchset template error: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	c.Data(http.StatusAccepted, fhirContentType, buf.Bytes())
+	c.Data(http.StatusOK, fhirContentType(c), buf.Bytes())
 }
 
 // HandleFhirSubscriptionDelete handles DELETE /fhir/Subscription/:id — cancel subscription (OTV-TR-0130).
 func HandleFhirSubscriptionDelete(c *gin.Context) {
 	subID := c.Param("id")
-	requestID := c.GetHeader("X-Request-Id")
-	log.Printf("[FHIR] DELETE /Subscription/%s RequestId=%s", subID, requestID)
+	log.Printf("[FHIR] DELETE /Subscription/%s %s", subID, requestRef(c))
 
 	// Specific IDs that return errors
 	switch subID {
-	case "00000000-0000-0000-0000-000000000004":
-		renderFhirError(c, http.StatusNotFound, "error", "not-found", "Subscription not found")
-		return
 	case "00000000-0000-0000-0000-000000000005":
 		renderFhirError(c, http.StatusInternalServerError, "fatal", "exception", "Internal server error")
 		return
 	}
 
+	// Unknown IDs (including 00000000-0000-0000-0000-000000000004) → 404
+	sub, ok := StoreFor(c).Subscription(subID)
+	if !ok {
+		renderFhirError(c, http.StatusNotFound, "error", "not-found", "Subscription not found")
+		return
+	}
+
+	sub.Status = "off"
+	if err := StoreFor(c).SaveSubscription(sub); err != nil {
+		log.Printf("[FHIR] Failed to cancel Subscription: %v", err)
+		renderFhirError(c, http.StatusInternalServerError, "fatal", "exception", "Failed to cancel Subscription")
+		return
+	}
+	recordEvent(StoreFor(c), storage.Event{Type: storage.EventSubscriptionCancelled, Resource: "Subscription/" + sub.ID, BSN: sub.BSN})
+
 	c.Status(http.StatusNoContent)
 }
 
 // HandleFhirProcessingStatus handles GET /fhir/{Subscription|Consent}/$processingStatus.
 func HandleFhirProcessingStatus(c *gin.Context) {
 	providerID := c.Query("providerid")
-	requestID := c.GetHeader("X-Request-Id")
 
 	resourceType := "Subscription"
 	if strings.Contains(c.Request.URL.Path, "/Consent/") {
 		resourceType = "Consent"
 	}
 
-	log.Printf("[FHIR] GET %s/$processingStatus RequestId=%s ProviderID=%s", resourceType, requestID, providerID)
+	log.Printf("[FHIR] GET %s/$processingStatus %s ProviderID=%s", resourceType, requestRef(c), providerID)
 
 	// Provider-based routing
 	switch providerID {
@@ -182,24 +260,31 @@ func HandleFhirBundle(c *gin.Context) {
 	body, err := c.GetRawData()
 	if err != nil {
 		log.Printf("[FHIR] Failed to read Bundle request body: %v", err)
-		c.Status(http.StatusBadRequest)
+		abortBodyError(c, err)
+		return
+	}
+	if !validateTransaction(c, body) || !validateProfile(c, "Bundle", body) {
 		return
 	}
 
-	req, err := parser.ParseFhirBundle(body)
+	req, err := parser.ParseFhirBundleWith(body, strictnessFor(c))
+	markPhase(c, phaseParse)
 	if err != nil {
 		log.Printf("[FHIR] Failed to parse Bundle: %v", err)
-		renderFhirError(c, http.StatusBadRequest, "error", "processing", "Failed to parse Bundle request")
+		renderFhirError(c, http.StatusBadRequest, "error", parseErrorIssueCode(err),
+			fmt.Sprintf("Failed to parse Bundle request: %v", err))
 		return
 	}
 
-	requestID := c.GetHeader("X-Request-Id")
 	txType := "migration"
 	if req.HasProvenance {
 		txType = "toestemmingsknop"
 	}
-	log.Printf("[FHIR] POST / Bundle RequestId=%s BSN=%s Type=%s Entries=%d",
-		requestID, req.BSN, txType, req.EntryCount)
+	log.Printf("[FHIR] POST / Bundle %s BSN=%s Type=%s Entries=%d",
+		requestRef(c), req.BSN, txType, req.EntryCount)
+	if !checkTestcaseBundle(c, txType) {
+		return
+	}
 
 	// SAML validation for migration bundles (OTV-TR-0150); toestemmingsknop uses Bearer JWT
 	if txType == "migration" && samlValidator != nil && samlValidator.IsEnabled() {
@@ -210,86 +295,173 @@ func HandleFhirBundle(c *gin.Context) {
 		}
 	}
 
-	// BSN-based routing
-	switch req.BSN {
-	case "000000003":
-		renderFhirError(c, http.StatusBadRequest, "error", "processing", "Patient BSN not found in register")
+	// Rule-based routing (BSN / URA)
+	outcome, _ := evaluateRules(c, rules.Request{Endpoint: rules.EndpointBundle, BSN: req.BSN, URA: req.ProviderID})
+	if outcome.FhirError != nil {
+		renderRuleFhirError(c, outcome.FhirError)
 		return
-	case "000000004":
-		c.Header("Retry-After", "30")
-		renderFhirError(c, http.StatusTooManyRequests, "error", "throttled", "Rate limit exceeded — retry after 30s")
-		return
-	case "000000005":
-		renderFhirError(c, http.StatusInternalServerError, "fatal", "exception", "Internal server error")
+	}
+
+	// No consent can be registered for a deceased patient
+	if patient, ok := deceasedPatient(req.BSN); ok && req.HasConsent {
+		log.Printf("[FHIR] Bundle rejected: BSN=%s is deceased %s", req.BSN, requestRef(c))
+		renderFhirError(c, http.StatusUnprocessableEntity, "error", "business-rule", deceasedMessage(patient))
 		return
 	}
 
 	// Build response entries matching the input resources
 	entries := []FhirBundleResponseEntry{
-		{Status: "201 Created", Location: "Patient/" + uuid.New().String()},
+		{Status: "201 Created", Location: "Patient/" + newIDFor(c)},
 	}
 	if req.HasOrganization {
 		entries = append(entries, FhirBundleResponseEntry{
 			Status:   "201 Created",
-			Location: "Organization/" + uuid.New().String(),
+			Location: "Organization/" + newIDFor(c),
 		})
 	}
+	bundleID := newIDFor(c)
 	if req.HasConsent {
+		effectiveFrom, effectiveUntil, err := parseConsentPeriod(req.ConsentStart, req.ConsentEnd)
+		if err != nil {
+			renderFhirError(c, http.StatusBadRequest, "error", "invalid", "Consent.provision."+err.Error())
+			return
+		}
+		consentID := newKey(consentExists(StoreFor(c)))
+		consent := storage.Consent{
+			ID:         consentID,
+			BSN:        req.BSN,
+			Status:     req.ConsentStatus,
+			Decision:   req.ConsentDecision,
+			Categories: req.Categories,
+			ProviderID: req.ProviderID,
+			Tenant:     c.GetHeader("X-Tenant"),
+			Source:     txType,
+			BundleID:   bundleID,
+			Created:    now(),
+
+			EffectiveFrom:  effectiveFrom,
+			EffectiveUntil: effectiveUntil,
+		}
+		eventType := consentEventType(StoreFor(c), consent)
+		if err := StoreFor(c).SaveConsent(consent); err != nil {
+			log.Printf("[FHIR] Failed to store Consent: %v", err)
+			renderFhirError(c, http.StatusInternalServerError, "fatal", "exception", "Failed to store Consent")
+			return
+		}
+		recordEvent(StoreFor(c), storage.Event{Type: eventType, Resource: "Consent/" + consent.ID, BSN: consent.BSN})
+		notifyConsentChange(c, StoreFor(c), consent)
+		markPhase(c, phaseStore)
 		entries = append(entries, FhirBundleResponseEntry{
 			Status:   "201 Created",
-			Location: "Consent/" + uuid.New().String(),
+			Location: "Consent/" + consentID,
 		})
 	}
 	if req.HasProvenance {
 		entries = append(entries, FhirBundleResponseEntry{
 			Status:   "201 Created",
-			Location: "Provenance/" + uuid.New().String(),
+			Location: "Provenance/" + newIDFor(c),
 		})
 	}
 
+	base := fhirBaseURL(c)
+	for i := range entries {
+		entries[i].FullURL = base + "/" + entries[i].Location
+		entries[i].Etag = xmlEscape(`W/"1"`)
+	}
 	data := FhirBundleResponseData{
-		BundleID: uuid.New().String(),
+		BundleID: bundleID,
+		SelfURL:  base,
 		Entries:  entries,
 	}
 
 	var buf bytes.Buffer
-	if err := fhirBundleResponseTmpl.Execute(&buf, data); err != nil {
+	if err := lookupTemplate(c, "fhir_bundle_response").Execute(&buf, data); err != nil {
 		log.Printf("[FHIR] Bundle response template error: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	c.Data(http.StatusOK, fhirContentType, buf.Bytes())
+	c.Data(http.StatusOK, fhirContentType(c), buf.Bytes())
 }
 
 // --- Rendering helpers ---
 
+// fhirBaseURL returns the escaped, absolute FHIR base URL the request was sent to.
+func fhirBaseURL(c *gin.Context) string {
+	return xmlEscape(requestBaseURL(c) + "/fhir")
+}
+
+// requestBaseURL returns the scheme and host the request was sent to.
+// X-Forwarded-Proto and X-Forwarded-Host from a trusted proxy in front of the
+// replicator win.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.ToLower(forwardedHeader(c, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := cmp.Or(forwardedHeader(c, "X-Forwarded-Host"), c.Request.Host)
+	return scheme + "://" + host
+}
+
+// subscriptionData converts a stored subscription into escaped template data.
+func subscriptionData(sub storage.Subscription) FhirSubscriptionData {
+	return FhirSubscriptionData{
+		SubscriptionID: sub.ID,
+		Status:         sub.Status,
+		Criteria:       xmlEscape(sub.Criteria),
+		Topic:          xmlEscape(sub.Topic),
+		Endpoint:       xmlEscape(sub.Endpoint),
+		PayloadType:    xmlEscape(sub.PayloadType),
+	}
+}
+
+func renderSubscription(c *gin.Context, status int, sub storage.Subscription) {
+	var buf bytes.Buffer
+	if err := lookupTemplate(c, "fhir_subscription").Execute(&buf, subscriptionData(sub)); err != nil {
+		log.Printf("[FHIR] Subscription template error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Data(status, fhirContentType(c), signIf(signSubscriptions, buf.Bytes()))
+}
+
 func renderProcessingStatus(c *gin.Context, count int) {
 	data := FhirProcessingStatusData{Count: count}
 
 	var buf bytes.Buffer
-	if err := fhirProcessingStatusTmpl.Execute(&buf, data); err != nil {
+	if err := lookupTemplate(c, "fhir_processing_status").Execute(&buf, data); err != nil {
 		log.Printf("[FHIR] Processing status template error: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	c.Data(http.StatusOK, fhirContentType, buf.Bytes())
+	c.Data(http.StatusOK, fhirContentType(c), buf.Bytes())
 }
 
 func renderFhirError(c *gin.Context, status int, severity, code, diagnostics string) {
-	data := FhirOperationOutcomeData{
-		Severity:    severity,
-		Code:        code,
-		Diagnostics: diagnostics,
+	renderFhirIssues(c, status, []FhirIssue{{Severity: severity, Code: code, Diagnostics: diagnostics}})
+}
+
+// renderFhirIssues writes an OperationOutcome with one or more issues.
+func renderFhirIssues(c *gin.Context, status int, issues []FhirIssue) {
+	data := FhirOperationOutcomeData{Issues: make([]FhirIssue, len(issues))}
+	for i, issue := range issues {
+		issue.Diagnostics = xmlEscape(issue.Diagnostics)
+		issue.Expression = xmlEscape(issue.Expression)
+		data.Issues[i] = issue
 	}
+	data.Severity, data.Code, data.Diagnostics = data.Issues[0].Severity, data.Issues[0].Code, data.Issues[0].Diagnostics
 
 	var buf bytes.Buffer
-	if err := fhirOperationOutcomeTmpl.Execute(&buf, data); err != nil {
+	if err := lookupTemplate(c, "fhir_operation_outcome").Execute(&buf, data); err != nil {
 		log.Printf("[FHIR] OperationOutcome template error: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	c.Data(status, fhirContentType, buf.Bytes())
-}
\ No newline at end of file
+	c.Data(status, fhirContentType(c), buf.Bytes())
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/fhirtest"
+	"mitz-replicator/rules"
+)
+
+func TestRenderFhirIssues(t *testing.T) {
+	loadRepoTemplates(t)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/fhir/", nil)
+	renderFhirIssues(c, http.StatusUnprocessableEntity, []FhirIssue{
+		{Severity: "error", Code: "required", Diagnostics: `Consent.patient is missing for <bsn> & "ura"`, Expression: "Consent.patient"},
+		{Severity: "warning", Code: "business-rule", Diagnostics: "Consent.period.end is in the past"},
+	})
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422", w.Code)
+	}
+	issue := fhirtest.AssertIssue(t, w.Body.Bytes(), "required", "error")
+	if want := `Consent.patient is missing for <bsn> & "ura"`; issue.Diagnostics != want {
+		t.Errorf("diagnostics %q, want %q", issue.Diagnostics, want)
+	}
+	if !slices.Equal(issue.Expression, []string{"Consent.patient"}) {
+		t.Errorf("expression %q, want [Consent.patient]", issue.Expression)
+	}
+	fhirtest.AssertIssue(t, w.Body.Bytes(), "business-rule", "warning")
+}
+
+func TestRenderRuleFhirErrorDefaultsToError(t *testing.T) {
+	loadRepoTemplates(t)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/fhir/", nil)
+	renderRuleFhirError(c, &rules.FhirError{Status: http.StatusTooManyRequests, Code: "throttled", Diagnostics: "slow down"})
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", w.Code)
+	}
+	outcome := fhirtest.MustParse(t, w.Body.Bytes())
+	if !outcome.HasIssue("throttled", "error") || !outcome.IsError() {
+		t.Errorf("got %s, want an error-level throttled issue", outcome)
+	}
+}
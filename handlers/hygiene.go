@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// singletonHeaders may appear at most once on a request passing the Mitz gateway.
+// Content-Length, Transfer-Encoding and Host are not listed: net/http rejects or
+// normalises repeats of those before any middleware runs (see README).
+var singletonHeaders = []string{
+	"Content-Type",
+	"Content-Encoding",
+	"Authorization",
+	"SOAPAction",
+	"X-Request-Id",
+}
+
+// HeaderHygiene returns a middleware that, when strict is set, rejects requests with
+// duplicate singleton headers — the way the gateway in front of Mitz does.
+func HeaderHygiene(strict bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strict {
+			c.Next()
+			return
+		}
+
+		if problem := headerProblem(c.Request); problem != "" {
+			log.Printf("[HYGIENE] Rejecting %s %s: %s %s",
+				c.Request.Method, c.Request.URL.Path, problem, requestRef(c))
+			c.Header("Connection", "close")
+			c.String(http.StatusBadRequest, "400 Bad Request\nRejected by gateway: %s\n", problem)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// headerProblem returns a description of the first transport header violation, or "".
+func headerProblem(r *http.Request) string {
+	for _, name := range singletonHeaders {
+		if values := r.Header.Values(name); len(values) > 1 {
+			return fmt.Sprintf("duplicate %s header (%d values)", name, len(values))
+		}
+	}
+
+	// Repeated headers folded into one comma-separated value by an intermediary
+	if v := r.Header.Get("Content-Type"); strings.Contains(v, ",") {
+		return fmt.Sprintf("duplicate Content-Type header (folded value %q)", v)
+	}
+
+	return ""
+}
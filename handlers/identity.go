@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/identity"
+)
+
+// identityContextKey holds the caller's resolved client certificate identity.
+const identityContextKey = "mitz.identity"
+
+var identityResolver atomic.Pointer[identity.Resolver]
+
+func init() {
+	identityResolver.Store(identity.New(nil))
+}
+
+// InitIdentity sets the resolver used to map client certificates to a URA.
+func InitIdentity(r *identity.Resolver) {
+	identityResolver.Store(r)
+}
+
+// ClientIdentity returns a middleware that resolves the URA behind the client
+// certificate, if one was presented, for use by URA-based routing.
+func ClientIdentity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cert := verifiedClientCert(c.Request.TLS); cert != nil {
+			if id, ok := identityResolver.Load().Resolve(cert); ok {
+				c.Set(identityContextKey, id)
+			}
+		}
+		c.Next()
+	}
+}
+
+// clientURA returns the URA resolved from the client certificate, or "".
+func clientURA(c *gin.Context) string {
+	if v, ok := c.Get(identityContextKey); ok {
+		return v.(identity.Identity).URA
+	}
+	return ""
+}
+
+// HandleAdminIdentity handles GET /admin/identity — shows how the caller's client
+// certificate resolves, to debug identity mappings. A certificate that does not
+// verify against CA_CERT is reported as absent.
+func HandleAdminIdentity(c *gin.Context) {
+	cert := verifiedClientCert(c.Request.TLS)
+	if cert == nil {
+		c.JSON(http.StatusOK, gin.H{"certificate": false})
+		return
+	}
+
+	id, ok := identityResolver.Load().Resolve(cert)
+	c.JSON(http.StatusOK, gin.H{"certificate": true, "resolved": ok, "identity": id})
+}
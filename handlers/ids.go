@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mitz-replicator/storage"
+)
+
+// deterministicEpoch is the first timestamp handed out in deterministic mode.
+var deterministicEpoch = time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+
+// ids generates resource IDs and timestamps for responses. In deterministic mode both
+// come from a seeded sequence, so golden-file comparisons need no masking.
+var ids = struct {
+	mu   sync.Mutex
+	seed *uint64
+	rng  *rand.Rand
+	tick int
+}{}
+
+// InitDeterministic makes IDs and timestamps reproducible for seed. Nil restores
+// random UUIDs and wall-clock time.
+func InitDeterministic(seed *uint64) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	ids.seed = seed
+	resetDeterministicLocked()
+}
+
+// resetDeterministic restarts the deterministic sequence (used by /admin/reset).
+func resetDeterministic() {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	resetDeterministicLocked()
+}
+
+func resetDeterministicLocked() {
+	ids.rng, ids.tick = nil, 0
+	if ids.seed != nil {
+		ids.rng = rand.New(rand.NewPCG(*ids.seed, *ids.seed^0x5851f42d4c957f2d))
+	}
+}
+
+// newID returns a version 4 UUID string.
+func newID() string {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	if ids.rng == nil {
+		return uuid.New().String()
+	}
+	return uuidFrom(ids.rng)
+}
+
+// newKey returns a newID that exists does not report as taken, for records that
+// are stored under their ID. Keys never come from X-Test-Seed, so re-running a
+// seeded request does not overwrite the records of the first run.
+func newKey(exists func(id string) bool) string {
+	for {
+		if id := newID(); !exists(id) {
+			return id
+		}
+	}
+}
+
+// subscriptionExists reports whether st holds a subscription with the given ID.
+func subscriptionExists(st storage.Store) func(id string) bool {
+	return func(id string) bool {
+		_, ok := st.Subscription(id)
+		return ok
+	}
+}
+
+// consentExists reports whether st holds a consent with the given ID.
+func consentExists(st storage.Store) func(id string) bool {
+	return func(id string) bool {
+		return slices.ContainsFunc(st.Consents(), func(consent storage.Consent) bool { return consent.ID == id })
+	}
+}
+
+// uuidFrom returns a version 4 UUID string drawn from rng.
+func uuidFrom(rng *rand.Rand) string {
+	var b uuid.UUID
+	for i := range b {
+		b[i] = byte(rng.UintN(256))
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return b.String()
+}
+
+// now returns the current time, or the next second of the deterministic sequence.
+func now() time.Time {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	if ids.rng == nil {
+		return time.Now()
+	}
+	t := deterministicEpoch.Add(time.Duration(ids.tick) * time.Second)
+	ids.tick++
+	return t
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/rules"
+)
+
+// delayHeader lets a client ask for a response delay on its own request.
+const delayHeader = "X-Mitz-Delay"
+
+// latencySettings are the injected response delays.
+type latencySettings struct {
+	endpoints map[string]rules.Delay // route name (xacml, xcpd, fhir) → delay
+	header    bool                   // honour X-Mitz-Delay
+	headerMax time.Duration          // longest delay X-Mitz-Delay may ask for
+	hangMax   time.Duration          // longest a hanging request is held
+}
+
+var latency atomic.Pointer[latencySettings]
+
+func init() {
+	latency.Store(&latencySettings{hangMax: 5 * time.Minute})
+}
+
+// InitLatency sets the per-route delays, whether clients may request a delay with
+// X-Mitz-Delay (up to headerMax), and how long a hang outcome holds a request.
+func InitLatency(endpoints map[string]rules.Delay, header bool, headerMax, hangMax time.Duration) {
+	latency.Store(&latencySettings{endpoints: maps.Clone(endpoints), header: header, headerMax: headerMax, hangMax: hangMax})
+}
+
+// Latency returns a middleware that delays requests on the named route by its
+// configured delay, or by the X-Mitz-Delay header when that is enabled. Rule delays
+// come on top.
+func Latency(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings := latency.Load()
+		delay, configured := settings.endpoints[endpoint]
+
+		if header := c.GetHeader(delayHeader); header != "" && settings.header {
+			requested, err := rules.ParseDelay(header)
+			if err != nil {
+				abortWithRouteError(c, http.StatusBadRequest, "invalid", "mitz:InvalidRequest", delayHeader+": "+err.Error())
+				return
+			}
+			if requested.Max > settings.headerMax {
+				abortWithRouteError(c, http.StatusBadRequest, "invalid", "mitz:InvalidRequest",
+					delayHeader+" exceeds the maximum of "+strconv.FormatInt(settings.headerMax.Milliseconds(), 10)+"ms")
+				return
+			}
+			delay, configured = requested, true
+		}
+
+		if configured {
+			if d := delay.DurationFrom(requestRand(c)); d > 0 {
+				log.Printf("[LATENCY] %s: delaying %s (%sms) %s", endpoint, d.Round(time.Millisecond), delay, requestRef(c))
+				pause(c, d)
+			}
+		}
+		c.Next()
+	}
+}
+
+// pause waits for d, or until the client goes away, and books the time as delay. An
+// enforced latency budget cuts the wait short and answers 504.
+func pause(c *gin.Context, d time.Duration) {
+	remaining, budgeted := budgetRemaining(c)
+	cut := budgeted && d > remaining
+	if cut {
+		d = remaining
+	}
+
+	timer := time.NewTimer(d)
+	select {
+	case <-timer.C:
+	case <-c.Request.Context().Done():
+		timer.Stop()
+		cut = false
+	}
+	markPhase(c, phaseDelay)
+	if cut {
+		exceedBudget(c)
+	}
+}
+
+// hang holds the request without responding until the client gives up or the hang cap
+// is reached; then the connection is dropped without a response.
+func hang(c *gin.Context, endpoint string) {
+	limit := latency.Load().hangMax
+	log.Printf("[LATENCY] %s: hanging for up to %s %s", endpoint, limit, requestRef(c))
+	pause(c, limit)
+	if !budgetExpired(c) {
+		dropConnection(c, rules.DisconnectBeforeHeaders)
+	}
+}
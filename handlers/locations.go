@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"cmp"
+	"hash/fnv"
+	"math/rand/v2"
+	"strconv"
+	"sync/atomic"
+
+	"mitz-replicator/provider"
+	"mitz-replicator/rules"
+)
+
+// registerLocationSettings control XCPD locations generated from the organisation register.
+type registerLocationSettings struct {
+	byDefault bool // generate locations for requests no rule picks a location set for
+	max       int  // most locations per answer
+}
+
+var registerLocations atomic.Pointer[registerLocationSettings]
+
+// fixedCustodians are the URAs of the custodians in the built-in location sets.
+var fixedCustodians atomic.Pointer[[2]string]
+
+func init() {
+	registerLocations.Store(&registerLocationSettings{max: 3})
+	fixedCustodians.Store(&[2]string{provider.Defaults[0].URA, provider.Defaults[1].URA})
+}
+
+// InitCustodians sets the URAs of the first and second custodian of the built-in
+// location sets, looked up in the organisation register.
+func InitCustodians(first, second string) {
+	fixedCustodians.Store(&[2]string{first, second})
+}
+
+// fixedCustodian returns the OID of custodian i of the built-in location sets,
+// falling back to the built-in organisation when the register no longer has it,
+// and the assigning authority of a location of that custodian: the organisation's
+// own if it has one, else the location's.
+func fixedCustodian(i int, locationAuthority string) (oid, assigningAuthority string) {
+	org, ok := providers.Load().Lookup(fixedCustodians.Load()[i])
+	if !ok || org.OID == "" {
+		org = provider.Defaults[i]
+	}
+	return xmlEscape(org.OID), xmlEscape(cmp.Or(org.AssigningAuthority, locationAuthority))
+}
+
+// InitRegisterLocations sets whether XCPD answers without a rule-picked location set
+// are generated from the organisation register, and the most locations per answer.
+func InitRegisterLocations(byDefault bool, max int) {
+	registerLocations.Store(&registerLocationSettings{byDefault: byDefault, max: max})
+}
+
+// xcpdLocations returns the locations of an XCPD answer: generated from the register
+// for the register location set (and by default when enabled), else a fixed set.
+func xcpdLocations(bsn string, outcome rules.Outcome) []XCPDLocation {
+	settings := registerLocations.Load()
+	if outcome.Locations == "register" || (outcome.Locations == "" && settings.byDefault) {
+		return registerLocationSet(bsn, settings.max, outcome.Custodians)
+	}
+	if outcome.Locations == "counted" {
+		return countedLocationSet(bsn)
+	}
+	return xcpdLocationSet(outcome.Locations)
+}
+
+// registerLocationSet picks 1 to max custodians from the register by weight, each
+// holding some of the categories of its organisation. The draw is seeded with the
+// BSN, so a patient keeps the same locations across requests and restarts.
+func registerLocationSet(bsn string, max int, custodians []string) []XCPDLocation {
+	h := fnv.New64a()
+	h.Write([]byte(bsn))
+	rng := rand.New(rand.NewPCG(h.Sum64(), 0))
+
+	var locations []XCPDLocation
+	for _, org := range providers.Load().Pick(rng, 1+rng.IntN(max), custodians) {
+		categories := org.Categories()
+		var codes []string
+		for _, category := range categories {
+			if rng.IntN(2) == 0 {
+				codes = append(codes, xmlEscape(category))
+			}
+		}
+		if len(codes) == 0 {
+			codes = append(codes, xmlEscape(categories[rng.IntN(len(categories))]))
+		}
+
+		locations = append(locations, XCPDLocation{
+			PatientID:    xmlEscape(bsn),
+			SourceID:     xmlEscape(org.AssigningAuthority),
+			CustodianOID: xmlEscape(org.OID),
+			EventCodes:   codes,
+		})
+	}
+	return locations
+}
+
+// countedLocationSet returns as many locations as the seventh digit of the BSN
+// (0–9), one per register organisation in register order. The last digit is the
+// check digit, so it cannot pick every count among valid BSNs. When the register runs out the
+// organisations are reused under a numbered custodian OID, so every location has
+// its own custodian.
+func countedLocationSet(bsn string) []XCPDLocation {
+	n := 0
+	if len(bsn) == 9 && bsn[6] >= '0' && bsn[6] <= '9' {
+		n = int(bsn[6] - '0')
+	}
+
+	var orgs []provider.Organization
+	for _, org := range providers.Load().All() {
+		if org.OID != "" {
+			orgs = append(orgs, org)
+		}
+	}
+	if len(orgs) == 0 {
+		orgs = provider.Defaults
+	}
+
+	locations := make([]XCPDLocation, 0, n)
+	for i := range n {
+		org := orgs[i%len(orgs)]
+		oid := org.OID
+		if round := i / len(orgs); round > 0 {
+			oid += "." + strconv.Itoa(round)
+		}
+		codes := org.Categories()
+		for j := range codes {
+			codes[j] = xmlEscape(codes[j])
+		}
+		locations = append(locations, XCPDLocation{
+			PatientID:    xmlEscape(bsn),
+			SourceID:     xmlEscape(org.AssigningAuthority),
+			CustodianOID: xmlEscape(oid),
+			EventCodes:   codes,
+		})
+	}
+	return locations
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceRoutes are the routes maintenance mode can be limited to.
+var maintenanceRoutes = []string{"xacml", "xcpd", "fhir"}
+
+// defaultMaintenanceRetryAfter is the Retry-After sent when a maintenance period has no end.
+const defaultMaintenanceRetryAfter = 300
+
+// maintenanceState is an active maintenance period.
+type maintenanceState struct {
+	Routes            []string  `json:"routes"`            // empty = every route outside /admin
+	Message           string    `json:"message"`           // reason returned to clients
+	RetryAfterSeconds int       `json:"retryAfterSeconds"` // Retry-After when the period has no end
+	Since             time.Time `json:"since"`
+	Until             time.Time `json:"until,omitzero"` // zero = until switched off
+}
+
+// covers reports whether the period applies to a request for path at t.
+func (m *maintenanceState) covers(path string, t time.Time) bool {
+	if !m.Until.IsZero() && !t.Before(m.Until) {
+		return false
+	}
+	if strings.HasPrefix(path, "/admin") {
+		return false
+	}
+	return len(m.Routes) == 0 || slices.ContainsFunc(m.Routes, func(route string) bool {
+		return strings.HasPrefix(path, "/"+route)
+	})
+}
+
+// retryAfter returns the Retry-After value in seconds at t.
+func (m *maintenanceState) retryAfter(t time.Time) int {
+	if m.Until.IsZero() {
+		return m.RetryAfterSeconds
+	}
+	return int(m.Until.Sub(t).Seconds()) + 1
+}
+
+var maintenance atomic.Pointer[maintenanceState]
+
+// MaintenanceActive reports whether a maintenance period is in effect.
+func MaintenanceActive() bool {
+	m := maintenance.Load()
+	return m != nil && (m.Until.IsZero() || time.Now().Before(m.Until))
+}
+
+// Maintenance returns a middleware that answers requests during a maintenance period
+// with 503 and Retry-After: a mitz:Unavailable SOAP fault on /xacml and /xcpd, an
+// OperationOutcome (transient) on /fhir. /admin stays available to end the period.
+func Maintenance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		m := maintenance.Load()
+		now := time.Now()
+		if m == nil || !m.covers(c.Request.URL.Path, now) {
+			c.Next()
+			return
+		}
+
+		log.Printf("[MAINTENANCE] %s %s rejected %s", c.Request.Method, c.Request.URL.Path, requestRef(c))
+		c.Header("Retry-After", strconv.Itoa(m.retryAfter(now)))
+		abortWithRouteError(c, http.StatusServiceUnavailable, "transient", "mitz:Unavailable", m.Message)
+	}
+}
+
+// MaintenanceRequest is the body of PUT /admin/maintenance.
+type MaintenanceRequest struct {
+	Routes            []string `json:"routes"`            // xacml, xcpd and/or fhir; empty = all
+	Message           string   `json:"message"`           // default "Mitz is unavailable due to maintenance"
+	RetryAfterSeconds int      `json:"retryAfterSeconds"` // default 300; ignored with durationSeconds
+	DurationSeconds   int      `json:"durationSeconds"`   // end the period automatically (0 = until DELETE)
+}
+
+// HandleAdminMaintenance handles GET /admin/maintenance — the active maintenance period.
+func HandleAdminMaintenance(c *gin.Context) {
+	if !MaintenanceActive() {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"active": true, "maintenance": maintenance.Load()})
+}
+
+// HandleAdminMaintenanceStart handles PUT /admin/maintenance — start a maintenance
+// period for all or selected routes.
+func HandleAdminMaintenanceStart(c *gin.Context) {
+	var req MaintenanceRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+			return
+		}
+	}
+	for _, route := range req.Routes {
+		if !slices.Contains(maintenanceRoutes, route) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown route " + strconv.Quote(route) + " (expected xacml, xcpd or fhir)"})
+			return
+		}
+	}
+	if req.RetryAfterSeconds < 0 || req.DurationSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "retryAfterSeconds and durationSeconds must not be negative"})
+		return
+	}
+
+	m := &maintenanceState{
+		Routes:            req.Routes,
+		Message:           cmp.Or(req.Message, "Mitz is unavailable due to maintenance"),
+		RetryAfterSeconds: cmp.Or(req.RetryAfterSeconds, defaultMaintenanceRetryAfter),
+		Since:             time.Now().UTC(),
+	}
+	if req.DurationSeconds > 0 {
+		m.Until = m.Since.Add(time.Duration(req.DurationSeconds) * time.Second)
+	}
+	maintenance.Store(m)
+
+	log.Printf("[MAINTENANCE] Started for %s (until %s)",
+		cmp.Or(strings.Join(m.Routes, ", "), "all routes"), cmp.Or(formatOptionalTime(m.Until), "switched off"))
+	c.JSON(http.StatusOK, gin.H{"active": true, "maintenance": m})
+}
+
+// HandleAdminMaintenanceStop handles DELETE /admin/maintenance — end maintenance.
+func HandleAdminMaintenanceStop(c *gin.Context) {
+	if maintenance.Swap(nil) != nil {
+		log.Printf("[MAINTENANCE] Ended")
+	}
+	c.JSON(http.StatusOK, gin.H{"active": false})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/rules"
+)
+
+// malformResponse corrupts the response about to be written in the given way
+// (rules.MalformedBrokenXML, MalformedWrongContentType or MalformedTruncated), so
+// clients can be tested for failing safely on a misbehaving Mitz.
+func malformResponse(c *gin.Context, kind string) {
+	skipCompression(c)
+	c.Writer = &malformedWriter{ResponseWriter: c.Writer, kind: kind}
+}
+
+// malformedWriter corrupts the first body write; later writes pass unchanged.
+type malformedWriter struct {
+	gin.ResponseWriter
+	kind string
+	done bool
+}
+
+func (w *malformedWriter) Write(data []byte) (int, error) {
+	if w.done {
+		return w.ResponseWriter.Write(data)
+	}
+	w.done = true
+
+	body := data
+	switch w.kind {
+	case rules.MalformedBrokenXML:
+		body = breakXML(data)
+	case rules.MalformedWrongContentType:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	case rules.MalformedTruncated:
+		body = data[:len(data)/2]
+	}
+	if _, err := w.ResponseWriter.Write(body); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *malformedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// breakXML returns data with an unclosed element before the last end tag, so the
+// document's end tags no longer match.
+func breakXML(data []byte) []byte {
+	i := bytes.LastIndex(data, []byte("</"))
+	if i < 0 {
+		return append([]byte("<broken>"), data...)
+	}
+	return bytes.Join([][]byte{data[:i], []byte("<broken>"), data[i:]}, nil)
+}
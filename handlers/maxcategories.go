@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits on the gegevenscategorieën per XACML request; 0 = unlimited / not set.
+var (
+	configuredMaxCategories atomic.Int64
+	scenarioMaxCategories   atomic.Int64
+)
+
+// InitMaxCategories sets the configured limit on categories per XACML request.
+func InitMaxCategories(limit int) {
+	configuredMaxCategories.Store(int64(limit))
+}
+
+// maxCategories returns the limit in effect: the scenario's, else the configured one.
+func maxCategories() int {
+	if limit := scenarioMaxCategories.Load(); limit > 0 {
+		return int(limit)
+	}
+	return int(configuredMaxCategories.Load())
+}
+
+// checkMaxCategories writes a mitz:InvalidRequest fault and returns false when an
+// XACML request asks for more categories than allowed.
+func checkMaxCategories(c *gin.Context, categories []string) bool {
+	limit := maxCategories()
+	if limit == 0 || len(categories) <= limit {
+		return true
+	}
+
+	log.Printf("[XACML] Rejected: %d categories, at most %d allowed %s", len(categories), limit, requestRef(c))
+	renderSoapFault(c, http.StatusBadRequest, FaultData{
+		FaultCode:    "soap:Sender",
+		FaultSubcode: "mitz:InvalidRequest",
+		FaultReason:  fmt.Sprintf("Request contains %d gegevenscategorieën; at most %d are allowed per request", len(categories), limit),
+		FaultDetail:  "RequestId: " + c.GetHeader("X-Request-Id"),
+	})
+	return false
+}
+
+// MaxCategoriesRequest is the body of PUT /admin/scenarios/max-categories.
+type MaxCategoriesRequest struct {
+	Limit int `json:"limit" binding:"required,min=1"`
+}
+
+// HandleAdminMaxCategories handles GET /admin/scenarios/max-categories — the limit in effect.
+func HandleAdminMaxCategories(c *gin.Context) {
+	c.JSON(http.StatusOK, maxCategoriesResponse())
+}
+
+// HandleAdminMaxCategoriesSet handles PUT /admin/scenarios/max-categories — overrides
+// the configured limit, typically lowering it to exercise client chunking.
+func HandleAdminMaxCategoriesSet(c *gin.Context) {
+	var req MaxCategoriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scenarioMaxCategories.Store(int64(req.Limit))
+	log.Printf("[ADMIN] Max categories per XACML request set to %d", req.Limit)
+	c.JSON(http.StatusOK, maxCategoriesResponse())
+}
+
+// HandleAdminMaxCategoriesClear handles DELETE /admin/scenarios/max-categories —
+// restores the configured limit.
+func HandleAdminMaxCategoriesClear(c *gin.Context) {
+	if scenarioMaxCategories.Swap(0) != 0 {
+		log.Printf("[ADMIN] Max categories per XACML request restored to %d", configuredMaxCategories.Load())
+	}
+	c.JSON(http.StatusOK, maxCategoriesResponse())
+}
+
+func maxCategoriesResponse() gin.H {
+	return gin.H{
+		"limit":      maxCategories(),
+		"configured": configuredMaxCategories.Load(),
+		"scenario":   scenarioMaxCategories.Load(),
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"cmp"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contentTypeOverrideHeader lets a test pin the exact Content-Type of a single response.
+const contentTypeOverrideHeader = "X-Mock-Content-Type"
+
+// Response Content-Type strings, sent verbatim. Configurable because some clients
+// break on charset parameters or only accept legacy variants such as application/xml+fhir.
+var mediaTypes = struct {
+	mu   sync.RWMutex
+	soap string
+	fhir string
+}{
+	soap: "application/soap+xml; charset=utf-8",
+	fhir: "application/fhir+xml; charset=utf-8",
+}
+
+// contentTypePresets are shorthand names accepted in place of a literal Content-Type.
+var contentTypePresets = map[string]map[string]string{
+	"soap": {
+		"default":    "application/soap+xml; charset=utf-8",
+		"no-charset": "application/soap+xml",
+		"soap11":     "text/xml; charset=utf-8",
+		"plain-xml":  "application/xml",
+	},
+	"fhir": {
+		"default":           "application/fhir+xml; charset=utf-8",
+		"no-charset":        "application/fhir+xml",
+		"legacy":            "application/xml+fhir; charset=utf-8",
+		"legacy-no-charset": "application/xml+fhir",
+		"plain-xml":         "application/xml",
+	},
+}
+
+// InitContentTypes sets the SOAP and FHIR response Content-Types. Each value is
+// either a preset name or a literal Content-Type; empty selects the default.
+func InitContentTypes(soap, fhir string) {
+	mediaTypes.mu.Lock()
+	defer mediaTypes.mu.Unlock()
+
+	mediaTypes.soap = resolveContentType("soap", cmp.Or(soap, "default"))
+	mediaTypes.fhir = resolveContentType("fhir", cmp.Or(fhir, "default"))
+}
+
+func resolveContentType(family, value string) string {
+	if preset, ok := contentTypePresets[family][value]; ok {
+		return preset
+	}
+	return value
+}
+
+// soapContentType returns the Content-Type for a SOAP response to c: the configured
+// one for SOAP 1.2, text/xml for SOAP 1.1.
+func soapContentType(c *gin.Context) string {
+	if override := c.GetHeader(contentTypeOverrideHeader); override != "" {
+		return resolveContentType("soap", override)
+	}
+	if isSOAP11(c) {
+		return soap11ContentType
+	}
+	mediaTypes.mu.RLock()
+	defer mediaTypes.mu.RUnlock()
+
+	return mediaTypes.soap
+}
+
+// fhirContentType returns the Content-Type for a FHIR response to c.
+func fhirContentType(c *gin.Context) string {
+	if override := c.GetHeader(contentTypeOverrideHeader); override != "" {
+		return resolveContentType("fhir", override)
+	}
+	return fhirMediaType()
+}
+
+// fhirMediaType returns the configured FHIR Content-Type, ignoring per-request overrides.
+func fhirMediaType() string {
+	mediaTypes.mu.RLock()
+	defer mediaTypes.mu.RUnlock()
+
+	return mediaTypes.fhir
+}
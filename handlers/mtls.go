@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/identity"
+)
+
+// PresentedCertificate is a client certificate seen on TLS handshakes.
+type PresentedCertificate struct {
+	Fingerprint string    `json:"fingerprint"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotAfter    time.Time `json:"notAfter"`
+	URA         string    `json:"ura,omitempty"`
+	Verified    bool      `json:"verified"`        // on the last handshake
+	Error       string    `json:"error,omitempty"` // why the last handshake failed verification
+	Handshakes  int       `json:"handshakes"`
+	Failures    int       `json:"failures"` // handshakes that failed verification
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+// clientCerts collects who presents which client certificate, to follow an mTLS
+// rollout in MTLS_MODE=request.
+var clientCerts = struct {
+	mu            sync.Mutex
+	mode          string
+	roots         *x509.CertPool                   // CA_CERT
+	presented     map[string]*PresentedCertificate // by fingerprint
+	anonymous     int                              // handshakes without a certificate
+	lastAnonymous time.Time
+}{mode: "off", presented: map[string]*PresentedCertificate{}}
+
+// InitClientCertMode sets the MTLS_MODE and the CAs (CA_CERT) client certificates
+// are verified against.
+func InitClientCertMode(mode string, roots *x509.CertPool) {
+	clientCerts.mu.Lock()
+	defer clientCerts.mu.Unlock()
+
+	clientCerts.mode = mode
+	clientCerts.roots = roots
+}
+
+// VerifyClientConnection is the listener's tls.Config.VerifyConnection hook. The
+// listener only asks for a certificate; this hook verifies it, records it (or its
+// absence) with the outcome and logs first sightings and failures. Only in require
+// mode does a certificate that fails verification reject the connection, so request
+// mode also collects the clients whose certificates are not trusted yet.
+func VerifyClientConnection(cs tls.ConnectionState) error {
+	clientCerts.mu.Lock()
+	defer clientCerts.mu.Unlock()
+
+	now := time.Now().UTC()
+	if len(cs.PeerCertificates) == 0 {
+		if clientCerts.anonymous == 0 {
+			log.Printf("[MTLS] Client connected without a certificate")
+		}
+		clientCerts.anonymous++
+		clientCerts.lastAnonymous = now
+		return nil
+	}
+
+	cert := cs.PeerCertificates[0]
+	fingerprint := identity.Fingerprint(cert)
+	p, ok := clientCerts.presented[fingerprint]
+	if !ok {
+		p = &PresentedCertificate{
+			Fingerprint: fingerprint,
+			Subject:     cert.Subject.String(),
+			Issuer:      cert.Issuer.String(),
+			NotAfter:    cert.NotAfter.UTC(),
+			FirstSeen:   now,
+		}
+		if id, resolved := identityResolver.Load().Resolve(cert); resolved {
+			p.URA = id.URA
+		}
+		clientCerts.presented[fingerprint] = p
+		log.Printf("[MTLS] Client certificate presented: subject=%q issuer=%q URA=%s fingerprint=%s", p.Subject, p.Issuer, p.URA, fingerprint)
+	}
+	p.Handshakes++
+	p.LastSeen = now
+
+	err := verifyClientCert(cs.PeerCertificates, clientCerts.roots)
+	p.Verified = err == nil
+	if err == nil {
+		p.Error = ""
+		return nil
+	}
+	p.Error = err.Error()
+	p.Failures++
+	if p.Failures == 1 {
+		log.Printf("[MTLS] Client certificate failed verification: subject=%q fingerprint=%s: %v", p.Subject, fingerprint, err)
+	}
+	if clientCerts.mode == "require" {
+		return fmt.Errorf("client certificate: %w", err)
+	}
+	return nil
+}
+
+// verifyClientCert verifies a client certificate chain as crypto/tls would for
+// tls.RequireAndVerifyClientCert.
+func verifyClientCert(certs []*x509.Certificate, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// verifiedClientCert returns the client certificate of a connection when it verifies
+// against CA_CERT, or nil. Unverified certificates, which request mode lets through,
+// never identify a client.
+func verifiedClientCert(cs *tls.ConnectionState) *x509.Certificate {
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	clientCerts.mu.Lock()
+	roots := clientCerts.roots
+	clientCerts.mu.Unlock()
+	if roots == nil || verifyClientCert(cs.PeerCertificates, roots) != nil {
+		return nil
+	}
+	return cs.PeerCertificates[0]
+}
+
+// HandleAdminMTLS handles GET /admin/mtls — the client certificates presented since
+// startup and the number of handshakes without one.
+func HandleAdminMTLS(c *gin.Context) {
+	clientCerts.mu.Lock()
+	defer clientCerts.mu.Unlock()
+
+	presented := make([]PresentedCertificate, 0, len(clientCerts.presented))
+	for _, p := range clientCerts.presented {
+		presented = append(presented, *p)
+	}
+	slices.SortFunc(presented, func(a, b PresentedCertificate) int { return strings.Compare(a.Subject, b.Subject) })
+
+	without := gin.H{"handshakes": clientCerts.anonymous}
+	if clientCerts.anonymous > 0 {
+		without["lastSeen"] = clientCerts.lastAnonymous
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mode":               clientCerts.mode,
+		"certificates":       presented,
+		"withoutCertificate": without,
+	})
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/storage"
+)
+
+// Notification payload formats. Subscribers on different FHIR versions receive the
+// consent in the shape of their version.
+const (
+	notificationSTU3XML = "stu3-xml" // fhir_notification template, STU3 Consent with except
+	notificationR4XML   = "r4-xml"   // fhir_notification template, R4 Consent with provision
+	notificationR4JSON  = "r4-json"  // R4 Consent with provision, rendered as JSON
+)
+
+// r4MediaType is the Content-Type of R4 JSON notifications.
+const r4MediaType = "application/fhir+json; fhirVersion=4.0"
+
+// errUnsignedR4 refuses R4 JSON notifications while notifications must be signed:
+// the XML-DSig signature only covers XML.
+var errUnsignedR4 = errors.New("r4-json notifications cannot be signed (SIGN_NOTIFICATIONS); use r4-xml or stu3-xml")
+
+// Code systems used in R4 Consents.
+const (
+	systemBSN              = "http://fhir.nl/fhir/NamingSystem/bsn"
+	systemGegevenscategory = "2.16.840.1.113883.2.4.3.111.5.10.1"
+	systemConsentScope     = "http://terminology.hl7.org/CodeSystem/consentscope"
+	systemLOINC            = "http://loinc.org"
+	systemActCode          = "http://terminology.hl7.org/CodeSystem/v3-ActCode"
+)
+
+// notificationFormatFor derives the notification format from a subscription's
+// channel.payload: JSON payloads get R4 JSON, XML payloads with fhirVersion=3.x STU3
+// XML, and all other XML the R4 shape of FHIR_VERSION (R4 and R4B Consents agree).
+func notificationFormatFor(payload string) string {
+	mediaType, params, err := mime.ParseMediaType(payload)
+	switch {
+	case err != nil:
+		return notificationR4XML
+	case strings.HasSuffix(mediaType, "json"):
+		return notificationR4JSON
+	case strings.HasPrefix(params["fhirversion"], "3"):
+		return notificationSTU3XML
+	}
+	return notificationR4XML
+}
+
+// checkNotificationFormat returns errUnsignedR4 for r4-json while notifications are signed.
+func checkNotificationFormat(format string) error {
+	if format == notificationR4JSON && signNotifications && xmlSigner != nil {
+		return errUnsignedR4
+	}
+	return nil
+}
+
+// renderNotification renders the notification Bundle for consent in the
+// subscription's format and returns it with its Content-Type.
+func renderNotification(sub storage.Subscription, consent storage.Consent, data FhirNotificationData) ([]byte, string, error) {
+	if err := checkNotificationFormat(sub.NotificationFormat); err != nil {
+		return nil, "", err
+	}
+	if sub.NotificationFormat == notificationR4JSON {
+		body, err := json.MarshalIndent(r4Notification(consent, data), "", "  ")
+		return body, r4MediaType, err
+	}
+
+	data.R4 = sub.NotificationFormat == notificationR4XML
+	var buf bytes.Buffer
+	if err := lookupTemplate(nil, "fhir_notification").Execute(&buf, data); err != nil {
+		return nil, "", err
+	}
+	return signIf(signNotifications, buf.Bytes()), fhirMediaType(), nil
+}
+
+type r4Coding struct {
+	System string `json:"system"`
+	Code   string `json:"code"`
+}
+
+type r4CodeableConcept struct {
+	Coding []r4Coding `json:"coding"`
+}
+
+type r4Period struct {
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+type r4Provision struct {
+	Type      string              `json:"type,omitempty"`
+	Period    *r4Period           `json:"period,omitempty"`
+	Code      []r4CodeableConcept `json:"code,omitempty"`
+	Provision []r4Provision       `json:"provision,omitempty"`
+}
+
+type r4Reference struct {
+	Identifier r4Identifier `json:"identifier"`
+}
+
+type r4Identifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+type r4Consent struct {
+	ResourceType string              `json:"resourceType"`
+	ID           string              `json:"id"`
+	Status       string              `json:"status"`
+	Scope        r4CodeableConcept   `json:"scope"`
+	Category     []r4CodeableConcept `json:"category"`
+	Patient      r4Reference         `json:"patient"`
+	PolicyRule   r4CodeableConcept   `json:"policyRule"`
+	Provision    r4Provision         `json:"provision"`
+}
+
+type r4Link struct {
+	Relation string `json:"relation"`
+	URL      string `json:"url"`
+}
+
+type r4Entry struct {
+	FullURL  string            `json:"fullUrl"`
+	Resource r4Consent         `json:"resource"`
+	Request  map[string]string `json:"request"`
+	Response map[string]string `json:"response"`
+}
+
+type r4Bundle struct {
+	ResourceType string    `json:"resourceType"`
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	Timestamp    string    `json:"timestamp"`
+	Link         []r4Link  `json:"link"`
+	Entry        []r4Entry `json:"entry"`
+}
+
+// r4Notification converts the stored consent to an R4 history Bundle.
+func r4Notification(consent storage.Consent, data FhirNotificationData) r4Bundle {
+	return r4Bundle{
+		ResourceType: "Bundle",
+		ID:           data.NotificationID,
+		Type:         "history",
+		Timestamp:    data.Timestamp,
+		Link:         []r4Link{{Relation: "subscription", URL: "Subscription/" + data.SubscriptionID}},
+		Entry: []r4Entry{{
+			FullURL:  "Consent/" + consent.ID,
+			Resource: r4ConsentResource(consent),
+			Request:  map[string]string{"method": "PUT", "url": "Consent/" + consent.ID},
+			Response: map[string]string{"status": "200 OK"},
+		}},
+	}
+}
+
+// policyRuleCode is the R4 Consent.policyRule code (required without a policy) for
+// decision: OPTIN for permit, OPTOUT for deny.
+func policyRuleCode(decision string) string {
+	if decision == "deny" {
+		return "OPTOUT"
+	}
+	return "OPTIN"
+}
+
+// r4ConsentResource converts the stored consent to an R4 Consent. Each category
+// becomes a nested provision, as in the Bundles clients submit.
+func r4ConsentResource(consent storage.Consent) r4Consent {
+	provision := r4Provision{Type: consent.Decision}
+	if !consent.EffectiveFrom.IsZero() || !consent.EffectiveUntil.IsZero() {
+		provision.Period = &r4Period{Start: fhirDate(consent.EffectiveFrom), End: fhirDate(consent.EffectiveUntil)}
+	}
+	for _, cat := range consent.Categories {
+		provision.Provision = append(provision.Provision, r4Provision{
+			Code: []r4CodeableConcept{{Coding: []r4Coding{{System: systemGegevenscategory, Code: cat}}}},
+		})
+	}
+
+	return r4Consent{
+		ResourceType: "Consent",
+		ID:           consent.ID,
+		Status:       consent.Status,
+		Scope:        r4CodeableConcept{Coding: []r4Coding{{System: systemConsentScope, Code: "patient-privacy"}}},
+		Category:     []r4CodeableConcept{{Coding: []r4Coding{{System: systemLOINC, Code: "59284-0"}}}},
+		Patient:      r4Reference{Identifier: r4Identifier{System: systemBSN, Value: consent.BSN}},
+		PolicyRule:   r4CodeableConcept{Coding: []r4Coding{{System: systemActCode, Code: policyRuleCode(consent.Decision)}}},
+		Provision:    provision,
+	}
+}
+
+// fhirDate formats t as a FHIR dateTime, or "" for the zero time.
+func fhirDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// HandleAdminNotificationFormat handles PUT /admin/subscriptions/:id/notification-format
+// with {"format": "stu3-xml" | "r4-xml" | "r4-json"} — overrides the format derived
+// from the subscription's channel.payload.
+func HandleAdminNotificationFormat(c *gin.Context) {
+	var body struct {
+		Format string `json:"format"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	if body.Format != notificationSTU3XML && body.Format != notificationR4XML && body.Format != notificationR4JSON {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("format must be %s, %s or %s", notificationSTU3XML, notificationR4XML, notificationR4JSON)})
+		return
+	}
+
+	st := StoreFor(c)
+	sub, ok := st.Subscription(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription/" + c.Param("id") + " not found"})
+		return
+	}
+	if err := checkNotificationFormat(body.Format); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	sub.NotificationFormat = body.Format
+	if err := st.SaveSubscription(sub); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordEvent(st, storage.Event{Type: storage.EventSubscriptionUpdated, Resource: "Subscription/" + sub.ID, BSN: sub.BSN})
+	c.JSON(http.StatusOK, sub)
+}
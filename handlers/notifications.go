@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/notify"
+	"mitz-replicator/storage"
+)
+
+// FhirNotificationData is the template data for fhir_notification.xml.
+type FhirNotificationData struct {
+	NotificationID string
+	Timestamp      string
+	SubscriptionID string
+	ConsentID      string
+	ConsentStatus  string
+	BSN            string
+	Decision       string   // except.type (STU3) or provision.type (R4): permit or deny
+	Categories     []string // except.code (STU3) or nested provision.code (R4)
+	PeriodStart    string
+	PeriodEnd      string
+	R4             bool   // render the R4 Consent shape (r4-xml)
+	PolicyRule     string // R4 policyRule: OPTIN or OPTOUT
+}
+
+var dispatcher *notify.Dispatcher
+
+// InitNotifications sets the rest-hook dispatcher. A nil dispatcher disables notifications.
+func InitNotifications(d *notify.Dispatcher) {
+	dispatcher = d
+}
+
+// notifyConsentChange queues a notification for every active subscription matching
+// the consent stored by request c.
+func notifyConsentChange(c *gin.Context, st storage.Store, consent storage.Consent) {
+	if dispatcher == nil {
+		return
+	}
+
+	start, deadline := notificationBudget(c)
+	for _, sub := range st.Subscriptions() {
+		if !subscriptionMatches(sub, consent) {
+			continue
+		}
+		if !matchesCategories(consent, sub.Categories) {
+			log.Printf("[NOTIFY] Skipped Subscription/%s: Consent/%s categories %v outside filter %v",
+				sub.ID, consent.ID, consent.Categories, sub.Categories)
+			continue
+		}
+
+		data := FhirNotificationData{
+			NotificationID: newIDFor(c),
+			Timestamp:      now().Format(time.RFC3339),
+			SubscriptionID: sub.ID,
+			ConsentID:      consent.ID,
+			ConsentStatus:  xmlEscape(consent.Status),
+			BSN:            xmlEscape(consent.BSN),
+			Decision:       xmlEscape(consent.Decision),
+			PeriodStart:    fhirDate(consent.EffectiveFrom),
+			PeriodEnd:      fhirDate(consent.EffectiveUntil),
+			PolicyRule:     policyRuleCode(consent.Decision),
+		}
+		for _, cat := range consent.Categories {
+			data.Categories = append(data.Categories, xmlEscape(cat))
+		}
+
+		body, contentType, err := renderNotification(sub, consent, data)
+		if err != nil {
+			log.Printf("[NOTIFY] Not sending Consent/%s to Subscription/%s: %v", consent.ID, sub.ID, err)
+			continue
+		}
+
+		log.Printf("[NOTIFY] Queued Consent/%s for Subscription/%s endpoint=%s format=%s",
+			consent.ID, sub.ID, sub.Endpoint, cmp.Or(sub.NotificationFormat, notificationSTU3XML))
+		dispatcher.Enqueue(notify.Notification{
+			ID:             data.NotificationID,
+			SubscriptionID: sub.ID,
+			Endpoint:       sub.Endpoint,
+			ContentType:    contentType,
+			Body:           body,
+			Deadline:       deadline,
+			Done:           budgetedDone(start, sub.ID, notificationEvents(st, sub, consent)),
+		})
+	}
+}
+
+// notificationEvents records the outcome of a notification's delivery in the event log.
+func notificationEvents(st storage.Store, sub storage.Subscription, consent storage.Consent) func(error) {
+	return func(err error) {
+		e := storage.Event{Type: storage.EventNotificationSent, Resource: "Subscription/" + sub.ID, Related: "Consent/" + consent.ID, BSN: consent.BSN}
+		if err != nil {
+			e.Type = storage.EventNotificationFailed
+		}
+		recordEvent(st, e)
+	}
+}
+
+// HandleFhirNotificationAck handles POST /fhir/Subscription/:id/$acknowledge?notification=<Bundle.id>
+// — the subscriber confirms it processed a notification. Unacknowledged notifications are
+// redelivered after NOTIFY_ACK_TIMEOUT_SECONDS.
+func HandleFhirNotificationAck(c *gin.Context) {
+	subID := c.Param("id")
+	notificationID := c.Query("notification")
+	log.Printf("[FHIR] POST /Subscription/%s/$acknowledge notification=%s %s", subID, notificationID, requestRef(c))
+
+	if dispatcher == nil || !dispatcher.AcksRequired() {
+		renderFhirError(c, http.StatusBadRequest, "error", "not-supported", "Notification acknowledgments are not enabled")
+		return
+	}
+	if notificationID == "" {
+		renderFhirError(c, http.StatusBadRequest, "error", "required", "Missing notification parameter")
+		return
+	}
+	if !dispatcher.Ack(subID, notificationID) {
+		renderFhirError(c, http.StatusNotFound, "error", "not-found", "No unacknowledged notification "+notificationID+" for Subscription/"+subID)
+		return
+	}
+
+	renderFhirError(c, http.StatusOK, "information", "informational", "Notification "+notificationID+" acknowledged")
+}
+
+// subscriptionMatches reports whether an active subscription's criteria cover the consent.
+// Subscriptions without a patientid in their criteria match every patient.
+func subscriptionMatches(sub storage.Subscription, consent storage.Consent) bool {
+	if sub.Status != "active" || sub.Endpoint == "" {
+		return false
+	}
+	return sub.BSN == "" || sub.BSN == consent.BSN
+}
+
+// matchesCategories reports whether the consent's categories satisfy a category
+// filter (see parser.CategoryFilter): every group must name at least one of them.
+// Consents without categories cover every category and always match.
+func matchesCategories(consent storage.Consent, filter [][]string) bool {
+	if len(consent.Categories) == 0 {
+		return true
+	}
+	for _, group := range filter {
+		if !slices.ContainsFunc(group, func(code string) bool {
+			return slices.ContainsFunc(consent.Categories, func(cat string) bool { return strings.EqualFold(code, cat) })
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/auth"
+	"mitz-replicator/notify"
+	"mitz-replicator/storage"
+)
+
+// TestNotifyConsentChangeSkipsUnrenderable checks that a subscription whose
+// notification cannot be rendered does not keep the ones after it from being notified.
+func TestNotifyConsentChangeSkipsUnrenderable(t *testing.T) {
+	loadRepoTemplates(t)
+	signer, err := auth.NewSelfSignedXMLSigner("notifications test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	InitSigning(signer, true, false, false)
+	t.Cleanup(func() { InitSigning(nil, false, false, false) })
+
+	delivered := make(chan string, 2)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.URL.Path
+	}))
+	defer subscriber.Close()
+
+	d := notify.NewDispatcher(subscriber.Client(), 10, notify.RetryPolicy{MaxAttempts: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	InitNotifications(d)
+	t.Cleanup(func() { InitNotifications(nil) })
+
+	st := storage.NewMemoryStore(storage.Retention{})
+	created := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	// Signed notifications cannot be sent as r4-json, so the first subscription fails
+	// to render.
+	for i, sub := range []storage.Subscription{
+		{ID: "unsigned", Endpoint: subscriber.URL + "/unsigned", NotificationFormat: notificationR4JSON},
+		{ID: "valid", Endpoint: subscriber.URL + "/valid", NotificationFormat: notificationSTU3XML},
+	} {
+		sub.Status, sub.BSN, sub.Created = "active", "999911120", created.Add(time.Duration(i)*time.Second)
+		if err := st.SaveSubscription(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/fhir/", nil)
+	notifyConsentChange(c, st, storage.Consent{ID: "c1", BSN: "999911120", Status: "active", Decision: "permit"})
+
+	select {
+	case path := <-delivered:
+		if path != "/valid" {
+			t.Fatalf("notification delivered to %s, want /valid", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the valid subscription was not notified")
+	}
+	select {
+	case path := <-delivered:
+		t.Fatalf("unexpected notification to %s", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
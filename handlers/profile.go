@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/profile"
+)
+
+// profileValidators validate a FHIR request body per resource type.
+var profileValidators = map[string]func([]byte) ([]profile.Issue, error){
+	"Subscription": profile.ValidateSubscription,
+	"Bundle":       profile.ValidateBundle,
+}
+
+// validateProfile checks a Subscription or Bundle against the Mitz/OTV profiles in
+// strict validation mode (VALIDATION_MODE=strict). A violating request gets a 400
+// OperationOutcome with one issue per violation and false is returned. Bodies that
+// are not well-formed are left to the parser.
+func validateProfile(c *gin.Context, resource string, body []byte) bool {
+	if !strictnessFor(c).Required {
+		return true
+	}
+
+	issues, err := profileValidators[resource](body)
+	if err != nil || len(issues) == 0 {
+		return true
+	}
+
+	log.Printf("[PROFILE] %s %s violates the Mitz profile: %d issue(s), first: %s", requestRef(c), resource, len(issues), issues[0])
+	renderFhirIssues(c, http.StatusBadRequest, fhirIssues(issues))
+	return false
+}
+
+// validateTransaction rejects a Bundle that is not a transaction, or has entries
+// without request.method and request.url, with a 400 OperationOutcome listing every
+// problem, in any validation mode. Bodies that are not well-formed are left to the
+// parser.
+func validateTransaction(c *gin.Context, body []byte) bool {
+	issues, err := profile.ValidateTransaction(body)
+	if err != nil || len(issues) == 0 {
+		return true
+	}
+
+	log.Printf("[FHIR] %s Bundle rejected, not a processable transaction: %d issue(s), first: %s", requestRef(c), len(issues), issues[0])
+	renderFhirIssues(c, http.StatusBadRequest, fhirIssues(issues))
+	return false
+}
+
+// fhirIssues converts profile violations to OperationOutcome issues.
+func fhirIssues(issues []profile.Issue) []FhirIssue {
+	outcome := make([]FhirIssue, len(issues))
+	for i, issue := range issues {
+		outcome[i] = FhirIssue{Severity: issue.Severity, Code: issue.Code, Diagnostics: issue.Diagnostics, Expression: issue.Expression}
+	}
+	return outcome
+}
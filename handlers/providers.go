@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/provider"
+)
+
+// FhirOrganizationData is the template data for fhir_organization.xml.
+type FhirOrganizationData struct {
+	ID          string
+	URA         string
+	OID         string
+	Name        string
+	Type        string
+	TypeDisplay string
+	City        string
+}
+
+// FhirOrganizationSearchsetData is the template data for fhir_organization_searchset.xml.
+type FhirOrganizationSearchsetData struct {
+	BundleID      string
+	Organizations []FhirOrganizationData
+}
+
+// providers is the organisation register, swapped as a whole on config reload.
+var providers atomic.Pointer[provider.Register]
+
+func init() {
+	providers.Store(provider.New(nil, nil))
+}
+
+// InitProviders sets the organisation register.
+func InitProviders(r *provider.Register) {
+	providers.Store(r)
+}
+
+// HandleAdminProviders handles GET /admin/providers — the organisation register.
+func HandleAdminProviders(c *gin.Context) {
+	orgs := providers.Load().All()
+	c.JSON(http.StatusOK, gin.H{"total": len(orgs), "providers": orgs})
+}
+
+// HandleFhirOrganizationRead handles GET /fhir/Organization/:id — read an organisation by URA.
+func HandleFhirOrganizationRead(c *gin.Context) {
+	id := c.Param("id")
+	log.Printf("[FHIR] GET /Organization/%s %s", id, requestRef(c))
+
+	org, ok := providers.Load().Lookup(id)
+	if !ok {
+		renderFhirError(c, http.StatusNotFound, "error", "not-found", "Organization not found")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := lookupTemplate(c, "fhir_organization").Execute(&buf, organizationData(org)); err != nil {
+		log.Printf("[FHIR] Organization template error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Data(http.StatusOK, fhirContentType(c), buf.Bytes())
+}
+
+// HandleFhirOrganizationSearch handles GET /fhir/Organization?identifier=&name= — search
+// the register by URA or custodian OID and name prefix.
+func HandleFhirOrganizationSearch(c *gin.Context) {
+	identifier := c.Query("identifier")
+	name := c.Query("name")
+	log.Printf("[FHIR] GET /Organization %s identifier=%q name=%q", requestRef(c), identifier, name)
+
+	data := FhirOrganizationSearchsetData{BundleID: newIDFor(c)}
+	for _, org := range providers.Load().Search(identifier, name) {
+		data.Organizations = append(data.Organizations, organizationData(org))
+	}
+
+	var buf bytes.Buffer
+	if err := lookupTemplate(c, "fhir_organization_searchset").Execute(&buf, data); err != nil {
+		log.Printf("[FHIR] Organization searchset template error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Data(http.StatusOK, fhirContentType(c), buf.Bytes())
+}
+
+// organizationData converts a register entry into escaped template data.
+func organizationData(org provider.Organization) FhirOrganizationData {
+	data := FhirOrganizationData{
+		ID:   xmlEscape(org.URA),
+		URA:  xmlEscape(org.URA),
+		OID:  xmlEscape(org.OID),
+		Name: xmlEscape(org.Name),
+		Type: xmlEscape(org.Type),
+		City: xmlEscape(org.City),
+	}
+	if org.Type != "" {
+		data.TypeDisplay = xmlEscape(provider.TypeDisplay(org.Type))
+	}
+	return data
+}
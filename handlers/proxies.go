@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trustedProxies holds the networks whose X-Forwarded-* headers are honoured.
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+// InitTrustedProxies sets the reverse proxies (TRUSTED_PROXIES) whose X-Forwarded-*
+// headers describe the original request. Headers from any other client are ignored.
+func InitTrustedProxies(prefixes []netip.Prefix) {
+	trustedProxies.Store(&prefixes)
+}
+
+// fromTrustedProxy reports whether c came from a trusted proxy: an address in
+// TRUSTED_PROXIES, or the sidecar on the other end of a Unix socket listener.
+func fromTrustedProxy(c *gin.Context) bool {
+	if addr, ok := c.Request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && addr.Network() == "unix" {
+		return true
+	}
+	prefixes := trustedProxies.Load()
+	if prefixes == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(c.RemoteIP())
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range *prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHeader returns the first element of the X-Forwarded-* header name — the
+// value the client-facing proxy set — when c came from a trusted proxy, or "".
+func forwardedHeader(c *gin.Context, name string) string {
+	if !fromTrustedProxy(c) {
+		return ""
+	}
+	first, _, _ := strings.Cut(c.GetHeader(name), ",")
+	return strings.TrimSpace(first)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/storage"
+)
+
+// errQuotaExceeded is returned by saveWithinQuota when the provider has no free slot.
+var errQuotaExceeded = errors.New("subscription quota exceeded")
+
+// quotas bounds the number of active subscriptions per provider (URA).
+var quotas = struct {
+	mu           sync.RWMutex
+	save         sync.Mutex     // serialises quota checks with the subscription save
+	defaultLimit int            // 0 = unlimited
+	limits       map[string]int // per-provider overrides
+}{limits: map[string]int{}}
+
+// InitSubscriptionQuota sets the default maximum number of active subscriptions per provider.
+func InitSubscriptionQuota(limit int) {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+
+	quotas.defaultLimit = limit
+}
+
+// quotaLimit returns the active-subscription limit for providerID (0 = unlimited).
+func quotaLimit(providerID string) int {
+	quotas.mu.RLock()
+	defer quotas.mu.RUnlock()
+
+	return quotaLimitLocked(providerID)
+}
+
+// activeSubscriptions counts the active subscriptions per provider.
+func activeSubscriptions(st storage.Store) map[string]int {
+	counts := map[string]int{}
+	for _, sub := range st.Subscriptions() {
+		if sub.Status == "active" {
+			counts[sub.ProviderID]++
+		}
+	}
+	return counts
+}
+
+// saveWithinQuota stores the subscription built by newSub unless providerID already
+// holds its maximum number of active subscriptions, in which case it returns
+// errQuotaExceeded and the limit. Counting and saving happen under one lock, so
+// concurrent requests cannot both take the last free slot.
+func saveWithinQuota(st storage.Store, providerID string, newSub func() storage.Subscription) (storage.Subscription, int, error) {
+	quotas.save.Lock()
+	defer quotas.save.Unlock()
+
+	limit := quotaLimit(providerID)
+	if limit > 0 && activeSubscriptions(st)[providerID] >= limit {
+		return storage.Subscription{}, limit, errQuotaExceeded
+	}
+	sub := newSub()
+	return sub, limit, st.SaveSubscription(sub)
+}
+
+// HandleAdminQuotas handles GET /admin/quotas — active subscriptions and limits per provider.
+func HandleAdminQuotas(c *gin.Context) {
+	active := activeSubscriptions(StoreFor(c))
+
+	quotas.mu.RLock()
+	providers := gin.H{}
+	for providerID, count := range active {
+		providers[providerID] = gin.H{"active": count, "limit": quotaLimitLocked(providerID)}
+	}
+	for providerID, limit := range quotas.limits {
+		if _, ok := active[providerID]; !ok {
+			providers[providerID] = gin.H{"active": 0, "limit": limit}
+		}
+	}
+	defaultLimit := quotas.defaultLimit
+	quotas.mu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{"defaultLimit": defaultLimit, "providers": providers})
+}
+
+// HandleAdminQuotaSet handles PUT /admin/quotas/:providerId — overrides one provider's limit.
+func HandleAdminQuotaSet(c *gin.Context) {
+	var body struct {
+		Limit *int `json:"limit" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	providerID := c.Param("providerId")
+	quotas.mu.Lock()
+	quotas.limits[providerID] = *body.Limit
+	quotas.mu.Unlock()
+
+	log.Printf("[ADMIN] Subscription quota for provider %s set to %d", providerID, *body.Limit)
+	c.Status(http.StatusNoContent)
+}
+
+// HandleAdminQuotaReset handles POST /admin/quotas/:providerId/reset — frees the provider's
+// quota by setting its active subscriptions to "off" and dropping any limit override.
+func HandleAdminQuotaReset(c *gin.Context) {
+	providerID := c.Param("providerId")
+	st := StoreFor(c)
+
+	cancelled := 0
+	for _, sub := range st.Subscriptions() {
+		if sub.ProviderID != providerID || sub.Status != "active" {
+			continue
+		}
+		sub.Status = "off"
+		if err := st.SaveSubscription(sub); err != nil {
+			log.Printf("[ADMIN] Failed to cancel Subscription/%s: %v", sub.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		recordEvent(st, storage.Event{Type: storage.EventSubscriptionCancelled, Resource: "Subscription/" + sub.ID, BSN: sub.BSN})
+		cancelled++
+	}
+
+	quotas.mu.Lock()
+	delete(quotas.limits, providerID)
+	quotas.mu.Unlock()
+
+	log.Printf("[ADMIN] Subscription quota for provider %s reset (%d subscriptions cancelled)", providerID, cancelled)
+	c.JSON(http.StatusOK, gin.H{"cancelled": cancelled})
+}
+
+// clearQuotaOverrides drops the per-provider limits set through the admin API.
+func clearQuotaOverrides() {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+
+	clear(quotas.limits)
+}
+
+// quotaLimitLocked is quotaLimit for callers already holding quotas.mu.
+func quotaLimitLocked(providerID string) int {
+	if limit, ok := quotas.limits[providerID]; ok {
+		return limit
+	}
+	return quotas.defaultLimit
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/ratelimit"
+)
+
+// rateLimitSettings are the per-route limiters and how clients are told apart.
+type rateLimitSettings struct {
+	limiters map[string]*ratelimit.Limiter // by route: xacml, xcpd or fhir
+	header   string                        // key on this request header instead of the client URA
+}
+
+var rateLimits atomic.Pointer[rateLimitSettings]
+
+func init() {
+	rateLimits.Store(&rateLimitSettings{})
+}
+
+// InitRateLimits limits each client to limits[route] on the given routes. Clients are
+// keyed on the header when it is set and present, else on their certificate URA, else
+// on their IP address. Limiters whose limit is unchanged keep their buckets.
+func InitRateLimits(limits map[string]ratelimit.Limit, header string) {
+	current := rateLimits.Load()
+	limiters := make(map[string]*ratelimit.Limiter, len(limits))
+	for route, limit := range limits {
+		if l, ok := current.limiters[route]; ok && l.Limit() == limit {
+			limiters[route] = l
+			continue
+		}
+		limiters[route] = ratelimit.New(limit)
+	}
+	rateLimits.Store(&rateLimitSettings{limiters: limiters, header: header})
+}
+
+// resetRateLimits refills every client's buckets on all routes.
+func resetRateLimits() {
+	for _, l := range rateLimits.Load().limiters {
+		l.Reset()
+	}
+}
+
+// RateLimit returns a middleware that rejects a client's requests on the named route
+// once it exceeds its limit: 429 with Retry-After, as a mitz:RateLimited SOAP fault on
+// /xacml and /xcpd and a throttled OperationOutcome on /fhir.
+func RateLimit(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings := rateLimits.Load()
+		limiter := settings.limiters[endpoint]
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		key := rateLimitKey(c, settings.header)
+		ok, remaining, wait := limiter.Allow(key, time.Now())
+		c.Header("X-RateLimit-Limit", limiter.Limit().String())
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if ok {
+			c.Next()
+			return
+		}
+
+		retryAfter := max(1, int(math.Ceil(wait.Seconds())))
+		log.Printf("[RATELIMIT] %s: client %s exceeded %s — rejecting %s",
+			endpoint, key, limiter.Limit(), requestRef(c))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		abortWithRouteError(c, http.StatusTooManyRequests, "throttled", "mitz:RateLimited",
+			fmt.Sprintf("Rate limit exceeded — retry after %ds", retryAfter))
+	}
+}
+
+// rateLimitKey identifies the client a request is counted against.
+func rateLimitKey(c *gin.Context, header string) string {
+	if header != "" {
+		if v := c.GetHeader(header); v != "" {
+			return header + "=" + v
+		}
+	}
+	if ura := clientURA(c); ura != "" {
+		return "ura=" + ura
+	}
+	return "ip=" + c.ClientIP()
+}
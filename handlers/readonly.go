@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readOnlyMessage is returned for rejected writes in read-only mode.
+const readOnlyMessage = "This is a read-only environment: changes are not accepted. Queries (GET, /xacml, /xcpd) still work."
+
+// ReadOnly returns a middleware that rejects write operations — Subscription
+// create/delete, Bundle transactions, acknowledgments and admin changes — so a demo
+// instance keeps its state. Reads and the XACML/XCPD queries pass. A disabled
+// middleware passes every request.
+func ReadOnly(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled || isReadRequest(c.Request) {
+			c.Next()
+			return
+		}
+
+		log.Printf("[READONLY] Rejected %s %s %s", c.Request.Method, c.Request.URL.Path, requestRef(c))
+		if isFhirRoute(c) {
+			renderFhirError(c, http.StatusForbidden, "error", "forbidden", readOnlyMessage)
+			c.Abort()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": readOnlyMessage})
+	}
+}
+
+// isReadRequest reports whether r leaves the stored state untouched: a GET, HEAD or
+// OPTIONS request, or a SOAP query.
+func isReadRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return r.URL.Path == "/xacml" || r.URL.Path == "/xcpd"
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+	"mitz-replicator/reconcile"
+	"mitz-replicator/storage"
+)
+
+// bundleSources are the consent sources written by Bundle transactions.
+var bundleSources = []string{"migration", "toestemmingsknop"}
+
+// HandleAdminReconciliation handles GET /admin/reconciliation — compares the consents
+// submitted in Bundle transactions (from the captured requests) with the stored
+// consents. Query parameters: since (FHIR dateTime, start of the run), source
+// (migration or toestemmingsknop; default both) and format (json or csv). The report
+// is returned as a download.
+func HandleAdminReconciliation(c *gin.Context) {
+	var since time.Time
+	if v := c.Query("since"); v != "" {
+		t, err := parseFhirDateTime(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since: " + err.Error()})
+			return
+		}
+		since = t
+	}
+	source := c.Query("source")
+	if source != "" && !slices.Contains(bundleSources, source) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source must be migration or toestemmingsknop"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	st := StoreFor(c)
+	var consents []storage.Consent
+	for _, consent := range st.Consents() {
+		if consent.Created.Before(since) || !matchesSource(consent.Source, source) {
+			continue
+		}
+		consents = append(consents, consent)
+	}
+	report := reconcile.Build(bundleSubmissions(st.Requests(), since, source), consents)
+	report.Generated = time.Now().UTC()
+	report.Since = since
+	report.Source = source
+
+	filename := "reconciliation-" + report.Generated.Format("20060102T150405Z")
+	if format == "csv" {
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", reconciliationCSV(report))
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+	c.IndentedJSON(http.StatusOK, report)
+}
+
+// matchesSource reports whether a consent source is included: any Bundle source
+// when want is empty.
+func matchesSource(source, want string) bool {
+	if want == "" {
+		return slices.Contains(bundleSources, source)
+	}
+	return source == want
+}
+
+// bundleSubmissions re-reads the captured Bundle transactions received since that
+// carry a Consent. Bundles that cannot be parsed any more are kept as failed
+// submissions.
+func bundleSubmissions(requests []storage.CapturedRequest, since time.Time, source string) []reconcile.Submission {
+	var subs []reconcile.Submission
+	for _, captured := range requests {
+		if captured.Method != http.MethodPost || captured.Path != "/fhir/" || captured.Received.Before(since) {
+			continue
+		}
+		s := reconcile.Submission{RequestID: captured.RequestID, Received: captured.Received.UTC(), Status: captured.Status}
+		req, err := parser.ParseFhirBundle([]byte(captured.Body))
+		if err != nil {
+			if source == "" {
+				s.Error = err.Error()
+				subs = append(subs, s)
+			}
+			continue
+		}
+		if !req.HasConsent {
+			continue
+		}
+		s.Source = "migration"
+		if req.HasProvenance {
+			s.Source = "toestemmingsknop"
+		}
+		if !matchesSource(s.Source, source) {
+			continue
+		}
+		s.BSN, s.ProviderID, s.Decision, s.Categories = req.BSN, req.ProviderID, req.ConsentDecision, req.Categories
+		subs = append(subs, s)
+	}
+	return subs
+}
+
+// reconciliationCSV renders the report entries as CSV, one row per entry.
+func reconciliationCSV(r reconcile.Report) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"result", "requestId", "received", "status", "bsn", "providerId", "decision", "categories", "consentIds", "detail"})
+	submission := func(result string, s reconcile.Submission) {
+		w.Write([]string{result, s.RequestID, s.Received.Format(time.RFC3339), strconv.Itoa(s.Status),
+			s.BSN, s.ProviderID, s.Decision, strings.Join(s.Categories, " "), "", s.Error})
+	}
+	for _, s := range r.Failed {
+		submission("failed", s)
+	}
+	for _, s := range r.Missing {
+		submission("missing", s)
+	}
+	for _, d := range r.Duplicated {
+		w.Write([]string{"duplicated", "", "", "", d.BSN, d.ProviderID, d.Decision, strings.Join(d.Categories, " "),
+			strings.Join(d.ConsentIDs, " "), strconv.Itoa(d.Submitted) + " accepted submissions"})
+	}
+	for _, consent := range r.Unmatched {
+		w.Write([]string{"unmatched", "", consent.Created.UTC().Format(time.RFC3339), "", consent.BSN, consent.ProviderID,
+			consent.Decision, strings.Join(consent.Categories, " "), consent.ID, "source " + consent.Source})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
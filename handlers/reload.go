@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var reloadConfig func() error
+
+// InitReload sets the function that re-reads and applies the configuration.
+func InitReload(reload func() error) {
+	reloadConfig = reload
+}
+
+// HandleAdminReload handles POST /admin/config/reload — applies changed rules and
+// scenario settings without restarting the TLS listener.
+func HandleAdminReload(c *gin.Context) {
+	if reloadConfig == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "configuration reload is not available"})
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
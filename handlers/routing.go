@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"cmp"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/rules"
+)
+
+// ruleEngine decides mock outcomes for XACML, XCPD and FHIR requests. It is rebuilt
+// and swapped atomically whenever the configured or runtime rules change.
+var ruleEngine atomic.Pointer[rules.Engine]
+
+func init() {
+	ruleEngine.Store(rules.New(rules.Defaults(nil)))
+}
+
+// evaluateRules returns the outcome of the first rule matching req. A matching rule's
+// delay or hang is applied, and its Retry-After header, streaming and connection drop
+// are set up, before returning. Requests that carry no URA are matched on the URA resolved
+// from the client certificate.
+func evaluateRules(c *gin.Context, req rules.Request) (rules.Outcome, bool) {
+	req.Path = c.Request.URL.Path
+	req.Header = c.Request.Header
+	if req.URA == "" {
+		req.URA = clientURA(c)
+	}
+
+	rule, ok := ruleEngine.Load().Evaluate(req)
+	markPhase(c, phaseMatch)
+	if !ok {
+		return rules.Outcome{}, false
+	}
+	if rule.Description != "" {
+		log.Printf("[RULES] %s matched rule %q (%s) %s", req.Endpoint, rule.Name, rule.Description, requestRef(c))
+	} else {
+		log.Printf("[RULES] %s matched rule %q %s", req.Endpoint, rule.Name, requestRef(c))
+	}
+
+	if d := rule.Outcome.Delay().DurationFrom(requestRand(c)); d > 0 {
+		pause(c, d)
+	}
+	if rule.Outcome.Hang {
+		hang(c, req.Endpoint)
+	}
+	if rule.Outcome.RetryAfter != "" {
+		c.Header("Retry-After", rule.Outcome.RetryAfter)
+	}
+	if s := rule.Outcome.Stream(); s.ChunkBytes > 0 {
+		streamResponse(c, req.Endpoint, s)
+	}
+	if rule.Outcome.BandwidthBytes > 0 {
+		throttleResponse(c, req.Endpoint, rule.Outcome.BandwidthBytes)
+	}
+	if rule.Outcome.Malformed != "" {
+		log.Printf("[RULES] %s: malforming response (%s) %s", req.Endpoint, rule.Outcome.Malformed, requestRef(c))
+		malformResponse(c, rule.Outcome.Malformed)
+	}
+	if rule.Outcome.Signature != "" {
+		c.Set(signatureContextKey, rule.Outcome.Signature)
+	}
+	if rule.Outcome.Disconnect != "" {
+		log.Printf("[RULES] %s: dropping connection (%s) %s", req.Endpoint, rule.Outcome.Disconnect, requestRef(c))
+		dropConnection(c, rule.Outcome.Disconnect)
+	}
+
+	return rule.Outcome, true
+}
+
+// renderRuleSoapFault writes the SOAP fault described by a rule outcome.
+func renderRuleSoapFault(c *gin.Context, tmpl *template.Template, f *rules.SoapFault) {
+	detail := f.Detail
+	if detail == "" {
+		detail = "RequestId: " + c.GetHeader("X-Request-Id")
+	}
+
+	renderSoapFaultWith(c, tmpl, cmp.Or(f.Status, http.StatusOK), FaultData{
+		FaultCode:    cmp.Or(f.Code, "soap:Sender"),
+		FaultSubcode: f.Subcode,
+		FaultReason:  f.Reason,
+		FaultDetail:  detail,
+	})
+}
+
+// renderRuleFhirError writes the OperationOutcome described by a rule outcome.
+func renderRuleFhirError(c *gin.Context, f *rules.FhirError) {
+	renderFhirError(c, f.Status, cmp.Or(f.Severity, "error"), f.Code, f.Diagnostics)
+}
+
+// renderSoapFaultWith writes a SOAP fault using tmpl, or the soap11_fault template
+// for a SOAP 1.1 request.
+func renderSoapFaultWith(c *gin.Context, tmpl *template.Template, status int, data FaultData) {
+	data.FaultReason = xmlEscape(data.FaultReason)
+	data.FaultDetail = xmlEscape(data.FaultDetail)
+	data.Addressing = addressingFor(c, wsaFaultAction)
+	if isSOAP11(c) {
+		// The SOAP 1.1 HTTP binding sends every fault as 500 Internal Server Error.
+		status = http.StatusInternalServerError
+		tmpl = lookupTemplate(c, "soap11_fault")
+		data.FaultCode = soap11FaultCode(data.FaultCode)
+		// WS-Security faults use the wsse code itself as SOAP 1.1 faultcode.
+		if strings.HasPrefix(data.FaultSubcode, "wsse:") {
+			data.FaultCode = data.FaultSubcode
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("[SOAP] Fault template error: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	writeSOAP(c, status, buf.Bytes())
+}
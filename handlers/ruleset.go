@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"mitz-replicator/rules"
+)
+
+// Rule sources, in the order they are evaluated at equal priority.
+const (
+	ruleSourceRuntime    = "runtime"
+	ruleSourceConfigured = "config"
+	ruleSourceBuiltin    = "builtin"
+)
+
+// RuleEntry is a rule as listed by the admin API.
+type RuleEntry struct {
+	ID     string `json:"id,omitempty"` // runtime rules only
+	Source string `json:"source"`
+	rules.Rule
+}
+
+// ruleSet holds the rules behind ruleEngine. Runtime rules are added through the
+// admin API and survive configuration reloads.
+var ruleSet = struct {
+	mu         sync.Mutex
+	configured []rules.Rule
+	builtin    []rules.Rule
+	runtime    []RuleEntry
+}{builtin: rules.Defaults(nil)}
+
+// InitRules sets the rules from the configuration and the built-in BSN table (see
+// rules.Defaults). Configured rules are evaluated after runtime rules and before the
+// built-in ones (at equal priority).
+func InitRules(configured, builtin []rules.Rule) {
+	ruleSet.mu.Lock()
+	defer ruleSet.mu.Unlock()
+
+	ruleSet.configured = slices.Clone(configured)
+	ruleSet.builtin = slices.Clone(builtin)
+	rebuildRulesLocked()
+}
+
+// ruleEntriesLocked returns every rule in evaluation order.
+func ruleEntriesLocked() []RuleEntry {
+	entries := slices.Clone(ruleSet.runtime)
+	for _, r := range ruleSet.configured {
+		entries = append(entries, RuleEntry{Source: ruleSourceConfigured, Rule: r})
+	}
+	for _, r := range ruleSet.builtin {
+		entries = append(entries, RuleEntry{Source: ruleSourceBuiltin, Rule: r})
+	}
+	slices.SortStableFunc(entries, func(a, b RuleEntry) int { return b.Priority - a.Priority })
+	return entries
+}
+
+func rebuildRulesLocked() {
+	entries := ruleEntriesLocked()
+	all := make([]rules.Rule, len(entries))
+	for i, e := range entries {
+		all[i] = e.Rule
+	}
+	ruleEngine.Store(rules.New(all))
+}
+
+// HandleAdminRules handles GET /admin/rules — every rule in evaluation order.
+func HandleAdminRules(c *gin.Context) {
+	ruleSet.mu.Lock()
+	entries := ruleEntriesLocked()
+	ruleSet.mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{"total": len(entries), "rules": entries})
+}
+
+// HandleAdminRuleCreate handles POST /admin/rules — adds a runtime rule. It is
+// evaluated before configured and built-in rules of the same priority.
+func HandleAdminRuleCreate(c *gin.Context) {
+	var rule rules.Rule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := rules.Validate([]rules.Rule{rule}); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry := RuleEntry{ID: uuid.New().String(), Source: ruleSourceRuntime, Rule: rule}
+	ruleSet.mu.Lock()
+	ruleSet.runtime = append([]RuleEntry{entry}, ruleSet.runtime...)
+	rebuildRulesLocked()
+	ruleSet.mu.Unlock()
+
+	log.Printf("[ADMIN] Runtime rule %s added: %q", entry.ID, rule.Name)
+	c.JSON(http.StatusCreated, entry)
+}
+
+// HandleAdminRuleUpdate handles PUT /admin/rules/:id — replaces a runtime rule.
+func HandleAdminRuleUpdate(c *gin.Context) {
+	var rule rules.Rule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := rules.Validate([]rules.Rule{rule}); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	ruleSet.mu.Lock()
+	defer ruleSet.mu.Unlock()
+
+	i := slices.IndexFunc(ruleSet.runtime, func(e RuleEntry) bool { return e.ID == id })
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no runtime rule with id " + id})
+		return
+	}
+	ruleSet.runtime[i].Rule = rule
+	rebuildRulesLocked()
+
+	log.Printf("[ADMIN] Runtime rule %s updated: %q", id, rule.Name)
+	c.JSON(http.StatusOK, ruleSet.runtime[i])
+}
+
+// HandleAdminRuleDelete handles DELETE /admin/rules/:id — removes a runtime rule.
+func HandleAdminRuleDelete(c *gin.Context) {
+	id := c.Param("id")
+	ruleSet.mu.Lock()
+	defer ruleSet.mu.Unlock()
+
+	i := slices.IndexFunc(ruleSet.runtime, func(e RuleEntry) bool { return e.ID == id })
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no runtime rule with id " + id})
+		return
+	}
+	ruleSet.runtime = slices.Delete(ruleSet.runtime, i, i+1)
+	rebuildRulesLocked()
+
+	log.Printf("[ADMIN] Runtime rule %s removed", id)
+	c.Status(http.StatusNoContent)
+}
+
+// HandleAdminRulesClear handles DELETE /admin/rules — removes all runtime rules.
+func HandleAdminRulesClear(c *gin.Context) {
+	ruleSet.mu.Lock()
+	n := len(ruleSet.runtime)
+	ruleSet.runtime = nil
+	rebuildRulesLocked()
+	ruleSet.mu.Unlock()
+
+	log.Printf("[ADMIN] %d runtime rules removed", n)
+	c.JSON(http.StatusOK, gin.H{"removed": n})
+}
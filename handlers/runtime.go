@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var startedAt = time.Now()
+
+// HandleAdminRuntime handles GET /admin/runtime — goroutine, heap and GC figures of
+// the replicator process itself, for tracking memory growth during soak tests.
+func HandleAdminRuntime(c *gin.Context) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var lastGC string
+	if mem.LastGC > 0 {
+		lastGC = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"goVersion":     runtime.Version(),
+		"uptimeSeconds": int64(time.Since(startedAt).Seconds()),
+		"goroutines":    runtime.NumGoroutine(),
+		"cpus":          runtime.NumCPU(),
+		"heap": gin.H{
+			"allocBytes":    mem.HeapAlloc,
+			"inuseBytes":    mem.HeapInuse,
+			"idleBytes":     mem.HeapIdle,
+			"releasedBytes": mem.HeapReleased,
+			"objects":       mem.HeapObjects,
+		},
+		"sysBytes":        mem.Sys,
+		"totalAllocBytes": mem.TotalAlloc,
+		"mallocs":         mem.Mallocs,
+		"frees":           mem.Frees,
+		"gc": gin.H{
+			"cycles":       mem.NumGC,
+			"forced":       mem.NumForcedGC,
+			"pauseTotalNs": mem.PauseTotalNs,
+			"lastPauseNs":  mem.PauseNs[(mem.NumGC+255)%256],
+			"last":         lastGC,
+			"cpuFraction":  mem.GCCPUFraction,
+			"nextHeapGoal": mem.NextGC,
+		},
+	})
+}
+
+// RegisterPprof mounts the net/http/pprof handlers under group (at /debug/pprof).
+func RegisterPprof(group *gin.RouterGroup) {
+	debug := group.Group("/debug/pprof")
+	debug.GET("/", gin.WrapF(pprof.Index))
+	debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/profile", gin.WrapF(pprof.Profile))
+	debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/trace", gin.WrapF(pprof.Trace))
+	for _, profile := range []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"} {
+		debug.GET("/"+profile, gin.WrapH(pprof.Handler(profile)))
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/storage"
+)
+
+// ConsentChangedRequest is the body of POST /admin/scenarios/consent-changed.
+type ConsentChangedRequest struct {
+	BSN        string   `json:"bsn" binding:"required"`
+	Decision   string   `json:"decision" binding:"required,oneof=permit deny"`
+	Status     string   `json:"status"`
+	Categories []string `json:"categories"`
+	ProviderID string   `json:"providerId"`
+	Tenant     string   `json:"tenant"`
+
+	// Backdating: FHIR dates or dateTimes. Registered defaults to now.
+	EffectiveFrom  string `json:"effectiveFrom"`
+	EffectiveUntil string `json:"effectiveUntil"`
+	Registered     string `json:"registered"`
+}
+
+// HandleAdminConsentChanged handles POST /admin/scenarios/consent-changed — records a
+// consent change in one step: the consent is stored, subsequent XACML decisions for the
+// BSN follow it, and matching subscriptions are notified.
+func HandleAdminConsentChanged(c *gin.Context) {
+	var req ConsentChangedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status == "" {
+		req.Status = "active"
+	}
+	if patient, ok := deceasedPatient(req.BSN); ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": deceasedMessage(patient)})
+		return
+	}
+	effectiveFrom, effectiveUntil, err := parseConsentPeriod(req.EffectiveFrom, req.EffectiveUntil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var registered time.Time
+	if req.Registered != "" {
+		if registered, err = parseFhirDateTime(req.Registered); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "registered: " + err.Error()})
+			return
+		}
+	}
+
+	consent := storage.Consent{
+		ID:         newKey(consentExists(StoreFor(c))),
+		BSN:        req.BSN,
+		Status:     req.Status,
+		Decision:   req.Decision,
+		Categories: req.Categories,
+		ProviderID: req.ProviderID,
+		Tenant:     req.Tenant,
+		Source:     "scenario",
+		BundleID:   newIDFor(c),
+	}
+	consent.Created = cmp.Or(registered, now())
+	consent.EffectiveFrom, consent.EffectiveUntil = effectiveFrom, effectiveUntil
+	eventType := consentEventType(StoreFor(c), consent)
+	if err := StoreFor(c).SaveConsent(consent); err != nil {
+		log.Printf("[ADMIN] Failed to store Consent: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordEvent(StoreFor(c), storage.Event{Type: eventType, Resource: "Consent/" + consent.ID, BSN: consent.BSN})
+	notifyConsentChange(c, StoreFor(c), consent)
+
+	log.Printf("[ADMIN] Consent changed BSN=%s Decision=%s Status=%s Categories=%v Effective=%s",
+		consent.BSN, consent.Decision, consent.Status, consent.Categories, consent.Effective().Format(time.RFC3339))
+	c.JSON(http.StatusCreated, consent)
+}
+
+// storedDecision returns the XACML decision implied by the stored consent for bsn that
+// covers the event code and took effect last, and that consent. Consents without
+// categories cover all categories; consents outside their period are skipped; a
+// consent that is no longer active yields Deny.
+func storedDecision(st storage.Store, bsn, eventCode string) (string, storage.Consent, bool) {
+	category := eventCode
+	if _, code, ok := strings.Cut(eventCode, "^"); ok {
+		category = code
+	}
+
+	var latest storage.Consent
+	found := false
+	at := time.Now()
+	for _, consent := range st.Consents() {
+		if consent.BSN != bsn || consent.Decision == "" || !consent.InEffect(at) {
+			continue
+		}
+		if len(consent.Categories) > 0 && !slices.ContainsFunc(consent.Categories, func(cat string) bool {
+			return strings.EqualFold(cat, category)
+		}) {
+			continue
+		}
+		// Consents come in registration order, so a later registration wins a tie.
+		if !found || !consent.Effective().Before(latest.Effective()) {
+			latest, found = consent, true
+		}
+	}
+	if !found {
+		return "", storage.Consent{}, false
+	}
+	if latest.Status == "active" && latest.Decision == "permit" {
+		return "Permit", latest, true
+	}
+	return "Deny", latest, true
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScheduleWindow is a daily time-of-day window during which matching routes fail,
+// e.g. a nightly batch window that returns 503 between 02:00 and 03:00.
+type ScheduleWindow struct {
+	Start  time.Duration // offset from midnight
+	End    time.Duration // offset from midnight; End < Start wraps past midnight
+	Path   string        // route prefix, "" matches every route
+	Status int
+}
+
+// contains reports whether the time-of-day offset falls inside the window.
+func (w ScheduleWindow) contains(offset time.Duration) bool {
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// remaining returns how long until the window closes, given an offset inside it.
+func (w ScheduleWindow) remaining(offset time.Duration) time.Duration {
+	if offset < w.End {
+		return w.End - offset
+	}
+	return 24*time.Hour - offset + w.End
+}
+
+// ParseScheduleWindows parses a comma-separated list of windows in the form
+// "HH:MM-HH:MM [path] [status]", e.g. "02:00-03:00 /fhir 503, 12:00-12:05".
+// Path defaults to every route and status to 503.
+func ParseScheduleWindows(spec string) ([]ScheduleWindow, error) {
+	var windows []ScheduleWindow
+
+	for _, part := range strings.Split(spec, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+
+		startStr, endStr, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule window %q: expected HH:MM-HH:MM", fields[0])
+		}
+		start, err := parseTimeOfDay(startStr)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(endStr)
+		if err != nil {
+			return nil, err
+		}
+
+		w := ScheduleWindow{Start: start, End: end, Status: http.StatusServiceUnavailable}
+		for _, f := range fields[1:] {
+			if strings.HasPrefix(f, "/") {
+				w.Path = f
+				continue
+			}
+			status, err := strconv.Atoi(f)
+			if err != nil || status < 400 || status > 599 {
+				return nil, fmt.Errorf("invalid status %q in schedule window %q", f, strings.TrimSpace(part))
+			}
+			w.Status = status
+		}
+
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ScheduleMiddleware rejects requests that arrive inside a configured window, with
+// a Retry-After header pointing at the end of the window. Times are evaluated in loc.
+func ScheduleMiddleware(windows []ScheduleWindow, loc *time.Location) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now().In(loc)
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		offset := now.Sub(midnight)
+
+		for _, w := range windows {
+			if !w.contains(offset) || !strings.HasPrefix(c.Request.URL.Path, w.Path) {
+				continue
+			}
+
+			retryAfter := int(w.remaining(offset).Seconds()) + 1
+			log.Printf("[SCHEDULE] %s %s inside window %s — returning %d %s",
+				c.Request.Method, c.Request.URL.Path, formatWindow(w), w.Status, requestRef(c))
+
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			abortWithRouteError(c, w.Status, "transient", "mitz:Unavailable",
+				fmt.Sprintf("Service unavailable during scheduled window %s", formatWindow(w)))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func formatWindow(w ScheduleWindow) string {
+	hm := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return hm(w.Start) + "-" + hm(w.End)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testSeedHeader seeds the random choices made for a single request.
+const testSeedHeader = "X-Test-Seed"
+
+// testSeedContextKey holds the request's seeded random source in the gin context.
+const testSeedContextKey = "mitz.testSeed"
+
+// TestSeed returns a middleware that gives a request with an X-Test-Seed header its
+// own random source, so generated IDs, chaos injection and random delays repeat
+// when a failing test is re-run with the same seed. The seed is a number, or any
+// other text, which is hashed. It is echoed in the response.
+func TestSeed() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if v := c.GetHeader(testSeedHeader); v != "" {
+			seed, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				h := fnv.New64a()
+				h.Write([]byte(v))
+				seed = h.Sum64()
+			}
+			c.Set(testSeedContextKey, rand.New(rand.NewPCG(seed, seed^0x5851f42d4c957f2d)))
+			c.Header(testSeedHeader, v)
+		}
+		c.Next()
+	}
+}
+
+// requestRand returns the random source seeded by the request's X-Test-Seed, or nil.
+func requestRand(c *gin.Context) *rand.Rand {
+	if v, ok := c.Get(testSeedContextKey); ok {
+		return v.(*rand.Rand)
+	}
+	return nil
+}
+
+// randIntN returns a random number in [0, n) from the request's seeded source, if any.
+func randIntN(c *gin.Context, n int) int {
+	if rng := requestRand(c); rng != nil {
+		return rng.IntN(n)
+	}
+	return rand.IntN(n)
+}
+
+// newIDFor returns a version 4 UUID for a response to c: from its X-Test-Seed
+// source when set, else as newID.
+func newIDFor(c *gin.Context) string {
+	if rng := requestRand(c); rng != nil {
+		return uuidFrom(rng)
+	}
+	return newID()
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/storage"
+)
+
+// sessionHeader scopes stored state to a test session.
+const sessionHeader = "X-Test-Session"
+
+// storeContextKey holds the request's (possibly session-scoped) store in the gin context.
+const storeContextKey = "mitz.store"
+
+// sessionIDPattern restricts session IDs to values that are safe in file names.
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// sessionSweepInterval is how often SessionScope looks for idle session stores.
+const sessionSweepInterval = time.Minute
+
+// sessionEntry is an open session store.
+type sessionEntry struct {
+	store    storage.Store
+	lastUsed time.Time
+	inFlight int // requests currently using the store; never evicted while > 0
+}
+
+var sessions = struct {
+	mu     sync.Mutex
+	shared storage.Store
+	open   func(session string) (storage.Store, error)
+	idle   time.Duration // close session stores unused this long (0 = never)
+	swept  time.Time
+	stores map[string]*sessionEntry
+}{stores: map[string]*sessionEntry{}}
+
+// InitStores sets the shared store and the function that opens the store for a new
+// test session. With a nil open, the X-Test-Session header is ignored and all
+// requests share one store. Session stores unused for idle are closed; the next
+// request of the session opens its store again.
+func InitStores(shared storage.Store, open func(session string) (storage.Store, error), idle time.Duration) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	sessions.shared = shared
+	sessions.open = open
+	sessions.idle = idle
+}
+
+// CloseSessions closes all open session stores, e.g. on shutdown.
+func CloseSessions() {
+	sessions.mu.Lock()
+	stores := sessions.stores
+	sessions.stores = map[string]*sessionEntry{}
+	sessions.mu.Unlock()
+
+	for session, e := range stores {
+		if err := e.store.Close(); err != nil {
+			log.Printf("[SESSION] Failed to close store for session %s: %v", session, err)
+		}
+	}
+}
+
+// SessionScope returns a middleware that resolves the store for the request's
+// X-Test-Session header, so parallel test runs do not see each other's state.
+func SessionScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		evictIdleSessions(time.Now())
+
+		session := c.GetHeader(sessionHeader)
+		if session == "" {
+			c.Set(storeContextKey, sharedStore())
+			c.Next()
+			return
+		}
+
+		if !sessionIDPattern.MatchString(session) {
+			abortWithRouteError(c, http.StatusBadRequest, "invalid", "mitz:InvalidRequest",
+				"Invalid "+sessionHeader+" header: expected 1-64 characters [A-Za-z0-9_.-]")
+			return
+		}
+
+		s, err := acquireSession(session)
+		if err != nil {
+			log.Printf("[SESSION] Failed to open store for session %s: %v", session, err)
+			abortWithRouteError(c, http.StatusInternalServerError, "exception", "mitz:InternalError",
+				"Failed to open session store")
+			return
+		}
+
+		if s == nil {
+			c.Set(storeContextKey, sharedStore())
+			c.Next()
+			return
+		}
+		defer releaseSession(session)
+		c.Set(storeContextKey, s)
+		c.Next()
+	}
+}
+
+// StoreFor returns the store for the request: its session store if it carries an
+// X-Test-Session header, the shared store otherwise.
+func StoreFor(c *gin.Context) storage.Store {
+	if s, ok := c.Get(storeContextKey); ok {
+		return s.(storage.Store)
+	}
+	return sharedStore()
+}
+
+func sharedStore() storage.Store {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	return sessions.shared
+}
+
+// acquireSession returns the store for session, opening it on first use, and keeps
+// it open until releaseSession. It returns nil when session isolation is off.
+func acquireSession(session string) (storage.Store, error) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	if sessions.open == nil {
+		return nil, nil
+	}
+	e, ok := sessions.stores[session]
+	if !ok {
+		s, err := sessions.open(session)
+		if err != nil {
+			return nil, err
+		}
+		e = &sessionEntry{store: s}
+		sessions.stores[session] = e
+		log.Printf("[SESSION] Opened store for session %s", session)
+	}
+	e.inFlight++
+	e.lastUsed = time.Now()
+	return e.store, nil
+}
+
+// releaseSession ends a request's use of the session store.
+func releaseSession(session string) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	if e, ok := sessions.stores[session]; ok {
+		e.inFlight--
+		e.lastUsed = time.Now()
+	}
+}
+
+// evictIdleSessions closes the session stores that have not been used for the idle
+// timeout, at most once per sessionSweepInterval. Closing a memory store loses its
+// state, so that is logged as a warning.
+func evictIdleSessions(now time.Time) {
+	sessions.mu.Lock()
+	if sessions.idle <= 0 || now.Sub(sessions.swept) < sessionSweepInterval {
+		sessions.mu.Unlock()
+		return
+	}
+	sessions.swept = now
+	idle := sessions.idle
+	evicted := map[string]storage.Store{}
+	for session, e := range sessions.stores {
+		if e.inFlight == 0 && now.Sub(e.lastUsed) >= idle {
+			evicted[session] = e.store
+			delete(sessions.stores, session)
+		}
+	}
+	sessions.mu.Unlock()
+
+	for session, s := range evicted {
+		if err := s.Close(); err != nil {
+			log.Printf("[SESSION] Failed to close idle store for session %s: %v", session, err)
+			continue
+		}
+		if _, inMemory := s.(*storage.MemoryStore); inMemory {
+			log.Printf("[SESSION] WARNING: closed memory store for session %s after %s idle — its subscriptions, consents and events are gone", session, idle)
+			continue
+		}
+		log.Printf("[SESSION] Closed store for session %s after %s idle", session, idle)
+	}
+}
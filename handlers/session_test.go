@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"mitz-replicator/storage"
+)
+
+// TestEvictIdleMemorySessionWarns checks that closing an idle memory-backed session
+// store, which drops its state, is logged as a warning.
+func TestEvictIdleMemorySessionWarns(t *testing.T) {
+	InitStores(storage.NewMemoryStore(storage.Retention{}), func(string) (storage.Store, error) {
+		return storage.NewMemoryStore(storage.Retention{}), nil
+	}, time.Minute)
+	t.Cleanup(func() {
+		CloseSessions()
+		InitStores(nil, nil, 0)
+	})
+
+	if _, err := acquireSession("idle"); err != nil {
+		t.Fatal(err)
+	}
+	releaseSession("idle")
+
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+	evictIdleSessions(time.Now().Add(time.Hour))
+
+	sessions.mu.Lock()
+	_, open := sessions.stores["idle"]
+	sessions.mu.Unlock()
+	if open {
+		t.Fatal("idle session store was not closed")
+	}
+	if !strings.Contains(logs.String(), "WARNING: closed memory store for session idle") {
+		t.Errorf("no warning logged for the dropped session state, got %q", logs.String())
+	}
+}
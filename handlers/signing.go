@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/auth"
+	"mitz-replicator/rules"
+)
+
+// responseSignatureTTL is the lifetime of the Timestamp in signed SOAP responses.
+const responseSignatureTTL = 5 * time.Minute
+
+// signatureContextKey holds the signature defect a rule asked for in the gin context.
+const signatureContextKey = "mitz.signature"
+
+var (
+	xmlSigner         *auth.XMLSigner
+	signNotifications bool
+	signSubscriptions bool
+	signResponses     bool
+)
+
+// untrustedSigner signs with a throwaway certificate, for the untrusted signature
+// outcome. The key is only generated when a rule asks for it.
+var untrustedSigner = sync.OnceValues(func() (*auth.XMLSigner, error) {
+	return auth.NewSelfSignedXMLSigner("mitz-replicator untrusted signer")
+})
+
+// InitSigning configures which outbound documents are signed with the server key.
+// A nil signer disables signing.
+func InitSigning(s *auth.XMLSigner, notifications, subscriptionResponses, responses bool) {
+	xmlSigner = s
+	signNotifications = notifications
+	signSubscriptions = subscriptionResponses
+	signResponses = responses
+}
+
+// signIf returns body with an enveloped XML-DSig signature when enabled.
+// Signing failures are logged and the unsigned body is returned.
+func signIf(enabled bool, body []byte) []byte {
+	if !enabled || xmlSigner == nil {
+		return body
+	}
+
+	signed, err := xmlSigner.Sign(body)
+	if err != nil {
+		log.Printf("[SIGN] %v — sending unsigned document", err)
+		return body
+	}
+	return signed
+}
+
+// writeSignedSOAP writes a SOAP response like writeSOAP, with a WS-Security
+// signature when response signing is enabled. A rule's signature outcome spoils the
+// signature on purpose.
+func writeSignedSOAP(c *gin.Context, status int, body []byte) {
+	signed, err := signSOAP(c, body)
+	if err != nil {
+		log.Printf("[SIGN] %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, soapContentType(c), signed)
+}
+
+// signSOAP returns body in the request's SOAP version, signed as writeSignedSOAP
+// would send it. It fails only when the untrusted signer cannot be created.
+func signSOAP(c *gin.Context, body []byte) ([]byte, error) {
+	body = inSOAPVersion(c, body)
+	if !signResponses || xmlSigner == nil {
+		return body, nil
+	}
+
+	signer := xmlSigner
+	opts := auth.SOAPSignOptions{Created: now(), TTL: responseSignatureTTL}
+	defect := c.GetString(signatureContextKey)
+	switch defect {
+	case rules.SignatureUnsigned:
+		return body, nil
+	case rules.SignatureBadDigest:
+		opts.BadDigest = true
+	case rules.SignatureBadSignature:
+		opts.BadSignature = true
+	case rules.SignatureExpired:
+		opts.Created = opts.Created.Add(-2 * responseSignatureTTL)
+	case rules.SignatureUntrusted:
+		s, err := untrustedSigner()
+		if err != nil {
+			return nil, err
+		}
+		signer = s
+	}
+
+	signed, err := signer.SignSOAP(body, opts)
+	if err != nil {
+		log.Printf("[SIGN] %v — sending unsigned response", err)
+		return body, nil
+	}
+	return signed, nil
+}
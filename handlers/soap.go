@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+)
+
+// SOAP envelope namespaces.
+const (
+	nsSOAP11 = "http://schemas.xmlsoap.org/soap/envelope/"
+	nsSOAP12 = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// soap11ContentType is the Content-Type of SOAP 1.1 responses.
+const soap11ContentType = "text/xml; charset=utf-8"
+
+// soap11ContextKey marks a request answered with SOAP 1.1 in the gin context.
+const soap11ContextKey = "mitz.soap11"
+
+// soap11FaultCodes maps SOAP 1.2 fault codes to their SOAP 1.1 names.
+var soap11FaultCodes = map[string]string{
+	"soap:Sender":   "soap:Client",
+	"soap:Receiver": "soap:Server",
+}
+
+// SOAPVersion returns a middleware that picks the SOAP version a request is answered
+// in before any other middleware can fault it, so maintenance, cutover and header
+// faults match the request too. A gzip body is only read once Compression has
+// inflated it; until then the Content-Type decides (see SOAPEnvelope).
+func SOAPVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isFhirRoute(c) {
+			c.Next()
+			return
+		}
+
+		var body []byte
+		if isSOAPRoute(c) && !contentEncoded(c) {
+			body = peekBody(c)
+		}
+		setSOAPVersion(c, body)
+		c.Next()
+	}
+}
+
+// SOAPEnvelope returns a middleware that settles the SOAP version from the inflated
+// request body and records the request's WS-Addressing headers, which responses then
+// answer (see addressingFor).
+func SOAPEnvelope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := peekBody(c)
+		setSOAPVersion(c, body)
+		if addressing := parser.ParseAddressing(body); addressing != nil {
+			c.Set(addressingContextKey, addressing)
+		}
+		c.Next()
+	}
+}
+
+// setSOAPVersion records the SOAP version of a request: the namespace of the Envelope
+// in body, or, when body has none, SOAP 1.1 for a text/xml Content-Type and SOAP 1.2
+// otherwise.
+func setSOAPVersion(c *gin.Context, body []byte) {
+	version := parser.SOAPVersion(body)
+	if version == "" {
+		if mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type")); err == nil && mediaType == "text/xml" {
+			version = "1.1"
+		}
+	}
+	c.Set(soap11ContextKey, version == "1.1")
+}
+
+// peekBody returns the request body and puts it back for the next reader.
+func peekBody(c *gin.Context) []byte {
+	body, _ := ReadBody(c)
+	return body
+}
+
+// isSOAPRoute reports whether c is a request to one of the SOAP endpoints.
+func isSOAPRoute(c *gin.Context) bool {
+	return c.Request.URL.Path == "/xacml" || c.Request.URL.Path == "/xcpd"
+}
+
+// contentEncoded reports whether the request body still carries a Content-Encoding.
+func contentEncoded(c *gin.Context) bool {
+	coding := strings.TrimSpace(c.GetHeader("Content-Encoding"))
+	return coding != "" && !strings.EqualFold(coding, "identity")
+}
+
+// isSOAP11 reports whether the response to c uses SOAP 1.1.
+func isSOAP11(c *gin.Context) bool {
+	return c.GetBool(soap11ContextKey)
+}
+
+// writeSOAP writes a SOAP response rendered from a SOAP 1.2 template, moving it to
+// the SOAP 1.1 envelope namespace when the request was SOAP 1.1.
+func writeSOAP(c *gin.Context, status int, body []byte) {
+	c.Data(status, soapContentType(c), inSOAPVersion(c, body))
+}
+
+// inSOAPVersion returns a response rendered from a SOAP 1.2 template in the SOAP
+// version of the request.
+func inSOAPVersion(c *gin.Context, body []byte) []byte {
+	if isSOAP11(c) {
+		return bytes.ReplaceAll(body, []byte(nsSOAP12), []byte(nsSOAP11))
+	}
+	return body
+}
+
+// soap11FaultCode returns the SOAP 1.1 name of a SOAP 1.2 fault code. VersionMismatch
+// and MustUnderstand are the same in both.
+func soap11FaultCode(code string) string {
+	if v, ok := soap11FaultCodes[code]; ok {
+		return v
+	}
+	return code
+}
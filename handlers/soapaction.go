@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+)
+
+// requestActions are the actions Mitz expects on each SOAP endpoint. XCPD also takes
+// query continuations and cancels of paged answers.
+var requestActions = map[string][]string{
+	"xacml": {"urn:oasis:names:tc:xacml:3.0:profile:saml2.0:v2:schema:protocol:XACMLAuthzDecisionQuery"},
+	"xcpd": {
+		"urn:hl7-org:v3:PRPA_IN201305UV02:CrossGatewayPatientDiscovery",
+		"urn:hl7-org:v3:QUQI_IN000003UV01",
+		"urn:hl7-org:v3:QUQI_IN000003UV01_Cancel",
+	},
+}
+
+var soapActionsStrict atomic.Bool
+
+// InitSOAPActions sets whether requests with an unexpected action are rejected
+// (strict) or only logged.
+func InitSOAPActions(strict bool) {
+	soapActionsStrict.Store(strict)
+}
+
+// SOAPAction returns a middleware that checks the actions a request to endpoint
+// declares — the SOAPAction header (SOAP 1.1), the action parameter of the
+// Content-Type (SOAP 1.2) and wsa:Action — against the actions Mitz expects there.
+// Absent or empty actions are accepted. Must run after SOAPEnvelope.
+func SOAPAction(endpoint string) gin.HandlerFunc {
+	expected := requestActions[endpoint]
+	want := strings.Join(expected, " or ")
+	return func(c *gin.Context) {
+		source, action := mismatchingAction(c, expected)
+		if source == "" {
+			c.Next()
+			return
+		}
+
+		if !soapActionsStrict.Load() {
+			log.Printf("[SOAP] %s: accepting %s %q, expected %s %s", endpoint, source, action, want, requestRef(c))
+			c.Next()
+			return
+		}
+		log.Printf("[SOAP] %s: rejecting %s %q, expected %s %s", endpoint, source, action, want, requestRef(c))
+		abortWithRouteError(c, http.StatusBadRequest, "invalid", "mitz:ActionMismatch",
+			source+" "+action+" does not match the expected action "+want)
+	}
+}
+
+// mismatchingAction returns the first declared action that is not one of expected,
+// with where it was declared, or "" when all declared actions match.
+func mismatchingAction(c *gin.Context, expected []string) (source, action string) {
+	if v := c.Request.Header.Values("SOAPAction"); len(v) > 0 {
+		if action := strings.Trim(strings.TrimSpace(v[0]), `"`); action != "" && !slices.Contains(expected, action) {
+			return "SOAPAction", action
+		}
+	}
+	if _, params, err := mime.ParseMediaType(c.GetHeader("Content-Type")); err == nil {
+		if action := params["action"]; action != "" && !slices.Contains(expected, action) {
+			return "Content-Type action", action
+		}
+	}
+	if v, ok := c.Get(addressingContextKey); ok {
+		if action := v.(*parser.Addressing).Action; action != "" && !slices.Contains(expected, action) {
+			return "wsa:Action", action
+		}
+	}
+	return "", ""
+}
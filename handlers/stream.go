@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"maps"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/rules"
+)
+
+// streamSettings are the chunked streaming modes and bandwidth limits.
+type streamSettings struct {
+	endpoints       map[string]rules.Stream // per route (xacml, xcpd, fhir)
+	bandwidth       map[string]int          // bytes per second per route
+	clientBandwidth map[string]int          // bytes per second per client URA or IP address
+}
+
+var streaming atomic.Pointer[streamSettings]
+
+func init() {
+	streaming.Store(&streamSettings{})
+}
+
+// InitStreaming sets the routes whose responses are streamed in chunks, and the
+// bandwidth (bytes per second) responses are limited to per route and per client.
+func InitStreaming(endpoints map[string]rules.Stream, bandwidth, clientBandwidth map[string]int) {
+	streaming.Store(&streamSettings{
+		endpoints:       maps.Clone(endpoints),
+		bandwidth:       maps.Clone(bandwidth),
+		clientBandwidth: maps.Clone(clientBandwidth),
+	})
+}
+
+// Stream returns a middleware that streams the responses of the named route in
+// chunks, or limits them to a bandwidth, when that is configured. A client's
+// bandwidth replaces the route's; a rule outcome can set its own.
+func Stream(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings := streaming.Load()
+		if s, ok := settings.endpoints[endpoint]; ok {
+			streamResponse(c, endpoint, s)
+		}
+		if bps, ok := clientBandwidth(c, settings.clientBandwidth); ok {
+			throttleResponse(c, endpoint, bps)
+		} else if bps, ok := settings.bandwidth[endpoint]; ok {
+			throttleResponse(c, endpoint, bps)
+		}
+		c.Next()
+	}
+}
+
+// clientBandwidth returns the bandwidth configured for the client's URA, else its IP address.
+func clientBandwidth(c *gin.Context, clients map[string]int) (int, bool) {
+	if ura := clientURA(c); ura != "" {
+		if bps, ok := clients[ura]; ok {
+			return bps, true
+		}
+	}
+	bps, ok := clients[c.ClientIP()]
+	return bps, ok
+}
+
+// throttleResponse limits the response body to bytesPerSecond, replacing any
+// chunking set earlier.
+func throttleResponse(c *gin.Context, endpoint string, bytesPerSecond int) {
+	log.Printf("[STREAM] %s: throttling to %d bytes/s %s", endpoint, bytesPerSecond, requestRef(c))
+	setStream(c, rules.Bandwidth(bytesPerSecond))
+}
+
+// streamResponse makes the response body go out in chunks of s.ChunkBytes, flushed
+// one by one with s.Interval in between, replacing any chunking set earlier.
+func streamResponse(c *gin.Context, endpoint string, s rules.Stream) {
+	log.Printf("[STREAM] %s: streaming in %d-byte chunks every %s %s", endpoint, s.ChunkBytes, s.Interval, requestRef(c))
+	setStream(c, s)
+}
+
+// setStream installs the streamWriter, or changes the chunking of the installed one.
+func setStream(c *gin.Context, s rules.Stream) {
+	skipCompression(c)
+	if w, ok := c.Writer.(*streamWriter); ok {
+		w.stream = s
+		return
+	}
+	c.Writer = &streamWriter{ResponseWriter: c.Writer, stream: s, ctx: c.Request.Context()}
+}
+
+// streamWriter writes the body in flushed chunks, pausing between them.
+type streamWriter struct {
+	gin.ResponseWriter
+	stream rules.Stream
+	ctx    context.Context // request context; streaming stops when the client goes away
+}
+
+func (w *streamWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		if written > 0 && w.stream.Interval > 0 {
+			timer := time.NewTimer(w.stream.Interval)
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				return written, w.ctx.Err()
+			}
+		}
+		chunk := data[:min(w.stream.ChunkBytes, len(data))]
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		w.ResponseWriter.Flush()
+		data = data[len(chunk):]
+	}
+	return written, nil
+}
+
+func (w *streamWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/parser"
+)
+
+// strictness holds the request parsing strictness per client. Clients are keyed by
+// the URA resolved from their certificate or, failing that, their X-Test-Session.
+var strictness = struct {
+	mu         sync.RWMutex
+	def        parser.Strictness
+	configured map[string]parser.Strictness // from the configuration
+	overrides  map[string]parser.Strictness // set through the admin API; survive reloads
+	validation bool                         // VALIDATION_MODE=strict: structure and required-content checks for every client
+}{configured: map[string]parser.Strictness{}, overrides: map[string]parser.Strictness{}}
+
+// InitStrictness sets the default parsing strictness and the configured per-client levels.
+func InitStrictness(def parser.Strictness, clients map[string]parser.Strictness) {
+	strictness.mu.Lock()
+	defer strictness.mu.Unlock()
+
+	strictness.def = def
+	strictness.configured = maps.Clone(clients)
+	if strictness.configured == nil {
+		strictness.configured = map[string]parser.Strictness{}
+	}
+}
+
+// InitValidationMode turns the strict validation profile on or off. Strict validation
+// adds structure and required-content checks to every client's strictness level.
+func InitValidationMode(strict bool) {
+	strictness.mu.Lock()
+	defer strictness.mu.Unlock()
+
+	strictness.validation = strict
+}
+
+// strictnessFor returns the parsing strictness for the client behind c.
+func strictnessFor(c *gin.Context) parser.Strictness {
+	strictness.mu.RLock()
+	defer strictness.mu.RUnlock()
+
+	s := strictness.def
+	for _, client := range []string{clientURA(c), c.GetHeader(sessionHeader)} {
+		if client == "" {
+			continue
+		}
+		if level, ok := strictness.overrides[client]; ok {
+			s = level
+			break
+		}
+		if level, ok := strictness.configured[client]; ok {
+			s = level
+			break
+		}
+	}
+	if strictness.validation {
+		s.Structure, s.Required = true, true
+	}
+	return s
+}
+
+// validationMode returns the name of the validation profile; callers hold strictness.mu.
+func validationMode() string {
+	if strictness.validation {
+		return "strict"
+	}
+	return "lenient"
+}
+
+// HandleAdminStrictness handles GET /admin/strictness — the default and per-client levels.
+func HandleAdminStrictness(c *gin.Context) {
+	strictness.mu.RLock()
+	defer strictness.mu.RUnlock()
+
+	clients := map[string]string{}
+	for client, s := range strictness.configured {
+		clients[client] = s.String()
+	}
+	for client, s := range strictness.overrides {
+		clients[client] = s.String()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"default":    strictness.def.String(),
+		"validation": validationMode(),
+		"clients":    clients,
+	})
+}
+
+// HandleAdminStrictnessSet handles PUT /admin/strictness/:client — sets one client's
+// level: {"strictness": "lenient" | "structure" | "namespaces" | "strict"}.
+func HandleAdminStrictnessSet(c *gin.Context) {
+	var body struct {
+		Strictness string `json:"strictness" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s, err := parser.ParseStrictness(body.Strictness)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client := c.Param("client")
+	strictness.mu.Lock()
+	strictness.overrides[client] = s
+	strictness.mu.Unlock()
+
+	log.Printf("[ADMIN] Parsing strictness for %s set to %s", client, s)
+	c.Status(http.StatusNoContent)
+}
+
+// HandleAdminStrictnessDelete handles DELETE /admin/strictness/:client — drops the
+// override so the configured level applies again.
+func HandleAdminStrictnessDelete(c *gin.Context) {
+	client := c.Param("client")
+	strictness.mu.Lock()
+	delete(strictness.overrides, client)
+	strictness.mu.Unlock()
+
+	log.Printf("[ADMIN] Parsing strictness override for %s removed", client)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requiredTemplates are the response templates every template set must provide.
+var requiredTemplates = []string{
+	"xacml_response", "xacml_fault",
+	"xcpd_found", "xcpd_empty", "xcpd_fault", "xcpd_accept_ack",
+	"fhir_subscription", "fhir_subscription_searchset", "fhir_consent_searchset",
+	"fhir_bundle_response", "fhir_processing_status", "fhir_operation_outcome",
+	"fhir_notification", "fhir_organization", "fhir_organization_searchset",
+	"fhir_capability_statement", "soap11_fault",
+}
+
+// versionHeader selects the Mitz release whose response templates are used.
+const versionHeader = "X-Mitz-Version"
+
+// versionContextKey holds the request's template version in the gin context.
+const versionContextKey = "mitz.version"
+
+// templateSets holds the parsed response templates per Mitz version ("" is the
+// unversioned set) and the version used when a request does not name one.
+type templateSets struct {
+	versions       map[string]map[string]*template.Template
+	defaultVersion string
+}
+
+// templates is swapped atomically so a reload never leaves handlers with a mix of
+// old and new templates.
+var templates atomic.Pointer[templateSets]
+
+// TemplateLayer is one set of template sources, e.g. the embedded templates or a
+// directory of overrides.
+type TemplateLayer struct {
+	Name     string                       // used in error messages
+	Sources  map[string]string            // template name → XML
+	Versions map[string]map[string]string // Mitz version → template name → XML
+}
+
+// LoadTemplates parses layers in order and installs the result. A file in a later
+// layer replaces the template of the same name, unless it only contains {{define}}
+// blocks: then it overrides just those blocks of the template below it. Partials
+// (files starting with "_") are shared by every template, later layers' blocks
+// replacing earlier ones. Each Mitz
+// version is built the same way, with a layer's version files applied right after
+// its unversioned ones. Requests without X-Mitz-Version use defaultVersion. If any
+// template is missing or fails to parse, the current set is kept.
+func LoadTemplates(defaultVersion string, layers ...TemplateLayer) error {
+	versions := map[string]bool{"": true}
+	for _, layer := range layers {
+		for version := range layer.Versions {
+			versions[version] = true
+		}
+	}
+
+	sets := &templateSets{versions: make(map[string]map[string]*template.Template), defaultVersion: defaultVersion}
+	var problems []string
+	for _, version := range slices.Sorted(maps.Keys(versions)) {
+		var partials []string
+		for _, layer := range layers {
+			partials = append(partials, partialSources(layer.Sources)...)
+			if version != "" {
+				partials = append(partials, partialSources(layer.Versions[version])...)
+			}
+		}
+
+		parsed := make(map[string]*template.Template)
+		parse := func(layerName string, sources map[string]string) {
+			for _, name := range slices.Sorted(maps.Keys(sources)) {
+				if isPartial(name) {
+					continue
+				}
+				t, ok := parsed[name]
+				if !ok {
+					t = template.New(name)
+					for _, partial := range partials {
+						if _, err := t.Parse(partial); err != nil {
+							problems = append(problems, layerName+": "+err.Error())
+						}
+					}
+				}
+				// A body of only whitespace and definitions keeps the existing body.
+				if _, err := t.Parse(sources[name]); err != nil {
+					problems = append(problems, layerName+": "+err.Error())
+					continue
+				}
+				parsed[name] = t
+			}
+		}
+		for _, layer := range layers {
+			parse(layer.Name, layer.Sources)
+			if version != "" {
+				parse(layer.Name+"/mitz-"+version, layer.Versions[version])
+			}
+		}
+		sets.versions[version] = parsed
+	}
+	for _, name := range requiredTemplates {
+		if _, ok := sets.versions[""][name]; !ok {
+			problems = append(problems, "missing template "+name)
+		}
+	}
+	if _, ok := sets.versions[defaultVersion]; !ok {
+		problems = append(problems, "no templates for default Mitz version "+defaultVersion)
+	}
+	if len(problems) > 0 {
+		// Errors in unversioned sources repeat for every version.
+		slices.Sort(problems)
+		return fmt.Errorf("invalid templates: %s", strings.Join(slices.Compact(problems), "; "))
+	}
+
+	templates.Store(sets)
+	return nil
+}
+
+// isPartial reports whether the template file name is a partial: a file starting with
+// "_" whose {{define}} blocks every template of the set can use.
+func isPartial(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+// partialSources returns the partials among sources, in name order.
+func partialSources(sources map[string]string) []string {
+	var partials []string
+	for _, name := range slices.Sorted(maps.Keys(sources)) {
+		if isPartial(name) {
+			partials = append(partials, sources[name])
+		}
+	}
+	return partials
+}
+
+// TemplateVersions returns the Mitz versions that have their own template set.
+func TemplateVersions() []string {
+	var versions []string
+	for version := range templates.Load().versions {
+		if version != "" {
+			versions = append(versions, version)
+		}
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+// MitzVersion returns a middleware that selects the template set named by the
+// X-Mitz-Version header ("2.0" or "mitz-2.0"). Unknown versions are rejected.
+func MitzVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader(versionHeader)
+		if header == "" {
+			c.Next()
+			return
+		}
+
+		version := strings.TrimPrefix(header, "mitz-")
+		if _, ok := templates.Load().versions[version]; !ok {
+			abortWithRouteError(c, http.StatusBadRequest, "not-supported", "mitz:InvalidRequest",
+				"Unknown "+versionHeader+" "+header+" (available: "+strings.Join(TemplateVersions(), ", ")+")")
+			return
+		}
+
+		c.Set(versionContextKey, version)
+		c.Next()
+	}
+}
+
+// lookupTemplate returns the named response template for the request's Mitz version.
+// A nil context (e.g. outbound notifications) uses the default version.
+func lookupTemplate(c *gin.Context, name string) *template.Template {
+	sets := templates.Load()
+	version := sets.defaultVersion
+	if c != nil {
+		if v, ok := c.Get(versionContextKey); ok {
+			version = v.(string)
+		}
+	}
+	return sets.versions[version][name]
+}
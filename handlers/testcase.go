@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/storage"
+)
+
+// testcaseHeader names the OTV-TR test case an acceptance run is executing.
+const testcaseHeader = "X-OTV-Testcase"
+
+// testcaseContextKey holds the request's normalised test case ID in the gin context.
+const testcaseContextKey = "mitz.testcase"
+
+// otvTestcase is the request shape an OTV-TR test case expects.
+type otvTestcase struct {
+	Description string `json:"description"`
+	Method      string `json:"method,omitempty"`
+	Route       string `json:"route,omitempty"`
+	Bundle      string `json:"bundle,omitempty"` // migration or toestemmingsknop, for Bundle transactions
+}
+
+// testcaseCatalogue is the artifact listing the OTV-TR test cases and their request shapes.
+const testcaseCatalogue = "testcases/otv-tr.json"
+
+// otvTestcases are the OTV-TR transactions of the catalogue, by ID.
+var otvTestcases = map[string]otvTestcase{}
+
+var testcasesEnforced atomic.Bool
+
+// InitTestcases loads the test case catalogue from the artifacts (so ARTIFACTS_DIR can
+// replace it) and sets whether requests must match the test case named in their
+// X-OTV-Testcase header. Tagging happens either way. Call it after InitArtifacts.
+func InitTestcases(enforce bool) (int, error) {
+	data, err := readArtifact(testcaseCatalogue)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", testcaseCatalogue, err)
+	}
+	testcases, err := parseTestcaseCatalogue(data)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", testcaseCatalogue, err)
+	}
+
+	otvTestcases = testcases
+	testcasesEnforced.Store(enforce)
+	return len(testcases), nil
+}
+
+// parseTestcaseCatalogue reads {"testcases": [{"id", "description", "method", "route",
+// "bundle"}, ...]}, keyed by upper-cased ID.
+func parseTestcaseCatalogue(data []byte) (map[string]otvTestcase, error) {
+	var catalogue struct {
+		Testcases []struct {
+			ID string `json:"id"`
+			otvTestcase
+		} `json:"testcases"`
+	}
+	if err := json.Unmarshal(data, &catalogue); err != nil {
+		return nil, err
+	}
+
+	testcases := make(map[string]otvTestcase, len(catalogue.Testcases))
+	for i, entry := range catalogue.Testcases {
+		id := strings.ToUpper(strings.TrimSpace(entry.ID))
+		tc := entry.otvTestcase
+		tc.Method = strings.ToUpper(tc.Method)
+		switch {
+		case id == "":
+			return nil, fmt.Errorf("test case %d has no id", i+1)
+		case tc.Method == "" || !strings.HasPrefix(tc.Route, "/"):
+			return nil, fmt.Errorf("%s: method and route (starting with /) are required", id)
+		case !slices.Contains([]string{"", "migration", "toestemmingsknop"}, tc.Bundle):
+			return nil, fmt.Errorf("%s: bundle must be migration or toestemmingsknop, got %q", id, tc.Bundle)
+		}
+		if _, dup := testcases[id]; dup {
+			return nil, fmt.Errorf("duplicate test case %s", id)
+		}
+		testcases[id] = tc
+	}
+	return testcases, nil
+}
+
+// OTVTestcase returns a middleware that tags the request with the test case in its
+// X-OTV-Testcase header, so logs and captured requests can be traced back to it. The
+// ID is echoed in the response. When enforcement is on, unknown test cases and
+// requests to another endpoint are rejected with 400.
+func OTVTestcase() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.ToUpper(strings.TrimSpace(c.GetHeader(testcaseHeader)))
+		if id == "" {
+			c.Next()
+			return
+		}
+		c.Set(testcaseContextKey, id)
+		c.Header(testcaseHeader, id)
+
+		if testcasesEnforced.Load() && !strings.HasPrefix(c.Request.URL.Path, "/admin") {
+			if problem := testcaseProblem(id, c.Request.Method, c.FullPath()); problem != "" {
+				rejectTestcase(c, problem)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// testcaseProblem describes why a request to method and route does not belong to
+// test case id, or returns "".
+func testcaseProblem(id, method, route string) string {
+	tc, ok := otvTestcases[id]
+	if !ok {
+		return fmt.Sprintf("unknown test case %s (known: %s)", id, strings.Join(slices.Sorted(maps.Keys(otvTestcases)), ", "))
+	}
+	if method != tc.Method || route != tc.Route {
+		return fmt.Sprintf("%s (%s) expects %s %s, got %s %s", id, tc.Description, tc.Method, tc.Route, method, route)
+	}
+	return ""
+}
+
+// checkTestcaseBundle verifies, when enforcement is on, that a Bundle transaction of
+// txType (migration or toestemmingsknop) is the one the request's test case expects.
+// It reports false after rejecting the request.
+func checkTestcaseBundle(c *gin.Context, txType string) bool {
+	id := TestcaseFor(c)
+	tc, ok := otvTestcases[id]
+	if !testcasesEnforced.Load() || !ok || tc.Bundle == "" || tc.Bundle == txType {
+		return true
+	}
+	rejectTestcase(c, fmt.Sprintf("%s (%s) expects a %s Bundle, got a %s Bundle", id, tc.Description, tc.Bundle, txType))
+	return false
+}
+
+func rejectTestcase(c *gin.Context, problem string) {
+	log.Printf("[TESTCASE] Rejecting %s %s: %s %s", c.Request.Method, c.Request.URL.Path, problem, requestRef(c))
+	abortWithRouteError(c, http.StatusBadRequest, "invalid", "mitz:TestcaseMismatch", "Request does not match test case: "+problem)
+}
+
+// TestcaseFor returns the OTV-TR test case the request is tagged with, or "".
+func TestcaseFor(c *gin.Context) string {
+	return c.GetString(testcaseContextKey)
+}
+
+// requestRef identifies the request in log lines: its X-Request-Id and, when it
+// carries one, its test case.
+func requestRef(c *gin.Context) string {
+	ref := "RequestId=" + c.GetHeader("X-Request-Id")
+	if id := TestcaseFor(c); id != "" {
+		ref += " Testcase=" + id
+	}
+	return ref
+}
+
+// testcaseSummary is a test case with the requests captured for it.
+type testcaseSummary struct {
+	ID string `json:"id"`
+	otvTestcase
+	Requests int            `json:"requests"`
+	ByStatus map[string]int `json:"byStatus"`
+}
+
+// HandleAdminTestcases handles GET /admin/testcases — the known OTV-TR test cases,
+// plus any other test case IDs clients sent, with their captured requests per status.
+func HandleAdminTestcases(c *gin.Context) {
+	summaries := make(map[string]*testcaseSummary, len(otvTestcases))
+	for id, tc := range otvTestcases {
+		summaries[id] = &testcaseSummary{ID: id, otvTestcase: tc, ByStatus: map[string]int{}}
+	}
+	for _, captured := range StoreFor(c).Requests() {
+		if captured.Testcase == "" {
+			continue
+		}
+		s, ok := summaries[captured.Testcase]
+		if !ok {
+			s = &testcaseSummary{ID: captured.Testcase, otvTestcase: otvTestcase{Description: "unknown"}, ByStatus: map[string]int{}}
+			summaries[captured.Testcase] = s
+		}
+		s.Requests++
+		countKey(s.ByStatus, fmt.Sprint(captured.Status))
+	}
+
+	list := make([]*testcaseSummary, 0, len(summaries))
+	for _, id := range slices.Sorted(maps.Keys(summaries)) {
+		list = append(list, summaries[id])
+	}
+	c.JSON(http.StatusOK, gin.H{"enforced": testcasesEnforced.Load(), "testcases": list})
+}
+
+// HandleAdminTestcaseRequests handles GET /admin/testcases/:id — the captured
+// requests tagged with the test case, in the order they were received.
+func HandleAdminTestcaseRequests(c *gin.Context) {
+	id := strings.ToUpper(c.Param("id"))
+	requests := []storage.CapturedRequest{}
+	for _, captured := range StoreFor(c).Requests() {
+		if captured.Testcase == id {
+			requests = append(requests, captured)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"testcase": id, "requests": requests})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timingContextKey holds the request's *requestTiming in the gin context.
+const timingContextKey = "mitz.timing"
+
+// Phases reported in the Server-Timing header. Each covers the time since the previous
+// one; time after the last phase, up to the first byte of the response, is render.
+const (
+	phaseQueue  = "queue"
+	phaseParse  = "parse"
+	phaseMatch  = "match"
+	phaseDelay  = "delay"
+	phaseStore  = "store"
+	phaseRender = "render"
+)
+
+// requestTiming accumulates the time spent per phase of one request.
+type requestTiming struct {
+	start, last time.Time
+	names       []string
+	durations   map[string]time.Duration
+}
+
+func (t *requestTiming) mark(name string) {
+	now := time.Now()
+	if _, ok := t.durations[name]; !ok {
+		t.names = append(t.names, name)
+	}
+	t.durations[name] += now.Sub(t.last)
+	t.last = now
+}
+
+// header formats the phases as a Server-Timing value, in milliseconds.
+func (t *requestTiming) header() string {
+	t.mark(phaseRender)
+	parts := make([]string, 0, len(t.names)+1)
+	for _, name := range t.names {
+		parts = append(parts, fmt.Sprintf("%s;dur=%.3f", name, milliseconds(t.durations[name])))
+	}
+	parts = append(parts, fmt.Sprintf("total;dur=%.3f", milliseconds(t.last.Sub(t.start))))
+	return strings.Join(parts, ", ")
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// markPhase ends the named phase of the request; repeated phases add up. It does
+// nothing unless timing is enabled.
+func markPhase(c *gin.Context, name string) {
+	if t, ok := c.Get(timingContextKey); ok {
+		t.(*requestTiming).mark(name)
+	}
+}
+
+// ServerTiming returns a middleware that reports the replicator's own time per phase
+// (queue, parse, match, delay, store, render) in a Server-Timing response header, so
+// client-side latency can be split into replicator and network time. A disabled
+// middleware passes requests through.
+func ServerTiming(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		t := &requestTiming{start: start, last: start, durations: make(map[string]time.Duration)}
+		c.Set(timingContextKey, t)
+		c.Writer = &timingWriter{ResponseWriter: c.Writer, timing: t}
+		c.Next()
+	}
+}
+
+// timingWriter adds the Server-Timing header just before the response is written.
+type timingWriter struct {
+	gin.ResponseWriter
+	timing  *requestTiming
+	stamped bool
+}
+
+func (w *timingWriter) stamp() {
+	if !w.stamped && !w.Written() {
+		w.stamped = true
+		w.Header().Set("Server-Timing", w.timing.header())
+	}
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.stamp()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) WriteHeaderNow() {
+	w.stamp()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timingWriter) Write(data []byte) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timingWriter) WriteString(s string) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VersionInfo is the body of GET /version.
+type VersionInfo struct {
+	Version          string   `json:"version"`
+	Environment      string   `json:"environment,omitempty"`
+	InsecureLabMode  bool     `json:"insecureLabMode"`
+	InsecureSettings []string `json:"insecureSettings,omitempty"`
+}
+
+var versionInfo atomic.Pointer[VersionInfo]
+
+func init() {
+	versionInfo.Store(&VersionInfo{})
+}
+
+// InitVersion sets the build and security posture reported by GET /version.
+func InitVersion(info VersionInfo) {
+	versionInfo.Store(&info)
+}
+
+// HandleVersion handles GET /version — the build, the environment and whether the
+// replicator runs in insecure lab mode, so shared environments can be audited.
+func HandleVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, versionInfo.Load())
+}
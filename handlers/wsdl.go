@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// wsdlBaseURL is the replicator address written in the WSDL artifacts; it is
+// replaced with the address a WSDL was requested on.
+const wsdlBaseURL = "https://localhost:8443"
+
+// HandleWSDL returns the handler for GET /<endpoint>?wsdl, which serves the
+// artifact wsdl/<endpoint>.wsdl with its service and schema addresses pointing at
+// this replicator. The schemas it imports are served under /artifacts/wsdl/.
+func HandleWSDL(endpoint string) gin.HandlerFunc {
+	name := "wsdl/" + endpoint + ".wsdl"
+	return func(c *gin.Context) {
+		if !c.Request.URL.Query().Has("wsdl") {
+			c.String(http.StatusBadRequest, "GET /%s serves the WSDL at /%s?wsdl; SOAP requests use POST\n", endpoint, endpoint)
+			return
+		}
+
+		data, err := readArtifact(name)
+		if err != nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		data = bytes.ReplaceAll(data, []byte(wsdlBaseURL), []byte(xmlEscape(requestBaseURL(c))))
+		c.Data(http.StatusOK, artifactContentType(name), data)
+	}
+}
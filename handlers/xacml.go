@@ -5,22 +5,45 @@ import (
 	"log"
 	"net/http"
 	"strings"
-	"text/template"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"mitz-replicator/decision"
+	"mitz-replicator/matrix"
 	"mitz-replicator/parser"
+	"mitz-replicator/rules"
+	"mitz-replicator/storage"
 )
 
 // XACMLResult holds a single decision result for template rendering.
 type XACMLResult struct {
 	Decision  string
 	EventCode string
+
+	// Set when a backdated stored consent decided the result.
+	ConsentEffectiveFrom string
+	ConsentRegistered    string
+
+	// Set when the patient is marked deceased; DeceasedDate may be empty.
+	Deceased     bool
+	DeceasedDate string
+
+	Policies []XACMLPolicyReference // PolicyIdentifierList, if any
+}
+
+// XACMLPolicyReference is a PolicyIdReference or PolicySetIdReference of a result.
+type XACMLPolicyReference struct {
+	Element string // PolicyIdReference or PolicySetIdReference
+	ID      string
+	Version string
 }
 
 // XACMLResponseData is the template data for xacml_response.xml.
 type XACMLResponseData struct {
-	Results []XACMLResult
+	Addressing *WSAddressing
+	Results    []XACMLResult
 }
 
 // FaultData is the template data for SOAP fault responses.
@@ -29,111 +52,146 @@ type FaultData struct {
 	FaultSubcode string
 	FaultReason  string
 	FaultDetail  string
+	Addressing   *WSAddressing
 }
 
-var (
-	xacmlResponseTmpl *template.Template
-	xacmlFaultTmpl    *template.Template
-)
-
-// InitXACMLTemplates loads the XACML response templates.
-func InitXACMLTemplates(responseXML, faultXML string) {
-	xacmlResponseTmpl = template.Must(template.New("xacml_response").Parse(responseXML))
-	xacmlFaultTmpl = template.Must(template.New("xacml_fault").Parse(faultXML))
-}
-
-const soapContentType = "application/soap+xml; charset=utf-8"
-
 // HandleXACML handles POST /xacml — gesloten autorisatievraag.
 func HandleXACML(c *gin.Context) {
 	body, err := c.GetRawData()
 	if err != nil {
 		log.Printf("[XACML] Failed to read request body: %v", err)
-		c.Status(http.StatusBadRequest)
+		abortBodyError(c, err)
+		return
+	}
+	if !checkRequestStructure(c, "xacml", body) {
 		return
 	}
 
-	req, err := parser.ParseXACMLRequest(body)
+	req, err := parser.ParseXACMLRequestWith(body, strictnessFor(c))
+	markPhase(c, phaseParse)
 	if err != nil {
 		log.Printf("[XACML] Failed to parse request: %v", err)
-		c.Status(http.StatusBadRequest)
+		renderSoapParseFault(c, err)
 		return
 	}
 
-	requestID := c.GetHeader("X-Request-Id")
-	log.Printf("[XACML] RequestId=%s BSN=%s Categories=%v", requestID, req.BSN, req.Categories)
+	log.Printf("[XACML] %s BSN=%s Categories=%v", requestRef(c), req.BSN, req.Categories)
+	if !checkMaxCategories(c, req.Categories) {
+		return
+	}
 
-	// Route on BSN pattern
-	switch req.BSN {
-	case "000000005":
-		renderXACMLFault(c)
+	// Route on BSN / event code rules
+	outcome, _ := evaluateRules(c, rules.Request{Endpoint: rules.EndpointXACML, BSN: req.BSN, EventCodes: req.Categories})
+	if outcome.SoapFault != nil {
+		renderRuleSoapFault(c, lookupTemplate(c, "xacml_fault"), outcome.SoapFault)
 		return
 	}
 
-	// Build results based on BSN
-	results := buildXACMLResults(req.BSN, req.Categories)
+	results := buildXACMLResults(StoreFor(c), req.BSN, clientURA(c), req.Categories, outcome)
+	markPhase(c, phaseMatch)
 
 	var buf bytes.Buffer
-	if err := xacmlResponseTmpl.Execute(&buf, XACMLResponseData{Results: results}); err != nil {
+	if err := lookupTemplate(c, "xacml_response").Execute(&buf, XACMLResponseData{Addressing: addressingFor(c, xacmlResponseAction), Results: results}); err != nil {
 		log.Printf("[XACML] Template error: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	c.Data(http.StatusOK, soapContentType, buf.Bytes())
+	writeSignedSOAP(c, http.StatusOK, buf.Bytes())
+}
+
+// decisionMatrix holds the (BSN, category) → decision table from DECISION_MATRIX, if any.
+var decisionMatrix atomic.Pointer[matrix.Matrix]
+
+// InitDecisionMatrix sets the decision matrix consulted before rule outcomes. Nil disables it.
+func InitDecisionMatrix(m *matrix.Matrix) {
+	decisionMatrix.Store(m)
 }
 
-func buildXACMLResults(bsn string, categories []string) []XACMLResult {
+// decisionPlugin holds the organisation-specific decision logic from DECISION_PLUGIN, if any.
+var decisionPlugin atomic.Pointer[decision.Plugin]
+
+// InitDecisionPlugin sets the decision plugin consulted after stored consents. Nil disables it.
+func InitDecisionPlugin(p *decision.Plugin) {
+	decisionPlugin.Store(p)
+}
+
+// pluginDecision returns the decision plugin's verdict on one category, else current.
+// Plugin errors are logged and keep the replicator's decision.
+func pluginDecision(bsn, category, ura, current string) string {
+	p := decisionPlugin.Load()
+	if p == nil {
+		return current
+	}
+	req := decision.Request{BSN: bsn, Category: category, URA: ura, Decision: current}
+	d, ok, err := p.Decide(req)
+	if err != nil {
+		log.Printf("[PLUGIN] %s failed for BSN=%s category=%s, keeping %s: %v", p.Name(), req.BSN, req.Category, req.Decision, err)
+		return req.Decision
+	}
+	if !ok {
+		return req.Decision
+	}
+	return d
+}
+
+// buildXACMLResults returns one result per event code. Decisions come from the decision
+// matrix, else the rule outcome (the last one repeats; Permit without a rule), unless a
+// stored consent applies; the decision plugin has the final say. A patient marked
+// deceased is always refused.
+func buildXACMLResults(st storage.Store, bsn, ura string, categories []string, outcome rules.Outcome) []XACMLResult {
 	results := make([]XACMLResult, len(categories))
+	patient, isDeceased := deceasedPatient(bsn)
 
 	for i, cat := range categories {
-		var decision string
-
-		switch bsn {
-		case "000000001":
-			decision = "Permit"
-		case "000000002":
-			decision = "Deny"
-		case "000000003":
-			if i == 0 {
-				decision = "Permit"
-			} else {
-				decision = "Deny"
-			}
-		case "000000004":
-			decision = "Indeterminate"
-		default:
-			// 999* and anything else → all Permit
-			if strings.HasPrefix(bsn, "999") {
-				decision = "Permit"
-			} else {
-				decision = "Permit"
-			}
+		decision := "Permit"
+		if n := len(outcome.Decisions); n > 0 {
+			decision = outcome.Decisions[min(i, n-1)]
+		}
+
+		// Qualification test tables
+		if tabled, ok := decisionMatrix.Load().Decision(bsn, cat); ok {
+			decision = tabled
+		}
+
+		// Consents registered via Bundle or the consent-changed scenario take precedence
+		var consent storage.Consent
+		if stored, applied, ok := storedDecision(st, bsn, cat); ok {
+			decision, consent = stored, applied
+		}
+		decision = pluginDecision(bsn, cat, ura, decision)
+
+		// Register data quality noise: echo event codes in unexpected case
+		if outcome.UpperCaseEventCodes {
+			cat = strings.ToUpper(cat)
 		}
 
 		results[i] = XACMLResult{
 			Decision:  decision,
 			EventCode: cat,
 		}
+		if !consent.EffectiveFrom.IsZero() {
+			results[i].ConsentEffectiveFrom = consent.EffectiveFrom.Format(time.RFC3339)
+			results[i].ConsentRegistered = consent.Created.Format(time.RFC3339)
+		}
+		if isDeceased {
+			results[i] = XACMLResult{Decision: "Deny", EventCode: cat, Deceased: true, DeceasedDate: patient.DeceasedDate}
+		}
+		results[i].Policies = policyReferences(outcome.Policies)
 	}
 
 	return results
 }
 
-func renderXACMLFault(c *gin.Context) {
-	data := FaultData{
-		FaultCode:    "soap:Sender",
-		FaultSubcode: "mitz:InvalidRequest",
-		FaultReason:  "Patient BSN not found in register",
-		FaultDetail:  "The requested BSN is not known in the Mitz consent register",
-	}
-
-	var buf bytes.Buffer
-	if err := xacmlFaultTmpl.Execute(&buf, data); err != nil {
-		log.Printf("[XACML] Fault template error: %v", err)
-		c.Status(http.StatusInternalServerError)
-		return
+// policyReferences converts the policies of a rule outcome to template data.
+func policyReferences(policies []rules.Policy) []XACMLPolicyReference {
+	var refs []XACMLPolicyReference
+	for _, p := range policies {
+		element := "PolicyIdReference"
+		if p.Set {
+			element = "PolicySetIdReference"
+		}
+		refs = append(refs, XACMLPolicyReference{Element: element, ID: xmlEscape(p.ID), Version: xmlEscape(p.Version)})
 	}
-
-	c.Data(http.StatusOK, soapContentType, buf.Bytes())
+	return refs
 }
@@ -2,19 +2,18 @@ package handlers
 
 import (
 	"bytes"
-	"fmt"
 	"log"
 	"net/http"
-	"strings"
-	"text/template"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 
 	"mitz-replicator/parser"
+	"mitz-replicator/rules"
 )
 
+// detectedIssueSystem is the code system of the detected issues in XCPD responses.
+const detectedIssueSystem = "urn:mitz-replicator:detected-issue"
+
 // XCPDLocation represents a single location in the XCPD response.
 type XCPDLocation struct {
 	PatientID    string
@@ -25,23 +24,47 @@ type XCPDLocation struct {
 
 // XCPDFoundData is the template data for xcpd_found.xml.
 type XCPDFoundData struct {
+	Addressing   *WSAddressing
 	ResponseID   string
 	Timestamp    string
 	RequestedBSN string
 	Locations    []XCPDLocation
+	Warning      *XCPDWarning  // partial result, if any
+	Query        *XCPDQueryAck // paged answer, if any
+	Ack          *XCPDAck      // application acknowledgement of the request message
 }
 
-var (
-	xcpdFoundTmpl *template.Template
-	xcpdEmptyTmpl *template.Template
-	xcpdFaultTmpl *template.Template
-)
+// XCPDAck is the acknowledgement of an XCPD answer, referring to the request message.
+type XCPDAck struct {
+	TypeCode        string // AA when empty; AE rejects the request
+	TargetRoot      string
+	TargetExtension string
+	Detail          *XCPDAckDetail // why the request was rejected, if it was
+}
+
+// XCPDAckDetail is the acknowledgementDetail of a rejected XCPD request.
+type XCPDAckDetail struct {
+	Code   string
+	System string
+	Text   string
+}
+
+// XCPDWarning is a warning-level detected issue returned alongside the locations.
+type XCPDWarning struct {
+	Code   string
+	System string
+	Text   string
+}
 
-// InitXCPDTemplates loads the XCPD response templates.
-func InitXCPDTemplates(foundXML, emptyXML, faultXML string) {
-	xcpdFoundTmpl = template.Must(template.New("xcpd_found").Parse(foundXML))
-	xcpdEmptyTmpl = template.Must(template.New("xcpd_empty").Parse(emptyXML))
-	xcpdFaultTmpl = template.Must(template.New("xcpd_fault").Parse(faultXML))
+// XCPDEmptyData is the template data for xcpd_empty.xml.
+type XCPDEmptyData struct {
+	Addressing *WSAddressing
+	Ack        *XCPDAck // application acknowledgement of the request message
+
+	// Detected issue explaining why no locations are returned, if any.
+	IssueCode   string
+	IssueSystem string
+	IssueText   string
 }
 
 // HandleXCPD handles POST /xcpd — open autorisatievraag.
@@ -49,118 +72,209 @@ func HandleXCPD(c *gin.Context) {
 	body, err := c.GetRawData()
 	if err != nil {
 		log.Printf("[XCPD] Failed to read request body: %v", err)
-		c.Status(http.StatusBadRequest)
+		abortBodyError(c, err)
+		return
+	}
+	if !checkRequestStructure(c, "xcpd", body) {
 		return
 	}
 
-	req, err := parser.ParseXCPDRequest(body)
+	if parser.IsXCPDContinuation(body) {
+		handleXCPDContinuation(c, body)
+		return
+	}
+
+	req, err := parser.ParseXCPDRequestWith(body, strictnessFor(c))
+	markPhase(c, phaseParse)
 	if err != nil {
 		log.Printf("[XCPD] Failed to parse request: %v", err)
-		c.Status(http.StatusBadRequest)
+		renderSoapParseFault(c, err)
 		return
 	}
 
-	requestID := c.GetHeader("X-Request-Id")
-	log.Printf("[XCPD] RequestId=%s BSN=%s SenderOrg=%s", requestID, req.BSN, req.SenderOrg)
+	log.Printf("[XCPD] %s BSN=%s SenderOrg=%s AcceptAckCode=%s", requestRef(c), req.BSN, req.SenderOrg, req.AcceptAckCode)
+	if req.RespondTo != "" {
+		log.Printf("[XCPD] BSN=%s deferred: answer goes to %s", req.BSN, req.RespondTo)
+		deferXCPD(c, req)
+	}
 
-	switch req.BSN {
-	case "000000001":
-		renderXCPDFound(c, req.BSN, twoLocationsMultipleEvents())
-	case "000000002":
-		renderXCPDFound(c, req.BSN, oneLocationOneEvent())
-	case "000000003":
-		renderXCPDEmpty(c)
-	case "000000004", "000000005":
-		renderXCPDFault(c)
-	default:
-		if strings.HasPrefix(req.BSN, "999") {
-			renderXCPDFound(c, req.BSN, defaultLocation())
-		} else {
-			renderXCPDFound(c, req.BSN, defaultLocation())
+	// Onboarding: only registered sender and receiver devices
+	if !checkXCPDDevices(c, req) {
+		return
+	}
+
+	// Route on BSN / sender rules
+	outcome, _ := evaluateRules(c, rules.Request{Endpoint: rules.EndpointXCPD, BSN: req.BSN, URA: req.SenderOrg})
+	if outcome.SoapFault != nil {
+		renderRuleSoapFault(c, lookupTemplate(c, "xcpd_fault"), outcome.SoapFault)
+		return
+	}
+	if d := deferredXCPD(c); d != nil {
+		d.withholdAck = outcome.WithholdAck
+	} else if outcome.WithholdAck {
+		log.Printf("[XCPD] BSN=%s synchronous query: no accept acknowledgement to withhold", req.BSN)
+	}
+
+	// Deceased patients: refuse with no locations and say why
+	if patient, ok := deceasedPatient(req.BSN); ok {
+		log.Printf("[XCPD] BSN=%s is deceased — no locations returned", req.BSN)
+		text := "Patient is deceased"
+		if patient.DeceasedDate != "" {
+			text += " (" + patient.DeceasedDate + ")"
 		}
+		renderXCPDEmpty(c, XCPDEmptyData{Ack: xcpdAck(req), IssueCode: "PatientDeceased", IssueSystem: detectedIssueSystem, IssueText: text})
+		return
+	}
+
+	locations := xcpdLocations(req.BSN, outcome)
+	if outcome.Locations == "empty" || len(locations) == 0 {
+		renderXCPDEmpty(c, XCPDEmptyData{Ack: xcpdAck(req)})
+		return
+	}
+	renderXCPDFound(c, req, locations, xcpdWarning(outcome.Warning))
+}
+
+// xcpdAck returns the application acknowledgement (AA) of a successful answer to
+// req. ITI-55 requires it in every answer, whatever the acceptAckCode; that code
+// only governs the accept acknowledgement of deferred queries (see writeXCPDAnswer).
+func xcpdAck(req *parser.XCPDRequest) *XCPDAck {
+	return &XCPDAck{TargetRoot: xmlEscape(req.MessageRoot), TargetExtension: xmlEscape(req.MessageExtension)}
+}
+
+// xcpdWarning converts a rule warning to template data, or returns nil.
+func xcpdWarning(w *rules.Warning) *XCPDWarning {
+	if w == nil {
+		return nil
+	}
+	code := w.Code
+	if code == "" {
+		code = "PartialResult"
+	}
+	return &XCPDWarning{Code: xmlEscape(code), System: detectedIssueSystem, Text: xmlEscape(w.Text)}
+}
+
+// xcpdLocationSet returns the named location set (see rules.LocationSets).
+func xcpdLocationSet(name string) []XCPDLocation {
+	switch name {
+	case "two-locations":
+		return twoLocationsMultipleEvents()
+	case "one-location":
+		return oneLocationOneEvent()
+	case "untrimmed-custodians":
+		return untrimmedCustodianLocations()
+	case "duplicated":
+		return duplicatedLocations()
+	case "mixed-case":
+		return mixedCaseEventCodeLocations()
+	default:
+		return defaultLocation()
 	}
 }
 
 func twoLocationsMultipleEvents() []XCPDLocation {
+	first, firstAuthority := fixedCustodian(0, "1.2.3.4.5.6.7")
+	second, secondAuthority := fixedCustodian(1, "")
 	return []XCPDLocation{
 		{
 			PatientID:    "123456789",
-			SourceID:     "1.2.3.4.5.6.7",
-			CustodianOID: "urn:oid:2.16.840.1.113883.2.4.6.6",
+			SourceID:     firstAuthority,
+			CustodianOID: first,
 			EventCodes:   []string{"huisartsgegevens", "medicatiegegevens"},
 		},
 		{
 			PatientID:    "987654321",
-			CustodianOID: "urn:oid:2.16.840.1.113883.2.4.3.11",
+			SourceID:     secondAuthority,
+			CustodianOID: second,
 			EventCodes:   []string{"medicatiegegevens"},
 		},
 	}
 }
 
 func oneLocationOneEvent() []XCPDLocation {
+	custodian, _ := fixedCustodian(0, "")
 	return []XCPDLocation{
 		{
 			PatientID:    "111222333",
-			CustodianOID: "urn:oid:2.16.840.1.113883.2.4.6.6",
+			CustodianOID: custodian,
 			EventCodes:   []string{"huisartsgegevens"},
 		},
 	}
 }
 
 func defaultLocation() []XCPDLocation {
+	custodian, authority := fixedCustodian(0, "1.2.3.4.5.6.8")
 	return []XCPDLocation{
 		{
 			PatientID:    "555666777",
-			SourceID:     "1.2.3.4.5.6.8",
-			CustodianOID: "urn:oid:2.16.840.1.113883.2.4.6.6",
+			SourceID:     authority,
+			CustodianOID: custodian,
 			EventCodes:   []string{"huisartsgegevens", "medicatiegegevens"},
 		},
 	}
 }
 
-func renderXCPDFound(c *gin.Context, bsn string, locations []XCPDLocation) {
-	data := XCPDFoundData{
-		ResponseID:   uuid.New().String(),
-		Timestamp:    time.Now().Format("20060102150405"),
-		RequestedBSN: bsn,
-		Locations:    locations,
-	}
+// --- Register data quality noise (BSN 000000006–000000008) ---
 
-	var buf bytes.Buffer
-	if err := xcpdFoundTmpl.Execute(&buf, data); err != nil {
-		log.Printf("[XCPD] Template error: %v", err)
-		c.Status(http.StatusInternalServerError)
-		return
+// untrimmedCustodianLocations returns custodian OIDs with leading/trailing whitespace.
+func untrimmedCustodianLocations() []XCPDLocation {
+	locations := twoLocationsMultipleEvents()
+	locations[0].CustodianOID = locations[0].CustodianOID + "  "
+	locations[1].CustodianOID = "\t" + locations[1].CustodianOID + " "
+	return locations
+}
+
+// duplicatedLocations returns the same location twice, as seen when a source is registered twice.
+func duplicatedLocations() []XCPDLocation {
+	locations := defaultLocation()
+	return append(locations, locations[0])
+}
+
+// mixedCaseEventCodeLocations returns event codes in unexpected letter case.
+func mixedCaseEventCodeLocations() []XCPDLocation {
+	locations := twoLocationsMultipleEvents()
+	locations[0].EventCodes = []string{"HUISARTSGEGEVENS", "Medicatiegegevens"}
+	locations[1].EventCodes = []string{"medicatieGegevens"}
+	return locations
+}
+
+func renderXCPDFound(c *gin.Context, req *parser.XCPDRequest, locations []XCPDLocation, warning *XCPDWarning) {
+	bsn := req.BSN
+	data := XCPDFoundData{RequestedBSN: bsn, Warning: warning, Ack: xcpdAck(req)}
+	data.Locations, data.Query = pageXCPDLocations(c, req, locations)
+	if warning != nil {
+		log.Printf("[XCPD] BSN=%s partial result: %d location(s) with warning %s", bsn, len(locations), warning.Code)
+	}
+	if data.Query != nil {
+		log.Printf("[XCPD] BSN=%s paged: %d of %d location(s), query %s", bsn, data.Query.Current, data.Query.Total, data.Query.QueryRoot)
 	}
 
-	c.Data(http.StatusOK, soapContentType, buf.Bytes())
+	writeXCPDFound(c, data)
 }
 
-func renderXCPDEmpty(c *gin.Context) {
+// writeXCPDFound renders xcpd_found with a new response ID and timestamp.
+func writeXCPDFound(c *gin.Context, data XCPDFoundData) {
+	data.Addressing = xcpdAddressing(c)
+	data.ResponseID = newIDFor(c)
+	data.Timestamp = now().Format("20060102150405")
+
 	var buf bytes.Buffer
-	if err := xcpdEmptyTmpl.Execute(&buf, nil); err != nil {
-		log.Printf("[XCPD] Empty template error: %v", err)
+	if err := lookupTemplate(c, "xcpd_found").Execute(&buf, data); err != nil {
+		log.Printf("[XCPD] Template error: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	c.Data(http.StatusOK, soapContentType, buf.Bytes())
+	writeXCPDAnswer(c, buf.Bytes())
 }
 
-func renderXCPDFault(c *gin.Context) {
-	data := FaultData{
-		FaultCode:    "soap:Sender",
-		FaultSubcode: "mitz:InvalidRequest",
-		FaultReason:  "Patient BSN not found in register",
-		FaultDetail:  fmt.Sprintf("RequestId: %s", c.GetHeader("X-Request-Id")),
-	}
-
+func renderXCPDEmpty(c *gin.Context, data XCPDEmptyData) {
+	data.Addressing = xcpdAddressing(c)
 	var buf bytes.Buffer
-	if err := xcpdFaultTmpl.Execute(&buf, data); err != nil {
-		log.Printf("[XCPD] Fault template error: %v", err)
+	if err := lookupTemplate(c, "xcpd_empty").Execute(&buf, data); err != nil {
+		log.Printf("[XCPD] Empty template error: %v", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	c.Data(http.StatusOK, soapContentType, buf.Bytes())
+	writeXCPDAnswer(c, buf.Bytes())
 }
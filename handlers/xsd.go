@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"mitz-replicator/xsd"
+)
+
+// requestStructureFiles are the artifacts describing the request structure of each
+// SOAP endpoint: the SOAP 1.2 and 1.1 envelopes and the Body payload. They are written
+// in XSD, but are the replicator's own trimmed definitions, not the published OASIS
+// and HL7 v3 schemas, so a request that matches them may still be schema-invalid.
+var requestStructureFiles = map[string][]string{
+	"xacml": {"wsdl/soap-envelope.xsd", "wsdl/soap11-envelope.xsd", "wsdl/xacml-samlp.xsd"},
+	"xcpd":  {"wsdl/soap-envelope.xsd", "wsdl/soap11-envelope.xsd", "wsdl/hl7v3-xcpd.xsd"},
+}
+
+// requestStructures holds the loaded request structure per endpoint.
+var requestStructures atomic.Pointer[map[string]*xsd.Schema]
+
+// InitRequestStructures loads the request structures from the artifacts, so an XSD
+// in ARTIFACTS_DIR replaces the embedded one. Call after InitArtifacts.
+func InitRequestStructures() error {
+	structures := map[string]*xsd.Schema{}
+	for endpoint, files := range requestStructureFiles {
+		s := xsd.New()
+		for _, name := range files {
+			if err := s.Load(artifactFS{}, name); err != nil {
+				return fmt.Errorf("%s request structure: %w", endpoint, err)
+			}
+		}
+		structures[endpoint] = s
+	}
+	requestStructures.Store(&structures)
+	return nil
+}
+
+// artifactFS is the artifact sources as one file system, earlier sources first.
+type artifactFS struct{}
+
+func (artifactFS) Open(name string) (fs.File, error) {
+	for _, src := range artifactSources {
+		f, err := src.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return f, err
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// checkRequestStructure checks a SOAP request body against the endpoint's request
+// structure in strict validation mode (VALIDATION_MODE=strict). A mismatching request
+// gets a 400 Sender fault listing the violations and false is returned. Bodies that
+// are not well-formed are left to the parser, which reports them with its own fault.
+func checkRequestStructure(c *gin.Context, endpoint string, body []byte) bool {
+	structures := requestStructures.Load()
+	if structures == nil || !strictnessFor(c).Required {
+		return true
+	}
+
+	violations, err := (*structures)[endpoint].Validate(body)
+	if err != nil || len(violations) == 0 {
+		return true
+	}
+
+	lines := make([]string, len(violations))
+	for i, v := range violations {
+		lines[i] = v.String()
+	}
+	log.Printf("[STRUCTURE] %s %s request has %d structure violation(s): %s", requestRef(c), strings.ToUpper(endpoint), len(violations), lines[0])
+	renderSoapFaultWith(c, lookupTemplate(c, endpoint+"_fault"), http.StatusBadRequest, FaultData{
+		FaultCode:    "soap:Sender",
+		FaultSubcode: "mitz:InvalidRequest",
+		FaultReason:  fmt.Sprintf("Request does not match the %s request structure: %d violation(s), first: %s", strings.ToUpper(endpoint), len(violations), lines[0]),
+		FaultDetail:  "RequestId: " + c.GetHeader("X-Request-Id") + "\n" + strings.Join(lines, "\n"),
+	})
+	return false
+}
@@ -0,0 +1,189 @@
+// Package identity resolves the organisation (URA) behind a client certificate.
+// UZI server certificates carry the URA in their subjectAltName; other
+// certificates (self-signed development certs) are looked up in a mapping table
+// by SHA-256 fingerprint or subject DN.
+package identity
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Sources of a resolved identity.
+const (
+	SourceUZI         = "uzi"
+	SourceFingerprint = "fingerprint"
+	SourceSubject     = "subject"
+)
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidUZIOtherName   = asn1.ObjectIdentifier{2, 5, 5, 5}
+)
+
+// Mapping assigns a URA to certificates matching Fingerprint or Subject.
+type Mapping struct {
+	Fingerprint  string `yaml:"fingerprint" json:"fingerprint,omitempty"` // SHA-256 of the DER certificate, hex (colons optional)
+	Subject      string `yaml:"subject" json:"subject,omitempty"`         // subject DN, e.g. "CN=dev-client,O=Example,C=NL"
+	URA          string `yaml:"ura" json:"ura"`
+	Organization string `yaml:"organization" json:"organization,omitempty"`
+}
+
+// Identity is the organisation a client certificate resolved to.
+type Identity struct {
+	URA          string `json:"ura"`
+	Organization string `json:"organization,omitempty"`
+	Source       string `json:"source"`
+	Fingerprint  string `json:"fingerprint"`
+	Subject      string `json:"subject"`
+}
+
+// Resolver maps client certificates to identities.
+type Resolver struct {
+	byFingerprint map[string]Mapping
+	bySubject     map[string]Mapping
+}
+
+// New returns a resolver for mappings.
+func New(mappings []Mapping) *Resolver {
+	r := &Resolver{byFingerprint: map[string]Mapping{}, bySubject: map[string]Mapping{}}
+	for _, m := range mappings {
+		if m.Fingerprint != "" {
+			r.byFingerprint[normalizeFingerprint(m.Fingerprint)] = m
+		}
+		if m.Subject != "" {
+			r.bySubject[normalizeSubject(m.Subject)] = m
+		}
+	}
+	return r
+}
+
+// Resolve returns the identity for cert. UZI attributes win over the mapping
+// table; within the table a fingerprint match wins over a subject match.
+func (r *Resolver) Resolve(cert *x509.Certificate) (Identity, bool) {
+	id := Identity{Fingerprint: Fingerprint(cert), Subject: cert.Subject.String()}
+
+	if ura, ok := uziURA(cert); ok {
+		id.URA, id.Organization, id.Source = ura, firstOf(cert.Subject.Organization), SourceUZI
+		return id, true
+	}
+	if m, ok := r.byFingerprint[id.Fingerprint]; ok {
+		id.URA, id.Organization, id.Source = m.URA, m.Organization, SourceFingerprint
+		return id, true
+	}
+	if m, ok := r.bySubject[normalizeSubject(id.Subject)]; ok {
+		id.URA, id.Organization, id.Source = m.URA, m.Organization, SourceSubject
+		return id, true
+	}
+	return id, false
+}
+
+// Fingerprint returns the lower-case hex SHA-256 fingerprint of cert.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Validate checks that every mapping names a URA and at least one certificate selector.
+func Validate(mappings []Mapping) error {
+	for i, m := range mappings {
+		if m.Fingerprint == "" && m.Subject == "" {
+			return fmt.Errorf("mapping #%d: fingerprint or subject is required", i+1)
+		}
+		if m.URA == "" {
+			return fmt.Errorf("mapping #%d: ura is required", i+1)
+		}
+		if m.Fingerprint != "" {
+			if fp := normalizeFingerprint(m.Fingerprint); len(fp) != sha256.Size*2 || strings.Trim(fp, "0123456789abcdef") != "" {
+				return fmt.Errorf("mapping #%d: fingerprint must be a hex SHA-256 digest", i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// uziURA extracts the URA (abonneenummer) from the UZI otherName in the
+// subjectAltName: "<oid>-<version>-<uzi>-<card type>-<ura>-<role>-<agb>".
+func uziURA(cert *x509.Certificate) (string, bool) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+
+		var names asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return "", false
+		}
+		for rest := names.Bytes; len(rest) > 0; {
+			var name asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+				return "", false
+			}
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+
+			var other struct {
+				TypeID asn1.ObjectIdentifier
+				Value  asn1.RawValue `asn1:"explicit,tag:0"`
+			}
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &other, "tag:0"); err != nil || !other.TypeID.Equal(oidUZIOtherName) {
+				continue
+			}
+			fields := strings.Split(string(other.Value.Bytes), "-")
+			if len(fields) >= 5 && fields[4] != "" {
+				return fields[4], true
+			}
+		}
+	}
+	return "", false
+}
+
+// UZISubjectAltName returns a subjectAltName extension carrying ura in a UZI
+// otherName, as read back by Resolve.
+func UZISubjectAltName(ura string) (pkix.Extension, error) {
+	value, err := asn1.MarshalWithParams("2.16.528.1.1003.1.3.5.5.2-1-00000000-S-"+ura+"-00.000-00000000", "ia5")
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	name, err := asn1.MarshalWithParams(struct {
+		TypeID asn1.ObjectIdentifier
+		Value  asn1.RawValue
+	}{oidUZIOtherName, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value}}, "tag:0")
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	names, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: name})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: names}, nil
+}
+
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(fp)), "sha256:")
+	return strings.NewReplacer(":", "", " ", "").Replace(fp)
+}
+
+// normalizeSubject compares DNs case-insensitively, ignoring spaces around separators.
+func normalizeSubject(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		key, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+		parts[i] = strings.ToUpper(strings.TrimSpace(key)) + "=" + strings.ToLower(strings.TrimSpace(value))
+	}
+	return strings.Join(parts, ",")
+}
+
+func firstOf(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
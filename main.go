@@ -1,56 +1,101 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"embed"
+	"flag"
+	"fmt"
+	"io/fs"
 	"log"
+	"maps"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"mitz-replicator/anomaly"
 	"mitz-replicator/auth"
+	"mitz-replicator/bsnpool"
+	"mitz-replicator/config"
+	"mitz-replicator/decision"
 	"mitz-replicator/handlers"
+	"mitz-replicator/identity"
+	"mitz-replicator/matrix"
+	"mitz-replicator/monitoring"
+	"mitz-replicator/notify"
+	"mitz-replicator/outbound"
+	"mitz-replicator/parser"
+	"mitz-replicator/provider"
+	"mitz-replicator/ratelimit"
+	"mitz-replicator/registry"
+	"mitz-replicator/rules"
+	"mitz-replicator/storage"
+	"mitz-replicator/supervisor"
 )
 
-//go:embed templates/*.xml
+//go:embed templates templates/_*.xml
 var templateFS embed.FS
 
+//go:embed artifacts
+var artifactFS embed.FS
+
+// shutdownTimeout bounds how long SIGTERM waits for in-flight requests.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	port := getEnv("PORT", "8443")
-	serverCert := getEnv("SERVER_CERT", "certs/server.crt")
-	serverKey := getEnv("SERVER_KEY", "certs/server.key")
-	caCert := getEnv("CA_CERT", "certs/ca.crt")
-	mtlsEnabled := getEnv("MTLS_ENABLED", "false")
-
-	// SAML validation config
-	samlEnabled := getEnv("SAML_VALIDATION_ENABLED", "false") == "true"
-	samlCertPath := getEnv("SAML_SIGNING_CERT", "certs/client.crt")
-	samlExpectedIssuer := getEnv("SAML_EXPECTED_ISSUER", "")
-	samlClockSkewSec, _ := strconv.Atoi(getEnv("SAML_CLOCK_SKEW_SECONDS", "5"))
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "supervise" {
+		os.Exit(superviseCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "monitoring" {
+		os.Exit(monitoringCommand(os.Args[2:]))
+	}
+
+	// Configuration: optional YAML/JSON file, overridden by environment variables
+	configFile := os.Getenv("CONFIG_FILE")
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	if configFile != "" {
+		log.Printf("Configuration loaded from %s", configFile)
+	}
+	logSecurityPosture(cfg)
 
 	var samlValidator *auth.SamlValidator
-	if samlEnabled {
-		certPEM, err := os.ReadFile(samlCertPath)
+	if cfg.SAML.Enabled {
+		certPEM, err := os.ReadFile(cfg.SAML.SigningCert)
 		if err != nil {
-			log.Fatalf("Failed to read SAML signing certificate %s: %v", samlCertPath, err)
+			log.Fatalf("Failed to read SAML signing certificate %s: %v", cfg.SAML.SigningCert, err)
 		}
 
 		samlValidator, err = auth.NewSamlValidator(auth.SamlValidatorConfig{
 			Enabled:        true,
 			SigningCert:    certPEM,
-			ExpectedIssuer: samlExpectedIssuer,
-			ClockSkew:      time.Duration(samlClockSkewSec) * time.Second,
+			ExpectedIssuer: cfg.SAML.ExpectedIssuer,
+			ClockSkew:      time.Duration(cfg.SAML.ClockSkewSeconds) * time.Second,
 		})
 		if err != nil {
 			log.Fatalf("Failed to create SAML validator: %v", err)
 		}
 
 		log.Printf("SAML validation enabled — cert=%s issuer=%q clockSkew=%ds",
-			samlCertPath, samlExpectedIssuer, samlClockSkewSec)
+			cfg.SAML.SigningCert, cfg.SAML.ExpectedIssuer, cfg.SAML.ClockSkewSeconds)
 	} else {
 		samlValidator, _ = auth.NewSamlValidator(auth.SamlValidatorConfig{Enabled: false})
 		log.Println("SAML validation disabled — FHIR endpoints accept any Authorization header")
@@ -58,35 +103,328 @@ func main() {
 
 	handlers.InitSamlValidator(samlValidator)
 
+	if cfg.WSSecurity.Enabled {
+		trusted, err := os.ReadFile(cfg.WSSecurity.TrustedCerts)
+		if err != nil {
+			log.Fatalf("Failed to read WS-Security trusted certificates %s: %v", cfg.WSSecurity.TrustedCerts, err)
+		}
+		wssValidator, err := auth.NewWSSecurityValidator(auth.WSSecurityConfig{
+			Enabled:      true,
+			TrustedCerts: trusted,
+			ClockSkew:    time.Duration(cfg.WSSecurity.ClockSkewSeconds) * time.Second,
+			SAML:         samlValidator,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create WS-Security validator: %v", err)
+		}
+		handlers.InitWSSecurity(wssValidator)
+		log.Printf("WS-Security validation enabled on /xacml and /xcpd — trusted=%s clockSkew=%ds",
+			cfg.WSSecurity.TrustedCerts, cfg.WSSecurity.ClockSkewSeconds)
+	}
+
+	// State store (subscriptions, consents, captured requests)
+	storeDriver, storeDSN := cfg.Store.Driver, cfg.Store.DSN
+	retention := storage.Retention{Requests: cfg.Store.MaxRequests, Events: cfg.Store.MaxEvents}
+	store, err := storage.Open(storeDriver, storeDSN, retention)
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", storeDriver, err)
+	}
+	defer store.Close()
+	log.Printf("State store: %s", storeDriver)
+
+	// Per-session stores for requests carrying X-Test-Session
+	var openSession func(session string) (storage.Store, error)
+	if cfg.Store.SessionIsolation {
+		openSession = func(session string) (storage.Store, error) {
+			return storage.Open(storeDriver, sessionDSN(storeDSN, session), retention)
+		}
+	}
+	handlers.InitStores(store, openSession, cfg.Store.SessionIdle())
+	defer handlers.CloseSessions()
+
+	// Scenario settings (quota, routing rules, magic BSNs, identities, parsing, Content-Types, decision matrix); reloadable
+	if err := applyScenarioConfig(cfg); err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	handlers.InitReload(func() error { return reloadConfig(configFile, cfg) })
+	go reloadOnSIGHUP(configFile, cfg)
+
 	// Load embedded templates
-	initTemplates()
+	initTemplates(cfg.Templates)
+	initArtifacts(cfg.Artifacts)
+	if err := handlers.InitRequestStructures(); err != nil {
+		log.Fatalf("Failed to load request structures: %v", err)
+	}
+	initSigning(cfg.Signing, cfg.Server)
+	initCertificateAuthority(cfg.Server)
+	initNotifications(cfg.Notifications, cfg.Outbound)
+	initXCPDDeferred(cfg.Outbound)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	registryDone := initRegistry(ctx, cfg, store)
+
+	// Synthetic BSN pools for test-run reservations
+	pools, err := bsnpool.New(cfg.BSNPools.Pools)
+	if err != nil {
+		log.Fatalf("BSN pools: %v", err)
+	}
+	handlers.InitBSNPools(pools, time.Duration(cfg.BSNPools.TTLSeconds)*time.Second)
+	if len(cfg.BSNPools.Pools) > 0 {
+		log.Printf("BSN pools: %d configured next to the default 999 range", len(cfg.BSNPools.Pools))
+	}
+
+	// Abuse-case scenario: BSNs of deceased patients
+	handlers.InitDeceased(cfg.DeceasedBSNs)
+	if len(cfg.DeceasedBSNs) > 0 {
+		log.Printf("Deceased patients: %d BSNs marked", len(cfg.DeceasedBSNs))
+	}
+
+	// Organisation-specific decision logic; Go plugins cannot be unloaded, so not reloadable
+	if cfg.Decisions.Plugin != "" {
+		p, err := decision.Load(cfg.Decisions.Plugin)
+		if err != nil {
+			log.Fatalf("Failed to load decision plugin (DECISION_PLUGIN): %v", err)
+		}
+		handlers.InitDecisionPlugin(p)
+		log.Printf("Decision plugin: %s has the final say on XACML decisions (deceased patients excepted)", cfg.Decisions.Plugin)
+	}
+
+	// Per-endpoint worker pool simulation
+	concurrencyLimits := cfg.Concurrency.Limits
+	concurrencyReject := cfg.Concurrency.Mode == "reject"
+	queueTimeoutMs := cfg.Concurrency.QueueTimeoutMs
+	concurrency := func(endpoint string) gin.HandlerFunc {
+		return handlers.ConcurrencyLimit(endpoint, handlers.ConcurrencyConfig{
+			Limit:        concurrencyLimits[endpoint],
+			Reject:       concurrencyReject,
+			QueueTimeout: time.Duration(queueTimeoutMs) * time.Millisecond,
+		})
+	}
+	if len(concurrencyLimits) > 0 {
+		log.Printf("Concurrency limits: %v (reject=%t, queueTimeout=%dms)", concurrencyLimits, concurrencyReject, queueTimeoutMs)
+	}
+
+	// Time-of-day windows (e.g. nightly batch window)
+	scheduleWindows, err := handlers.ParseScheduleWindows(strings.Join(cfg.Schedule.Windows, ","))
+	if err != nil {
+		log.Fatalf("Invalid schedule windows (SCHEDULE_WINDOWS): %v", err)
+	}
+	scheduleLoc := time.Local
+	if tz := cfg.Schedule.Timezone; tz != "" {
+		if scheduleLoc, err = time.LoadLocation(tz); err != nil {
+			log.Fatalf("Invalid SCHEDULE_TIMEZONE %q: %v", tz, err)
+		}
+	}
+	if len(scheduleWindows) > 0 {
+		log.Printf("Schedule windows: %d configured (timezone %s)", len(scheduleWindows), scheduleLoc)
+	}
+
+	// Reproducible resource IDs and timestamps for golden-file tests
+	if cfg.DeterministicSeed != "" {
+		seed, _ := strconv.ParseUint(cfg.DeterministicSeed, 10, 64)
+		handlers.InitDeterministic(&seed)
+		log.Printf("Deterministic mode: IDs and timestamps seeded with %d (restart the sequence with POST /admin/reset)", seed)
+	}
+
+	// Per-client request baselines (size, element count, header set)
+	if cfg.Anomalies.Enabled {
+		handlers.InitAnomalies(anomaly.New(cfg.Anomalies.Warmup, float64(cfg.Anomalies.Threshold)))
+		log.Printf("Anomaly detection: flagging deviations beyond %d standard deviations after %d requests per client",
+			cfg.Anomalies.Threshold, cfg.Anomalies.Warmup)
+	}
+
+	headerHygieneStrict := cfg.HeaderHygiene == "strict"
+	if headerHygieneStrict {
+		log.Println("Strict header hygiene enabled — malformed transport headers are rejected")
+	}
+
+	handlers.InitSOAPActions(cfg.SOAPActions == "strict")
+	if cfg.SOAPActions == "lenient" {
+		log.Println("Lenient SOAP actions — unexpected SOAPAction/action/wsa:Action values are logged, not rejected")
+	}
+	handlers.InitContentTypeCheck(cfg.RequestTypes == "strict")
+	if cfg.RequestTypes == "strict" {
+		log.Println("Content-Type check enabled — requests the endpoint cannot parse are rejected with 415")
+	}
+
+	handlers.InitExport(time.Duration(cfg.ExportDelay) * time.Second)
+	if cfg.ExportDelay > 0 {
+		log.Printf("Bulk $export files ready %ds after kick-off", cfg.ExportDelay)
+	}
+	handlers.InitMaxCategories(cfg.MaxCategories)
+	handlers.InitCompression(cfg.MaxInflatedMB)
+	if cfg.MaxCategories > 0 {
+		log.Printf("XACML requests limited to %d categories", cfg.MaxCategories)
+	}
+
+	handlers.InitCutover(cfg.CutoverPhase)
+	if cfg.CutoverPhase != handlers.CutoverOff {
+		log.Printf("Migration cutover phase %d — switch phases via /admin/cutover", cfg.CutoverPhase)
+	}
+
+	handlers.InitFhirVersion(cfg.FHIRVersion, buildVersion())
+	if cfg.FHIRVersion != "R4" {
+		log.Printf("FHIR version %s — Subscriptions must be topic-based", cfg.FHIRVersion)
+	}
+
+	testcases, err := handlers.InitTestcases(cfg.OTVTestcases == "enforce")
+	if err != nil {
+		log.Fatalf("Failed to load the OTV test case catalogue: %v", err)
+	}
+	if cfg.OTVTestcases == "enforce" {
+		log.Printf("Test case enforcement enabled — requests must match their X-OTV-Testcase (%d test cases)", testcases)
+	}
+
+	if cfg.ReadOnly {
+		log.Println("Read-only mode — write operations are rejected, queries still work")
+	}
+
+	if cfg.Admin.Token != "" {
+		log.Println("Admin API protected — /admin requires the ADMIN_TOKEN bearer token")
+	}
+	if cfg.Admin.Pprof && cfg.Admin.Token == "" {
+		log.Println("WARNING: pprof enabled without ADMIN_TOKEN — profiles are readable by any client")
+	}
+	if cfg.Server.CAKey != "" {
+		log.Println("WARNING: certificate issuing enabled (CA_KEY) — admin clients can obtain client certificates trusted for mTLS and WS-Security")
+	}
+	if cfg.Debug.Timing {
+		log.Println("Debug timing enabled — responses carry a Server-Timing header")
+	}
+
+	proxies, _ := cfg.Server.ProxyPrefixes() // checked by Validate
+	handlers.InitTrustedProxies(proxies)
+	if len(proxies) > 0 {
+		log.Printf("Trusted proxies: %s — their X-Forwarded-* headers set client IP and base URLs", strings.Join(cfg.Server.TrustedProxies, ", "))
+	}
 
 	// Configure Gin
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	router.Use(requestLogger())
+	router.Use(handlers.ServerTiming(cfg.Debug.Timing))
+	router.Use(handlers.SessionScope())
+	router.Use(handlers.TestSeed())
+	router.Use(handlers.MitzVersion())
+	router.Use(handlers.ClientIdentity())
+	router.Use(handlers.SOAPVersion())
+	router.Use(handlers.Compression())
+	router.Use(requestRecorder())
+	router.Use(handlers.OTVTestcase())
+	router.Use(handlers.RequestProfile())
+	router.Use(handlers.HeaderHygiene(headerHygieneStrict))
+	router.Use(handlers.ScheduleMiddleware(scheduleWindows, scheduleLoc))
+	router.Use(handlers.Maintenance())
+	router.Use(handlers.Cutover())
+	router.Use(handlers.ReadOnly(cfg.ReadOnly))
 
 	// SOAP endpoints
 	router.HEAD("/xacml", handlers.HealthCheck)
-	router.POST("/xacml", handlers.HandleXACML)
-	router.POST("/xcpd", handlers.HandleXCPD)
+	router.GET("/xacml", handlers.HandleWSDL("xacml"))
+	router.POST("/xacml", handlers.LatencyBudget("xacml"), handlers.SOAPEnvelope(), handlers.RequestContentType("soap"), handlers.SOAPAction("xacml"), handlers.RateLimit("xacml"), handlers.WSSecurity(), concurrency("xacml"), handlers.Latency("xacml"), handlers.Stream("xacml"), handlers.Chaos("xacml"), handlers.HandleXACML)
+	router.GET("/xcpd", handlers.HandleWSDL("xcpd"))
+	router.POST("/xcpd", handlers.LatencyBudget("xcpd"), handlers.SOAPEnvelope(), handlers.RequestContentType("soap"), handlers.SOAPAction("xcpd"), handlers.RateLimit("xcpd"), handlers.WSSecurity(), concurrency("xcpd"), handlers.Latency("xcpd"), handlers.Stream("xcpd"), handlers.Chaos("xcpd"), handlers.HandleXCPD)
 
 	// FHIR endpoints (configure MITZ_FHIR_ENDPOINT=https://localhost:8443/fhir)
-	fhir := router.Group("/fhir")
+	fhir := router.Group("/fhir", handlers.LatencyBudget("fhir"), handlers.RateLimit("fhir"), concurrency("fhir"), handlers.Latency("fhir"), handlers.Stream("fhir"), handlers.Chaos("fhir"))
 	{
-		fhir.POST("/Subscription", auth.SamlAuthMiddleware(samlValidator), handlers.HandleFhirSubscriptionCreate)
+		fhir.GET("/metadata", handlers.HandleFhirMetadata)
+		fhir.POST("/Subscription", handlers.RequestContentType("fhir"), auth.SamlAuthMiddleware(samlValidator), handlers.HandleFhirSubscriptionCreate)
 		fhir.DELETE("/Subscription/:id", auth.SamlAuthMiddleware(samlValidator), handlers.HandleFhirSubscriptionDelete)
+		fhir.GET("/Subscription", handlers.HandleFhirSubscriptionSearch)
+		fhir.GET("/Subscription/:id", handlers.HandleFhirSubscriptionRead)
+		fhir.POST("/Subscription/:id/$acknowledge", handlers.HandleFhirNotificationAck)
 		fhir.GET("/Subscription/$processingStatus", handlers.HandleFhirProcessingStatus)
+		fhir.GET("/Consent", handlers.HandleFhirConsentSearch)
 		fhir.GET("/Consent/$processingStatus", handlers.HandleFhirProcessingStatus)
-		fhir.POST("/", handlers.HandleFhirBundle) // SAML checked inside handler (migration only)
+		fhir.GET("/Organization", handlers.HandleFhirOrganizationSearch)
+		fhir.GET("/Organization/:id", handlers.HandleFhirOrganizationRead)
+		fhir.GET("/$export", handlers.HandleFhirExport)
+		fhir.GET("/$export-poll-status/:id", handlers.HandleFhirExportStatus)
+		fhir.DELETE("/$export-poll-status/:id", handlers.HandleFhirExportCancel)
+		fhir.GET("/$export-file/:id/:type", handlers.HandleFhirExportFile)
+		fhir.POST("/", handlers.RequestContentType("fhir"), handlers.HandleFhirBundle) // SAML checked inside handler (migration only)
+	}
+
+	router.GET("/version", handlers.HandleVersion)
+
+	// Conformance artifacts (schemas, profiles, example payloads)
+	router.GET("/artifacts", handlers.HandleArtifactIndex)
+	router.GET("/artifacts/*path", handlers.HandleArtifact)
+
+	// Admin endpoints
+	admin := router.Group("/admin", handlers.AdminAuth(cfg.Admin.Token))
+	{
+		admin.GET("/stats", handlers.HandleAdminStats)
+		admin.GET("/notifications/dead-letters", handlers.HandleAdminDeadLetters)
+		admin.GET("/notifications/unacked", handlers.HandleAdminUnacked)
+		admin.POST("/selftest", handlers.HandleAdminSelftest)
+		admin.POST("/reset", handlers.HandleAdminReset)
+		admin.GET("/state/export", handlers.HandleAdminStateExport)
+		admin.GET("/events", handlers.HandleAdminEvents)
+		admin.GET("/reconciliation", handlers.HandleAdminReconciliation)
+		admin.GET("/testcases", handlers.HandleAdminTestcases)
+		admin.GET("/testcases/:id", handlers.HandleAdminTestcaseRequests)
+		admin.POST("/state/import", handlers.HandleAdminStateImport)
+		admin.GET("/quotas", handlers.HandleAdminQuotas)
+		admin.PUT("/quotas/:providerId", handlers.HandleAdminQuotaSet)
+		admin.POST("/quotas/:providerId/reset", handlers.HandleAdminQuotaReset)
+		admin.PUT("/subscriptions/:id/notification-format", handlers.HandleAdminNotificationFormat)
+		admin.POST("/scenarios/consent-changed", handlers.HandleAdminConsentChanged)
+		admin.GET("/scenarios/deceased", handlers.HandleAdminDeceased)
+		admin.POST("/scenarios/deceased", handlers.HandleAdminDeceasedMark)
+		admin.DELETE("/scenarios/deceased/:bsn", handlers.HandleAdminDeceasedClear)
+		admin.GET("/scenarios/max-categories", handlers.HandleAdminMaxCategories)
+		admin.PUT("/scenarios/max-categories", handlers.HandleAdminMaxCategoriesSet)
+		admin.DELETE("/scenarios/max-categories", handlers.HandleAdminMaxCategoriesClear)
+		admin.GET("/identity", handlers.HandleAdminIdentity)
+		admin.POST("/certificates", handlers.HandleAdminCertificateIssue)
+		admin.GET("/providers", handlers.HandleAdminProviders)
+		admin.POST("/config/reload", handlers.HandleAdminReload)
+		admin.GET("/strictness", handlers.HandleAdminStrictness)
+		admin.PUT("/strictness/:client", handlers.HandleAdminStrictnessSet)
+		admin.DELETE("/strictness/:client", handlers.HandleAdminStrictnessDelete)
+		admin.GET("/rules", handlers.HandleAdminRules)
+		admin.POST("/rules", handlers.HandleAdminRuleCreate)
+		admin.DELETE("/rules", handlers.HandleAdminRulesClear)
+		admin.PUT("/rules/:id", handlers.HandleAdminRuleUpdate)
+		admin.DELETE("/rules/:id", handlers.HandleAdminRuleDelete)
+		admin.GET("/runtime", handlers.HandleAdminRuntime)
+		admin.GET("/anomalies", handlers.HandleAdminAnomalies)
+		admin.GET("/mtls", handlers.HandleAdminMTLS)
+		admin.GET("/latency-budget", handlers.HandleAdminLatencyBudget)
+		admin.DELETE("/latency-budget", handlers.HandleAdminLatencyBudgetReset)
+		admin.GET("/bsn/pools", handlers.HandleAdminBSNPools)
+		admin.GET("/bsn/reservations", handlers.HandleAdminBSNReservations)
+		admin.POST("/bsn/reservations", handlers.HandleAdminBSNReserve)
+		admin.GET("/bsn/reservations/:id", handlers.HandleAdminBSNReservation)
+		admin.PUT("/bsn/reservations/:id", handlers.HandleAdminBSNExtend)
+		admin.DELETE("/bsn/reservations/:id", handlers.HandleAdminBSNRelease)
+		admin.GET("/maintenance", handlers.HandleAdminMaintenance)
+		admin.PUT("/maintenance", handlers.HandleAdminMaintenanceStart)
+		admin.DELETE("/maintenance", handlers.HandleAdminMaintenanceStop)
+		admin.GET("/cutover", handlers.HandleAdminCutover)
+		admin.PUT("/cutover", handlers.HandleAdminCutoverSet)
+		admin.DELETE("/cutover", handlers.HandleAdminCutoverStop)
+		admin.DELETE("/anomalies", handlers.HandleAdminAnomaliesReset)
+	}
+	if cfg.Admin.Pprof {
+		handlers.RegisterPprof(admin)
 	}
 
 	// Configure TLS
+	socketPath, unixSocket := cfg.Server.UnixSocket()
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
 	}
 
-	if mtlsEnabled == "true" {
-		caCertPEM, err := os.ReadFile(caCert)
+	mtlsMode := cfg.Server.ClientCertMode()
+	if unixSocket {
+		log.Println("Unix socket listener — plain HTTP, TLS is terminated by the sidecar")
+	} else if mtlsMode != "off" {
+		caCertPEM, err := os.ReadFile(cfg.Server.CACert)
 		if err != nil {
 			log.Fatalf("Failed to read CA certificate: %v", err)
 		}
@@ -95,58 +433,864 @@ func main() {
 			log.Fatal("Failed to parse CA certificate")
 		}
 		tlsConfig.ClientCAs = caCertPool
-		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-		log.Println("mTLS enabled — client certificates will be verified")
+		// The handshake only asks for a certificate; VerifyClientConnection verifies
+		// it, so failures are recorded before require mode rejects them.
+		tlsConfig.VerifyConnection = handlers.VerifyClientConnection
+		handlers.InitClientCertMode(mtlsMode, caCertPool)
+		if mtlsMode == "request" {
+			tlsConfig.ClientAuth = tls.RequestClientCert
+			log.Println("mTLS in request mode — client certificates are verified and recorded when presented, connections without one or with an untrusted one are allowed (GET /admin/mtls)")
+		} else {
+			tlsConfig.ClientAuth = tls.RequireAnyClientCert
+			log.Println("mTLS enabled — client certificates will be verified")
+		}
 	} else {
+		handlers.InitClientCertMode(mtlsMode, nil)
 		log.Println("mTLS disabled — any client can connect")
 	}
 
+	conns := cfg.Connections
 	server := &http.Server{
-		Addr:      ":" + port,
-		Handler:   router,
-		TLSConfig: tlsConfig,
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           router,
+		TLSConfig:         tlsConfig,
+		ConnContext:       handlers.ConnContext,
+		IdleTimeout:       time.Duration(conns.IdleTimeoutSeconds) * time.Second,
+		ReadHeaderTimeout: time.Duration(conns.ReadHeaderTimeoutSeconds) * time.Second,
+		HTTP2:             &http.HTTP2Config{MaxConcurrentStreams: conns.MaxConcurrentStreams},
+		Protocols:         new(http.Protocols),
+	}
+	server.SetKeepAlivesEnabled(conns.KeepAlives)
+	server.Protocols.SetHTTP1(true)
+	if unixSocket {
+		// Sidecar proxies may speak HTTP/2 without TLS (h2c) to their upstream
+		server.TLSConfig = nil
+		server.Protocols.SetUnencryptedHTTP2(conns.HTTP2)
+	} else {
+		server.Protocols.SetHTTP2(conns.HTTP2)
 	}
+	logConnections(conns)
 
-	log.Printf("Mitz Replicator starting on https://localhost:%s", port)
+	if unixSocket {
+		log.Printf("Mitz Replicator starting on %s", cfg.Server.Listen)
+	} else {
+		log.Printf("Mitz Replicator starting on https://localhost:%s", cfg.Server.Port)
+	}
 	log.Printf("  SOAP endpoints:")
 	log.Printf("    HEAD /xacml  — health check")
 	log.Printf("    POST /xacml  — gesloten autorisatievraag")
 	log.Printf("    POST /xcpd   — open autorisatievraag")
+	log.Printf("    GET  /xacml?wsdl, /xcpd?wsdl — WSDL (schemas under /artifacts/wsdl/)")
 	log.Printf("  FHIR endpoints:")
+	log.Printf("    GET    /fhir/metadata                  — CapabilityStatement (FHIR_VERSION)")
 	log.Printf("    POST   /fhir/Subscription              — create subscription (OTV-TR-0120)")
 	log.Printf("    DELETE /fhir/Subscription/:id           — cancel subscription (OTV-TR-0130)")
+	log.Printf("    GET    /fhir/Subscription/:id           — read recorded subscription")
+	log.Printf("    GET    /fhir/Subscription?criteria=&status= — search recorded subscriptions")
+	log.Printf("    POST   /fhir/Subscription/:id/$acknowledge  — acknowledge a notification")
 	log.Printf("    POST   /fhir/                           — Bundle transaction (OTV-TR-0150/0160)")
 	log.Printf("    GET    /fhir/Subscription/$processingStatus — query processing status")
+	log.Printf("    GET    /fhir/Consent?patientid=             — query stored consents")
 	log.Printf("    GET    /fhir/Consent/$processingStatus      — query processing status")
+	log.Printf("    GET    /fhir/Organization?identifier=&name= — search the provider register")
+	log.Printf("    GET    /fhir/Organization/:id               — read an organisation by URA")
+	log.Printf("    GET    /fhir/$export                        — bulk NDJSON export of Consents and Subscriptions")
+	log.Printf("    GET    /fhir/$export-poll-status/:id        — export status and manifest (DELETE to cancel)")
+	log.Printf("  Artifacts:")
+	log.Printf("    GET    /artifacts/*path                  — schemas, profiles and example payloads")
+	log.Printf("    GET    /version                          — build, environment and insecure lab mode")
+	log.Printf("  Admin endpoints:")
+	log.Printf("    GET    /admin/stats                      — consent/subscription statistics")
+	log.Printf("    GET    /admin/notifications/dead-letters — undeliverable notifications")
+	log.Printf("    GET    /admin/notifications/unacked      — notifications awaiting acknowledgment")
+	log.Printf("    POST   /admin/selftest                   — fuzz the request parsers")
+	log.Printf("    POST   /admin/reset                      — clear all stored state")
+	log.Printf("    GET    /admin/state/export               — dump subscriptions and consents")
+	log.Printf("    POST   /admin/state/import               — restore a state dump, or load a Mitz register export (?format=mitz-export)")
+	log.Printf("    GET    /admin/events                     — state change log (?since=<seq>)")
+	log.Printf("    GET    /admin/reconciliation             — submitted Bundles vs stored consents (?since=&format=csv)")
+	log.Printf("    GET    /admin/testcases                  — requests per OTV-TR test case (/:id for the captures)")
+	log.Printf("    GET    /admin/quotas                     — subscription quota usage per provider")
+	log.Printf("    PUT    /admin/subscriptions/:id/notification-format — stu3-xml, r4-xml or r4-json notifications")
+	log.Printf("    POST   /admin/scenarios/consent-changed  — store consent, flip decisions, notify")
+	log.Printf("    POST   /admin/scenarios/deceased         — mark a BSN deceased (DELETE /:bsn to clear)")
+	log.Printf("    PUT    /admin/scenarios/max-categories   — lower the categories per XACML request (DELETE to restore)")
+	log.Printf("    GET    /admin/identity                   — resolved client certificate identity")
+	if cfg.Server.CAKey != "" {
+		log.Printf("    POST   /admin/certificates               — issue a client certificate for a URA (CA_KEY)")
+	}
+	log.Printf("    GET    /admin/providers                  — provider register (URA, custodian OID, name)")
+	log.Printf("    POST   /admin/config/reload              — re-read CONFIG_FILE and environment (also on SIGHUP)")
+	log.Printf("    GET    /admin/strictness                 — parsing strictness per client (PUT/DELETE /:client)")
+	log.Printf("    GET    /admin/rules                      — routing rules (POST to add, PUT/DELETE /:id)")
+	log.Printf("    GET    /admin/runtime                    — goroutines, heap and GC statistics")
+	log.Printf("    GET    /admin/anomalies                  — per-client request baselines and anomalies (DELETE to reset)")
+	log.Printf("    GET    /admin/mtls                       — client certificates presented on TLS handshakes")
+	log.Printf("    GET    /admin/latency-budget             — requests and notification chains against LATENCY_BUDGET_MS (DELETE to reset)")
+	log.Printf("    PUT    /admin/maintenance                — start maintenance mode (DELETE to end)")
+	log.Printf("    PUT    /admin/cutover                    — switch the migration cutover phase (DELETE to end)")
+	log.Printf("    POST   /admin/bsn/reservations           — reserve synthetic test BSNs")
+	if cfg.Admin.Pprof {
+		log.Printf("    GET    /admin/debug/pprof/               — Go profiling (ADMIN_PPROF)")
+	}
 
-	if err := server.ListenAndServeTLS(serverCert, serverKey); err != nil {
+	served := make(chan error, 1)
+	if unixSocket {
+		listener, err := listenUnix(socketPath)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", cfg.Server.Listen, err)
+		}
+		go func() { served <- server.Serve(listener) }()
+	} else {
+		go func() { served <- server.ListenAndServeTLS(cfg.Server.Cert, cfg.Server.Key) }()
+	}
+
+	select {
+	case err := <-served:
 		log.Fatalf("Server failed: %v", err)
+	case <-ctx.Done():
+	}
+
+	// Graceful shutdown: finish in-flight requests, deregister, then close the stores
+	log.Printf("Shutting down — waiting up to %s for in-flight requests", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown: %v — closing remaining connections", err)
+		server.Close()
+	}
+	<-registryDone
+}
+
+// logConnections reports the connection settings of the listener.
+func logConnections(conns config.ConnectionsConfig) {
+	if conns.HTTP2 {
+		log.Printf("HTTP/2 enabled — up to %d concurrent streams per connection", conns.MaxConcurrentStreams)
+	} else {
+		log.Println("HTTP/2 disabled — HTTP/1.1 only")
+	}
+	if !conns.KeepAlives {
+		log.Println("Keep-alives disabled — connections close after each response")
+	}
+	log.Printf("Connection timeouts — idle=%ds readHeader=%ds (0 = none)", conns.IdleTimeoutSeconds, conns.ReadHeaderTimeoutSeconds)
+}
+
+// listenUnix listens on the Unix domain socket at path, replacing a socket left
+// behind by an earlier run.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
 	}
+	return net.Listen("unix", path)
 }
 
-func initTemplates() {
-	xacmlResponse := mustReadTemplate("templates/xacml_response.xml")
-	xacmlFault := mustReadTemplate("templates/xacml_fault.xml")
-	handlers.InitXACMLTemplates(xacmlResponse, xacmlFault)
+// applyScenarioConfig installs the settings that can change without a restart.
+// Files are read first, so a failure leaves the running settings untouched.
+func applyScenarioConfig(cfg config.Config) error {
+	var decisionMatrix *matrix.Matrix
+	if cfg.Decisions.Matrix != "" {
+		m, err := matrix.Load(cfg.Decisions.Matrix)
+		if err != nil {
+			return fmt.Errorf("decision matrix (DECISION_MATRIX): %w", err)
+		}
+		decisionMatrix = m
+	}
 
-	xcpdFound := mustReadTemplate("templates/xcpd_found.xml")
-	xcpdEmpty := mustReadTemplate("templates/xcpd_empty.xml")
-	xcpdFault := mustReadTemplate("templates/xcpd_fault.xml")
-	handlers.InitXCPDTemplates(xcpdFound, xcpdEmpty, xcpdFault)
+	// Injected response delays per route, and per request via X-Mitz-Delay
+	delays := make(map[string]rules.Delay, len(cfg.Latency.Endpoints))
+	for route, delay := range cfg.Latency.Endpoints {
+		delays[route], _ = rules.ParseDelay(delay)
+	}
+	handlers.InitLatency(delays, cfg.Latency.Header,
+		time.Duration(cfg.Latency.HeaderMaxMs)*time.Millisecond, time.Duration(cfg.Latency.HangMaxMs)*time.Millisecond)
+	for _, route := range slices.Sorted(maps.Keys(delays)) {
+		log.Printf("Latency: %s delayed by %sms", route, delays[route])
+	}
+	if cfg.Latency.Header {
+		log.Printf("Latency: X-Mitz-Delay honoured up to %dms", cfg.Latency.HeaderMaxMs)
+	}
 
-	fhirSubscription := mustReadTemplate("templates/fhir_subscription.xml")
-	fhirBundleResponse := mustReadTemplate("templates/fhir_bundle_response.xml")
-	fhirProcessingStatus := mustReadTemplate("templates/fhir_processing_status.xml")
-	fhirOperationOutcome := mustReadTemplate("templates/fhir_operation_outcome.xml")
-	handlers.InitFhirTemplates(fhirSubscription, fhirBundleResponse, fhirProcessingStatus, fhirOperationOutcome)
+	// End-to-end budget per request and the notification chain it triggers
+	handlers.InitLatencyBudget(time.Duration(cfg.Latency.BudgetMs)*time.Millisecond, cfg.Latency.BudgetMode == "enforce")
+	if cfg.Latency.BudgetMs > 0 {
+		log.Printf("Latency budget: %dms end to end (mode %s, GET /admin/latency-budget)", cfg.Latency.BudgetMs, cfg.Latency.BudgetMode)
+	}
+
+	// Chunked response streaming per route
+	streams := make(map[string]rules.Stream, len(cfg.Streaming.Endpoints))
+	for route, stream := range cfg.Streaming.Endpoints {
+		streams[route], _ = rules.ParseStream(stream)
+	}
+	handlers.InitStreaming(streams, cfg.Streaming.Bandwidth, cfg.Streaming.ClientBandwidth)
+	for _, route := range slices.Sorted(maps.Keys(streams)) {
+		log.Printf("Streaming: %s responses in %s (bytes/ms) chunks", route, streams[route])
+	}
+	for _, route := range slices.Sorted(maps.Keys(cfg.Streaming.Bandwidth)) {
+		log.Printf("Bandwidth: %s responses limited to %d bytes/s", route, cfg.Streaming.Bandwidth[route])
+	}
+	for _, client := range slices.Sorted(maps.Keys(cfg.Streaming.ClientBandwidth)) {
+		log.Printf("Bandwidth: responses to client %s limited to %d bytes/s", client, cfg.Streaming.ClientBandwidth[client])
+	}
+
+	// Probabilistic fault injection
+	handlers.InitChaos(cfg.Chaos.Rate, cfg.Chaos.Routes, cfg.Chaos.Kinds, cfg.Chaos.Status)
+	if cfg.Chaos.Rate > 0 {
+		log.Printf("Chaos mode: %d%% of requests on %s fail with %s", cfg.Chaos.Rate,
+			cmp.Or(strings.Join(cfg.Chaos.Routes, ", "), "all routes"), strings.Join(cfg.Chaos.Kinds, " or "))
+	}
+
+	// Token-bucket rate limits per client and route
+	limits := make(map[string]ratelimit.Limit, len(cfg.RateLimits.Limits))
+	for route, limit := range cfg.RateLimits.Limits {
+		limits[route], _ = ratelimit.ParseLimit(limit)
+	}
+	handlers.InitRateLimits(limits, cfg.RateLimits.Header)
+	for _, route := range slices.Sorted(maps.Keys(limits)) {
+		log.Printf("Rate limit: %s at %s per client (keyed on %s)", route, limits[route],
+			cmp.Or(cfg.RateLimits.Header, "certificate URA"))
+	}
+
+	// Per-provider subscription quota (0 = unlimited)
+	handlers.InitSubscriptionQuota(cfg.Subscriptions.Quota)
+	if cfg.Subscriptions.Quota > 0 {
+		log.Printf("Subscription quota: %d active subscriptions per provider", cfg.Subscriptions.Quota)
+	}
+
+	// Response routing rules: configured rules first, then the built-in BSN table
+	handlers.InitRules(cfg.Rules, rules.Defaults(cfg.MagicBSNs))
+	if len(cfg.Rules) > 0 {
+		log.Printf("Routing rules: %d configured", len(cfg.Rules))
+	}
+	for _, builtin := range slices.Sorted(maps.Keys(cfg.MagicBSNs)) {
+		log.Printf("Magic BSN %s replaced by %s", builtin, cfg.MagicBSNs[builtin].BSN)
+	}
+
+	handlers.InitIdentity(identity.New(cfg.Identities))
+	if len(cfg.Identities) > 0 {
+		log.Printf("Client identity mappings: %d configured", len(cfg.Identities))
+	}
+
+	// Organisation register behind /fhir/Organization
+	handlers.InitProviders(provider.New(cfg.Providers, cfg.Identities))
+	if len(cfg.Providers) > 0 {
+		log.Printf("Provider register: %d configured organisations", len(cfg.Providers))
+	}
+	handlers.InitRegisterLocations(cfg.XCPDLocations.Source == "register", cfg.XCPDLocations.Max)
+	handlers.InitCustodians(cfg.XCPDLocations.Custodians[0], cfg.XCPDLocations.Custodians[1])
+	if cfg.XCPDLocations.Source == "register" {
+		log.Printf("XCPD locations: up to %d per BSN, generated from the provider register", cfg.XCPDLocations.Max)
+	}
+	handlers.InitXCPDPaging(cfg.XCPDLocations.PageSize)
+	if cfg.XCPDLocations.PageSize > 0 {
+		log.Printf("XCPD paging: %d locations per response, the rest via query continuation", cfg.XCPDLocations.PageSize)
+	}
+	handlers.InitXCPDDevices(cfg.XCPDDevices.Senders, cfg.XCPDDevices.Receivers)
+	if len(cfg.XCPDDevices.Senders) > 0 {
+		log.Printf("XCPD senders: %d registered device(s), others get an AE acknowledgement", len(cfg.XCPDDevices.Senders))
+	}
+	if len(cfg.XCPDDevices.Receivers) > 0 {
+		log.Printf("XCPD receivers: %s", strings.Join(cfg.XCPDDevices.Receivers, ", "))
+	}
+
+	// Parsing strictness, per client URA or test session
+	defaultStrictness, _ := parser.ParseStrictness(cfg.Parsing.Strictness)
+	clientStrictness := make(map[string]parser.Strictness, len(cfg.Parsing.Clients))
+	for client, level := range cfg.Parsing.Clients {
+		clientStrictness[client], _ = parser.ParseStrictness(level)
+	}
+	handlers.InitStrictness(defaultStrictness, clientStrictness)
+	if defaultStrictness != (parser.Strictness{}) || len(clientStrictness) > 0 {
+		log.Printf("Parsing strictness: %s (%d client overrides)", defaultStrictness, len(clientStrictness))
+	}
+	handlers.InitValidationMode(cfg.Parsing.Validation == "strict")
+	if cfg.Parsing.Validation == "strict" {
+		log.Printf("Validation mode: strict (requests missing required content, invalid against the XSDs or violating the FHIR profiles are rejected)")
+	}
+
+	// Response Content-Type variants (e.g. without charset, legacy application/xml+fhir)
+	handlers.InitContentTypes(cfg.ContentTypes.SOAP, cfg.ContentTypes.FHIR)
+
+	// (BSN, category) → decision table for XACML
+	handlers.InitDecisionMatrix(decisionMatrix)
+	if decisionMatrix != nil {
+		log.Printf("Decision matrix: %d entries from %s", decisionMatrix.Len(), cfg.Decisions.Matrix)
+	}
+	return nil
 }
 
-func mustReadTemplate(path string) string {
-	data, err := templateFS.ReadFile(path)
+// configCommand runs "mitz-replicator config <subcommand>" and returns the exit code:
+// "validate" checks CONFIG_FILE and the environment, "print-effective" prints the
+// merged configuration (defaults, file, environment) as YAML with secrets redacted.
+func configCommand(args []string) int {
+	if len(args) != 1 || (args[0] != "validate" && args[0] != "print-effective") {
+		fmt.Fprintln(os.Stderr, "usage: mitz-replicator config validate|print-effective")
+		return 2
+	}
+
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
 	if err != nil {
-		log.Fatalf("Failed to read embedded template %s: %v", path, err)
+		fmt.Fprintf(os.Stderr, "Configuration error: %v\n", err)
+		return 1
+	}
+	if args[0] == "validate" {
+		fmt.Println("Configuration is valid")
+		return 0
 	}
-	return string(data)
+
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render configuration: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
+
+// superviseCommand runs the instances listed in an instances file as child
+// processes of this binary until interrupted.
+func superviseCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: mitz-replicator supervise <instances.yaml>")
+		return 2
+	}
+	instances, err := supervisor.Load(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Instances error: %v\n", err)
+		return 1
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot locate the replicator binary: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("[SUPERVISOR] Running %d instances from %s", len(instances), args[0])
+	supervisor.Run(ctx, exe, instances)
+	return 0
+}
+
+// monitoringCommand writes Prometheus alert rules or a Grafana dashboard for the
+// replicator's metrics to stdout or -o.
+func monitoringCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "alerts" && args[0] != "dashboard") {
+		fmt.Fprintln(os.Stderr, "usage: mitz-replicator monitoring alerts|dashboard [-job name] [-o file]")
+		return 2
+	}
+	flags := flag.NewFlagSet("monitoring "+args[0], flag.ContinueOnError)
+	job := flags.String("job", monitoring.DefaultJob, "Prometheus job name that scrapes the replicators")
+	output := flags.String("o", "", "write to this file instead of stdout")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	var out []byte
+	var err error
+	if args[0] == "alerts" {
+		out, err = monitoring.AlertsYAML(*job)
+	} else {
+		out, err = monitoring.DashboardJSON(*job)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render %s: %v\n", args[0], err)
+		return 1
+	}
+
+	if *output == "" {
+		os.Stdout.Write(out)
+		return 0
+	}
+	if err := os.WriteFile(*output, out, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	fmt.Printf("Wrote %s to %s\n", args[0], *output)
+	return 0
+}
+
+// reloadConfig re-reads the configuration and applies its scenario settings. An
+// invalid configuration is rejected and the running one is kept.
+func reloadConfig(path string, running config.Config) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		log.Printf("[CONFIG] Reload rejected, keeping current configuration: %v", err)
+		return err
+	}
+
+	if err := applyScenarioConfig(cfg); err != nil {
+		log.Printf("[CONFIG] Reload rejected, keeping current configuration: %v", err)
+		return err
+	}
+	if err := loadTemplates(running.Templates.Version, templateDirs(running.Templates)); err != nil {
+		log.Printf("[TEMPLATES] Reload failed, keeping current templates: %v", err)
+	}
+	log.Printf("[CONFIG] Reloaded %s", cmp.Or(path, "environment"))
+
+	// Everything else is wired into the listener and middleware at startup.
+	copyReloadable(&cfg, running)
+	if !reflect.DeepEqual(cfg, running) {
+		log.Printf("[CONFIG] Changes outside %s take effect after a restart", reloadableSections)
+	}
+	return nil
+}
+
+// reloadableSections names the sections copyReloadable copies, for the reload log.
+const reloadableSections = "subscriptions, rules, magicBsns, identities, providers, xcpdLocations, xcpdDevices, parsing, contentTypes, decisions.matrix, latency, streaming, chaos and rateLimits"
+
+// copyReloadable copies the sections applyScenarioConfig applies on reload from src
+// to dst. Keep it in step with applyScenarioConfig and reloadableSections.
+func copyReloadable(dst *config.Config, src config.Config) {
+	dst.Subscriptions = src.Subscriptions
+	dst.Rules = src.Rules
+	dst.MagicBSNs = src.MagicBSNs
+	dst.Identities = src.Identities
+	dst.Providers = src.Providers
+	dst.XCPDLocations = src.XCPDLocations
+	dst.XCPDDevices = src.XCPDDevices
+	dst.Parsing = src.Parsing
+	dst.ContentTypes = src.ContentTypes
+	dst.Decisions.Matrix = src.Decisions.Matrix
+	dst.Latency = src.Latency
+	dst.Streaming = src.Streaming
+	dst.Chaos = src.Chaos
+	dst.RateLimits = src.RateLimits
+}
+
+// reloadOnSIGHUP reloads the configuration whenever the process receives SIGHUP.
+func reloadOnSIGHUP(path string, running config.Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		log.Printf("[CONFIG] SIGHUP received")
+		reloadConfig(path, running)
+	}
+}
+
+// initTemplates loads the response templates and, with template directories
+// configured, watches them for changes.
+func initTemplates(cfg config.TemplatesConfig) {
+	dirs := templateDirs(cfg)
+	if err := loadTemplates(cfg.Version, dirs); err != nil {
+		log.Fatalf("Failed to load templates: %v", err)
+	}
+	if versions := handlers.TemplateVersions(); len(versions) > 0 {
+		log.Printf("Templates: Mitz versions %s selectable with X-Mitz-Version (default %s)",
+			strings.Join(versions, ", "), cmp.Or(cfg.Version, "unversioned"))
+	}
+	if len(dirs) == 0 {
+		return
+	}
+
+	log.Printf("Templates: embedded, overlaid with %s", strings.Join(dirs, ", "))
+	if cfg.WatchSeconds > 0 {
+		go watchTemplates(cfg.Version, dirs, time.Duration(cfg.WatchSeconds)*time.Second)
+	}
+}
+
+// templateDirs returns the template directories in layering order: TEMPLATE_DIR,
+// then TEMPLATE_OVERLAYS.
+func templateDirs(cfg config.TemplatesConfig) []string {
+	var dirs []string
+	if cfg.Dir != "" {
+		dirs = append(dirs, cfg.Dir)
+	}
+	return append(dirs, cfg.Overlays...)
+}
+
+// loadTemplates layers the templates in dirs over the embedded ones and hands the
+// set to the handlers.
+func loadTemplates(defaultVersion string, dirs []string) error {
+	embedded, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		return err
+	}
+	layer, err := readTemplateLayer("embedded", embedded)
+	if err != nil {
+		return err
+	}
+
+	layers := []handlers.TemplateLayer{layer}
+	for _, dir := range dirs {
+		layer, err := readTemplateLayer(dir, os.DirFS(dir))
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+		layers = append(layers, layer)
+	}
+	return handlers.LoadTemplates(defaultVersion, layers...)
+}
+
+// readTemplateLayer reads the *.xml files in the root of fsys and, per Mitz version,
+// those in its mitz-<version>/ subdirectories.
+func readTemplateLayer(name string, fsys fs.FS) (handlers.TemplateLayer, error) {
+	layer := handlers.TemplateLayer{Name: name, Versions: map[string]map[string]string{}}
+
+	var err error
+	if layer.Sources, err = readTemplates(fsys, "*.xml"); err != nil {
+		return layer, err
+	}
+	versionDirs, err := fs.Glob(fsys, "mitz-*")
+	if err != nil {
+		return layer, err
+	}
+	for _, dir := range versionDirs {
+		if info, err := fs.Stat(fsys, dir); err != nil || !info.IsDir() {
+			continue
+		}
+		sources, err := readTemplates(fsys, dir+"/*.xml")
+		if err != nil {
+			return layer, err
+		}
+		layer.Versions[strings.TrimPrefix(dir, "mitz-")] = sources
+	}
+	return layer, nil
+}
+
+// readTemplates reads the files matching pattern, keyed by name without extension.
+func readTemplates(fsys fs.FS, pattern string) (map[string]string, error) {
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[string]string, len(paths))
+	for _, path := range paths {
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		sources[strings.TrimSuffix(filepath.Base(path), ".xml")] = string(data)
+	}
+	return sources, nil
+}
+
+// watchTemplates polls dirs and reloads the templates when a file is added, removed
+// or modified. A template that fails to parse is logged and the previous set kept.
+func watchTemplates(defaultVersion string, dirs []string, interval time.Duration) {
+	signature := func() string {
+		var sig strings.Builder
+		for _, dir := range dirs {
+			sig.WriteString(dir + "=" + dirSignature(dir) + "|")
+		}
+		return sig.String()
+	}
+
+	last := signature()
+	for range time.Tick(interval) {
+		current := signature()
+		if current == last {
+			continue
+		}
+		last = current
+
+		if err := loadTemplates(defaultVersion, dirs); err != nil {
+			log.Printf("[TEMPLATES] Reload failed, keeping current templates: %v", err)
+			continue
+		}
+		log.Printf("[TEMPLATES] Reloaded from %s", strings.Join(dirs, ", "))
+	}
+}
+
+// dirSignature summarises the names, sizes and modification times of the XML files in
+// dir and its mitz-<version>/ subdirectories.
+func dirSignature(dir string) string {
+	entries, _ := os.ReadDir(dir)
+	var sig strings.Builder
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "mitz-") {
+			fmt.Fprintf(&sig, "%s/[%s];", entry.Name(), dirSignature(filepath.Join(dir, entry.Name())))
+			continue
+		}
+		if info, err := entry.Info(); err == nil && strings.HasSuffix(entry.Name(), ".xml") {
+			fmt.Fprintf(&sig, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return sig.String()
+}
+
+// initSigning loads the XML-DSig signing key when any outbound signing is enabled.
+// The server certificate and key are used unless a signing pair is configured.
+func initSigning(cfg config.SigningConfig, server config.ServerConfig) {
+	signNotifications := cfg.Notifications
+	signSubscriptions := cfg.SubscriptionResponses
+	signResponses := cfg.Responses
+
+	if !signNotifications && !signSubscriptions && !signResponses {
+		handlers.InitSigning(nil, false, false, false)
+		return
+	}
+
+	certPath := cmp.Or(cfg.Cert, server.Cert)
+	keyPath := cmp.Or(cfg.Key, server.Key)
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		log.Fatalf("Failed to read signing certificate %s: %v", certPath, err)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		log.Fatalf("Failed to read signing key %s: %v", keyPath, err)
+	}
+
+	signer, err := auth.NewXMLSigner(certPEM, keyPEM)
+	if err != nil {
+		log.Fatalf("Failed to create XML signer: %v", err)
+	}
+
+	handlers.InitSigning(signer, signNotifications, signSubscriptions, signResponses)
+	log.Printf("XML-DSig signing enabled — cert=%s notifications=%t subscriptionResponses=%t responses=%t",
+		certPath, signNotifications, signSubscriptions, signResponses)
+}
+
+// initCertificateAuthority loads the CA key pair that signs client certificates
+// issued via POST /admin/certificates, when CA_KEY is set.
+func initCertificateAuthority(cfg config.ServerConfig) {
+	if cfg.CAKey == "" {
+		handlers.InitCertificateAuthority(nil)
+		return
+	}
+
+	certPEM, err := os.ReadFile(cfg.CACert)
+	if err != nil {
+		log.Fatalf("Failed to read CA certificate %s: %v", cfg.CACert, err)
+	}
+	keyPEM, err := os.ReadFile(cfg.CAKey)
+	if err != nil {
+		log.Fatalf("Failed to read CA key %s: %v", cfg.CAKey, err)
+	}
+
+	ca, err := auth.NewCertificateAuthority(certPEM, keyPEM)
+	if err != nil {
+		log.Fatalf("Failed to load certificate authority: %v", err)
+	}
+
+	handlers.InitCertificateAuthority(ca)
+	log.Printf("Client certificate issuing enabled — CA=%s", cfg.CACert)
+}
+
+// outboundClient builds the HTTP client for outbound calls, presenting the
+// configured client certificate and trusting the configured CA.
+func outboundClient(cfg config.OutboundConfig, timeout time.Duration) (*http.Client, error) {
+	clientCfg := outbound.ClientConfig{
+		Timeout:            timeout,
+		CertFile:           cfg.ClientCert,
+		KeyFile:            cfg.ClientKey,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		ProxyURL:           cfg.Proxy,
+		NoProxy:            cfg.NoProxy,
+	}
+	for _, caFile := range strings.Split(cfg.CACert, ",") {
+		if caFile = strings.TrimSpace(caFile); caFile != "" {
+			clientCfg.CAFiles = append(clientCfg.CAFiles, caFile)
+		}
+	}
+	if clientCfg.CertFile != "" {
+		log.Printf("Outbound mTLS enabled — client cert=%s", clientCfg.CertFile)
+	}
+	if clientCfg.ProxyURL != "" {
+		log.Printf("Outbound proxy: %s (direct: %v)", outbound.RedactedProxy(clientCfg.ProxyURL), clientCfg.NoProxy)
+	}
+	return outbound.NewClient(clientCfg)
+}
+
+// initNotifications starts the rest-hook dispatcher (unless disabled) and hands it to the handlers.
+func initNotifications(cfg config.NotificationsConfig, outboundCfg config.OutboundConfig) {
+	var dispatcher *notify.Dispatcher
+
+	if cfg.Enabled {
+		timeoutSec := cfg.TimeoutSeconds
+		queueSize := cfg.QueueSize
+		maxAttempts := cfg.MaxAttempts
+		initialBackoffMs := cfg.InitialBackoffMs
+		maxBackoffMs := cfg.MaxBackoffMs
+
+		client, err := outboundClient(outboundCfg, time.Duration(timeoutSec)*time.Second)
+		if err != nil {
+			log.Fatalf("Failed to configure notification client: %v", err)
+		}
+		dispatcher = notify.NewDispatcher(client, queueSize, notify.RetryPolicy{
+			MaxAttempts:    maxAttempts,
+			InitialBackoff: time.Duration(initialBackoffMs) * time.Millisecond,
+			MaxBackoff:     time.Duration(maxBackoffMs) * time.Millisecond,
+			AckTimeout:     time.Duration(cfg.AckTimeoutSeconds) * time.Second,
+		})
+		go dispatcher.Run(context.Background())
+
+		log.Printf("Notifications enabled — timeout=%ds queue=%d maxAttempts=%d backoff=%dms..%dms",
+			timeoutSec, queueSize, maxAttempts, initialBackoffMs, maxBackoffMs)
+		if cfg.AckTimeoutSeconds > 0 {
+			log.Printf("Notification acknowledgments required — unacknowledged notifications are redelivered after %ds", cfg.AckTimeoutSeconds)
+		}
+	} else {
+		log.Println("Notifications disabled — consent changes are not delivered to subscribers")
+	}
+
+	handlers.InitNotifications(dispatcher)
+}
+
+// initXCPDDeferred hands the handlers the client that posts deferred XCPD answers.
+func initXCPDDeferred(cfg config.OutboundConfig) {
+	client, err := outboundClient(cfg, 10*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to configure deferred XCPD client: %v", err)
+	}
+	handlers.InitXCPDDeferred(client)
+}
+
+// initRegistry registers the instance with the central test-environment registry
+// and starts the heartbeats, when a registry is configured.
+func initRegistry(ctx context.Context, cfg config.Config, store storage.Store) <-chan struct{} {
+	done := make(chan struct{})
+	if cfg.Registry.URL == "" {
+		close(done)
+		return done
+	}
+
+	client, err := outboundClient(cfg.Outbound, 10*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to configure registry client: %v", err)
+	}
+	// Default to the address the server actually listens on
+	hostname, _ := os.Hostname()
+	address, baseURL := hostname+":"+cfg.Server.Port, "https://"+hostname+":"+cfg.Server.Port
+	if socketPath, ok := cfg.Server.UnixSocket(); ok {
+		address, baseURL = hostname+":"+socketPath, cfg.Server.Listen
+	}
+	instance := registry.Instance{
+		ID:           cmp.Or(cfg.Registry.InstanceID, address),
+		URL:          cmp.Or(cfg.Registry.InstanceURL, baseURL),
+		Version:      buildVersion(),
+		MitzVersions: handlers.TemplateVersions(),
+		Features:     enabledFeatures(cfg),
+		Tenants:      cfg.Registry.Tenants,
+		StartedAt:    time.Now().UTC(),
+	}
+	state := func() registry.State {
+		var st registry.State
+		for _, consent := range store.Consents() {
+			if consent.Tenant != "" && !slices.Contains(st.Tenants, consent.Tenant) {
+				st.Tenants = append(st.Tenants, consent.Tenant)
+			}
+		}
+		slices.Sort(st.Tenants)
+		if handlers.MaintenanceActive() {
+			st.Status = "maintenance"
+		}
+		return st
+	}
+
+	interval := time.Duration(cfg.Registry.IntervalSeconds) * time.Second
+	reporter := registry.New(client, cfg.Registry.URL, cfg.Registry.Token, interval, instance, state)
+	go func() {
+		defer close(done)
+		reporter.Run(ctx)
+	}()
+	log.Printf("Registry: announcing %s (%s) to %s every %s", instance.ID, instance.URL, cfg.Registry.URL, interval)
+	return done
+}
+
+// logSecurityPosture announces the environment and, loudly, insecure lab mode, and
+// publishes both on GET /version.
+func logSecurityPosture(cfg config.Config) {
+	insecure := cfg.InsecureSettings()
+	handlers.InitVersion(handlers.VersionInfo{
+		Version:          buildVersion(),
+		Environment:      cfg.Environment,
+		InsecureLabMode:  cfg.LabMode(),
+		InsecureSettings: insecure,
+	})
+	if cfg.Environment != "" {
+		log.Printf("Environment: %s", cfg.Environment)
+	}
+	if !cfg.LabMode() {
+		return
+	}
+
+	banner := strings.Repeat("!", 72)
+	log.Println(banner)
+	log.Println("!!! INSECURE LAB MODE — security checks are weakened on purpose")
+	for _, setting := range insecure {
+		log.Printf("!!!   %s", setting)
+	}
+	log.Println("!!! Do not expose this instance; acceptance environments refuse to start like this")
+	log.Println(banner)
+}
+
+// buildVersion returns the module version, or the VCS revision the binary was built from.
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	if revision != "" {
+		return revision[:min(12, len(revision))] + modified
+	}
+	return "devel"
+}
+
+// enabledFeatures lists the optional behaviour switched on in cfg, for the registry.
+func enabledFeatures(cfg config.Config) []string {
+	features := []string{"store:" + cfg.Store.Driver}
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"mtls", cfg.Server.ClientCertMode() == "require"},
+		{"mtls-request", cfg.Server.ClientCertMode() == "request"},
+		{"unix-socket", cfg.Server.Listen != ""},
+		{"http2", cfg.Connections.HTTP2},
+		{"certificate-issuing", cfg.Server.CAKey != ""},
+		{"saml", cfg.SAML.Enabled},
+		{"ws-security", cfg.WSSecurity.Enabled},
+		{"session-isolation", cfg.Store.SessionIsolation},
+		{"notifications", cfg.Notifications.Enabled},
+		{"signing", cfg.Signing.Notifications || cfg.Signing.SubscriptionResponses || cfg.Signing.Responses},
+		{"strict-validation", cfg.Parsing.Validation == "strict"},
+		{"rules", len(cfg.Rules) > 0},
+		{"decision-matrix", cfg.Decisions.Matrix != ""},
+		{"decision-plugin", cfg.Decisions.Plugin != ""},
+		{"register-locations", cfg.XCPDLocations.Source == "register"},
+		{"xcpd-paging", cfg.XCPDLocations.PageSize > 0},
+		{"xcpd-devices", len(cfg.XCPDDevices.Senders) > 0 || len(cfg.XCPDDevices.Receivers) > 0},
+		{"latency", len(cfg.Latency.Endpoints) > 0 || cfg.Latency.Header},
+		{"chaos", cfg.Chaos.Rate > 0},
+		{"latency-budget", cfg.Latency.BudgetMs > 0},
+		{"rate-limits", len(cfg.RateLimits.Limits) > 0},
+		{"read-only", cfg.ReadOnly},
+		{"header-hygiene", cfg.HeaderHygiene == "strict"},
+		{"lenient-soap-actions", cfg.SOAPActions == "lenient"},
+		{"content-type-check", cfg.RequestTypes == "strict"},
+		{"max-categories", cfg.MaxCategories > 0},
+		{"cutover", cfg.CutoverPhase != 0},
+		{"testcase-enforcement", cfg.OTVTestcases == "enforce"},
+		{"deterministic", cfg.DeterministicSeed != ""},
+		{"fhir-r4b", cfg.FHIRVersion == "R4B"},
+		{"anomalies", cfg.Anomalies.Enabled},
+		{"admin-token", cfg.Admin.Token != ""},
+		{"debug-timing", cfg.Debug.Timing},
+		{"insecure-lab-mode", cfg.LabMode()},
+	} {
+		if f.on {
+			features = append(features, f.name)
+		}
+	}
+	return features
 }
 
 func requestLogger() gin.HandlerFunc {
@@ -156,20 +1300,77 @@ func requestLogger() gin.HandlerFunc {
 
 		c.Next()
 
-		log.Printf("%s %s %d %s RequestId=%s",
+		testcase := ""
+		if id := handlers.TestcaseFor(c); id != "" {
+			testcase = " Testcase=" + id
+		}
+		log.Printf("%s %s %d %s RequestId=%s%s",
 			c.Request.Method,
 			c.Request.URL.Path,
 			c.Writer.Status(),
 			time.Since(start),
 			requestID,
+			testcase,
 		)
 	}
 }
 
-func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
+// requestRecorder captures every inbound request (including its body) in the
+// request's store, so session-scoped requests land in their session. Stores keep
+// the most recent STORE_MAX_REQUESTS.
+func requestRecorder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Admin calls operate on the recorded state; don't record them into it.
+		if strings.HasPrefix(c.Request.URL.Path, "/admin") {
+			c.Next()
+			return
+		}
+
+		body, _ := handlers.ReadBody(c)
+
+		c.Next()
+
+		err := handlers.StoreFor(c).CaptureRequest(storage.CapturedRequest{
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			RequestID: c.GetHeader("X-Request-Id"),
+			Testcase:  handlers.TestcaseFor(c),
+			Status:    c.Writer.Status(),
+			Body:      string(body),
+			Received:  time.Now(),
+		})
+		if err != nil {
+			log.Printf("Failed to capture request: %v", err)
+		}
+	}
+}
+
+// initArtifacts serves the configured artifacts directory (if any) ahead of the embedded artifacts.
+func initArtifacts(cfg config.ArtifactsConfig) {
+	embedded, err := fs.Sub(artifactFS, "artifacts")
+	if err != nil {
+		log.Fatalf("Failed to load embedded artifacts: %v", err)
+	}
+
+	dir := cfg.Dir
+	if dir == "" {
+		handlers.InitArtifacts(embedded)
+		return
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		log.Fatalf("Invalid artifacts directory (ARTIFACTS_DIR): %v", err)
 	}
-	return fallback
+	log.Printf("Serving artifacts from %s (overrides embedded artifacts)", dir)
+	handlers.InitArtifacts(os.DirFS(dir), embedded)
 }
 
+// sessionDSN derives a session's STORE_DSN by inserting the session ID before the
+// extension, e.g. /data/state.json → /data/state.ci-42.json.
+func sessionDSN(dsn, session string) string {
+	if dsn == "" {
+		return ""
+	}
+	ext := filepath.Ext(dsn)
+	return strings.TrimSuffix(dsn, ext) + "." + session + ext
+}
@@ -0,0 +1,143 @@
+// Package matrix loads decision matrices: tables mapping (BSN, gegevenscategorie)
+// to an XACML decision, as qualification test sets are usually written.
+package matrix
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AnyCategory in the category column applies an entry to every category of the BSN.
+const AnyCategory = "*"
+
+var decisions = map[string]string{
+	"permit":        "Permit",
+	"deny":          "Deny",
+	"indeterminate": "Indeterminate",
+	"notapplicable": "NotApplicable",
+}
+
+// Entry is one row of the matrix.
+type Entry struct {
+	BSN      string `json:"bsn"`
+	Category string `json:"category"`
+	Decision string `json:"decision"`
+}
+
+type key struct {
+	bsn      string
+	category string
+}
+
+// Matrix maps (BSN, category) to a decision.
+type Matrix struct {
+	entries map[key]string
+}
+
+// New builds a matrix from entries, normalising categories and decisions.
+func New(entries []Entry) (*Matrix, error) {
+	m := &Matrix{entries: make(map[key]string, len(entries))}
+	for i, e := range entries {
+		bsn := strings.TrimSpace(e.BSN)
+		category := normalizeCategory(e.Category)
+		decision, ok := decisions[strings.ToLower(strings.TrimSpace(e.Decision))]
+		switch {
+		case bsn == "":
+			return nil, fmt.Errorf("entry %d: bsn is required", i+1)
+		case category == "":
+			return nil, fmt.Errorf("entry %d: category is required (use %q for all categories)", i+1, AnyCategory)
+		case !ok:
+			return nil, fmt.Errorf("entry %d: unknown decision %q (expected Permit, Deny, Indeterminate or NotApplicable)", i+1, e.Decision)
+		}
+		m.entries[key{bsn, category}] = decision
+	}
+	return m, nil
+}
+
+// Load reads a matrix from a .csv or .json file. CSV files have the columns
+// bsn, category, decision; a header row and lines starting with # are skipped.
+func Load(path string) (*Matrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.NewDecoder(f).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".csv":
+		if entries, err = readCSV(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported decision matrix format (expected .csv or .json)", path)
+	}
+
+	m, err := New(entries)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+func readCSV(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = 3
+	cr.TrimLeadingSpace = true
+
+	var entries []Entry
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "bsn") {
+			continue
+		}
+		entries = append(entries, Entry{BSN: record[0], Category: record[1], Decision: record[2]})
+	}
+}
+
+// Decision returns the decision for bsn and category: an exact entry, else the
+// BSN's AnyCategory entry.
+func (m *Matrix) Decision(bsn, category string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	if d, ok := m.entries[key{bsn, normalizeCategory(category)}]; ok {
+		return d, true
+	}
+	d, ok := m.entries[key{bsn, AnyCategory}]
+	return d, ok
+}
+
+// Len returns the number of entries.
+func (m *Matrix) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.entries)
+}
+
+// normalizeCategory strips a code system prefix ("<oid>^code") and lower-cases.
+func normalizeCategory(category string) string {
+	category = strings.TrimSpace(category)
+	if i := strings.LastIndex(category, "^"); i >= 0 {
+		category = category[i+1:]
+	}
+	return strings.ToLower(category)
+}
@@ -0,0 +1,273 @@
+// Package mitzexport reads anonymised exports of the Mitz consent register into
+// consents, so an acceptance environment can be filled with production-shaped data.
+// An export is a CSV file with one consent per row; patients are pseudonymised and
+// get a synthetic BSN, the same one for every row of the same pseudonym.
+package mitzexport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"mitz-replicator/bsnpool"
+	"mitz-replicator/storage"
+)
+
+// DefaultBSNRange holds the synthetic BSNs: the upper half of the 999 test range,
+// above the default BSN pool and below the 999999* block of the built-in rules.
+const DefaultBSNRange = "999500000-999998999"
+
+// Columns of an export with the header names they are recognised by, compared
+// case-insensitively and ignoring spaces, dashes and underscores.
+var columns = map[string][]string{
+	"patient":    {"pseudoniem", "pseudonym", "patient", "patientid", "bsn"},
+	"decision":   {"toestemming", "besluit", "decision"},
+	"category":   {"categorie", "gegevenscategorie", "categories", "category"},
+	"provider":   {"zorgaanbieder", "ura", "providerid", "provider"},
+	"status":     {"status"},
+	"registered": {"registratiedatum", "geregistreerd", "registered", "created"},
+	"start":      {"ingangsdatum", "geldigvanaf", "start", "effectivefrom"},
+	"end":        {"einddatum", "geldigtot", "end", "effectiveuntil"},
+	"channel":    {"kanaal", "bron", "channel", "source"},
+	"id":         {"toestemmingid", "consentid", "id"},
+}
+
+var decisions = map[string]string{
+	"ja": "permit", "yes": "permit", "permit": "permit", "true": "permit",
+	"nee": "deny", "no": "deny", "deny": "deny", "false": "deny",
+}
+
+var statuses = map[string]string{
+	"": "active", "actief": "active", "active": "active",
+	"ingetrokken": "inactive", "inactief": "inactive", "inactive": "inactive", "revoked": "inactive",
+	"verlopen": "inactive", "expired": "inactive",
+	"concept": "draft", "draft": "draft",
+}
+
+var channels = map[string]string{
+	"migratie": "migration", "migration": "migration",
+	"toestemmingsknop": "toestemmingsknop", "knop": "toestemmingsknop",
+}
+
+// allCategories in the category column covers every gegevenscategorie.
+var allCategories = map[string]bool{"": true, "*": true, "alle": true, "all": true}
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02", "02-01-2006 15:04:05", "02-01-2006"}
+
+// Result is an import: the consents and how many patients they cover.
+type Result struct {
+	Consents []storage.Consent
+	Patients int
+	FirstBSN string
+	LastBSN  string
+}
+
+// Read parses an export. Fields are separated by semicolons or commas (detected from
+// the header row); several categories in one field are separated by commas or pipes.
+// Pseudonyms get the valid BSNs of bsnRange ("start-end" within the 999 test range,
+// DefaultBSNRange when empty) in order of first appearance, passing over those for
+// which skip reports true. Consents get IDs derived from their row, so importing the
+// same export again replaces them. Rows without a registration date are registered
+// at now. An export without consents is an error.
+func Read(r io.Reader, bsnRange string, skip func(bsn string) bool, now time.Time) (Result, error) {
+	bsns, err := newAllocator(bsnRange, skip)
+	if err != nil {
+		return Result{}, err
+	}
+
+	br := bufio.NewReader(r)
+	header, err := br.Peek(4096)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Result{}, err
+	}
+	cr := csv.NewReader(br)
+	if line, _, _ := bytes.Cut(header, []byte("\n")); bytes.Count(line, []byte(";")) > bytes.Count(line, []byte(",")) {
+		cr.Comma = ';'
+	}
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	names, err := cr.Read()
+	if err != nil {
+		return Result{}, fmt.Errorf("header: %w", err)
+	}
+	index, err := columnIndex(names)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var res Result
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(column string) string {
+			if i, ok := index[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		consent, err := parseRow(field, now)
+		if err != nil {
+			return Result{}, fmt.Errorf("line %d: %w", line, err)
+		}
+		if consent.BSN, err = bsns.bsn(field("patient")); err != nil {
+			return Result{}, fmt.Errorf("line %d: %w", line, err)
+		}
+		if consent.ID == "" {
+			consent.ID = rowID(row, record)
+		}
+		res.Consents = append(res.Consents, consent)
+	}
+
+	if len(res.Consents) == 0 {
+		return Result{}, errors.New("export has no consents")
+	}
+	res.Patients = len(bsns.assigned)
+	res.FirstBSN, res.LastBSN = bsns.first, bsns.last
+	return res, nil
+}
+
+// columnIndex maps the recognised columns to their position in the header.
+func columnIndex(names []string) (map[string]int, error) {
+	index := map[string]int{}
+	for i, name := range names {
+		name = strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))))
+		for column, aliases := range columns {
+			for _, alias := range aliases {
+				if _, seen := index[column]; name == alias && !seen {
+					index[column] = i
+				}
+			}
+		}
+	}
+	for _, required := range []string{"patient", "decision"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("header has no %s column (expected one of %s)", required, strings.Join(columns[required], ", "))
+		}
+	}
+	return index, nil
+}
+
+// parseRow converts the fields of a row, except the patient, to a consent.
+func parseRow(field func(string) string, now time.Time) (storage.Consent, error) {
+	decision, ok := decisions[strings.ToLower(field("decision"))]
+	if !ok {
+		return storage.Consent{}, fmt.Errorf("unknown decision %q (expected ja, nee, permit or deny)", field("decision"))
+	}
+	status, ok := statuses[strings.ToLower(field("status"))]
+	if !ok {
+		return storage.Consent{}, fmt.Errorf("unknown status %q (expected actief, ingetrokken, verlopen or concept)", field("status"))
+	}
+	if field("patient") == "" {
+		return storage.Consent{}, fmt.Errorf("no patient pseudonym")
+	}
+
+	consent := storage.Consent{
+		ID:         field("id"),
+		Status:     status,
+		Decision:   decision,
+		ProviderID: field("provider"),
+		Source:     "import",
+		Created:    now,
+	}
+	if source, ok := channels[strings.ToLower(field("channel"))]; ok {
+		consent.Source = source
+	}
+	if category := field("category"); !allCategories[strings.ToLower(category)] {
+		for code := range strings.FieldsFuncSeq(category, func(r rune) bool { return r == ',' || r == '|' }) {
+			if i := strings.LastIndex(code, "^"); i >= 0 {
+				code = code[i+1:]
+			}
+			if code = strings.TrimSpace(code); code != "" {
+				consent.Categories = append(consent.Categories, code)
+			}
+		}
+	}
+
+	var err error
+	for _, date := range []struct {
+		column string
+		dst    *time.Time
+	}{{"registered", &consent.Created}, {"start", &consent.EffectiveFrom}, {"end", &consent.EffectiveUntil}} {
+		if value := field(date.column); value != "" {
+			if *date.dst, err = parseDate(value); err != nil {
+				return storage.Consent{}, fmt.Errorf("%s: %w", date.column, err)
+			}
+		}
+	}
+	if !consent.EffectiveFrom.IsZero() && !consent.EffectiveUntil.IsZero() && !consent.EffectiveUntil.After(consent.EffectiveFrom) {
+		return storage.Consent{}, fmt.Errorf("end %s is not after the start", consent.EffectiveUntil.Format(time.DateOnly))
+	}
+	return consent, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (expected e.g. 2024-03-01, 01-03-2024 or RFC 3339)", value)
+}
+
+// rowID derives a UUID-shaped consent ID from the row number and contents.
+func rowID(row int, record []string) string {
+	sum := sha256.Sum256([]byte(strconv.Itoa(row) + "\x00" + strings.Join(record, "\x00")))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// allocator hands out the valid BSNs of a range to pseudonyms.
+type allocator struct {
+	next, end   int
+	skip        func(bsn string) bool
+	assigned    map[string]string
+	first, last string
+}
+
+func newAllocator(bsnRange string, skip func(bsn string) bool) (*allocator, error) {
+	if bsnRange == "" {
+		bsnRange = DefaultBSNRange
+	}
+	lo, hi, ok := strings.Cut(bsnRange, "-")
+	start, errStart := strconv.Atoi(strings.TrimSpace(lo))
+	end, errEnd := strconv.Atoi(strings.TrimSpace(hi))
+	if !ok || errStart != nil || errEnd != nil || len(strings.TrimSpace(lo)) != 9 || len(strings.TrimSpace(hi)) != 9 || end < start {
+		return nil, fmt.Errorf("invalid BSN range %q (expected nine-digit bounds, e.g. %s)", bsnRange, DefaultBSNRange)
+	}
+	if start < bsnpool.TestRangeStart || end > bsnpool.TestRangeEnd {
+		return nil, fmt.Errorf("BSN range %q is outside the test range %d-%d", bsnRange, bsnpool.TestRangeStart, bsnpool.TestRangeEnd)
+	}
+	return &allocator{next: start, end: end, skip: skip, assigned: map[string]string{}}, nil
+}
+
+func (a *allocator) bsn(pseudonym string) (string, error) {
+	if bsn, ok := a.assigned[pseudonym]; ok {
+		return bsn, nil
+	}
+	for ; a.next <= a.end; a.next++ {
+		if candidate := fmt.Sprintf("%09d", a.next); bsnpool.Valid(candidate) && (a.skip == nil || !a.skip(candidate)) {
+			a.next++
+			a.assigned[pseudonym] = candidate
+			if a.first == "" {
+				a.first = candidate
+			}
+			a.last = candidate
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("BSN range exhausted after %d patients", len(a.assigned))
+}
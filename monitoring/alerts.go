@@ -0,0 +1,104 @@
+package monitoring
+
+import (
+	"fmt"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Alert thresholds.
+const (
+	errorRatioThreshold = 0.05 // share of unscripted requests answered with 5xx
+	latencyThreshold    = 2.0  // seconds, p95 of unscripted requests
+)
+
+// RuleFile is a Prometheus rule file.
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+// RuleGroup is a named group of alerting rules.
+type RuleGroup struct {
+	Name  string      `yaml:"name"`
+	Rules []AlertRule `yaml:"rules"`
+}
+
+// AlertRule is one Prometheus alerting rule.
+type AlertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// Alerts returns the alerting rules for the replicators scraped by job. Errors and
+// latency are only judged on requests without a scenario, since scripted faults,
+// rate limits and delays are what the scenarios are for.
+func Alerts(job string) RuleFile {
+	sel := fmt.Sprintf(`job=%q`, job)
+	unscripted := fmt.Sprintf(`%s, %s=%q`, sel, LabelScenario, ScenarioNone)
+
+	return RuleFile{Groups: []RuleGroup{{
+		Name: job,
+		Rules: []AlertRule{
+			{
+				Alert:  "MitzReplicatorDown",
+				Expr:   fmt.Sprintf("up{%s} == 0", sel),
+				For:    "2m",
+				Labels: map[string]string{"severity": "critical"},
+				Annotations: map[string]string{
+					"summary":     "Mitz replicator {{ $labels.instance }} is down",
+					"description": "Prometheus could not scrape {{ $labels.instance }} for 2 minutes; clients under test get connection errors instead of scenario responses.",
+				},
+			},
+			{
+				Alert: "MitzReplicatorUnscriptedErrors",
+				Expr: fmt.Sprintf(`sum by (instance, %[3]s) (rate(%[1]s{%[2]s, %[4]s=~"5.."}[5m]))
+  / sum by (instance, %[3]s) (rate(%[1]s{%[2]s}[5m])) > %[5]g`,
+					MetricRequests, unscripted, LabelEndpoint, LabelStatus, errorRatioThreshold),
+				For:    "10m",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "Mitz replicator {{ $labels.instance }} answers {{ $labels.endpoint }} with server errors",
+					"description": fmt.Sprintf("More than %g%% of the {{ $labels.endpoint }} requests no scenario matched get a 5xx response ({{ $value | humanizePercentage }}).", errorRatioThreshold*100),
+				},
+			},
+			{
+				Alert: "MitzReplicatorSlowResponses",
+				Expr: fmt.Sprintf(`histogram_quantile(0.95, sum by (instance, %[3]s, le) (rate(%[1]s_bucket{%[2]s}[5m]))) > %[4]g`,
+					MetricRequestDuration, unscripted, LabelEndpoint, latencyThreshold),
+				For:    "10m",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "Mitz replicator {{ $labels.instance }} is slow on {{ $labels.endpoint }}",
+					"description": fmt.Sprintf("The 95th percentile response time of unscripted {{ $labels.endpoint }} requests is above %gs ({{ $value | humanizeDuration }}).", latencyThreshold),
+				},
+			},
+			{
+				Alert:  "MitzReplicatorNotificationsFailing",
+				Expr:   fmt.Sprintf(`sum by (instance) (rate(%s{%s, %s=~"failed|dead-lettered"}[15m])) > 0`, MetricNotifications, sel, LabelResult),
+				For:    "15m",
+				Labels: map[string]string{"severity": "warning"},
+				Annotations: map[string]string{
+					"summary":     "Mitz replicator {{ $labels.instance }} cannot deliver notifications",
+					"description": "Subscription notifications have been failing for 15 minutes; check GET /admin/notifications/dead-letters for the subscriber endpoints.",
+				},
+			},
+			{
+				Alert:  "MitzReplicatorRequestAnomalies",
+				Expr:   fmt.Sprintf(`sum by (instance, %s, %s) (increase(%s{%s}[15m])) > 0`, LabelEndpoint, LabelMetric, MetricAnomalies, sel),
+				Labels: map[string]string{"severity": "info"},
+				Annotations: map[string]string{
+					"summary":     "Unusual {{ $labels.endpoint }} requests on {{ $labels.instance }}",
+					"description": "A client's {{ $labels.metric }} deviates from its baseline; see GET /admin/anomalies.",
+				},
+			},
+		},
+	}}}
+}
+
+// AlertsYAML renders Alerts(job) as a Prometheus rule file.
+func AlertsYAML(job string) ([]byte, error) {
+	return yaml.Marshal(Alerts(job))
+}
@@ -0,0 +1,107 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// dashboardUID is the fixed uid of the generated dashboard, so importing a newer
+// version replaces the old one.
+const dashboardUID = "mitz-replicator"
+
+// Dashboard returns a Grafana dashboard for the replicators scraped by job, with
+// datasource, instance, endpoint and scenario variables.
+func Dashboard(job string) map[string]any {
+	sel := fmt.Sprintf(`job=%q, instance=~"$instance", %s=~"$endpoint", %s=~"$scenario"`, job, LabelEndpoint, LabelScenario)
+	unscripted := fmt.Sprintf(`job=%q, instance=~"$instance", %s=~"$endpoint", %s=%q`, job, LabelEndpoint, LabelScenario, ScenarioNone)
+	instanceSel := fmt.Sprintf(`job=%q, instance=~"$instance"`, job)
+
+	panels := []map[string]any{
+		panel("Requests per second by endpoint", "reqps", 0, 0,
+			target(fmt.Sprintf(`sum by (%s) (rate(%s{%s}[$__rate_interval]))`, LabelEndpoint, MetricRequests, sel), "{{"+LabelEndpoint+"}}")),
+		panel("Responses by status", "reqps", 12, 0,
+			target(fmt.Sprintf(`sum by (%s) (rate(%s{%s}[$__rate_interval]))`, LabelStatus, MetricRequests, sel), "{{"+LabelStatus+"}}")),
+		panel("Requests per second by scenario", "reqps", 0, 8,
+			target(fmt.Sprintf(`sum by (%s) (rate(%s{%s}[$__rate_interval]))`, LabelScenario, MetricRequests, sel), "{{"+LabelScenario+"}}")),
+		panel("Unscripted 5xx ratio", "percentunit", 12, 8,
+			target(fmt.Sprintf(`sum by (%[3]s) (rate(%[1]s{%[2]s, %[4]s=~"5.."}[$__rate_interval])) / sum by (%[3]s) (rate(%[1]s{%[2]s}[$__rate_interval]))`,
+				MetricRequests, unscripted, LabelEndpoint, LabelStatus), "{{"+LabelEndpoint+"}}")),
+		panel("Response time by endpoint", "s", 0, 16,
+			target(fmt.Sprintf(`histogram_quantile(0.5, sum by (%s, le) (rate(%s_bucket{%s}[$__rate_interval])))`, LabelEndpoint, MetricRequestDuration, sel), "p50 {{"+LabelEndpoint+"}}"),
+			target(fmt.Sprintf(`histogram_quantile(0.95, sum by (%s, le) (rate(%s_bucket{%s}[$__rate_interval])))`, LabelEndpoint, MetricRequestDuration, sel), "p95 {{"+LabelEndpoint+"}}"),
+			target(fmt.Sprintf(`histogram_quantile(0.99, sum by (%s, le) (rate(%s_bucket{%s}[$__rate_interval])))`, LabelEndpoint, MetricRequestDuration, sel), "p99 {{"+LabelEndpoint+"}}")),
+		panel("Response time by scenario (p95)", "s", 12, 16,
+			target(fmt.Sprintf(`histogram_quantile(0.95, sum by (%s, le) (rate(%s_bucket{%s}[$__rate_interval])))`, LabelScenario, MetricRequestDuration, sel), "{{"+LabelScenario+"}}")),
+		panel("Notifications by result", "ops", 0, 24,
+			target(fmt.Sprintf(`sum by (%s) (rate(%s{%s}[$__rate_interval]))`, LabelResult, MetricNotifications, instanceSel), "{{"+LabelResult+"}}")),
+		panel("Request anomalies", "short", 12, 24,
+			target(fmt.Sprintf(`sum by (%s, %s) (increase(%s{%s}[$__rate_interval]))`, LabelEndpoint, LabelMetric, MetricAnomalies, instanceSel), "{{"+LabelEndpoint+"}} {{"+LabelMetric+"}}")),
+	}
+	for i, p := range panels {
+		p["id"] = i + 1
+		for j, t := range p["targets"].([]map[string]any) {
+			t["refId"] = string(rune('A' + j))
+		}
+	}
+
+	return map[string]any{
+		"uid":           dashboardUID,
+		"title":         "Mitz replicator",
+		"tags":          []string{"mitz", "mitz-replicator"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"templating": map[string]any{"list": []map[string]any{
+			{"name": "datasource", "label": "Data source", "type": "datasource", "query": "prometheus"},
+			labelVariable("instance", "Instance", fmt.Sprintf(`label_values(%s{job=%q}, instance)`, MetricRequests, job)),
+			labelVariable(LabelEndpoint, "Endpoint", fmt.Sprintf(`label_values(%s{job=%q}, %s)`, MetricRequests, job, LabelEndpoint)),
+			labelVariable(LabelScenario, "Scenario", fmt.Sprintf(`label_values(%s{job=%q}, %s)`, MetricRequests, job, LabelScenario)),
+		}},
+		"panels": panels,
+	}
+}
+
+// DashboardJSON renders Dashboard(job) for import into Grafana.
+func DashboardJSON(job string) ([]byte, error) {
+	return json.MarshalIndent(Dashboard(job), "", "  ")
+}
+
+// panel returns a half-width time series panel at x, y.
+func panel(title, unit string, x, y int, targets ...map[string]any) map[string]any {
+	return map[string]any{
+		"type":       "timeseries",
+		"title":      title,
+		"datasource": datasource(),
+		"gridPos":    map[string]int{"x": x, "y": y, "w": 12, "h": 8},
+		"fieldConfig": map[string]any{
+			"defaults":  map[string]any{"unit": unit},
+			"overrides": []any{},
+		},
+		"targets": targets,
+	}
+}
+
+func target(expr, legend string) map[string]any {
+	return map[string]any{"datasource": datasource(), "expr": expr, "legendFormat": legend}
+}
+
+// labelVariable returns a multi-value query variable over a label, defaulting to all values.
+func labelVariable(name, label, query string) map[string]any {
+	return map[string]any{
+		"name":       name,
+		"label":      label,
+		"type":       "query",
+		"datasource": datasource(),
+		"query":      map[string]string{"query": query, "refId": name},
+		"refresh":    2,
+		"multi":      true,
+		"includeAll": true,
+		"allValue":   ".*",
+		"current":    map[string]any{"text": "All", "value": "$__all"},
+	}
+}
+
+func datasource() map[string]string {
+	return map[string]string{"type": "prometheus", "uid": "${datasource}"}
+}
@@ -0,0 +1,32 @@
+// Package monitoring generates Prometheus alert rules and a Grafana dashboard for
+// the replicator. The metric and label names below are the contract the metrics
+// endpoint exposes, so the generated monitoring works against it unchanged.
+package monitoring
+
+// Metric names.
+const (
+	// MetricRequests counts answered requests by endpoint, status and scenario.
+	MetricRequests = "mitz_replicator_requests_total"
+	// MetricRequestDuration is a histogram of response times by endpoint and scenario.
+	MetricRequestDuration = "mitz_replicator_request_duration_seconds"
+	// MetricNotifications counts subscription notification attempts by result.
+	MetricNotifications = "mitz_replicator_notifications_total"
+	// MetricAnomalies counts request anomalies by endpoint and metric (see package anomaly).
+	MetricAnomalies = "mitz_replicator_anomalies_total"
+)
+
+// Label names.
+const (
+	LabelEndpoint = "endpoint" // xacml, xcpd, fhir or admin
+	LabelStatus   = "status"   // HTTP status code, e.g. "200"
+	LabelScenario = "scenario" // name of the matched rule, or ScenarioNone
+	LabelResult   = "result"   // notifications: delivered, failed or dead-lettered
+	LabelMetric   = "metric"   // anomalies: size, elements or headers
+)
+
+// ScenarioNone is the scenario label of requests no rule matched. Faults and slow
+// responses of scripted scenarios are intended, so alerts only look at these.
+const ScenarioNone = "none"
+
+// DefaultJob is the Prometheus job name the generated monitoring selects on.
+const DefaultJob = "mitz-replicator"
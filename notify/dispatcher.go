@@ -0,0 +1,397 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Notification is a single rest-hook delivery to a subscriber endpoint.
+type Notification struct {
+	ID             string // notification Bundle id; acknowledgments refer to it
+	SubscriptionID string
+	Endpoint       string
+	ContentType    string
+	Body           []byte
+	Attempt        int       // number of delivery attempts made so far
+	Deadline       time.Time // no attempts after this end-to-end latency budget (zero = none)
+
+	// Done, if set, is called after each successful delivery with nil, and with the
+	// last error when the notification is dead-lettered.
+	Done func(err error)
+}
+
+// ErrBudgetExceeded is the error of a notification dead-lettered at its Deadline.
+var ErrBudgetExceeded = errors.New("latency budget exceeded")
+
+// ErrQueueFull is the error of a retry dead-lettered because the queue was full.
+var ErrQueueFull = errors.New("notification queue full")
+
+// RetryPolicy controls redelivery of failed notifications with exponential backoff.
+type RetryPolicy struct {
+	MaxAttempts    int           // total attempts including the first (<= 1 disables retries)
+	InitialBackoff time.Duration // delay before the second attempt
+	MaxBackoff     time.Duration // upper bound for the delay between attempts
+	AckTimeout     time.Duration // wait this long for an acknowledgment after delivery (0 = a 2xx is enough)
+}
+
+// backoff returns the delay before the given (1-based) attempt number.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+
+	d := p.InitialBackoff
+	for i := 2; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+// DeadLetter is a notification that exhausted its delivery attempts.
+type DeadLetter struct {
+	SubscriptionID string    `json:"subscriptionId"`
+	Endpoint       string    `json:"endpoint"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"lastError"`
+	LastStatus     int       `json:"lastStatus,omitempty"`
+	FailedAt       time.Time `json:"failedAt"`
+	Body           string    `json:"body"`
+}
+
+// Unacked is a delivered notification that the subscriber has not acknowledged yet.
+type Unacked struct {
+	NotificationID string    `json:"notificationId"`
+	SubscriptionID string    `json:"subscriptionId"`
+	Endpoint       string    `json:"endpoint"`
+	Attempts       int       `json:"attempts"`
+	DeliveredAt    time.Time `json:"deliveredAt"`
+}
+
+// AckStats counts acknowledgment outcomes since the last reset.
+type AckStats struct {
+	Acknowledged int `json:"acknowledged"`
+	TimedOut     int `json:"timedOut"`
+}
+
+type pendingAck struct {
+	n           Notification
+	deliveredAt time.Time
+	timer       *time.Timer
+}
+
+// Dispatcher delivers notifications asynchronously from a background goroutine.
+type Dispatcher struct {
+	client *http.Client
+	queue  chan Notification
+	retry  RetryPolicy
+
+	mu          sync.Mutex
+	deadLetters []DeadLetter
+	pending     map[string]*pendingAck
+	ackStats    AckStats
+}
+
+// NewDispatcher creates a dispatcher with a buffered queue of queueSize notifications.
+func NewDispatcher(client *http.Client, queueSize int, retry RetryPolicy) *Dispatcher {
+
+	return &Dispatcher{
+		client:  client,
+		queue:   make(chan Notification, queueSize),
+		retry:   retry,
+		pending: make(map[string]*pendingAck),
+	}
+}
+
+// AcksRequired reports whether delivered notifications must be acknowledged.
+func (d *Dispatcher) AcksRequired() bool {
+	return d.retry.AckTimeout > 0
+}
+
+// Ack records the subscriber's acknowledgment of a delivered notification. It returns
+// false when no such notification is awaiting acknowledgment for the subscription.
+func (d *Dispatcher) Ack(subscriptionID, notificationID string) bool {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok := d.pending[notificationID]
+	if !ok || p.n.SubscriptionID != subscriptionID {
+		return false
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	delete(d.pending, notificationID)
+	d.ackStats.Acknowledged++
+
+	log.Printf("[NOTIFY] Acknowledged Subscription/%s notification=%s attempt=%d after %s",
+		subscriptionID, notificationID, p.n.Attempt, time.Since(p.deliveredAt))
+	return true
+}
+
+// Unacked returns the notifications awaiting acknowledgment, oldest delivery first.
+func (d *Dispatcher) Unacked() []Unacked {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	unacked := make([]Unacked, 0, len(d.pending))
+	for _, p := range d.pending {
+		unacked = append(unacked, Unacked{
+			NotificationID: p.n.ID,
+			SubscriptionID: p.n.SubscriptionID,
+			Endpoint:       p.n.Endpoint,
+			Attempts:       p.n.Attempt,
+			DeliveredAt:    p.deliveredAt,
+		})
+	}
+	slices.SortFunc(unacked, func(a, b Unacked) int { return a.DeliveredAt.Compare(b.DeliveredAt) })
+	return unacked
+}
+
+// AckStats returns the acknowledgment counters.
+func (d *Dispatcher) AckStats() AckStats {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.ackStats
+}
+
+// ClearUnacked forgets the notifications awaiting acknowledgment whose subscription
+// match selects. A nil match forgets all of them and also resets the counters.
+func (d *Dispatcher) ClearUnacked(match func(subscriptionID string) bool) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id, p := range d.pending {
+		if match != nil && !match(p.n.SubscriptionID) {
+			continue
+		}
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		delete(d.pending, id)
+	}
+	if match == nil {
+		d.ackStats = AckStats{}
+	}
+}
+
+// DeadLetters returns the notifications that exhausted all delivery attempts, oldest first.
+func (d *Dispatcher) DeadLetters() []DeadLetter {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]DeadLetter(nil), d.deadLetters...)
+}
+
+// ClearDeadLetters discards the recorded dead letters whose subscription match
+// selects, or all of them for a nil match.
+func (d *Dispatcher) ClearDeadLetters(match func(subscriptionID string) bool) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if match == nil {
+		d.deadLetters = nil
+		return
+	}
+	d.deadLetters = slices.DeleteFunc(d.deadLetters, func(dl DeadLetter) bool { return match(dl.SubscriptionID) })
+}
+
+// Run delivers queued notifications until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-d.queue:
+			d.deliver(ctx, n)
+		}
+	}
+}
+
+// Enqueue schedules a notification for delivery. It returns false (and drops the
+// notification) when the queue is full.
+func (d *Dispatcher) Enqueue(n Notification) bool {
+
+	if d.tryEnqueue(n) {
+		return true
+	}
+	log.Printf("[NOTIFY] Queue full — dropping notification for Subscription/%s", n.SubscriptionID)
+	return false
+}
+
+func (d *Dispatcher) tryEnqueue(n Notification) bool {
+
+	select {
+	case d.queue <- n:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, n Notification) {
+
+	if !n.Deadline.IsZero() && time.Now().After(n.Deadline) {
+		d.deadLetter(n, 0, fmt.Errorf("%w before attempt %d", ErrBudgetExceeded, n.Attempt+1))
+		return
+	}
+
+	n.Attempt++
+	d.expectAck(n)
+	start := time.Now()
+	status, err := d.post(ctx, n)
+	if err == nil {
+		log.Printf("[NOTIFY] Delivered Subscription/%s endpoint=%s status=%d attempt=%d duration=%s",
+			n.SubscriptionID, n.Endpoint, status, n.Attempt, time.Since(start))
+		d.startAckTimer(ctx, n.ID)
+		if n.Done != nil {
+			n.Done(nil)
+		}
+		return
+	}
+
+	d.forgetAck(n.ID)
+	d.retryOrDeadLetter(ctx, n, status, err)
+}
+
+// retryOrDeadLetter schedules another attempt, or records a dead letter when the
+// attempts are exhausted.
+func (d *Dispatcher) retryOrDeadLetter(ctx context.Context, n Notification, status int, err error) {
+
+	if n.Attempt < d.retry.MaxAttempts {
+		delay := d.retry.backoff(n.Attempt + 1)
+		if !n.Deadline.IsZero() && time.Now().Add(delay).After(n.Deadline) {
+			d.deadLetter(n, status, fmt.Errorf("%w after attempt %d: %v", ErrBudgetExceeded, n.Attempt, err))
+			return
+		}
+		log.Printf("[NOTIFY] Delivery failed Subscription/%s endpoint=%s attempt=%d/%d: %v — retrying in %s",
+			n.SubscriptionID, n.Endpoint, n.Attempt, d.retry.MaxAttempts, err, delay)
+		time.AfterFunc(delay, func() {
+			if ctx.Err() == nil && !d.tryEnqueue(n) {
+				d.deadLetter(n, status, fmt.Errorf("%w on retry after attempt %d: %v", ErrQueueFull, n.Attempt, err))
+			}
+		})
+		return
+	}
+
+	d.deadLetter(n, status, err)
+}
+
+// deadLetter records a notification that will not be delivered.
+func (d *Dispatcher) deadLetter(n Notification, status int, err error) {
+
+	log.Printf("[NOTIFY] Delivery failed Subscription/%s endpoint=%s attempt=%d: %v — moved to dead-letter list",
+		n.SubscriptionID, n.Endpoint, n.Attempt, err)
+
+	d.mu.Lock()
+	d.deadLetters = append(d.deadLetters, DeadLetter{
+		SubscriptionID: n.SubscriptionID,
+		Endpoint:       n.Endpoint,
+		Attempts:       n.Attempt,
+		LastError:      err.Error(),
+		LastStatus:     status,
+		FailedAt:       time.Now(),
+		Body:           string(n.Body),
+	})
+	d.mu.Unlock()
+
+	if n.Done != nil {
+		n.Done(err)
+	}
+}
+
+// expectAck registers the notification as awaiting acknowledgment before it is sent,
+// so acknowledgments arriving before the delivery response are not lost.
+func (d *Dispatcher) expectAck(n Notification) {
+
+	if !d.AcksRequired() || n.ID == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if old, ok := d.pending[n.ID]; ok && old.timer != nil {
+		old.timer.Stop()
+	}
+	d.pending[n.ID] = &pendingAck{n: n, deliveredAt: time.Now()}
+}
+
+// startAckTimer redelivers the notification if it is not acknowledged within AckTimeout.
+func (d *Dispatcher) startAckTimer(ctx context.Context, id string) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok := d.pending[id]
+	if !ok {
+		return // not required, or already acknowledged
+	}
+	p.timer = time.AfterFunc(d.retry.AckTimeout, func() {
+		d.mu.Lock()
+		current, ok := d.pending[id]
+		if !ok || current != p {
+			d.mu.Unlock()
+			return
+		}
+		delete(d.pending, id)
+		d.ackStats.TimedOut++
+		d.mu.Unlock()
+
+		if ctx.Err() == nil {
+			d.retryOrDeadLetter(ctx, p.n, 0, fmt.Errorf("not acknowledged within %s", d.retry.AckTimeout))
+		}
+	})
+}
+
+// forgetAck drops a pending acknowledgment after a failed delivery.
+func (d *Dispatcher) forgetAck(id string) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.pending, id)
+}
+
+// post sends the notification and returns the HTTP status. Non-2xx responses are errors.
+func (d *Dispatcher) post(ctx context.Context, n Notification) (int, error) {
+
+	if !n.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, n.Deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Endpoint, bytes.NewReader(n.Body))
+	if err != nil {
+		return 0, fmt.Errorf("invalid notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", n.ContentType)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("subscriber returned HTTP %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
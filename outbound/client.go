@@ -0,0 +1,73 @@
+package outbound
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ClientConfig configures HTTP clients used for calls the replicator makes itself
+// (notification delivery, upstream forwarding).
+type ClientConfig struct {
+	Timeout            time.Duration
+	CertFile           string   // PEM client certificate presented for mTLS (optional)
+	KeyFile            string   // PEM private key for CertFile
+	CAFiles            []string // PEM CA bundles trusted for server and proxy certificates (optional, add to system roots)
+	InsecureSkipVerify bool     // skip server certificate verification (local testing only)
+	ProxyURL           string   // proxy for all calls, credentials in the URL (default: HTTP(S)_PROXY environment)
+	NoProxy            []string // hosts, domains, host:port or CIDRs reached directly
+}
+
+// NewClient builds an HTTP client from cfg.
+func NewClient(cfg ClientConfig) (*http.Client, error) {
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("outbound client certificate and key must be configured together")
+		}
+		pair, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load outbound client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	if len(cfg.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, caFile := range cfg.CAFiles {
+			caPEM, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read outbound CA bundle %s: %w", caFile, err)
+			}
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, fmt.Errorf("no certificates found in outbound CA bundle %s", caFile)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	proxy, err := proxyFunc(cfg.ProxyURL, cfg.NoProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+	}, nil
+}
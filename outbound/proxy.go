@@ -0,0 +1,81 @@
+package outbound
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// proxyFunc returns the transport's proxy selector: proxyURL if set, otherwise the
+// HTTP_PROXY / HTTPS_PROXY / NO_PROXY environment, in both cases skipping hosts
+// matched by noProxy.
+func proxyFunc(proxyURL string, noProxy []string) (func(*http.Request) (*url.URL, error), error) {
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid outbound proxy URL %q", proxyURL)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			return nil, fmt.Errorf("unsupported outbound proxy scheme %q (expected http, https or socks5)", u.Scheme)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	if len(noProxy) == 0 {
+		return proxy, nil
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		return proxy(req)
+	}, nil
+}
+
+// bypassProxy reports whether target matches a no-proxy entry: "*", a host name
+// (also matching its subdomains; a leading dot is optional), host:port, an IP
+// address or a CIDR range.
+func bypassProxy(target *url.URL, noProxy []string) bool {
+	host := strings.ToLower(target.Hostname())
+	port := target.Port()
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		entryHost = strings.TrimPrefix(entryHost, ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactedProxy returns proxyURL with any password masked, for logging.
+func RedactedProxy(proxyURL string) string {
+	if u, err := url.Parse(proxyURL); err == nil {
+		return u.Redacted()
+	}
+	return proxyURL
+}
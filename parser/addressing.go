@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// nsWSA is the WS-Addressing 1.0 namespace.
+const nsWSA = "http://www.w3.org/2005/08/addressing"
+
+// Addressing holds the WS-Addressing headers of a SOAP request.
+type Addressing struct {
+	MessageID string
+	Action    string
+	To        string
+	ReplyTo   string // ReplyTo/Address
+}
+
+// ParseAddressing returns the WS-Addressing headers of a SOAP envelope, or nil when
+// it has none or cannot be parsed.
+func ParseAddressing(body []byte) *Addressing {
+	var env struct {
+		Header struct {
+			MessageID string `xml:"http://www.w3.org/2005/08/addressing MessageID"`
+			Action    string `xml:"http://www.w3.org/2005/08/addressing Action"`
+			To        string `xml:"http://www.w3.org/2005/08/addressing To"`
+			ReplyTo   struct {
+				Address string `xml:"http://www.w3.org/2005/08/addressing Address"`
+			} `xml:"http://www.w3.org/2005/08/addressing ReplyTo"`
+		} `xml:"Header"`
+	}
+	if err := xml.Unmarshal(body, &env); err != nil {
+		return nil
+	}
+
+	h := env.Header
+	a := &Addressing{
+		MessageID: strings.TrimSpace(h.MessageID),
+		Action:    strings.TrimSpace(h.Action),
+		To:        strings.TrimSpace(h.To),
+		ReplyTo:   strings.TrimSpace(h.ReplyTo.Address),
+	}
+	if *a == (Addressing{}) {
+		return nil
+	}
+	return a
+}
@@ -0,0 +1,106 @@
+package parser_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"mitz-replicator/parser"
+)
+
+// benchSizes are the payload sizes each benchmark runs with: a single item, a
+// typical multi-category request, and a worst case well beyond what clients send.
+var benchSizes = []int{1, 10, 100}
+
+var benchCategories = []string{"huisartsgegevens", "medicatiegegevens", "labuitslagen", "beeldvorming", "opnamegegevens"}
+
+func BenchmarkParseXACMLRequest(b *testing.B) {
+	for _, n := range benchSizes {
+		body := xacmlRequest(n)
+		b.Run(fmt.Sprintf("codes=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for b.Loop() {
+				if _, err := parser.ParseXACMLRequest(body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParseFhirBundle(b *testing.B) {
+	for _, n := range benchSizes {
+		body := fhirBundle(n)
+		b.Run(fmt.Sprintf("categories=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for b.Loop() {
+				if _, err := parser.ParseFhirBundle(body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// xacmlRequest returns an XACMLAuthzDecisionQuery asking for n event codes.
+func xacmlRequest(n int) []byte {
+	var codes strings.Builder
+	for i := range n {
+		fmt.Fprintf(&codes, `
+          <xacml-context:Attribute AttributeId="urn:ihe:iti:appc:2016:document-entry:event-code">
+            <xacml-context:AttributeValue>2.16.840.1.113883.2.4.3.111.5.10.1^%s</xacml-context:AttributeValue>
+          </xacml-context:Attribute>`, benchCategories[i%len(benchCategories)])
+	}
+
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope">
+  <soap:Body>
+    <xacml-samlp:XACMLAuthzDecisionQuery xmlns:xacml-samlp="urn:oasis:names:tc:xacml:3.0:profile:saml2.0:v2:schema:protocol" xmlns:xacml-context="urn:oasis:names:tc:xacml:3.0:core:schema:wd-17">
+      <xacml-context:Request>
+        <xacml-context:Attributes Category="urn:oasis:names:tc:xacml:3.0:attribute-category:resource">
+          <xacml-context:Attribute AttributeId="urn:oasis:names:tc:xacml:2.0:resource:resource-id">
+            <xacml-context:AttributeValue>999999011</xacml-context:AttributeValue>
+          </xacml-context:Attribute>
+        </xacml-context:Attributes>
+        <xacml-context:Attributes Category="urn:oasis:names:tc:xacml:3.0:attribute-category:action">` + codes.String() + `
+        </xacml-context:Attributes>
+      </xacml-context:Request>
+    </xacml-samlp:XACMLAuthzDecisionQuery>
+  </soap:Body>
+</soap:Envelope>`)
+}
+
+// fhirBundle returns a transaction Bundle registering a Consent with n categories.
+func fhirBundle(n int) []byte {
+	var provisions strings.Builder
+	for i := range n {
+		fmt.Fprintf(&provisions, `
+          <provision><code><coding><system value="2.16.840.1.113883.2.4.3.111.5.10.1"/><code value="%s"/></coding></code></provision>`, benchCategories[i%len(benchCategories)])
+	}
+
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Bundle xmlns="http://hl7.org/fhir">
+  <type value="transaction"/>
+  <entry>
+    <resource><Patient><identifier><system value="http://fhir.nl/fhir/NamingSystem/bsn"/><value value="999999011"/></identifier></Patient></resource>
+    <request><method value="POST"/><url value="Patient"/></request>
+  </entry>
+  <entry>
+    <resource><Organization><identifier><system value="http://fhir.nl/fhir/NamingSystem/ura"/><value value="12345678"/></identifier></Organization></resource>
+    <request><method value="POST"/><url value="Organization"/></request>
+  </entry>
+  <entry>
+    <resource>
+      <Consent>
+        <status value="active"/>
+        <provision>
+          <type value="permit"/>` + provisions.String() + `
+        </provision>
+      </Consent>
+    </resource>
+    <request><method value="POST"/><url value="Consent"/></request>
+  </entry>
+</Bundle>`)
+}
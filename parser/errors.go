@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error kinds returned by the parse functions. Errors are wrapped, so use
+// errors.Is to branch on the kind.
+var (
+	// ErrMissingBSN indicates the request carries no patient BSN.
+	ErrMissingBSN = errors.New("missing patient BSN")
+
+	// ErrSchemaViolation indicates the body is not well-formed or does not match the expected structure.
+	ErrSchemaViolation = errors.New("schema violation")
+
+	// ErrUnsupportedInteraction indicates a well-formed message of a type the endpoint does not handle.
+	ErrUnsupportedInteraction = errors.New("unsupported interaction")
+
+	// ErrMissingAttribute indicates a strict request lacks a required XACML attribute; the
+	// error is a *MissingAttributeError naming the attributes.
+	ErrMissingAttribute = errors.New("missing required attribute")
+)
+
+// MissingAttributeError lists the required XACML attributes a request lacks.
+type MissingAttributeError struct {
+	Attributes []XACMLAttribute
+}
+
+func (e *MissingAttributeError) Error() string {
+	names := make([]string, len(e.Attributes))
+	for i, a := range e.Attributes {
+		names[i] = a.Name
+	}
+	return fmt.Sprintf("%s: XACML request has no %s", ErrMissingAttribute, strings.Join(names, ", "))
+}
+
+func (e *MissingAttributeError) Unwrap() error {
+	return ErrMissingAttribute
+}
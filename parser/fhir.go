@@ -12,7 +12,9 @@ import (
 type FhirSubscriptionRequest struct {
 	BSN         string
 	ProviderID  string
-	Criteria    string
+	Criteria    string     // Consent search; the filter criteria of a topic-based Subscription
+	Categories  [][]string // gegevenscategorie filter from the criteria's category parameters (see CategoryFilter); empty = all
+	Topic       string     // SubscriptionTopic canonical of a topic-based (R4B backport) Subscription
 	Endpoint    string
 	PayloadType string
 }
@@ -22,8 +24,14 @@ type FhirBundleRequest struct {
 	BSN             string
 	BundleType      string
 	HasConsent      bool
+	ConsentStatus   string
+	ConsentDecision string   // provision.type: permit or deny
+	ConsentStart    string   // provision.period.start, as sent
+	ConsentEnd      string   // provision.period.end, as sent
+	Categories      []string // gegevenscategorie codes from (nested) provision.code
 	HasProvenance   bool
 	HasOrganization bool
+	ProviderID      string // Organization identifier (URA)
 	EntryCount      int
 }
 
@@ -35,14 +43,34 @@ func stripFhirNamespace(body []byte) []byte {
 	return fhirNsRe.ReplaceAll(body, nil)
 }
 
+// rootElementName returns the local name of the document element, or "" if none is found.
+func rootElementName(body []byte) string {
+	return rootElement(body).Local
+}
+
 type fhirValueAttr struct {
 	Value string `xml:"value,attr"`
 }
 
 type fhirSubscriptionXML struct {
-	XMLName  xml.Name       `xml:"Subscription"`
-	Criteria fhirValueAttr  `xml:"criteria"`
-	Channel  fhirChannelXML `xml:"channel"`
+	XMLName  xml.Name        `xml:"Subscription"`
+	Status   fhirValueAttr   `xml:"status"`
+	Criteria fhirCriteriaXML `xml:"criteria"`
+	Channel  fhirChannelXML  `xml:"channel"`
+}
+
+// backportFilterCriteria is the extension on Subscription.criteria carrying the
+// filter of a topic-based Subscription (Subscriptions R5 Backport, used on R4B).
+const backportFilterCriteria = "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-filter-criteria"
+
+type fhirCriteriaXML struct {
+	Value     string             `xml:"value,attr"`
+	Extension []fhirExtensionXML `xml:"extension"`
+}
+
+type fhirExtensionXML struct {
+	URL         string        `xml:"url,attr"`
+	ValueString fhirValueAttr `xml:"valueString"`
 }
 
 type fhirChannelXML struct {
@@ -53,11 +81,25 @@ type fhirChannelXML struct {
 
 // ParseFhirSubscription extracts BSN, provider ID, and channel info from a FHIR Subscription request.
 func ParseFhirSubscription(body []byte) (*FhirSubscriptionRequest, error) {
+	return ParseFhirSubscriptionWith(body, Strictness{})
+}
+
+// ParseFhirSubscriptionWith is ParseFhirSubscription with additional strictness checks.
+func ParseFhirSubscriptionWith(body []byte, strict Strictness) (*FhirSubscriptionRequest, error) {
+	if strict.Namespaces {
+		if err := requireNamespace(rootElement(body), nsFHIR); err != nil {
+			return nil, err
+		}
+	}
 	cleaned := stripFhirNamespace(body)
 
+	if name := rootElementName(cleaned); name != "" && name != "Subscription" {
+		return nil, fmt.Errorf("%w: expected Subscription resource, got %s", ErrUnsupportedInteraction, name)
+	}
+
 	var sub fhirSubscriptionXML
 	if err := xml.Unmarshal(cleaned, &sub); err != nil {
-		return nil, fmt.Errorf("failed to parse FHIR Subscription: %w", err)
+		return nil, fmt.Errorf("%w: failed to parse FHIR Subscription: %w", ErrSchemaViolation, err)
 	}
 
 	req := &FhirSubscriptionRequest{
@@ -65,18 +107,86 @@ func ParseFhirSubscription(body []byte) (*FhirSubscriptionRequest, error) {
 		Endpoint:    sub.Channel.Endpoint.Value,
 		PayloadType: sub.Channel.Payload.Value,
 	}
+	// A topic-based Subscription names the topic in criteria and filters in an extension
+	for _, ext := range sub.Criteria.Extension {
+		if ext.URL == backportFilterCriteria {
+			req.Topic, req.Criteria = sub.Criteria.Value, ext.ValueString.Value
+			break
+		}
+	}
 
 	// Parse BSN and provider ID from criteria query string
-	// Format: Consent?_query=otv&patientid={bsn}&providerid={ura}&providertype={type}
-	if idx := strings.Index(sub.Criteria.Value, "?"); idx >= 0 {
-		params, _ := url.ParseQuery(sub.Criteria.Value[idx+1:])
+	// Format: Consent?_query=otv&patientid={bsn}&providerid={ura}&providertype={type}[&category={codes}]
+	var params url.Values
+	if idx := strings.Index(req.Criteria, "?"); idx >= 0 {
+		params, _ = url.ParseQuery(req.Criteria[idx+1:])
 		req.BSN = params.Get("patientid")
 		req.ProviderID = params.Get("providerid")
+		req.Categories = CategoryFilter(params["category"])
+	}
+
+	if strict.Structure {
+		switch {
+		case req.Criteria == "":
+			return nil, fmt.Errorf("%w: Subscription.criteria is required", ErrSchemaViolation)
+		case req.BSN == "":
+			return nil, fmt.Errorf("%w: Subscription.criteria has no patientid", ErrMissingBSN)
+		case req.ProviderID == "":
+			return nil, fmt.Errorf("%w: Subscription.criteria has no providerid", ErrSchemaViolation)
+		case sub.Channel.Type.Value != "rest-hook":
+			return nil, fmt.Errorf("%w: Subscription.channel.type must be rest-hook, got %q", ErrSchemaViolation, sub.Channel.Type.Value)
+		case req.Endpoint == "":
+			return nil, fmt.Errorf("%w: Subscription.channel.endpoint is required", ErrSchemaViolation)
+		}
+	}
+	if strict.Required {
+		switch {
+		case sub.Status.Value == "":
+			return nil, fmt.Errorf("%w: Subscription.status is required", ErrSchemaViolation)
+		case !strings.HasPrefix(req.Criteria, "Consent?"):
+			return nil, fmt.Errorf("%w: Subscription.criteria must search Consent, got %q", ErrSchemaViolation, req.Criteria)
+		case req.BSN == "":
+			return nil, fmt.Errorf("%w: Subscription.criteria has no patientid", ErrMissingBSN)
+		case !validBSN(req.BSN):
+			return nil, fmt.Errorf("%w: Subscription.criteria patientid must be a 9-digit BSN, got %q", ErrSchemaViolation, req.BSN)
+		case req.ProviderID == "":
+			return nil, fmt.Errorf("%w: Subscription.criteria has no providerid", ErrSchemaViolation)
+		case params.Get("providertype") == "":
+			return nil, fmt.Errorf("%w: Subscription.criteria has no providertype", ErrSchemaViolation)
+		case sub.Channel.Type.Value != "rest-hook":
+			return nil, fmt.Errorf("%w: Subscription.channel.type must be rest-hook, got %q", ErrSchemaViolation, sub.Channel.Type.Value)
+		case req.Endpoint == "":
+			return nil, fmt.Errorf("%w: Subscription.channel.endpoint is required", ErrSchemaViolation)
+		case req.PayloadType == "":
+			return nil, fmt.Errorf("%w: Subscription.channel.payload is required", ErrSchemaViolation)
+		}
 	}
 
 	return req, nil
 }
 
+// CategoryFilter returns the gegevenscategorie codes of category search values, as
+// FHIR search combines them: one group per repeated parameter (AND), holding its
+// comma-separated codes (OR). A "system|" prefix is dropped.
+func CategoryFilter(values []string) [][]string {
+	var filter [][]string
+	for _, value := range values {
+		var group []string
+		for token := range strings.SplitSeq(value, ",") {
+			if _, code, ok := strings.Cut(token, "|"); ok {
+				token = code
+			}
+			if token = strings.TrimSpace(token); token != "" {
+				group = append(group, token)
+			}
+		}
+		if len(group) > 0 {
+			filter = append(filter, group)
+		}
+	}
+	return filter
+}
+
 // --- FHIR Bundle parsing ---
 
 type fhirBundleXML struct {
@@ -90,10 +200,10 @@ type fhirEntryXML struct {
 }
 
 type fhirResourceXML struct {
-	Patient      *fhirPatientXML `xml:"Patient"`
-	Consent      *fhirAnyXML     `xml:"Consent"`
-	Provenance   *fhirAnyXML     `xml:"Provenance"`
-	Organization *fhirAnyXML     `xml:"Organization"`
+	Patient      *fhirPatientXML      `xml:"Patient"`
+	Consent      *fhirConsentXML      `xml:"Consent"`
+	Provenance   *fhirAnyXML          `xml:"Provenance"`
+	Organization *fhirOrganizationXML `xml:"Organization"`
 }
 
 // fhirAnyXML is a placeholder for any FHIR resource we only need to detect (presence check).
@@ -101,6 +211,52 @@ type fhirAnyXML struct {
 	XMLName xml.Name
 }
 
+type fhirConsentXML struct {
+	Status    fhirValueAttr    `xml:"status"`
+	Provision fhirProvisionXML `xml:"provision"`
+}
+
+type fhirProvisionXML struct {
+	Type      fhirValueAttr            `xml:"type"`
+	Period    fhirPeriodXML            `xml:"period"`
+	Code      []fhirCodeableConceptXML `xml:"code"`
+	Provision []fhirProvisionXML       `xml:"provision"`
+}
+
+type fhirPeriodXML struct {
+	Start fhirValueAttr `xml:"start"`
+	End   fhirValueAttr `xml:"end"`
+}
+
+type fhirCodeableConceptXML struct {
+	Coding []fhirCodingXML `xml:"coding"`
+}
+
+type fhirCodingXML struct {
+	System fhirValueAttr `xml:"system"`
+	Code   fhirValueAttr `xml:"code"`
+}
+
+// codes collects the coding codes of this provision and its nested provisions.
+func (p fhirProvisionXML) codes() []string {
+	var codes []string
+	for _, cc := range p.Code {
+		for _, coding := range cc.Coding {
+			if coding.Code.Value != "" {
+				codes = append(codes, coding.Code.Value)
+			}
+		}
+	}
+	for _, nested := range p.Provision {
+		codes = append(codes, nested.codes()...)
+	}
+	return codes
+}
+
+type fhirOrganizationXML struct {
+	Identifier []fhirIdentifierXML `xml:"identifier"`
+}
+
 type fhirPatientXML struct {
 	Identifier fhirIdentifierXML `xml:"identifier"`
 }
@@ -112,32 +268,88 @@ type fhirIdentifierXML struct {
 
 // ParseFhirBundle extracts BSN and bundle metadata from a FHIR Bundle transaction request.
 func ParseFhirBundle(body []byte) (*FhirBundleRequest, error) {
+	return ParseFhirBundleWith(body, Strictness{})
+}
+
+// ParseFhirBundleWith is ParseFhirBundle with additional strictness checks.
+func ParseFhirBundleWith(body []byte, strict Strictness) (*FhirBundleRequest, error) {
+	if strict.Namespaces {
+		if err := requireNamespace(rootElement(body), nsFHIR); err != nil {
+			return nil, err
+		}
+	}
 	cleaned := stripFhirNamespace(body)
 
+	if name := rootElementName(cleaned); name != "" && name != "Bundle" {
+		return nil, fmt.Errorf("%w: expected Bundle resource, got %s", ErrUnsupportedInteraction, name)
+	}
+
 	var bundle fhirBundleXML
 	if err := xml.Unmarshal(cleaned, &bundle); err != nil {
-		return nil, fmt.Errorf("failed to parse FHIR Bundle: %w", err)
+		return nil, fmt.Errorf("%w: failed to parse FHIR Bundle: %w", ErrSchemaViolation, err)
 	}
 
 	req := &FhirBundleRequest{
 		BundleType: bundle.Type.Value,
 		EntryCount: len(bundle.Entry),
 	}
+	var bsnSystem, uraSystem string
 
 	for _, entry := range bundle.Entry {
 		if entry.Resource.Patient != nil {
 			req.BSN = entry.Resource.Patient.Identifier.Value.Value
+			bsnSystem = entry.Resource.Patient.Identifier.System.Value
 		}
 		if entry.Resource.Consent != nil {
 			req.HasConsent = true
+			req.ConsentStatus = entry.Resource.Consent.Status.Value
+			req.ConsentDecision = entry.Resource.Consent.Provision.Type.Value
+			req.ConsentStart = entry.Resource.Consent.Provision.Period.Start.Value
+			req.ConsentEnd = entry.Resource.Consent.Provision.Period.End.Value
+			req.Categories = entry.Resource.Consent.Provision.codes()
 		}
 		if entry.Resource.Provenance != nil {
 			req.HasProvenance = true
 		}
 		if entry.Resource.Organization != nil {
 			req.HasOrganization = true
+			if ids := entry.Resource.Organization.Identifier; len(ids) > 0 {
+				req.ProviderID = ids[0].Value.Value
+				uraSystem = ids[0].System.Value
+			}
+		}
+	}
+
+	if strict.Structure {
+		switch {
+		case req.BundleType == "":
+			return nil, fmt.Errorf("%w: Bundle.type is required", ErrSchemaViolation)
+		case req.EntryCount == 0:
+			return nil, fmt.Errorf("%w: Bundle has no entries", ErrSchemaViolation)
+		case req.BSN == "":
+			return nil, fmt.Errorf("%w: Bundle has no Patient with a BSN identifier", ErrMissingBSN)
+		}
+	}
+	if strict.Required {
+		switch {
+		case req.BundleType != "transaction":
+			return nil, fmt.Errorf("%w: Bundle.type must be transaction, got %q", ErrSchemaViolation, req.BundleType)
+		case req.BSN == "":
+			return nil, fmt.Errorf("%w: Bundle has no Patient with a BSN identifier", ErrMissingBSN)
+		case bsnSystem != systemBSN:
+			return nil, fmt.Errorf("%w: Patient.identifier.system must be %s, got %q", ErrSchemaViolation, systemBSN, bsnSystem)
+		case !validBSN(req.BSN):
+			return nil, fmt.Errorf("%w: Patient.identifier.value must be a 9-digit BSN, got %q", ErrSchemaViolation, req.BSN)
+		case !req.HasOrganization || req.ProviderID == "":
+			return nil, fmt.Errorf("%w: Bundle has no Organization with a URA identifier", ErrSchemaViolation)
+		case uraSystem != systemURA:
+			return nil, fmt.Errorf("%w: Organization.identifier.system must be %s, got %q", ErrSchemaViolation, systemURA, uraSystem)
+		case !req.HasConsent:
+			return nil, fmt.Errorf("%w: Bundle has no Consent", ErrSchemaViolation)
+		case req.ConsentStatus == "":
+			return nil, fmt.Errorf("%w: Consent.status is required", ErrSchemaViolation)
 		}
 	}
 
 	return req, nil
-}
\ No newline at end of file
+}
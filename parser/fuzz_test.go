@@ -0,0 +1,96 @@
+package parser_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"mitz-replicator/fuzzgen"
+	"mitz-replicator/parser"
+)
+
+// seedCount is the number of fuzzgen payloads, valid and mutated, seeded per target.
+const seedCount = 32
+
+// seed adds the example artifact and fuzzgen payloads of kind to the corpus, each
+// under every strictness.
+func seed(f *testing.F, kind fuzzgen.Kind, example string) {
+	f.Helper()
+
+	bodies := [][]byte{}
+	if data, err := os.ReadFile("../artifacts/examples/" + example); err == nil {
+		bodies = append(bodies, data)
+	}
+	g := fuzzgen.New(1)
+	for i := range seedCount {
+		bodies = append(bodies, g.Payload(kind, float64(i%2)))
+	}
+
+	for _, body := range bodies {
+		for flags := range uint8(8) {
+			f.Add(body, flags)
+		}
+	}
+}
+
+// strictness decodes the fuzzed flags: bit 0 structure, bit 1 namespaces, bit 2 required.
+func strictness(flags uint8) parser.Strictness {
+	return parser.Strictness{Structure: flags&1 != 0, Namespaces: flags&2 != 0, Required: flags&4 != 0}
+}
+
+// checkResult fails unless exactly one of result and err is set, and err is one of
+// the classified parser error kinds the handlers map to faults.
+func checkResult[T any](t *testing.T, result *T, err error) {
+	t.Helper()
+
+	switch {
+	case err == nil && result == nil:
+		t.Fatal("no result and no error")
+	case err != nil && result != nil:
+		t.Fatalf("result returned with error %v", err)
+	case err != nil && !errors.Is(err, parser.ErrMissingBSN) && !errors.Is(err, parser.ErrSchemaViolation) &&
+		!errors.Is(err, parser.ErrUnsupportedInteraction) && !errors.Is(err, parser.ErrMissingAttribute):
+		t.Fatalf("unclassified error: %v", err)
+	}
+}
+
+func FuzzParseXACMLRequest(f *testing.F) {
+	seed(f, fuzzgen.KindXACML, "xacml_request.xml")
+	f.Fuzz(func(t *testing.T, body []byte, flags uint8) {
+		req, err := parser.ParseXACMLRequestWith(body, strictness(flags))
+		checkResult(t, req, err)
+		if err == nil && req.BSN == "" {
+			t.Fatal("request parsed without a BSN")
+		}
+	})
+}
+
+func FuzzParseXCPDRequest(f *testing.F) {
+	seed(f, fuzzgen.KindXCPD, "xcpd_request.xml")
+	f.Fuzz(func(t *testing.T, body []byte, flags uint8) {
+		req, err := parser.ParseXCPDRequestWith(body, strictness(flags))
+		checkResult(t, req, err)
+		if err == nil && req.BSN == "" {
+			t.Fatal("request parsed without a BSN")
+		}
+	})
+}
+
+func FuzzParseFhirBundle(f *testing.F) {
+	seed(f, fuzzgen.KindFhirBundle, "fhir_bundle_migration.xml")
+	f.Fuzz(func(t *testing.T, body []byte, flags uint8) {
+		req, err := parser.ParseFhirBundleWith(body, strictness(flags))
+		checkResult(t, req, err)
+		if err == nil && req.EntryCount < 0 {
+			t.Fatalf("negative entry count %d", req.EntryCount)
+		}
+	})
+}
+
+func FuzzParseFhirSubscription(f *testing.F) {
+	seed(f, fuzzgen.KindFhirSubscription, "fhir_subscription.xml")
+	f.Fuzz(func(t *testing.T, body []byte, flags uint8) {
+		req, err := parser.ParseFhirSubscriptionWith(body, strictness(flags))
+		checkResult(t, req, err)
+	})
+}
@@ -3,7 +3,10 @@ package parser
 import (
 	"encoding/xml"
 	"fmt"
+	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -26,6 +29,39 @@ type XACMLRequest struct {
 type XCPDRequest struct {
 	BSN       string
 	SenderOrg string
+	Receiver  string // receiver/device/id/@root, normally the Mitz OID
+
+	// MessageRoot and MessageExtension identify the request message (id), the
+	// target of the acknowledgement in the response.
+	MessageRoot      string
+	MessageExtension string
+	AcceptAckCode    string // AL, ER or NE; "" when absent
+
+	// RespondTo is respondTo/telecom/@value: the endpoint of a deferred answer
+	// (IHE XCPD Deferred Response option), "" for a synchronous query.
+	RespondTo string
+
+	// QueryRoot and QueryExtension are queryByParameter/queryId, which a paged
+	// answer's queryAck echoes and continuations refer to.
+	QueryRoot      string
+	QueryExtension string
+}
+
+// AcceptAckCodes are the HL7v3 acceptAckCode values: acknowledge always, on error only, never.
+var AcceptAckCodes = []string{"AL", "ER", "NE"}
+
+// XCPDContinuation holds the extracted fields from an HL7v3 query continuation
+// (QUQI_IN000003UV01) or cancel (QUQI_IN000003UV01_Cancel) sent to the XCPD endpoint.
+type XCPDContinuation struct {
+	QueryRoot      string // queryContinuation/queryId: the queryId of the original query
+	QueryExtension string
+	Quantity       int // continuationQuantity; 0 when absent
+	Cancel         bool
+	SenderOrg      string
+
+	// MessageRoot and MessageExtension identify the continuation message (id).
+	MessageRoot      string
+	MessageExtension string
 }
 
 // --- XACML XML structs (minimal, just what we need) ---
@@ -40,6 +76,7 @@ type xacmlBody struct {
 }
 
 type xacmlQuery struct {
+	XMLName xml.Name
 	Request xacmlRequest `xml:"Request"`
 }
 
@@ -57,20 +94,72 @@ type xacmlAttribute struct {
 	AttributeValue string `xml:"AttributeValue"`
 }
 
+// XACML attribute categories.
+const (
+	CategoryAccessSubject = "urn:oasis:names:tc:xacml:1.0:subject-category:access-subject"
+	CategoryResource      = "urn:oasis:names:tc:xacml:3.0:attribute-category:resource"
+	CategoryAction        = "urn:oasis:names:tc:xacml:3.0:attribute-category:action"
+	CategoryEnvironment   = "urn:oasis:names:tc:xacml:3.0:attribute-category:environment"
+)
+
+// XACMLAttribute identifies an attribute of a gesloten autorisatievraag.
+type XACMLAttribute struct {
+	Name        string   // short name used in faults, e.g. "purpose-of-use"
+	AttributeID string   // e.g. "urn:oasis:names:tc:xspa:1.0:subject:purposeofuse"
+	Categories  []string // categories it may appear in
+}
+
+// RequiredXACMLAttributes must each carry a value in a strict XACML request. Like the
+// BSN and event codes, they match on the last segment of their category and AttributeId.
+var RequiredXACMLAttributes = []XACMLAttribute{
+	{"subject-id", "urn:oasis:names:tc:xacml:1.0:subject:subject-id", []string{CategoryAccessSubject}},
+	{"organization-id", "urn:oasis:names:tc:xspa:1.0:subject:organization-id", []string{CategoryAccessSubject}},
+	{"purpose-of-use", "urn:oasis:names:tc:xspa:1.0:subject:purposeofuse", []string{CategoryEnvironment, CategoryAccessSubject}},
+	{"resource-id", "urn:oasis:names:tc:xacml:2.0:resource:resource-id", []string{CategoryResource}},
+	{"event-code", "urn:ihe:iti:appc:2016:document-entry:event-code", []string{CategoryAction}},
+}
+
 // ParseXACMLRequest extracts the patient BSN and gegevenscategorieen from an XACML request body.
 func ParseXACMLRequest(body []byte) (*XACMLRequest, error) {
+	return ParseXACMLRequestWith(body, Strictness{})
+}
+
+// ParseXACMLRequestWith is ParseXACMLRequest with additional strictness checks.
+func ParseXACMLRequestWith(body []byte, strict Strictness) (*XACMLRequest, error) {
+	if !strict.Structure {
+		body = sanitizeXML(body)
+	}
+
 	var env xacmlEnvelope
-	if err := xml.Unmarshal(sanitizeXML(body), &env); err != nil {
-		return nil, fmt.Errorf("failed to parse XACML request: %w", err)
+	if err := xml.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse XACML request: %w", ErrSchemaViolation, err)
+	}
+
+	if name := env.Body.Query.XMLName.Local; name != "" && name != "XACMLAuthzDecisionQuery" {
+		return nil, fmt.Errorf("%w: expected XACMLAuthzDecisionQuery, got %s", ErrUnsupportedInteraction, name)
+	}
+
+	if strict.Structure && env.Body.Query.XMLName.Local == "" {
+		return nil, fmt.Errorf("%w: SOAP Body has no XACMLAuthzDecisionQuery", ErrSchemaViolation)
+	}
+	if strict.Namespaces {
+		if err := requireSOAPEnvelope(env.XMLName); err != nil {
+			return nil, err
+		}
+		if err := requireNamespace(env.Body.Query.XMLName, nsXACMLSAML); err != nil {
+			return nil, err
+		}
 	}
 
 	req := &XACMLRequest{}
+	resourceIDs, emptyCodes := 0, 0
 
 	for _, attrs := range env.Body.Query.Request.Attributes {
 		switch {
 		case strings.HasSuffix(attrs.Category, ":resource"):
 			for _, attr := range attrs.Attribute {
 				if strings.HasSuffix(attr.AttributeId, "resource-id") {
+					resourceIDs++
 					req.BSN = strings.TrimSpace(attr.AttributeValue)
 				}
 			}
@@ -82,6 +171,9 @@ func ParseXACMLRequest(body []byte) (*XACMLRequest, error) {
 					if idx := strings.LastIndex(val, "^"); idx >= 0 {
 						val = val[idx+1:]
 					}
+					if val == "" {
+						emptyCodes++
+					}
 					req.Categories = append(req.Categories, val)
 				}
 			}
@@ -89,12 +181,64 @@ func ParseXACMLRequest(body []byte) (*XACMLRequest, error) {
 	}
 
 	if req.BSN == "" {
-		return nil, fmt.Errorf("no patient BSN found in XACML request")
+		return nil, fmt.Errorf("%w: no patient BSN found in XACML request", ErrMissingBSN)
+	}
+	if strict.Structure && len(req.Categories) == 0 {
+		return nil, fmt.Errorf("%w: no event-code attribute found in XACML request", ErrSchemaViolation)
+	}
+	if strict.Required {
+		// After the BSN and event-code checks, so those keep their own faults; a
+		// missing event-code is reported here when structure checks are off.
+		if missing := missingXACMLAttributes(env.Body.Query.Request); len(missing) > 0 {
+			return nil, &MissingAttributeError{Attributes: missing}
+		}
+		switch {
+		case resourceIDs > 1:
+			return nil, fmt.Errorf("%w: resource-id attribute must occur once, found %d", ErrSchemaViolation, resourceIDs)
+		case !validBSN(req.BSN):
+			return nil, fmt.Errorf("%w: resource-id must be a 9-digit BSN, got %q", ErrSchemaViolation, req.BSN)
+		case emptyCodes > 0:
+			return nil, fmt.Errorf("%w: event-code attribute has no value", ErrSchemaViolation)
+		}
 	}
 
 	return req, nil
 }
 
+// missingXACMLAttributes returns the RequiredXACMLAttributes the request lacks or leaves empty.
+func missingXACMLAttributes(req xacmlRequest) []XACMLAttribute {
+	var missing []XACMLAttribute
+	for _, required := range RequiredXACMLAttributes {
+		if !hasXACMLAttribute(req, required) {
+			missing = append(missing, required)
+		}
+	}
+	return missing
+}
+
+func hasXACMLAttribute(req xacmlRequest, required XACMLAttribute) bool {
+	id := lastSegment(required.AttributeID)
+	for _, attrs := range req.Attributes {
+		inCategory := slices.ContainsFunc(required.Categories, func(category string) bool {
+			return strings.HasSuffix(attrs.Category, ":"+lastSegment(category))
+		})
+		if !inCategory {
+			continue
+		}
+		for _, attr := range attrs.Attribute {
+			if strings.HasSuffix(attr.AttributeId, id) && strings.TrimSpace(attr.AttributeValue) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lastSegment returns the part of a URN after its last colon.
+func lastSegment(urn string) string {
+	return urn[strings.LastIndex(urn, ":")+1:]
+}
+
 // --- XCPD XML structs (minimal) ---
 
 type xcpdEnvelope struct {
@@ -107,10 +251,22 @@ type xcpdBody struct {
 }
 
 type xcpdMessage struct {
+	XMLName       xml.Name
+	ID            xcpdID `xml:"id"`
+	AcceptAckCode struct {
+		Code string `xml:"code,attr"`
+	} `xml:"acceptAckCode"`
+	RespondTo struct {
+		Telecom struct {
+			Value string `xml:"value,attr"`
+		} `xml:"telecom"`
+	} `xml:"respondTo"`
+	Receiver          xcpdSender            `xml:"receiver"`
 	Sender            xcpdSender            `xml:"sender"`
 	ControlActProcess xcpdControlActProcess `xml:"controlActProcess"`
 }
 
+// xcpdSender is the sender or receiver of an HL7v3 message.
 type xcpdSender struct {
 	Device xcpdDevice `xml:"device"`
 }
@@ -129,6 +285,7 @@ type xcpdControlActProcess struct {
 }
 
 type xcpdQueryByParameter struct {
+	QueryID       xcpdID            `xml:"queryId"`
 	ParameterList xcpdParameterList `xml:"parameterList"`
 }
 
@@ -142,17 +299,144 @@ type xcpdLivingSubjectId struct {
 
 // ParseXCPDRequest extracts the patient BSN and sender org from an XCPD request body.
 func ParseXCPDRequest(body []byte) (*XCPDRequest, error) {
+	return ParseXCPDRequestWith(body, Strictness{})
+}
+
+// ParseXCPDRequestWith is ParseXCPDRequest with additional strictness checks.
+func ParseXCPDRequestWith(body []byte, strict Strictness) (*XCPDRequest, error) {
 	var env xcpdEnvelope
 	if err := xml.Unmarshal(body, &env); err != nil {
-		return nil, fmt.Errorf("failed to parse XCPD request: %w", err)
+		return nil, fmt.Errorf("%w: failed to parse XCPD request: %w", ErrSchemaViolation, err)
+	}
+
+	if name := env.Body.Message.XMLName.Local; name != "" && name != "PRPA_IN201305UV02" {
+		return nil, fmt.Errorf("%w: expected PRPA_IN201305UV02, got %s", ErrUnsupportedInteraction, name)
+	}
+
+	if strict.Structure && env.Body.Message.XMLName.Local == "" {
+		return nil, fmt.Errorf("%w: SOAP Body has no PRPA_IN201305UV02", ErrSchemaViolation)
+	}
+	if strict.Namespaces {
+		if err := requireSOAPEnvelope(env.XMLName); err != nil {
+			return nil, err
+		}
+		if err := requireNamespace(env.Body.Message.XMLName, nsHL7v3); err != nil {
+			return nil, err
+		}
 	}
 
 	req := &XCPDRequest{}
-	req.BSN = env.Body.Message.ControlActProcess.QueryByParameter.ParameterList.LivingSubjectId.Value.Extension
+	subjectID := env.Body.Message.ControlActProcess.QueryByParameter.ParameterList.LivingSubjectId.Value
+	req.BSN = subjectID.Extension
 	req.SenderOrg = env.Body.Message.Sender.Device.ID.Root
+	req.Receiver = env.Body.Message.Receiver.Device.ID.Root
+	req.MessageRoot = env.Body.Message.ID.Root
+	req.MessageExtension = env.Body.Message.ID.Extension
+	req.AcceptAckCode = env.Body.Message.AcceptAckCode.Code
+	req.RespondTo = strings.TrimSpace(env.Body.Message.RespondTo.Telecom.Value)
+	req.QueryRoot = env.Body.Message.ControlActProcess.QueryByParameter.QueryID.Root
+	req.QueryExtension = env.Body.Message.ControlActProcess.QueryByParameter.QueryID.Extension
 
 	if req.BSN == "" {
-		return nil, fmt.Errorf("no patient BSN found in XCPD request")
+		return nil, fmt.Errorf("%w: no patient BSN found in XCPD request", ErrMissingBSN)
+	}
+	if req.AcceptAckCode != "" && !slices.Contains(AcceptAckCodes, req.AcceptAckCode) {
+		return nil, fmt.Errorf("%w: acceptAckCode/@code must be AL, ER or NE, got %q", ErrSchemaViolation, req.AcceptAckCode)
+	}
+	if u, err := url.Parse(req.RespondTo); req.RespondTo != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		return nil, fmt.Errorf("%w: respondTo/telecom/@value must be an http or https URL, got %q", ErrSchemaViolation, req.RespondTo)
+	}
+	if strict.Structure && req.SenderOrg == "" {
+		return nil, fmt.Errorf("%w: sender/device/id/@root is required", ErrSchemaViolation)
+	}
+	if strict.Required {
+		switch {
+		case req.SenderOrg == "":
+			return nil, fmt.Errorf("%w: sender/device/id/@root is required", ErrSchemaViolation)
+		case req.AcceptAckCode == "":
+			return nil, fmt.Errorf("%w: acceptAckCode is required", ErrSchemaViolation)
+		case subjectID.Root != oidBSN:
+			return nil, fmt.Errorf("%w: livingSubjectId/value/@root must be %s, got %q", ErrSchemaViolation, oidBSN, subjectID.Root)
+		case !validBSN(req.BSN):
+			return nil, fmt.Errorf("%w: livingSubjectId/value/@extension must be a 9-digit BSN, got %q", ErrSchemaViolation, req.BSN)
+		}
+	}
+
+	return req, nil
+}
+
+// --- XCPD query continuation ---
+
+type xcpdContinuationEnvelope struct {
+	XMLName xml.Name             `xml:"Envelope"`
+	Body    xcpdContinuationBody `xml:"Body"`
+}
+
+type xcpdContinuationBody struct {
+	Message xcpdContinuationMessage `xml:",any"`
+}
+
+type xcpdContinuationMessage struct {
+	XMLName           xml.Name
+	ID                xcpdID     `xml:"id"`
+	Sender            xcpdSender `xml:"sender"`
+	ControlActProcess struct {
+		QueryContinuation xcpdQueryContinuation `xml:"queryContinuation"`
+	} `xml:"controlActProcess"`
+}
+
+type xcpdQueryContinuation struct {
+	QueryID    xcpdID `xml:"queryId"`
+	StatusCode struct {
+		Code string `xml:"code,attr"`
+	} `xml:"statusCode"`
+	ContinuationQuantity struct {
+		Value string `xml:"value,attr"`
+	} `xml:"continuationQuantity"`
+}
+
+// IsXCPDContinuation reports whether body is a query continuation or cancel rather
+// than a patient discovery query.
+func IsXCPDContinuation(body []byte) bool {
+	var env xcpdContinuationEnvelope
+	if err := xml.Unmarshal(body, &env); err != nil {
+		return false
+	}
+	return strings.HasPrefix(env.Body.Message.XMLName.Local, "QUQI_IN000003UV01")
+}
+
+// ParseXCPDContinuation extracts the query ID and continuation quantity from a
+// QUQI_IN000003UV01 message. A _Cancel message or statusCode aborted cancels the query.
+func ParseXCPDContinuation(body []byte) (*XCPDContinuation, error) {
+	var env xcpdContinuationEnvelope
+	if err := xml.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse query continuation: %w", ErrSchemaViolation, err)
+	}
+
+	msg := env.Body.Message
+	if name := msg.XMLName.Local; name != "QUQI_IN000003UV01" && name != "QUQI_IN000003UV01_Cancel" {
+		return nil, fmt.Errorf("%w: expected QUQI_IN000003UV01, got %s", ErrUnsupportedInteraction, name)
+	}
+
+	continuation := msg.ControlActProcess.QueryContinuation
+	req := &XCPDContinuation{
+		QueryRoot:      continuation.QueryID.Root,
+		QueryExtension: continuation.QueryID.Extension,
+		Cancel:         msg.XMLName.Local == "QUQI_IN000003UV01_Cancel" || continuation.StatusCode.Code == "aborted",
+		SenderOrg:      msg.Sender.Device.ID.Root,
+
+		MessageRoot:      msg.ID.Root,
+		MessageExtension: msg.ID.Extension,
+	}
+	if req.QueryRoot == "" {
+		return nil, fmt.Errorf("%w: queryContinuation/queryId/@root is required", ErrSchemaViolation)
+	}
+	if v := continuation.ContinuationQuantity.Value; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%w: continuationQuantity/@value must be a positive number, got %q", ErrSchemaViolation, v)
+		}
+		req.Quantity = n
 	}
 
 	return req, nil
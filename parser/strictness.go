@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Namespaces required by strict namespace checking.
+const (
+	nsSOAP11    = "http://schemas.xmlsoap.org/soap/envelope/"
+	nsSOAP12    = "http://www.w3.org/2003/05/soap-envelope"
+	nsXACMLSAML = "urn:oasis:names:tc:xacml:3.0:profile:saml2.0:v2:schema:protocol"
+	nsHL7v3     = "urn:hl7-org:v3"
+	nsFHIR      = "http://hl7.org/fhir"
+)
+
+// Strictness selects checks applied on top of the default, lenient parse. The zero
+// value accepts everything the parsers can extract a BSN from.
+type Strictness struct {
+	Structure  bool // reject requests missing mandatory elements, and invalid XML the lenient parse repairs
+	Namespaces bool // reject requests without the specification namespaces
+	Required   bool // reject requests missing attributes, elements or fields the specifications require (VALIDATION_MODE=strict)
+}
+
+// Named strictness levels accepted by ParseStrictness. "schema" is the former name of
+// "structure": the checks are the parsers' own, not validation against the published
+// XACML and HL7 v3 schemas.
+var strictnessLevels = map[string]Strictness{
+	"lenient":    {},
+	"structure":  {Structure: true},
+	"schema":     {Structure: true},
+	"namespaces": {Namespaces: true},
+	"strict":     {Structure: true, Namespaces: true},
+}
+
+// ParseStrictness returns the strictness for a level name: lenient, structure, namespaces or strict.
+func ParseStrictness(name string) (Strictness, error) {
+	s, ok := strictnessLevels[name]
+	if !ok {
+		return Strictness{}, fmt.Errorf("unknown strictness %q (expected lenient, structure, namespaces or strict)", name)
+	}
+	return s, nil
+}
+
+// String returns the level name of s, with "+required" when required-content
+// validation is on.
+func (s Strictness) String() string {
+	if s.Required {
+		return Strictness{Structure: s.Structure, Namespaces: s.Namespaces}.String() + "+required"
+	}
+
+	switch {
+	case s.Structure && s.Namespaces:
+		return "strict"
+	case s.Structure:
+		return "structure"
+	case s.Namespaces:
+		return "namespaces"
+	default:
+		return "lenient"
+	}
+}
+
+// requireNamespace fails with ErrSchemaViolation unless name is in namespace ns.
+func requireNamespace(name xml.Name, ns string) error {
+	if name.Space != ns {
+		return fmt.Errorf("%w: element %s must be in namespace %s, got %q", ErrSchemaViolation, name.Local, ns, name.Space)
+	}
+	return nil
+}
+
+// requireSOAPEnvelope checks that name is a SOAP 1.1 or SOAP 1.2 Envelope.
+func requireSOAPEnvelope(name xml.Name) error {
+	if name.Space == nsSOAP11 {
+		return nil
+	}
+	return requireNamespace(name, nsSOAP12)
+}
+
+// SOAPVersion returns "1.1" or "1.2" when body is an Envelope in that SOAP version's
+// namespace, or "" otherwise.
+func SOAPVersion(body []byte) string {
+	switch root := rootElement(body); {
+	case root.Local != "Envelope":
+		return ""
+	case root.Space == nsSOAP11:
+		return "1.1"
+	case root.Space == nsSOAP12:
+		return "1.2"
+	}
+	return ""
+}
+
+// Identifier systems checked by required-content validation.
+const (
+	oidBSN    = "2.16.840.1.113883.2.4.6.3"
+	systemBSN = "http://fhir.nl/fhir/NamingSystem/bsn"
+	systemURA = "http://fhir.nl/fhir/NamingSystem/ura"
+)
+
+// validBSN reports whether bsn has the nine digits of a BSN. The elfproef is not
+// checked, so the magic test BSNs stay valid.
+func validBSN(bsn string) bool {
+	return len(bsn) == 9 && strings.Trim(bsn, "0123456789") == ""
+}
+
+// rootElement returns the name of the document element, or the zero name if none is found.
+func rootElement(body []byte) xml.Name {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return xml.Name{}
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name
+		}
+	}
+}
@@ -0,0 +1,50 @@
+# Compares two `go test -bench -benchmem` outputs, old then new, measured on the
+# same machine in the same session. Each benchmark's best ns/op and allocs/op over
+# its -count runs are compared; the script exits 1 when the new run is more than
+# max_ratio (default 1.5) times the old one. Benchmarks in only one run are listed
+# but not compared.
+#
+#   awk -v max_ratio=1.5 -f perf/compare.awk old.txt new.txt
+
+FNR == 1 { side++ }
+
+/^Benchmark/ {
+	name = $1
+	sub(/-[0-9]+$/, "", name)
+	for (i = 3; i <= NF; i++) {
+		if ($i == "ns/op") record(side, name, "ns", $(i - 1))
+		if ($i == "allocs/op") record(side, name, "allocs", $(i - 1))
+	}
+	if (!(name in order)) { order[name] = ++count; names[count] = name }
+}
+
+function record(side, name, metric, value,    key) {
+	key = side SUBSEP name SUBSEP metric
+	if (!(key in best) || value + 0 < best[key] + 0) best[key] = value
+}
+
+function ratio(name, metric,    old, new) {
+	old = best[1, name, metric]; new = best[2, name, metric]
+	return old > 0 ? new / old : 1
+}
+
+END {
+	if (max_ratio == "") max_ratio = 1.5
+	printf "%-48s %12s %12s %7s %7s\n", "benchmark", "old ns/op", "new ns/op", "time", "allocs"
+	for (i = 1; i <= count; i++) {
+		name = names[i]
+		if (!((1, name, "ns") in best) || !((2, name, "ns") in best)) {
+			printf "%-48s only in the %s run\n", name, (1, name, "ns") in best ? "old" : "new"
+			continue
+		}
+		t = ratio(name, "ns"); a = ratio(name, "allocs")
+		flag = (t > max_ratio || a > max_ratio) ? "  REGRESSION" : ""
+		if (flag != "") regressions++
+		printf "%-48s %12d %12d %6.2fx %6.2fx%s\n", name, best[1, name, "ns"], best[2, name, "ns"], t, a, flag
+	}
+	if (regressions > 0) {
+		printf "%d benchmark(s) regressed beyond %.2fx\n", regressions, max_ratio
+		exit 1
+	}
+	printf "No regressions beyond %.2fx\n", max_ratio
+}
@@ -0,0 +1,210 @@
+package profile
+
+// bundleResources are the resources of a migration (OTV-TR-0150) or toestemmingsknop
+// (OTV-TR-0160) Bundle with their cardinality.
+var bundleResources = []struct {
+	name     string
+	min, max int
+}{
+	{"Patient", 1, 1},
+	{"Organization", 1, 1},
+	{"Consent", 1, 1},
+	{"Provenance", 0, 1},
+}
+
+// httpVerbs are the FHIR HTTPVerb codes of Bundle.entry.request.method.
+var httpVerbs = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "PATCH"}
+
+// ValidateTransaction checks that a Bundle is a transaction the replicator can
+// process: Bundle.type transaction and a request with method and url in every
+// entry. Documents that are not a Bundle are left to the parser; the error is set
+// for documents that are not well-formed XML.
+func ValidateTransaction(body []byte) ([]Issue, error) {
+	root, err := parse(body)
+	if err != nil || root.name.Local != "Bundle" {
+		return nil, err
+	}
+	r := &report{}
+	r.transaction(root)
+	return r.issues, nil
+}
+
+// ValidateBundle checks a consent registration Bundle against the Mitz profiles: a
+// transaction with one Patient identified by BSN, one Organization identified by
+// URA, one Consent whose provisions permit or deny gegevenscategorieën, and for the
+// toestemmingsknop a Provenance. The error is set for documents that are not
+// well-formed XML.
+func ValidateBundle(body []byte) ([]Issue, error) {
+	root, err := parse(body)
+	if err != nil {
+		return nil, err
+	}
+	r := &report{}
+	if !r.root(root, "Bundle") {
+		return r.issues, nil
+	}
+
+	r.transaction(root)
+
+	entries := root.all("entry")
+	if len(entries) == 0 {
+		r.add("required", "Bundle.entry", "Bundle.entry is required by the Mitz profile")
+		return r.issues, nil
+	}
+
+	counts := map[string]int{}
+	for i, entry := range entries {
+		expression := indexed("Bundle.entry", i) + ".resource"
+		resource := entry.first("resource")
+		if !r.required(resource, expression) {
+			continue
+		}
+		if len(resource.children) != 1 {
+			r.add("structure", expression, "%s must hold exactly one resource, got %d", expression, len(resource.children))
+			continue
+		}
+
+		res := resource.children[0]
+		counts[res.name.Local]++
+		switch res.name.Local {
+		case "Patient":
+			r.identifier(res, expression, "Patient", systemBSN, "a 9-digit BSN", bsnRe.MatchString)
+		case "Organization":
+			r.identifier(res, expression, "Organization", systemURA, "an 8-digit URA", uraRe.MatchString)
+		case "Consent":
+			r.consent(res, expression)
+		case "Provenance":
+			r.provenance(res, expression)
+		default:
+			r.add("not-supported", expression, "%s is a %s; the Mitz Bundle holds only Patient, Organization, Consent and Provenance", expression, res.name.Local)
+		}
+	}
+
+	for _, res := range bundleResources {
+		switch n := counts[res.name]; {
+		case n < res.min:
+			r.add("required", "Bundle.entry", "Bundle has no %s entry", res.name)
+		case n > res.max:
+			r.add("structure", "Bundle.entry", "Bundle has %d %s entries, at most %d allowed", n, res.name, res.max)
+		}
+	}
+	return r.issues, nil
+}
+
+// transaction checks the type of a Bundle and the request of each entry.
+func (r *report) transaction(bundle *element) {
+	r.code(bundle.first("type"), "Bundle.type", "transaction")
+	for i, entry := range bundle.all("entry") {
+		expression := indexed("Bundle.entry", i) + ".request"
+		request := entry.first("request")
+		if !r.required(request, expression) {
+			continue
+		}
+		r.code(request.first("method"), expression+".method", httpVerbs...)
+		r.required(request.first("url"), expression+".url")
+	}
+}
+
+// identifier checks that a resource has an identifier in system whose value matches valid.
+func (r *report) identifier(res *element, expression, resource, system, format string, valid func(string) bool) {
+	for j, id := range res.all("identifier") {
+		if id.valueOf("system") != system {
+			continue
+		}
+		idExpression := indexed(expression+".identifier", j) + ".value"
+		if value := id.valueOf("value"); !valid(value) {
+			r.add("value", idExpression, "%s.identifier.value must be %s, got %q", resource, format, value)
+		}
+		return
+	}
+	r.add("required", expression+".identifier", "%s.identifier with system %s is required by the Mitz profile", resource, system)
+}
+
+// consent checks the status and provisions of a Consent.
+func (r *report) consent(res *element, expression string) {
+	r.code(res.first("status"), expression+".status", "draft", "proposed", "active", "rejected", "inactive", "entered-in-error")
+
+	provision := res.first("provision")
+	if !r.required(provision, expression+".provision") {
+		return
+	}
+	r.code(provision.first("type"), expression+".provision.type", "permit", "deny")
+	r.provision(provision, expression+".provision")
+}
+
+// provision checks the period and gegevenscategorie codes of a provision and its
+// nested provisions.
+func (r *report) provision(p *element, expression string) {
+	if period := p.first("period"); period != nil {
+		start, hasStart := r.dateTime(period.first("start"), expression+".period.start")
+		end, hasEnd := r.dateTime(period.first("end"), expression+".period.end")
+		if hasStart && hasEnd && !end.After(start) {
+			r.add("value", expression+".period.end", "%s.period.end must be after period.start", expression)
+		}
+	}
+
+	for i, code := range p.all("code") {
+		codings := code.all("coding")
+		if len(codings) == 0 {
+			r.add("required", indexed(expression+".code", i)+".coding", "%s.code must have a coding with system %s", expression, systemGegevenscategory)
+		}
+		for j, coding := range codings {
+			codingExpression := indexed(indexed(expression+".code", i)+".coding", j)
+			if system := coding.valueOf("system"); system != systemGegevenscategory {
+				r.add("code-invalid", codingExpression+".system", "gegevenscategorie coding system must be %s, got %q", systemGegevenscategory, system)
+			}
+			if coding.valueOf("code") == "" {
+				r.add("required", codingExpression+".code", "gegevenscategorie coding has no code")
+			}
+		}
+	}
+
+	for i, nested := range p.all("provision") {
+		nestedExpression := indexed(expression+".provision", i)
+		if t := nested.first("type"); t != nil {
+			r.code(t, nestedExpression+".type", "permit", "deny")
+		}
+		if len(nested.all("code")) == 0 && len(nested.all("provision")) == 0 {
+			r.add("required", nestedExpression+".code", "nested provision must name a gegevenscategorie in code")
+		}
+		r.provision(nested, nestedExpression)
+	}
+}
+
+// provenance checks the target, recorded time and URA agent of a toestemmingsknop Provenance.
+func (r *report) provenance(res *element, expression string) {
+	targets := res.all("target")
+	if len(targets) == 0 {
+		r.add("required", expression+".target", "%s.target is required by the Mitz profile", expression)
+	}
+	for i, target := range targets {
+		if target.valueOf("reference") == "" {
+			r.add("required", indexed(expression+".target", i)+".reference", "Provenance.target must reference the Consent")
+		}
+	}
+
+	if recorded := res.first("recorded"); r.required(recorded, expression+".recorded") {
+		r.dateTime(recorded, expression+".recorded")
+	}
+
+	agents := res.all("agent")
+	if len(agents) == 0 {
+		r.add("required", expression+".agent", "%s.agent is required by the Mitz profile", expression)
+	}
+	for i, agent := range agents {
+		who := agent.first("who")
+		whoExpression := indexed(expression+".agent", i) + ".who"
+		if !r.required(who, whoExpression) {
+			continue
+		}
+		id := who.first("identifier")
+		switch {
+		case id == nil:
+			r.add("required", whoExpression+".identifier", "Provenance.agent.who must identify the organisation by URA")
+		case id.valueOf("system") != systemURA:
+			r.add("code-invalid", whoExpression+".identifier.system", "Provenance.agent.who.identifier.system must be %s, got %q", systemURA, id.valueOf("system"))
+		case !uraRe.MatchString(id.valueOf("value")):
+			r.add("value", whoExpression+".identifier.value", "Provenance.agent.who.identifier.value must be an 8-digit URA, got %q", id.valueOf("value"))
+		}
+	}
+}
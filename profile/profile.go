@@ -0,0 +1,195 @@
+// Package profile validates FHIR Subscription and Bundle payloads against the
+// Mitz/OTV profiles: the patient and provider identifiers, the format of the
+// Subscription criteria and the constraints on Consent.provision. Unlike the
+// parser, which stops at the first problem, it reports every violation with the
+// FHIRPath expression of the offending element.
+package profile
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Issue is one profile violation, shaped like an OperationOutcome.issue.
+type Issue struct {
+	Severity    string // error
+	Code        string // issue type: required, value, code-invalid, structure, not-supported
+	Expression  string // FHIRPath of the element, e.g. Bundle.entry[2].resource.provision.type
+	Diagnostics string
+}
+
+func (i Issue) String() string {
+	return i.Expression + ": " + i.Diagnostics
+}
+
+// Namespaces, identifier systems and code systems of the profiles.
+const (
+	nsFHIR                 = "http://hl7.org/fhir"
+	systemBSN              = "http://fhir.nl/fhir/NamingSystem/bsn"
+	systemURA              = "http://fhir.nl/fhir/NamingSystem/ura"
+	systemGegevenscategory = "2.16.840.1.113883.2.4.3.111.5.10.1"
+)
+
+var (
+	bsnRe = regexp.MustCompile(`^[0-9]{9}$`)
+	uraRe = regexp.MustCompile(`^[0-9]{8}$`)
+	// dateTimeRe is the lexical form of a FHIR dateTime (which includes date).
+	dateTimeRe = regexp.MustCompile(`^([0-9]{4})(-(0[1-9]|1[0-2])(-(0[1-9]|[12][0-9]|3[01])(T([01][0-9]|2[0-3]):[0-5][0-9]:([0-5][0-9]|60)(\.[0-9]+)?(Z|[+-]((0[0-9]|1[0-3]):[0-5][0-9]|14:00)))?)?)?$`)
+)
+
+// element is a FHIR XML element. Primitive values are in the value attribute.
+type element struct {
+	name     xml.Name
+	attrs    map[string]string
+	children []*element
+}
+
+func (e *element) value() string {
+	return e.attrs["value"]
+}
+
+// all returns the children named name.
+func (e *element) all(name string) []*element {
+	var out []*element
+	for _, c := range e.children {
+		if c.name.Local == name {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// first returns the first child named name, or nil.
+func (e *element) first(name string) *element {
+	if all := e.all(name); len(all) > 0 {
+		return all[0]
+	}
+	return nil
+}
+
+// valueOf returns the value of the first child named name, or "".
+func (e *element) valueOf(name string) string {
+	if c := e.first(name); c != nil {
+		return c.value()
+	}
+	return ""
+}
+
+// parse reads a FHIR XML document into an element tree.
+func parse(body []byte) (*element, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	var root *element
+	var stack []*element
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			e := &element{name: t.Name, attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				if a.Name.Space == "" {
+					e.attrs[a.Name.Local] = a.Value
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, e)
+			} else if root == nil {
+				root = e
+			}
+			stack = append(stack, e)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no document element")
+	}
+	return root, nil
+}
+
+// report collects the issues of one validation.
+type report struct {
+	issues []Issue
+}
+
+func (r *report) add(code, expression, format string, args ...any) {
+	r.issues = append(r.issues, Issue{Severity: "error", Code: code, Expression: expression, Diagnostics: fmt.Sprintf(format, args...)})
+}
+
+// required reports a missing element and returns false when e is nil or has no value
+// (for primitives) or no children.
+func (r *report) required(e *element, expression string) bool {
+	if e == nil || (e.value() == "" && len(e.children) == 0) {
+		r.add("required", expression, "%s is required by the Mitz profile", expression)
+		return false
+	}
+	return true
+}
+
+// code checks a required coded value against the allowed codes.
+func (r *report) code(e *element, expression string, allowed ...string) {
+	if !r.required(e, expression) {
+		return
+	}
+	for _, a := range allowed {
+		if e.value() == a {
+			return
+		}
+	}
+	r.add("code-invalid", expression, "%s must be %s, got %q", expression, oneOf(allowed), e.value())
+}
+
+// root checks the document element of a resource and reports whether validation
+// can continue.
+func (r *report) root(e *element, resource string) bool {
+	if e.name.Local != resource {
+		r.add("structure", resource, "expected a %s resource, got %s", resource, e.name.Local)
+		return false
+	}
+	if e.name.Space != nsFHIR {
+		r.add("structure", resource, "%s must be in the FHIR namespace %s", resource, nsFHIR)
+	}
+	return true
+}
+
+// dateTime parses a FHIR date or dateTime, reporting an invalid value.
+func (r *report) dateTime(e *element, expression string) (time.Time, bool) {
+	if e == nil || e.value() == "" {
+		return time.Time{}, false
+	}
+	if !dateTimeRe.MatchString(e.value()) {
+		r.add("value", expression, "%s %q is not a FHIR dateTime", expression, e.value())
+		return time.Time{}, false
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, e.value()); err == nil {
+			return t, true
+		}
+	}
+	r.add("value", expression, "%s %q is not a valid date", expression, e.value())
+	return time.Time{}, false
+}
+
+// indexed returns the expression of the i-th element of a repeating element.
+func indexed(expression string, i int) string {
+	return fmt.Sprintf("%s[%d]", expression, i)
+}
+
+// oneOf lists allowed codes: "a", "a or b", "a, b or c".
+func oneOf(codes []string) string {
+	if len(codes) == 1 {
+		return codes[0]
+	}
+	return strings.Join(codes[:len(codes)-1], ", ") + " or " + codes[len(codes)-1]
+}
@@ -0,0 +1,126 @@
+package profile
+
+import (
+	"maps"
+	"mime"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// backportFilterCriteria is the extension carrying the filter of a topic-based
+// Subscription (Subscriptions R5 Backport).
+const backportFilterCriteria = "http://hl7.org/fhir/uv/subscriptions-backport/StructureDefinition/backport-filter-criteria"
+
+// criteriaParams are the search parameters of the Mitz consent criteria.
+var criteriaParams = []string{"_query", "patientid", "providerid", "providertype", "category"}
+
+// payloadTypes are the channel.payload media types notifications can be sent as.
+var payloadTypes = []string{"application/fhir+xml", "application/fhir+json", "application/xml+fhir"}
+
+// ValidateSubscription checks a Subscription (OTV-TR-0120) against the Mitz profile:
+//
+//	Consent?_query=otv&patientid={BSN}&providerid={URA}&providertype={code}[&category={codes}]
+//
+// as criteria (or as the filter of a topic-based Subscription), a rest-hook channel
+// with an https endpoint and a FHIR payload. The error is set for documents that
+// are not well-formed XML.
+func ValidateSubscription(body []byte) ([]Issue, error) {
+	root, err := parse(body)
+	if err != nil {
+		return nil, err
+	}
+	r := &report{}
+	if !r.root(root, "Subscription") {
+		return r.issues, nil
+	}
+
+	r.code(root.first("status"), "Subscription.status", "requested", "active", "error", "off")
+
+	criteria := root.first("criteria")
+	if r.required(criteria, "Subscription.criteria") {
+		filter, expression := criteria.value(), "Subscription.criteria"
+		for i, ext := range criteria.all("extension") {
+			if ext.attrs["url"] == backportFilterCriteria {
+				filter, expression = ext.valueOf("valueString"), indexed("Subscription.criteria.extension", i)+".valueString"
+				if topic := criteria.value(); !strings.HasPrefix(topic, "http://") && !strings.HasPrefix(topic, "https://") {
+					r.add("value", "Subscription.criteria", "Subscription.criteria of a topic-based Subscription must be the SubscriptionTopic canonical URL, got %q", topic)
+				}
+				break
+			}
+		}
+		r.criteria(filter, expression)
+	}
+
+	channel := root.first("channel")
+	if !r.required(channel, "Subscription.channel") {
+		return r.issues, nil
+	}
+	r.code(channel.first("type"), "Subscription.channel.type", "rest-hook")
+	if endpoint := channel.first("endpoint"); r.required(endpoint, "Subscription.channel.endpoint") {
+		if u, err := url.Parse(endpoint.value()); err != nil || u.Scheme != "https" || u.Host == "" {
+			r.add("value", "Subscription.channel.endpoint", "Subscription.channel.endpoint must be an absolute https URL, got %q", endpoint.value())
+		}
+	}
+	if payload := channel.first("payload"); r.required(payload, "Subscription.channel.payload") {
+		mediaType, _, err := mime.ParseMediaType(payload.value())
+		if err != nil || !slices.Contains(payloadTypes, mediaType) {
+			r.add("code-invalid", "Subscription.channel.payload", "Subscription.channel.payload must be %s, got %q", oneOf(payloadTypes), payload.value())
+		}
+	}
+	return r.issues, nil
+}
+
+// criteria checks the Mitz consent search of a Subscription.
+func (r *report) criteria(criteria, expression string) {
+	resource, query, _ := strings.Cut(criteria, "?")
+	if resource != "Consent" {
+		r.add("value", expression, "%s must search Consent (Consent?_query=otv&...), got %q", expression, criteria)
+		return
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		r.add("value", expression, "%s has an invalid query: %v", expression, err)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		switch values := params[name]; {
+		case !slices.Contains(criteriaParams, name):
+			r.add("not-supported", expression, "%s has unknown search parameter %s (allowed: %s)", expression, name, strings.Join(criteriaParams, ", "))
+		case len(values) > 1 && name != "category":
+			r.add("value", expression, "%s repeats search parameter %s", expression, name)
+		}
+	}
+	if q := params.Get("_query"); q != "otv" {
+		r.add("value", expression, "%s must have _query=otv, got %q", expression, q)
+	}
+	switch bsn := params.Get("patientid"); {
+	case bsn == "":
+		r.add("required", expression, "%s has no patientid", expression)
+	case !bsnRe.MatchString(bsn):
+		r.add("value", expression, "%s patientid must be a 9-digit BSN, got %q", expression, bsn)
+	}
+	switch ura := params.Get("providerid"); {
+	case ura == "":
+		r.add("required", expression, "%s has no providerid", expression)
+	case !uraRe.MatchString(ura):
+		r.add("value", expression, "%s providerid must be an 8-digit URA, got %q", expression, ura)
+	}
+	if params.Get("providertype") == "" {
+		r.add("required", expression, "%s has no providertype", expression)
+	}
+	for _, value := range params["category"] {
+		for token := range strings.SplitSeq(value, ",") {
+			system, code, found := strings.Cut(token, "|")
+			if !found {
+				system, code = "", system
+			}
+			switch {
+			case strings.TrimSpace(code) == "":
+				r.add("value", expression, "%s has an empty category", expression)
+			case found && system != systemGegevenscategory:
+				r.add("code-invalid", expression, "%s category system must be %s, got %q", expression, systemGegevenscategory, system)
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package provider is the register of healthcare organisations the replicator
+// knows by URA and custodian OID, so clients can resolve the custodians in XCPD
+// answers into organisation details.
+package provider
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"mitz-replicator/identity"
+)
+
+// Identifier systems an organisation can be searched by.
+const (
+	SystemURA = "http://fhir.nl/fhir/NamingSystem/ura"
+	SystemOID = "urn:ietf:rfc:3986"
+)
+
+// Organization is one register entry.
+type Organization struct {
+	URA  string `yaml:"ura" json:"ura"`
+	OID  string `yaml:"oid" json:"oid,omitempty"` // custodian OID as used in XCPD answers, "urn:oid:" optional
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type,omitempty"` // RoleCodeNL organisation type, e.g. Z3 (huisartspraktijk)
+	City string `yaml:"city" json:"city,omitempty"`
+
+	// AssigningAuthority is the root of the patient IDs the organisation issues,
+	// returned next to the BSN in XCPD answers.
+	AssigningAuthority string `yaml:"assigningAuthority" json:"assigningAuthority,omitempty"`
+
+	// Generated XCPD locations: the relative chance of being picked (default 1) and
+	// the gegevenscategorieën held (default by type, see Categories).
+	Weight     int      `yaml:"weight" json:"weight,omitempty"`
+	EventCodes []string `yaml:"eventCodes" json:"eventCodes,omitempty"`
+}
+
+// Categories returns the gegevenscategorieën the organisation holds: its EventCodes,
+// else the usual ones for its type.
+func (o Organization) Categories() []string {
+	if len(o.EventCodes) > 0 {
+		return slices.Clone(o.EventCodes)
+	}
+	if categories, ok := typeCategories[o.Type]; ok {
+		return slices.Clone(categories)
+	}
+	return []string{"huisartsgegevens", "medicatiegegevens"}
+}
+
+// Defaults are the custodians of the built-in XCPD location sets.
+var Defaults = []Organization{
+	{URA: "90000001", OID: "urn:oid:2.16.840.1.113883.2.4.6.6", Name: "Huisartsenpraktijk De Linde", Type: "Z3", City: "Utrecht"},
+	{URA: "90000002", OID: "urn:oid:2.16.840.1.113883.2.4.3.11", Name: "Apotheek Centrum", Type: "J8", City: "Utrecht"},
+}
+
+// Register looks up organisations by URA, custodian OID or name.
+type Register struct {
+	orgs     []Organization
+	replaced map[string]string // URA of a replaced organisation → URA of its replacement
+}
+
+// New returns a register of the built-in custodians, the configured organisations
+// and the organisations named in client identity mappings. A configured
+// organisation replaces a built-in one with the same URA or custodian OID; the
+// URA of the replaced one keeps resolving to it.
+func New(configured []Organization, mappings []identity.Mapping) *Register {
+	r := &Register{replaced: make(map[string]string)}
+	byURA := make(map[string]Organization)
+	for _, org := range Defaults {
+		byURA[org.URA] = org
+	}
+	for _, m := range mappings {
+		if _, ok := byURA[m.URA]; !ok && m.Organization != "" {
+			byURA[m.URA] = Organization{URA: m.URA, Name: m.Organization}
+		}
+	}
+	for _, org := range configured {
+		org.OID = normalizeOID(org.OID)
+		for ura, existing := range byURA {
+			if org.OID != "" && existing.OID == org.OID && ura != org.URA {
+				delete(byURA, ura)
+				r.replaced[ura] = org.URA
+			}
+		}
+		byURA[org.URA] = org
+	}
+
+	for _, org := range byURA {
+		r.orgs = append(r.orgs, org)
+	}
+	slices.SortFunc(r.orgs, func(a, b Organization) int { return strings.Compare(a.URA, b.URA) })
+	return r
+}
+
+// All returns every organisation, ordered by URA.
+func (r *Register) All() []Organization {
+	return slices.Clone(r.orgs)
+}
+
+// Lookup returns the organisation with the given URA, or the organisation that
+// replaced it.
+func (r *Register) Lookup(ura string) (Organization, bool) {
+	if replacement, ok := r.replaced[ura]; ok {
+		ura = replacement
+	}
+	i := slices.IndexFunc(r.orgs, func(org Organization) bool { return org.URA == ura })
+	if i < 0 {
+		return Organization{}, false
+	}
+	return r.orgs[i], true
+}
+
+// Search returns the organisations matching a FHIR token identifier ("system|value",
+// "|value" or "value") and a name prefix. Empty parameters match everything.
+func (r *Register) Search(identifier, name string) []Organization {
+	system, value, hasSystem := strings.Cut(identifier, "|")
+	if !hasSystem {
+		system, value = "", identifier
+	}
+	value = strings.TrimSpace(value)
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	var found []Organization
+	for _, org := range r.orgs {
+		if value != "" {
+			byURA := (system == "" || system == SystemURA) && org.URA == value
+			byOID := (system == "" || system == SystemOID) && org.OID != "" && org.OID == normalizeOID(value)
+			if !byURA && !byOID {
+				continue
+			}
+		}
+		if name != "" && !strings.HasPrefix(strings.ToLower(org.Name), name) {
+			continue
+		}
+		found = append(found, org)
+	}
+	return found
+}
+
+// Pick draws up to n organisations with a custodian OID from the register, without
+// replacement and in proportion to their weight. A non-empty filter limits the draw
+// to the organisations whose URA or type it lists.
+func (r *Register) Pick(rng *rand.Rand, n int, filter []string) []Organization {
+	var pool []Organization
+	for _, org := range r.orgs {
+		if org.OID == "" {
+			continue
+		}
+		if len(filter) > 0 && !slices.Contains(filter, org.URA) && !slices.Contains(filter, org.Type) {
+			continue
+		}
+		pool = append(pool, org)
+	}
+
+	var picked []Organization
+	for len(picked) < n && len(pool) > 0 {
+		total := 0
+		for _, org := range pool {
+			total += weight(org)
+		}
+		x := rng.IntN(total)
+		i := 0
+		for ; x >= weight(pool[i]); i++ {
+			x -= weight(pool[i])
+		}
+		picked = append(picked, pool[i])
+		pool = slices.Delete(pool, i, i+1)
+	}
+	return picked
+}
+
+func weight(org Organization) int {
+	return cmp.Or(org.Weight, 1)
+}
+
+// Validate checks that every organisation has a URA and a name, and that URAs and
+// OIDs are unique.
+func Validate(orgs []Organization) error {
+	seenURA, seenOID := make(map[string]int), make(map[string]int)
+	for i, org := range orgs {
+		switch {
+		case org.URA == "":
+			return fmt.Errorf("provider #%d: ura is required", i+1)
+		case strings.Trim(org.URA, "0123456789") != "":
+			return fmt.Errorf("provider #%d: ura %q must be numeric", i+1, org.URA)
+		case org.Name == "":
+			return fmt.Errorf("provider #%d: name is required", i+1)
+		case org.Weight < 0:
+			return fmt.Errorf("provider #%d: weight must not be negative", i+1)
+		case strings.Trim(org.AssigningAuthority, "0123456789.") != "":
+			return fmt.Errorf("provider #%d: assigningAuthority %q is not a dotted OID", i+1, org.AssigningAuthority)
+		}
+		if prev, ok := seenURA[org.URA]; ok {
+			return fmt.Errorf("provider #%d: ura %s already used by provider #%d", i+1, org.URA, prev)
+		}
+		seenURA[org.URA] = i + 1
+
+		if org.OID == "" {
+			continue
+		}
+		oid := normalizeOID(org.OID)
+		if strings.Trim(strings.TrimPrefix(oid, "urn:oid:"), "0123456789.") != "" {
+			return fmt.Errorf("provider #%d: oid %q is not a dotted OID", i+1, org.OID)
+		}
+		if prev, ok := seenOID[oid]; ok {
+			return fmt.Errorf("provider #%d: oid %s already used by provider #%d", i+1, oid, prev)
+		}
+		seenOID[oid] = i + 1
+	}
+	return nil
+}
+
+// normalizeOID trims oid and adds the "urn:oid:" prefix XCPD answers use.
+func normalizeOID(oid string) string {
+	oid = strings.TrimSpace(oid)
+	if oid == "" {
+		return ""
+	}
+	return "urn:oid:" + strings.TrimPrefix(oid, "urn:oid:")
+}
+
+// TypeDisplay returns the RoleCodeNL display name of a known organisation type.
+func TypeDisplay(code string) string {
+	return cmp.Or(typeDisplays[code], code)
+}
+
+// typeCategories are the gegevenscategorieën an organisation of a type usually holds.
+var typeCategories = map[string][]string{
+	"V4": {"labuitslagen", "beeldvorming", "opnamegegevens", "medicatiegegevens"},
+	"Z3": {"huisartsgegevens", "medicatiegegevens", "labuitslagen"},
+	"J8": {"medicatiegegevens"},
+	"X3": {"medicatiegegevens"},
+	"B2": {"beeldvorming"},
+	"R5": {"labuitslagen", "beeldvorming"},
+}
+
+var typeDisplays = map[string]string{
+	"V4": "Ziekenhuis",
+	"Z3": "Huisartspraktijk",
+	"J8": "Openbare apotheek",
+	"X3": "Verplegings- of verzorgingsinstelling",
+	"B2": "Tandartspraktijk",
+	"R5": "Verloskundigenpraktijk",
+}
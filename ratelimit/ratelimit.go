@@ -0,0 +1,136 @@
+// Package ratelimit simulates the Mitz rate limits with a token bucket per client:
+// each client may send Limit.Requests requests per Limit.Per, in bursts of at most
+// Limit.Requests.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBuckets bounds the buckets kept for clients; beyond it the least recently used
+// bucket is dropped.
+const maxBuckets = 10000
+
+// Limit is a number of requests per period.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses "<requests>/<period>", the period a Go duration or a bare unit
+// ("20/1s", "600/m").
+func ParseLimit(s string) (Limit, error) {
+	count, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	period = strings.TrimSpace(period)
+	if period != "" && strings.Trim(period[:1], "0123456789") != "" {
+		period = "1" + period
+	}
+	per, errPer := time.ParseDuration(period)
+	if !ok || err != nil || errPer != nil || n <= 0 || per <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q (expected <requests>/<period>, e.g. 20/1s or 600/m)", s)
+	}
+	return Limit{Requests: n, Per: per}, nil
+}
+
+// String formats l the way ParseLimit reads it, without zero trailing units ("600/1m").
+func (l Limit) String() string {
+	per := l.Per.String()
+	if strings.HasSuffix(per, "m0s") {
+		per = strings.TrimSuffix(per, "0s")
+	}
+	if strings.HasSuffix(per, "h0m") {
+		per = strings.TrimSuffix(per, "0m")
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, per)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter holds one bucket per client key.
+type Limiter struct {
+	limit   Limit
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time // last pruneLocked
+}
+
+// New returns a limiter for limit, with every client starting on a full bucket.
+func New(limit Limit) *Limiter {
+	return &Limiter{limit: limit, buckets: make(map[string]*bucket)}
+}
+
+// Limit returns the limiter's limit.
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Allow takes a token from key's bucket at now. When the bucket is empty it reports
+// false and how long until the next token; remaining is the number of tokens left.
+func (l *Limiter) Allow(key string, now time.Time) (ok bool, remaining int, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(l.limit.Requests)
+	rate := capacity / l.limit.Per.Seconds() // tokens per second
+
+	if now.Sub(l.pruned) >= l.limit.Per {
+		l.pruneLocked(now, capacity, rate)
+	}
+	b, found := l.buckets[key]
+	if !found {
+		if len(l.buckets) >= maxBuckets {
+			l.evictOldestLocked()
+		}
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration(math.Ceil((1 - b.tokens) / rate * float64(time.Second)))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// Reset refills every client's bucket.
+func (l *Limiter) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	clear(l.buckets)
+}
+
+// pruneLocked drops the buckets of idle clients: those that have refilled completely,
+// which every bucket unused for a whole period has. A new bucket starts full, so
+// dropping them changes no client's limit.
+func (l *Limiter) pruneLocked(now time.Time, capacity, rate float64) {
+	l.pruned = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// evictOldestLocked drops the least recently used bucket.
+func (l *Limiter) evictOldestLocked() {
+	var oldest string
+	for key, b := range l.buckets {
+		if oldest == "" || b.last.Before(l.buckets[oldest].last) {
+			oldest = key
+		}
+	}
+	delete(l.buckets, oldest)
+}
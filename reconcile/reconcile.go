@@ -0,0 +1,142 @@
+// Package reconcile compares the consents submitted in Bundle transactions with the
+// consents in the store, so a migration team can check a run for completeness
+// without scraping logs.
+package reconcile
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"mitz-replicator/storage"
+)
+
+// Submission is one Bundle transaction carrying a Consent, as received.
+type Submission struct {
+	RequestID  string    `json:"requestId,omitempty"`
+	Received   time.Time `json:"received"`
+	Status     int       `json:"status"`
+	Source     string    `json:"source,omitempty"` // migration or toestemmingsknop
+	BSN        string    `json:"bsn,omitempty"`
+	ProviderID string    `json:"providerId,omitempty"`
+	Decision   string    `json:"decision,omitempty"`
+	Categories []string  `json:"categories,omitempty"`
+	Error      string    `json:"error,omitempty"` // why the Bundle could not be read
+}
+
+// succeeded reports whether the replicator accepted the submission.
+func (s Submission) succeeded() bool {
+	return s.Status >= 200 && s.Status <= 299 && s.Error == ""
+}
+
+// Duplicate is a consent stored more than once.
+type Duplicate struct {
+	BSN        string   `json:"bsn"`
+	ProviderID string   `json:"providerId,omitempty"`
+	Decision   string   `json:"decision,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	ConsentIDs []string `json:"consentIds"`
+	Submitted  int      `json:"submitted"` // accepted submissions of this consent
+}
+
+// Summary counts the report entries.
+type Summary struct {
+	Submitted  int `json:"submitted"`
+	Stored     int `json:"stored"`
+	Matched    int `json:"matched"`
+	Failed     int `json:"failed"`
+	Missing    int `json:"missing"`
+	Duplicated int `json:"duplicated"`
+	Unmatched  int `json:"unmatched"`
+}
+
+// Report is the outcome of a reconciliation.
+type Report struct {
+	Generated time.Time `json:"generated"`
+	Since     time.Time `json:"since,omitzero"`
+	Source    string    `json:"source,omitempty"`
+	Summary   Summary   `json:"summary"`
+
+	Failed     []Submission      `json:"failed"`     // rejected by the replicator
+	Missing    []Submission      `json:"missing"`    // accepted, but no consent in the store
+	Duplicated []Duplicate       `json:"duplicated"` // stored more than once
+	Unmatched  []storage.Consent `json:"unmatched"`  // stored without a recorded submission
+}
+
+// key identifies a consent across submissions and the store: patient, organisation,
+// decision and categories.
+func key(bsn, providerID, decision string, categories []string) string {
+	cats := make([]string, len(categories))
+	for i, cat := range categories {
+		cats[i] = strings.ToLower(cat)
+	}
+	slices.Sort(cats)
+	return strings.Join([]string{bsn, providerID, decision, strings.Join(cats, " ")}, "|")
+}
+
+// Build compares submissions with the stored consents, both in the order they were
+// received. Accepted submissions are matched with stored consents of the same key
+// in order; the excess on either side is reported as missing or, for keys never
+// submitted, unmatched. Keys stored more than once are duplicated.
+func Build(submissions []Submission, consents []storage.Consent) Report {
+	r := Report{
+		Failed:     []Submission{},
+		Missing:    []Submission{},
+		Duplicated: []Duplicate{},
+		Unmatched:  []storage.Consent{},
+	}
+
+	accepted := make(map[string][]Submission)
+	var submittedKeys []string
+	for _, s := range submissions {
+		if !s.succeeded() {
+			r.Failed = append(r.Failed, s)
+			continue
+		}
+		k := key(s.BSN, s.ProviderID, s.Decision, s.Categories)
+		if _, seen := accepted[k]; !seen {
+			submittedKeys = append(submittedKeys, k)
+		}
+		accepted[k] = append(accepted[k], s)
+	}
+
+	stored := make(map[string][]storage.Consent)
+	var storedKeys []string
+	for _, c := range consents {
+		k := key(c.BSN, c.ProviderID, c.Decision, c.Categories)
+		if _, seen := stored[k]; !seen {
+			storedKeys = append(storedKeys, k)
+		}
+		stored[k] = append(stored[k], c)
+	}
+
+	for _, k := range submittedKeys {
+		subs := accepted[k]
+		matched := min(len(subs), len(stored[k]))
+		r.Summary.Matched += matched
+		r.Missing = append(r.Missing, subs[matched:]...)
+	}
+
+	for _, k := range storedKeys {
+		list := stored[k]
+		if len(accepted[k]) == 0 {
+			r.Unmatched = append(r.Unmatched, list...)
+		}
+		if len(list) > 1 {
+			first := list[0]
+			d := Duplicate{BSN: first.BSN, ProviderID: first.ProviderID, Decision: first.Decision, Categories: first.Categories, Submitted: len(accepted[k])}
+			for _, c := range list {
+				d.ConsentIDs = append(d.ConsentIDs, c.ID)
+			}
+			r.Duplicated = append(r.Duplicated, d)
+		}
+	}
+
+	r.Summary.Submitted = len(submissions)
+	r.Summary.Stored = len(consents)
+	r.Summary.Failed = len(r.Failed)
+	r.Summary.Missing = len(r.Missing)
+	r.Summary.Duplicated = len(r.Duplicated)
+	r.Summary.Unmatched = len(r.Unmatched)
+	return r
+}
@@ -0,0 +1,179 @@
+// Package registry announces the replicator to a central test-environment registry
+// and keeps it informed with periodic heartbeats, so instances run by different
+// teams can be found, and their health seen, in one place.
+package registry
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Events sent to the registry.
+const (
+	EventRegister   = "register"   // first successful ping
+	EventHeartbeat  = "heartbeat"  // every ping after that
+	EventDeregister = "deregister" // on shutdown
+)
+
+// deregisterTimeout bounds the deregistration sent on shutdown.
+const deregisterTimeout = 5 * time.Second
+
+// Instance describes this replicator to the registry.
+type Instance struct {
+	ID           string    `json:"id"`
+	URL          string    `json:"url"`
+	Version      string    `json:"version"`
+	MitzVersions []string  `json:"mitzVersions,omitempty"` // selectable template versions
+	Features     []string  `json:"features"`
+	Tenants      []string  `json:"tenants"`
+	StartedAt    time.Time `json:"startedAt"`
+}
+
+// State is the part of a ping that changes while the instance runs.
+type State struct {
+	Status  string   // up or maintenance; pings report down on deregister
+	Tenants []string // tenants with stored state, added to the configured ones
+}
+
+// Ping is the JSON document POSTed to the registry. Every ping carries the full
+// instance description, so a registry that lost its state picks the instance up again.
+type Ping struct {
+	Event string `json:"event"`
+	Instance
+	Status        string    `json:"status"`
+	UptimeSeconds int64     `json:"uptimeSeconds"`
+	SentAt        time.Time `json:"sentAt"`
+}
+
+// Reporter sends the pings.
+type Reporter struct {
+	client   *http.Client
+	url      string
+	token    string
+	interval time.Duration
+	instance Instance
+	state    func() State
+
+	registered bool
+	failing    bool
+}
+
+// New returns a reporter that POSTs to url every interval, authenticating with token
+// as a bearer token when set. state is read for every ping.
+func New(client *http.Client, url, token string, interval time.Duration, instance Instance, state func() State) *Reporter {
+	return &Reporter{client: client, url: url, token: token, interval: interval, instance: instance, state: state}
+}
+
+// Run registers the instance and sends heartbeats until ctx is cancelled, then
+// deregisters it. Failures are logged once, and recovery again; the next ping retries.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.ping(ctx)
+		select {
+		case <-ctx.Done():
+			r.deregister(ctx)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deregister tells the registry the instance is going away, if it was registered.
+func (r *Reporter) deregister(ctx context.Context) {
+	if !r.registered {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deregisterTimeout)
+	defer cancel()
+
+	if err := r.send(ctx, EventDeregister); err != nil {
+		log.Printf("[REGISTRY] deregister failed: %v", err)
+		return
+	}
+	log.Printf("[REGISTRY] Deregistered %s at %s", r.instance.ID, r.url)
+}
+
+func (r *Reporter) ping(ctx context.Context) {
+	event := EventHeartbeat
+	if !r.registered {
+		event = EventRegister
+	}
+
+	err := r.send(ctx, event)
+	switch {
+	case err != nil && !r.failing:
+		log.Printf("[REGISTRY] %s failed, retrying every %s: %v", event, r.interval, err)
+	case err == nil && r.failing:
+		log.Printf("[REGISTRY] %s to %s succeeded again", event, r.url)
+	case err == nil && !r.registered:
+		log.Printf("[REGISTRY] Registered %s at %s", r.instance.ID, r.url)
+	}
+	r.failing = err != nil
+	if err == nil {
+		r.registered = true
+	}
+}
+
+func (r *Reporter) send(ctx context.Context, event string) error {
+	state := r.state()
+	ping := Ping{
+		Event:         event,
+		Instance:      r.instance,
+		Status:        cmp.Or(state.Status, "up"),
+		UptimeSeconds: int64(time.Since(r.instance.StartedAt).Seconds()),
+		SentAt:        time.Now().UTC(),
+	}
+	ping.Tenants = mergeTenants(r.instance.Tenants, state.Tenants)
+	if event == EventDeregister {
+		ping.Status = "down"
+	}
+
+	body, err := json.Marshal(ping)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("registry returned %s", resp.Status)
+	}
+	return nil
+}
+
+// mergeTenants returns the configured tenants followed by the stored ones not
+// configured, without duplicates.
+func mergeTenants(configured, stored []string) []string {
+	seen := make(map[string]bool, len(configured))
+	merged := make([]string, 0, len(configured)+len(stored))
+	for _, tenant := range slices.Concat(configured, stored) {
+		if tenant != "" && !seen[tenant] {
+			seen[tenant] = true
+			merged = append(merged, tenant)
+		}
+	}
+	return merged
+}
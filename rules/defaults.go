@@ -0,0 +1,116 @@
+package rules
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// BuiltinBSNs are the test BSNs the built-in rule table routes on. Each can be
+// replaced through MagicBSN configuration.
+var BuiltinBSNs = []string{
+	"000000001", "000000002", "000000003", "000000004",
+	"000000005", "000000006", "000000007", "000000008",
+	"000000009", "000000010", "999999*",
+}
+
+// MagicBSN replaces a built-in test BSN, optionally with a description that is
+// logged whenever one of its rules matches.
+type MagicBSN struct {
+	BSN         string `yaml:"bsn" json:"bsn"` // exact, or prefix with trailing "*"
+	Description string `yaml:"description" json:"description,omitempty"`
+}
+
+// bsnNotFound is the SOAP fault returned for BSNs unknown to the register.
+var bsnNotFound = SoapFault{
+	Code:    "soap:Sender",
+	Subcode: "mitz:InvalidRequest",
+	Reason:  "Patient BSN not found in register",
+}
+
+// fhirErrorRules returns the FHIR error scenarios (BSN 000000003 and 000000005) for endpoint.
+func fhirErrorRules(endpoint string) []Rule {
+	return []Rule{
+		{
+			Name:        endpoint + " BSN not found",
+			Description: "400 BSN not found",
+			Match:       Match{Endpoint: endpoint, BSN: "000000003"},
+			Outcome:     Outcome{FhirError: &FhirError{Status: 400, Code: "processing", Diagnostics: "Patient BSN not found in register"}},
+		},
+		{
+			Name:        endpoint + " server error",
+			Description: "500 server error",
+			Match:       Match{Endpoint: endpoint, BSN: "000000005"},
+			Outcome:     Outcome{FhirError: &FhirError{Status: 500, Severity: "fatal", Code: "exception", Diagnostics: "Internal server error"}},
+		},
+	}
+}
+
+// Defaults returns the built-in BSN routing table (see README "BSN-Based Mock Routing")
+// with the built-in BSNs replaced as configured in magic (keyed by built-in BSN).
+// Configured rules are evaluated before these unless given a negative priority.
+func Defaults(magic map[string]MagicBSN) []Rule {
+	xacmlFault := bsnNotFound
+	xacmlFault.Detail = "The requested BSN is not known in the Mitz consent register"
+
+	defaults := []Rule{
+		// XACML — gesloten autorisatievraag
+		{Name: "xacml all permit", Description: "all Permit", Match: Match{Endpoint: EndpointXACML, BSN: "000000001"}, Outcome: Outcome{Decisions: []string{"Permit"}}},
+		{Name: "xacml all deny", Description: "all Deny", Match: Match{Endpoint: EndpointXACML, BSN: "000000002"}, Outcome: Outcome{Decisions: []string{"Deny"}}},
+		{Name: "xacml first permit", Description: "first Permit, rest Deny", Match: Match{Endpoint: EndpointXACML, BSN: "000000003"}, Outcome: Outcome{Decisions: []string{"Permit", "Deny"}}},
+		{Name: "xacml indeterminate", Description: "all Indeterminate", Match: Match{Endpoint: EndpointXACML, BSN: "000000004"}, Outcome: Outcome{Decisions: []string{"Indeterminate"}}},
+		{Name: "xacml fault", Description: "SOAP fault", Match: Match{Endpoint: EndpointXACML, BSN: "000000005"}, Outcome: Outcome{SoapFault: &xacmlFault}},
+		{Name: "xacml upper-cased event codes", Description: "all Permit, event codes upper-cased", Match: Match{Endpoint: EndpointXACML, BSN: "000000008"}, Outcome: Outcome{Decisions: []string{"Permit"}, UpperCaseEventCodes: true}},
+
+		// XCPD — open autorisatievraag
+		{Name: "xcpd two locations", Description: "two locations", Match: Match{Endpoint: EndpointXCPD, BSN: "000000001"}, Outcome: Outcome{Locations: "two-locations"}},
+		{Name: "xcpd one location", Description: "one location", Match: Match{Endpoint: EndpointXCPD, BSN: "000000002"}, Outcome: Outcome{Locations: "one-location"}},
+		{Name: "xcpd patient not found", Description: "patient not found", Match: Match{Endpoint: EndpointXCPD, BSN: "000000003"}, Outcome: Outcome{Locations: "empty"}},
+		{Name: "xcpd fault", Description: "SOAP fault", Match: Match{Endpoint: EndpointXCPD, BSN: "000000004"}, Outcome: Outcome{SoapFault: &bsnNotFound}},
+		{Name: "xcpd fault", Description: "SOAP fault", Match: Match{Endpoint: EndpointXCPD, BSN: "000000005"}, Outcome: Outcome{SoapFault: &bsnNotFound}},
+		{Name: "xcpd untrimmed custodians", Description: "custodian OIDs with surrounding whitespace", Match: Match{Endpoint: EndpointXCPD, BSN: "000000006"}, Outcome: Outcome{Locations: "untrimmed-custodians"}},
+		{Name: "xcpd duplicated location", Description: "same location returned twice", Match: Match{Endpoint: EndpointXCPD, BSN: "000000007"}, Outcome: Outcome{Locations: "duplicated"}},
+		{Name: "xcpd mixed-case event codes", Description: "event codes in mixed case", Match: Match{Endpoint: EndpointXCPD, BSN: "000000008"}, Outcome: Outcome{Locations: "mixed-case"}},
+		{Name: "xcpd withheld acknowledgement", Description: "two locations, accept acknowledgement withheld", Match: Match{Endpoint: EndpointXCPD, BSN: "000000010"}, Outcome: Outcome{Locations: "two-locations", WithholdAck: true}},
+		{Name: "xcpd counted locations", Description: "as many locations as the seventh BSN digit", Match: Match{Endpoint: EndpointXCPD, BSN: "999999*"}, Outcome: Outcome{Locations: "counted"}},
+		{Name: "xcpd partial result", Description: "one location, some sources unavailable", Match: Match{Endpoint: EndpointXCPD, BSN: "000000009"}, Outcome: Outcome{Locations: "one-location", Warning: &Warning{Text: "Some sources unavailable; locations may be incomplete"}}},
+	}
+
+	defaults = append(defaults, fhirErrorRules(EndpointSubscription)...)
+	defaults = append(defaults, fhirErrorRules(EndpointBundle)...)
+
+	for i, r := range defaults {
+		builtin := r.Match.BSN
+		if m, ok := magic[builtin]; ok {
+			defaults[i].Match.BSN = m.BSN
+			if m.Description != "" {
+				defaults[i].Description = m.Description
+			}
+		}
+		defaults[i].Description = "BSN " + defaults[i].Match.BSN + ": " + defaults[i].Description
+	}
+	return defaults
+}
+
+// ValidateMagicBSNs checks that magic only replaces built-in BSNs, each by a distinct BSN.
+func ValidateMagicBSNs(magic map[string]MagicBSN) error {
+	seen := make(map[string]string)
+	for _, builtin := range slices.Sorted(maps.Keys(magic)) {
+		m := magic[builtin]
+		if !slices.Contains(BuiltinBSNs, builtin) {
+			return fmt.Errorf("%s is not a built-in BSN (expected one of %s)", builtin, strings.Join(BuiltinBSNs, ", "))
+		}
+		if m.BSN == "" {
+			return fmt.Errorf("%s: bsn is required", builtin)
+		}
+		if _, replaced := magic[m.BSN]; slices.Contains(BuiltinBSNs, m.BSN) && !replaced {
+			return fmt.Errorf("%s: bsn %s is still in use as a built-in BSN", builtin, m.BSN)
+		}
+		if other, ok := seen[m.BSN]; ok {
+			return fmt.Errorf("%s: bsn %s is already used for %s", builtin, m.BSN, other)
+		}
+		seen[m.BSN] = builtin
+	}
+	return nil
+}
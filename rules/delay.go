@@ -0,0 +1,60 @@
+package rules
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delay is an artificial response delay: fixed when Min equals Max, otherwise drawn
+// uniformly from [Min, Max].
+type Delay struct {
+	Min, Max time.Duration
+}
+
+// ParseDelay parses a delay in milliseconds, fixed ("500") or as a range ("200-800").
+func ParseDelay(s string) (Delay, error) {
+	lo, hi, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	if !isRange {
+		hi = lo
+	}
+	loMs, errLo := strconv.Atoi(strings.TrimSpace(lo))
+	hiMs, errHi := strconv.Atoi(strings.TrimSpace(hi))
+	if errLo != nil || errHi != nil || loMs < 0 || hiMs < loMs {
+		return Delay{}, fmt.Errorf("invalid delay %q (expected milliseconds, e.g. 500 or 200-800)", s)
+	}
+	return Delay{Min: time.Duration(loMs) * time.Millisecond, Max: time.Duration(hiMs) * time.Millisecond}, nil
+}
+
+// Duration returns the delay to apply: Min, or a random value up to Max.
+func (d Delay) Duration() time.Duration {
+	return d.DurationFrom(nil)
+}
+
+// DurationFrom is Duration drawing from rng, or the global source when nil.
+func (d Delay) DurationFrom(rng *rand.Rand) time.Duration {
+	if d.Max <= d.Min {
+		return d.Min
+	}
+	if rng == nil {
+		return d.Min + rand.N(d.Max-d.Min+1)
+	}
+	return d.Min + time.Duration(rng.Int64N(int64(d.Max-d.Min+1)))
+}
+
+// String formats d the way ParseDelay reads it.
+func (d Delay) String() string {
+	if d.Max <= d.Min {
+		return strconv.FormatInt(d.Min.Milliseconds(), 10)
+	}
+	return fmt.Sprintf("%d-%d", d.Min.Milliseconds(), d.Max.Milliseconds())
+}
+
+// Delay returns the outcome's delay: delayMs, or a range up to delayMaxMs.
+func (o Outcome) Delay() Delay {
+	d := Delay{Min: time.Duration(o.DelayMs) * time.Millisecond}
+	d.Max = max(d.Min, time.Duration(o.DelayMaxMs)*time.Millisecond)
+	return d
+}
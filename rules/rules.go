@@ -0,0 +1,286 @@
+// Package rules maps request attributes (BSN, URA, event code, path, headers) to
+// mock outcomes. Rules are evaluated in priority order; the first match wins.
+package rules
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Endpoints a rule can be restricted to.
+const (
+	EndpointXACML        = "xacml"
+	EndpointXCPD         = "xcpd"
+	EndpointSubscription = "fhir-subscription"
+	EndpointBundle       = "fhir-bundle"
+)
+
+var endpoints = []string{EndpointXACML, EndpointXCPD, EndpointSubscription, EndpointBundle}
+
+// LocationSets are the named XCPD location sets an outcome can select.
+var LocationSets = []string{
+	"default", "two-locations", "one-location", "empty",
+	"untrimmed-custodians", "duplicated", "mixed-case", "register", "counted",
+}
+
+var decisions = []string{"Permit", "Deny", "Indeterminate", "NotApplicable"}
+
+// Ways an outcome can drop the connection instead of completing the response.
+const (
+	DisconnectBeforeHeaders = "before-headers" // close without sending a response
+	DisconnectMidResponse   = "mid-response"   // close after the headers and half the body
+	DisconnectReset         = "reset"          // reset (TCP RST) without sending a response
+)
+
+var disconnects = []string{DisconnectBeforeHeaders, DisconnectMidResponse, DisconnectReset}
+
+// Ways an outcome can corrupt an otherwise normal response.
+const (
+	MalformedBrokenXML        = "broken-xml"         // body is not well-formed XML
+	MalformedWrongContentType = "wrong-content-type" // body sent as text/html
+	MalformedTruncated        = "truncated"          // only the first half of the body, as a complete response
+)
+
+var malformations = []string{MalformedBrokenXML, MalformedWrongContentType, MalformedTruncated}
+
+// Ways an outcome can spoil the signature of a signed SOAP response.
+const (
+	SignatureBadDigest    = "bad-digest"    // Body changed after signing
+	SignatureBadSignature = "bad-signature" // SignatureValue corrupted
+	SignatureUntrusted    = "untrusted"     // signed with an unknown self-signed certificate
+	SignatureExpired      = "expired"       // Timestamp expired before the response was sent
+	SignatureUnsigned     = "unsigned"      // no wsse:Security header
+)
+
+var signatureDefects = []string{SignatureBadDigest, SignatureBadSignature, SignatureUntrusted, SignatureExpired, SignatureUnsigned}
+
+// policyVersion is the XACML VersionType of a policy reference.
+var policyVersion = regexp.MustCompile(`^\d+(\.\d+)*$`)
+
+// Rule maps a request match to an outcome.
+type Rule struct {
+	Name        string  `yaml:"name" json:"name"`
+	Description string  `yaml:"description" json:"description,omitempty"` // logged when the rule matches
+	Priority    int     `yaml:"priority" json:"priority"`                 // higher is evaluated first
+	Match       Match   `yaml:"match" json:"match"`
+	Outcome     Outcome `yaml:"outcome" json:"outcome"`
+}
+
+// Match selects requests. Empty fields match anything; all set fields must match.
+type Match struct {
+	Endpoint  string            `yaml:"endpoint" json:"endpoint,omitempty"`
+	BSN       string            `yaml:"bsn" json:"bsn,omitempty"`             // exact, or prefix with trailing "*"
+	URA       string            `yaml:"ura" json:"ura,omitempty"`             // exact, or prefix with trailing "*"
+	EventCode string            `yaml:"eventCode" json:"eventCode,omitempty"` // any requested event code, case-insensitive
+	Path      string            `yaml:"path" json:"path,omitempty"`           // URL path prefix
+	Headers   map[string]string `yaml:"headers" json:"headers,omitempty"`     // exact header values
+}
+
+// Outcome describes the mock response. Fields that don't apply to the matched
+// endpoint are ignored.
+type Outcome struct {
+	Decisions           []string   `yaml:"decisions" json:"decisions,omitempty"`                     // XACML: per event code; the last repeats
+	UpperCaseEventCodes bool       `yaml:"upperCaseEventCodes" json:"upperCaseEventCodes,omitempty"` // XACML: echo event codes upper-cased
+	Locations           string     `yaml:"locations" json:"locations,omitempty"`                     // XCPD: one of LocationSets
+	Custodians          []string   `yaml:"custodians" json:"custodians,omitempty"`                   // XCPD with locations register: URAs or organisation types to pick from
+	Warning             *Warning   `yaml:"warning" json:"warning,omitempty"`                         // XCPD: warning returned with the locations
+	SoapFault           *SoapFault `yaml:"soapFault" json:"soapFault,omitempty"`                     // XACML/XCPD
+	FhirError           *FhirError `yaml:"fhirError" json:"fhirError,omitempty"`                     // FHIR endpoints
+	RetryAfter          string     `yaml:"retryAfter" json:"retryAfter,omitempty"`
+	DelayMs             int        `yaml:"delayMs" json:"delayMs,omitempty"`
+	DelayMaxMs          int        `yaml:"delayMaxMs" json:"delayMaxMs,omitempty"`             // with delayMs: random delay between the two
+	Disconnect          string     `yaml:"disconnect" json:"disconnect,omitempty"`             // drop the connection: before-headers, mid-response or reset
+	StreamChunkBytes    int        `yaml:"streamChunkBytes" json:"streamChunkBytes,omitempty"` // send the body in chunks of this size
+	StreamIntervalMs    int        `yaml:"streamIntervalMs" json:"streamIntervalMs,omitempty"` // with streamChunkBytes: pause between chunks
+	Hang                bool       `yaml:"hang" json:"hang,omitempty"`                         // never respond; the connection is dropped at the hang cap
+	Malformed           string     `yaml:"malformed" json:"malformed,omitempty"`               // corrupt the response: broken-xml, wrong-content-type or truncated
+	BandwidthBytes      int        `yaml:"bandwidthBytes" json:"bandwidthBytes,omitempty"`     // write the body at this many bytes per second
+	Signature           string     `yaml:"signature" json:"signature,omitempty"`               // XACML/XCPD with SIGN_RESPONSES: bad-digest, bad-signature, untrusted, expired or unsigned
+	Policies            []Policy   `yaml:"policies" json:"policies,omitempty"`                 // XACML: PolicyIdentifierList of every result
+	WithholdAck         bool       `yaml:"withholdAck" json:"withholdAck,omitempty"`           // XCPD: leave out the accept acknowledgement of a deferred query
+}
+
+// Policy is a policy (set) reported as evaluated in an XACML PolicyIdentifierList.
+type Policy struct {
+	ID      string `yaml:"id" json:"id"`
+	Version string `yaml:"version" json:"version"`
+	Set     bool   `yaml:"set" json:"set,omitempty"` // a PolicySetIdReference instead of a PolicyIdReference
+}
+
+// SoapFault is a SOAP fault outcome.
+type SoapFault struct {
+	Status  int    `yaml:"status" json:"status,omitempty"` // HTTP status (default 200)
+	Code    string `yaml:"code" json:"code,omitempty"`     // default soap:Sender
+	Subcode string `yaml:"subcode" json:"subcode,omitempty"`
+	Reason  string `yaml:"reason" json:"reason"`
+	Detail  string `yaml:"detail" json:"detail,omitempty"` // default "RequestId: <id>"
+}
+
+// Warning is a warning-level detected issue returned with XCPD locations, for
+// partial results.
+type Warning struct {
+	Code string `yaml:"code" json:"code,omitempty"` // default PartialResult
+	Text string `yaml:"text" json:"text"`
+}
+
+// FhirError is an OperationOutcome outcome.
+type FhirError struct {
+	Status      int    `yaml:"status" json:"status"`
+	Severity    string `yaml:"severity" json:"severity,omitempty"` // default error
+	Code        string `yaml:"code" json:"code"`
+	Diagnostics string `yaml:"diagnostics" json:"diagnostics"`
+}
+
+// Request is the subset of a request that rules match on.
+type Request struct {
+	Endpoint   string
+	Path       string
+	BSN        string
+	URA        string
+	EventCodes []string
+	Header     http.Header
+}
+
+// Engine evaluates rules in priority order.
+type Engine struct {
+	rules []Rule
+}
+
+// New returns an engine for rules. Rules with equal priority keep their given order.
+func New(rules []Rule) *Engine {
+	sorted := slices.Clone(rules)
+	slices.SortStableFunc(sorted, func(a, b Rule) int { return b.Priority - a.Priority })
+	return &Engine{rules: sorted}
+}
+
+// Rules returns the rules in evaluation order.
+func (e *Engine) Rules() []Rule {
+	return slices.Clone(e.rules)
+}
+
+// RoutesBSN reports whether a rule matches on bsn by its BSN.
+func (e *Engine) RoutesBSN(bsn string) bool {
+	return slices.ContainsFunc(e.rules, func(r Rule) bool {
+		return r.Match.BSN != "" && matchValue(r.Match.BSN, bsn)
+	})
+}
+
+// Evaluate returns the first rule matching req.
+func (e *Engine) Evaluate(req Request) (Rule, bool) {
+	for _, r := range e.rules {
+		if r.Match.matches(req) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+func (m Match) matches(req Request) bool {
+	if m.Endpoint != "" && m.Endpoint != req.Endpoint {
+		return false
+	}
+	if m.BSN != "" && !matchValue(m.BSN, req.BSN) {
+		return false
+	}
+	if m.URA != "" && !matchValue(m.URA, req.URA) {
+		return false
+	}
+	if m.EventCode != "" && !slices.ContainsFunc(req.EventCodes, func(code string) bool {
+		return strings.EqualFold(code, m.EventCode) || strings.HasSuffix(strings.ToLower(code), "^"+strings.ToLower(m.EventCode))
+	}) {
+		return false
+	}
+	if m.Path != "" && !strings.HasPrefix(req.Path, m.Path) {
+		return false
+	}
+	for name, value := range m.Headers {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matchValue matches value exactly, or by prefix when pattern ends in "*".
+func matchValue(pattern, value string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(value, prefix)
+	}
+	return pattern == value
+}
+
+// Validate checks a rule set for unknown endpoints, decisions, location sets,
+// fault statuses, disconnect modes and policy references.
+func Validate(rules []Rule) error {
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		if r.Match.Endpoint != "" && !slices.Contains(endpoints, r.Match.Endpoint) {
+			return fmt.Errorf("rule %s: unknown endpoint %q (expected one of %s)", name, r.Match.Endpoint, strings.Join(endpoints, ", "))
+		}
+		for _, d := range r.Outcome.Decisions {
+			if !slices.Contains(decisions, d) {
+				return fmt.Errorf("rule %s: unknown decision %q (expected one of %s)", name, d, strings.Join(decisions, ", "))
+			}
+		}
+		if r.Outcome.Locations != "" && !slices.Contains(LocationSets, r.Outcome.Locations) {
+			return fmt.Errorf("rule %s: unknown location set %q (expected one of %s)", name, r.Outcome.Locations, strings.Join(LocationSets, ", "))
+		}
+		if len(r.Outcome.Custodians) > 0 && r.Outcome.Locations != "register" {
+			return fmt.Errorf("rule %s: custodians requires locations: register", name)
+		}
+		if w := r.Outcome.Warning; w != nil && w.Text == "" {
+			return fmt.Errorf("rule %s: warning.text is required", name)
+		}
+		if r.Outcome.Warning != nil && r.Outcome.Locations == "empty" {
+			return fmt.Errorf("rule %s: warning cannot be combined with locations: empty", name)
+		}
+		for _, p := range r.Outcome.Policies {
+			if p.ID == "" {
+				return fmt.Errorf("rule %s: policies[].id is required", name)
+			}
+			if !policyVersion.MatchString(p.Version) {
+				return fmt.Errorf("rule %s: policy %s: version %q must be dotted numbers like 1.0", name, p.ID, p.Version)
+			}
+		}
+		if f := r.Outcome.SoapFault; f != nil && f.Status != 0 && f.Status != http.StatusOK && (f.Status < 400 || f.Status > 599) {
+			return fmt.Errorf("rule %s: soapFault.status must be 200 or a 4xx or 5xx status", name)
+		}
+		if f := r.Outcome.FhirError; f != nil && (f.Status < 400 || f.Status > 599) {
+			return fmt.Errorf("rule %s: fhirError.status must be a 4xx or 5xx status", name)
+		}
+		if r.Outcome.Disconnect != "" && !slices.Contains(disconnects, r.Outcome.Disconnect) {
+			return fmt.Errorf("rule %s: unknown disconnect %q (expected one of %s)", name, r.Outcome.Disconnect, strings.Join(disconnects, ", "))
+		}
+		if r.Outcome.Malformed != "" && !slices.Contains(malformations, r.Outcome.Malformed) {
+			return fmt.Errorf("rule %s: unknown malformed %q (expected one of %s)", name, r.Outcome.Malformed, strings.Join(malformations, ", "))
+		}
+		if r.Outcome.Signature != "" && !slices.Contains(signatureDefects, r.Outcome.Signature) {
+			return fmt.Errorf("rule %s: unknown signature %q (expected one of %s)", name, r.Outcome.Signature, strings.Join(signatureDefects, ", "))
+		}
+		if r.Outcome.StreamChunkBytes < 0 || r.Outcome.StreamIntervalMs < 0 {
+			return fmt.Errorf("rule %s: streamChunkBytes and streamIntervalMs must not be negative", name)
+		}
+		if r.Outcome.StreamIntervalMs > 0 && r.Outcome.StreamChunkBytes == 0 {
+			return fmt.Errorf("rule %s: streamIntervalMs requires streamChunkBytes", name)
+		}
+		if r.Outcome.BandwidthBytes < 0 {
+			return fmt.Errorf("rule %s: bandwidthBytes must not be negative", name)
+		}
+		if r.Outcome.BandwidthBytes > 0 && r.Outcome.StreamChunkBytes > 0 {
+			return fmt.Errorf("rule %s: bandwidthBytes and streamChunkBytes cannot be combined", name)
+		}
+		if r.Outcome.DelayMs < 0 {
+			return fmt.Errorf("rule %s: delayMs must not be negative", name)
+		}
+		if r.Outcome.DelayMaxMs != 0 && r.Outcome.DelayMaxMs < r.Outcome.DelayMs {
+			return fmt.Errorf("rule %s: delayMaxMs must be at least delayMs", name)
+		}
+	}
+	return nil
+}
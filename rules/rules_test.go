@@ -0,0 +1,36 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFaultStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		outcome Outcome
+		wantErr string
+	}{
+		{"soap fault default status", Outcome{SoapFault: &SoapFault{Reason: "down"}}, ""},
+		{"soap fault 200", Outcome{SoapFault: &SoapFault{Status: 200, Reason: "down"}}, ""},
+		{"soap fault 500", Outcome{SoapFault: &SoapFault{Status: 500, Reason: "down"}}, ""},
+		{"soap fault 42", Outcome{SoapFault: &SoapFault{Status: 42, Reason: "down"}}, "soapFault.status"},
+		{"soap fault 302", Outcome{SoapFault: &SoapFault{Status: 302, Reason: "down"}}, "soapFault.status"},
+		{"soap fault 1000", Outcome{SoapFault: &SoapFault{Status: 1000, Reason: "down"}}, "soapFault.status"},
+		{"fhir error 404", Outcome{FhirError: &FhirError{Status: 404}}, ""},
+		{"fhir error without status", Outcome{FhirError: &FhirError{}}, "fhirError.status"},
+		{"fhir error 600", Outcome{FhirError: &FhirError{Status: 600}}, "fhirError.status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate([]Rule{{Name: "r", Outcome: tt.outcome}})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate: %v, want no error", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate: %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package rules
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream makes a response arrive in chunks of ChunkBytes, Interval apart.
+type Stream struct {
+	ChunkBytes int
+	Interval   time.Duration
+}
+
+// ParseStream parses a chunk size in bytes and an interval in milliseconds ("64/200").
+func ParseStream(s string) (Stream, error) {
+	size, interval, _ := strings.Cut(strings.TrimSpace(s), "/")
+	chunk, errChunk := strconv.Atoi(strings.TrimSpace(size))
+	ms, errMs := strconv.Atoi(strings.TrimSpace(cmp.Or(interval, "0")))
+	if errChunk != nil || errMs != nil || chunk <= 0 || ms < 0 {
+		return Stream{}, fmt.Errorf("invalid stream %q (expected <chunk bytes>/<interval ms>, e.g. 64/200)", s)
+	}
+	return Stream{ChunkBytes: chunk, Interval: time.Duration(ms) * time.Millisecond}, nil
+}
+
+// bandwidthTick is how often a bandwidth-limited response is written to.
+const bandwidthTick = 50 * time.Millisecond
+
+// Bandwidth returns the chunking that writes bytesPerSecond: a chunk every 50ms, or
+// single bytes further apart for very low rates.
+func Bandwidth(bytesPerSecond int) Stream {
+	chunk := bytesPerSecond * int(bandwidthTick) / int(time.Second)
+	if chunk < 1 {
+		return Stream{ChunkBytes: 1, Interval: time.Second / time.Duration(bytesPerSecond)}
+	}
+	return Stream{ChunkBytes: chunk, Interval: bandwidthTick}
+}
+
+// String formats s the way ParseStream reads it.
+func (s Stream) String() string {
+	return fmt.Sprintf("%d/%d", s.ChunkBytes, s.Interval.Milliseconds())
+}
+
+// Stream returns the outcome's streaming mode; ChunkBytes is 0 when it has none.
+func (o Outcome) Stream() Stream {
+	return Stream{ChunkBytes: o.StreamChunkBytes, Interval: time.Duration(o.StreamIntervalMs) * time.Millisecond}
+}
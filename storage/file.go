@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// compactEvery is the number of journalled changes after which FileStore rewrites
+// its file as a single snapshot.
+const compactEvery = 1000
+
+// fileSnapshot is the JSON document at the start of a FileStore file.
+type fileSnapshot struct {
+	Subscriptions []Subscription    `json:"subscriptions"`
+	Consents      []Consent         `json:"consents"`
+	Requests      []CapturedRequest `json:"requests"`
+	Events        []Event           `json:"events"`
+	LastSeq       int64             `json:"lastSeq"` // last event sequence number, kept across resets
+}
+
+// fileChange is one journal line after the snapshot; exactly one field is set.
+type fileChange struct {
+	Subscription *Subscription    `json:"subscription,omitempty"`
+	Consent      *Consent         `json:"consent,omitempty"`
+	Request      *CapturedRequest `json:"request,omitempty"`
+	Event        *Event           `json:"event,omitempty"`
+}
+
+// FileStore keeps state in memory and appends every change to a JSON file: a
+// snapshot followed by one change per line. The file is compacted into a single
+// snapshot when opened, after compactEvery changes, on Reset and on Close.
+type FileStore struct {
+	*MemoryStore
+	path    string
+	writeMu sync.Mutex // serialises changes, so the journal follows the memory order
+	journal *os.File
+	changes int
+}
+
+// OpenFileStore opens the file at path, loading existing state if the file exists.
+func OpenFileStore(path string, retention Retention) (*FileStore, error) {
+
+	if path == "" {
+		return nil, fmt.Errorf("STORE_DSN is required for the file store driver")
+	}
+
+	s := &FileStore{MemoryStore: NewMemoryStore(retention), path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the snapshot and replays the journalled changes after it. A change cut
+// off by a crash ends the journal.
+func (s *FileStore) load() error {
+
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file %s: %w", s.path, err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	var snap fileSnapshot
+	if err := dec.Decode(&snap); errors.Is(err, io.EOF) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to decode state file %s: %w", s.path, err)
+	}
+
+	for _, sub := range snap.Subscriptions {
+		s.subscriptions[sub.ID] = sub
+	}
+	for _, consent := range snap.Consents {
+		s.consents[consent.ID] = consent
+	}
+	for _, req := range snap.Requests {
+		s.requests.push(req)
+	}
+	s.lastSeq = snap.LastSeq
+	for _, e := range snap.Events {
+		s.restoreEvent(e)
+	}
+
+	for {
+		var change fileChange
+		err := dec.Decode(&change)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode state file %s: %w", s.path, err)
+		}
+
+		switch {
+		case change.Subscription != nil:
+			s.subscriptions[change.Subscription.ID] = *change.Subscription
+		case change.Consent != nil:
+			s.consents[change.Consent.ID] = *change.Consent
+		case change.Request != nil:
+			s.requests.push(*change.Request)
+		case change.Event != nil:
+			s.restoreEvent(*change.Event)
+		}
+	}
+}
+
+// SaveSubscription inserts or replaces a subscription and journals it.
+func (s *FileStore) SaveSubscription(sub Subscription) error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.MemoryStore.SaveSubscription(sub)
+	return s.append(fileChange{Subscription: &sub})
+}
+
+// SaveConsent inserts or replaces a consent and journals it.
+func (s *FileStore) SaveConsent(consent Consent) error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.MemoryStore.SaveConsent(consent)
+	return s.append(fileChange{Consent: &consent})
+}
+
+// CaptureRequest appends a captured request and journals it.
+func (s *FileStore) CaptureRequest(req CapturedRequest) error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.MemoryStore.CaptureRequest(req)
+	return s.append(fileChange{Request: &req})
+}
+
+// AppendEvent appends an event and journals it.
+func (s *FileStore) AppendEvent(e Event) (Event, error) {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	e, _ = s.MemoryStore.AppendEvent(e)
+	return e, s.append(fileChange{Event: &e})
+}
+
+// Reset discards all state and rewrites the file as an empty snapshot.
+func (s *FileStore) Reset() error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.MemoryStore.Reset()
+	return s.compact()
+}
+
+// Close compacts the file and closes it.
+func (s *FileStore) Close() error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	err := s.compact()
+	if s.journal != nil {
+		err = errors.Join(err, s.journal.Close())
+		s.journal = nil
+	}
+	return err
+}
+
+// append writes one change to the journal. The caller holds writeMu.
+func (s *FileStore) append(change fileChange) error {
+
+	if s.journal == nil {
+		return fmt.Errorf("state file %s is closed", s.path)
+	}
+	data, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("failed to encode state change: %w", err)
+	}
+	if _, err := s.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	s.changes++
+	if s.changes >= compactEvery {
+		return s.compact()
+	}
+	return nil
+}
+
+// compact atomically replaces the file with a snapshot of the current state and
+// reopens it for appending. The caller holds writeMu.
+func (s *FileStore) compact() error {
+
+	data, err := json.MarshalIndent(fileSnapshot{
+		Subscriptions: s.Subscriptions(),
+		Consents:      s.Consents(),
+		Requests:      s.Requests(),
+		Events:        s.Events(0),
+		LastSeq:       s.lastEventSeq(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if s.journal != nil {
+		s.journal.Close()
+	}
+	s.journal, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open state file: %w", err)
+	}
+	s.changes = 0
+	return nil
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps all state in process memory.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	subscriptions map[string]Subscription
+	consents      map[string]Consent
+	requests      ring[CapturedRequest]
+	events        ring[Event]
+	lastSeq       int64 // survives Reset, so sequence numbers are never reused
+}
+
+// NewMemoryStore creates an empty in-memory store that keeps at most
+// retention.Requests captured requests and retention.Events events.
+func NewMemoryStore(retention Retention) *MemoryStore {
+
+	return &MemoryStore{
+		subscriptions: make(map[string]Subscription),
+		consents:      make(map[string]Consent),
+		requests:      ring[CapturedRequest]{limit: retention.Requests},
+		events:        ring[Event]{limit: retention.Events},
+	}
+}
+
+// SaveSubscription inserts or replaces a subscription.
+func (s *MemoryStore) SaveSubscription(sub Subscription) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscriptions[sub.ID] = sub
+	return nil
+}
+
+// Subscription returns the subscription with the given ID.
+func (s *MemoryStore) Subscription(id string) (Subscription, bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sub, ok := s.subscriptions[id]
+	return sub, ok
+}
+
+// Subscriptions returns all subscriptions ordered by creation time.
+func (s *MemoryStore) Subscriptions() []Subscription {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subs := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Created.Before(subs[j].Created) })
+	return subs
+}
+
+// SaveConsent inserts or replaces a consent.
+func (s *MemoryStore) SaveConsent(consent Consent) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.consents[consent.ID] = consent
+	return nil
+}
+
+// Consents returns all consents ordered by creation time.
+func (s *MemoryStore) Consents() []Consent {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	consents := make([]Consent, 0, len(s.consents))
+	for _, consent := range s.consents {
+		consents = append(consents, consent)
+	}
+	sort.Slice(consents, func(i, j int) bool { return consents[i].Created.Before(consents[j].Created) })
+	return consents
+}
+
+// CaptureRequest appends an inbound request to the capture log, dropping the
+// oldest when the log is full.
+func (s *MemoryStore) CaptureRequest(req CapturedRequest) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests.push(req)
+	return nil
+}
+
+// Requests returns the captured requests in arrival order.
+func (s *MemoryStore) Requests() []CapturedRequest {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.requests.all()
+}
+
+// AppendEvent appends an event with the next sequence number, dropping the oldest
+// event when the log is full.
+func (s *MemoryStore) AppendEvent(e Event) (Event, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSeq++
+	e.Seq = s.lastSeq
+	s.events.push(e)
+	return e, nil
+}
+
+// Events returns the retained events after sequence number since, in order.
+func (s *MemoryStore) Events(since int64) []Event {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := s.events.all()
+	i := sort.Search(len(events), func(i int) bool { return events[i].Seq > since })
+	return events[i:]
+}
+
+// lastEventSeq returns the sequence number of the last event appended.
+func (s *MemoryStore) lastEventSeq() int64 {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lastSeq
+}
+
+// restoreEvent adds a persisted event, keeping its sequence number.
+func (s *MemoryStore) restoreEvent(e Event) {
+
+	s.events.push(e)
+	s.lastSeq = max(s.lastSeq, e.Seq)
+}
+
+// Reset discards all subscriptions, consents, captured requests and events. The
+// event numbering continues where it was.
+func (s *MemoryStore) Reset() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscriptions = make(map[string]Subscription)
+	s.consents = make(map[string]Consent)
+	s.requests.reset()
+	s.events.reset()
+	return nil
+}
+
+// Close is a no-op for the in-memory store.
+func (s *MemoryStore) Close() error {
+
+	return nil
+}
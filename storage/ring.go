@@ -0,0 +1,32 @@
+package storage
+
+// ring keeps the most recent items up to limit, dropping the oldest first. A
+// limit of 0 keeps everything.
+type ring[T any] struct {
+	items []T
+	start int // index of the oldest item once the ring is full
+	limit int
+}
+
+func (r *ring[T]) push(item T) {
+
+	if r.limit <= 0 || len(r.items) < r.limit {
+		r.items = append(r.items, item)
+		return
+	}
+	r.items[r.start] = item
+	r.start = (r.start + 1) % r.limit
+}
+
+// all returns a copy of the items, oldest first.
+func (r *ring[T]) all() []T {
+
+	items := make([]T, 0, len(r.items))
+	items = append(items, r.items[r.start:]...)
+	return append(items, r.items[:r.start]...)
+}
+
+func (r *ring[T]) reset() {
+
+	r.items, r.start = nil, 0
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Snapshot is a portable dump of the subscriptions and consents in a store.
+type Snapshot struct {
+	ExportedAt    time.Time      `json:"exportedAt"`
+	Subscriptions []Subscription `json:"subscriptions"`
+	Consents      []Consent      `json:"consents"`
+}
+
+// Export returns the subscriptions and consents held by s.
+func Export(s Store) Snapshot {
+
+	return Snapshot{
+		ExportedAt:    time.Now(),
+		Subscriptions: s.Subscriptions(),
+		Consents:      s.Consents(),
+	}
+}
+
+// Import writes every record in snap to s, replacing records with the same ID.
+func Import(s Store, snap Snapshot) error {
+
+	for _, sub := range snap.Subscriptions {
+		if sub.ID == "" {
+			return fmt.Errorf("subscription without id")
+		}
+		if err := s.SaveSubscription(sub); err != nil {
+			return fmt.Errorf("failed to import subscription %s: %w", sub.ID, err)
+		}
+	}
+	for _, consent := range snap.Consents {
+		if consent.ID == "" {
+			return fmt.Errorf("consent without id")
+		}
+		if err := s.SaveConsent(consent); err != nil {
+			return fmt.Errorf("failed to import consent %s: %w", consent.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// sqliteDriverName is the database/sql driver used for STORE_DRIVER=sqlite.
+// The driver itself is only linked in when building with -tags sqlite.
+const sqliteDriverName = "sqlite"
+
+// Records are stored as JSON documents keyed by ID, so adding fields to the
+// storage types does not require schema migrations.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS subscriptions (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS consents (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS requests (id INTEGER PRIMARY KEY AUTOINCREMENT, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS events (seq INTEGER PRIMARY KEY, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS meta (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+}
+
+// lastSeqKey is the meta row holding the last event sequence number, which Reset
+// keeps so the numbering continues.
+const lastSeqKey = "lastSeq"
+
+// SQLiteStore keeps state in memory and writes every change through to SQLite.
+type SQLiteStore struct {
+	*MemoryStore
+	db        *sql.DB
+	writeMu   sync.Mutex // serialises changes, so the database follows the memory order
+	retention Retention
+}
+
+// OpenSQLiteStore opens the database at dsn, creating the schema if needed and
+// loading any previously persisted state.
+func OpenSQLiteStore(dsn string, retention Retention) (*SQLiteStore, error) {
+
+	db, err := openSQLite(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLiteStore{MemoryStore: NewMemoryStore(retention), db: db, retention: retention}
+	if err := s.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SaveSubscription writes the subscription to SQLite, then to memory.
+func (s *SQLiteStore) SaveSubscription(sub Subscription) error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := upsertRow(s.db, "subscriptions", sub.ID, sub); err != nil {
+		return err
+	}
+	return s.MemoryStore.SaveSubscription(sub)
+}
+
+// SaveConsent writes the consent to SQLite, then to memory.
+func (s *SQLiteStore) SaveConsent(consent Consent) error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := upsertRow(s.db, "consents", consent.ID, consent); err != nil {
+		return err
+	}
+	return s.MemoryStore.SaveConsent(consent)
+}
+
+// CaptureRequest writes the captured request to SQLite, then to memory.
+func (s *SQLiteStore) CaptureRequest(req CapturedRequest) error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := insertRequest(s.db, req, s.retention.Requests); err != nil {
+		return err
+	}
+	return s.MemoryStore.CaptureRequest(req)
+}
+
+// AppendEvent numbers the event in memory, then writes it to SQLite.
+func (s *SQLiteStore) AppendEvent(e Event) (Event, error) {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	e, _ = s.MemoryStore.AppendEvent(e)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return e, fmt.Errorf("failed to encode event: %w", err)
+	}
+	if _, err := s.db.Exec(`INSERT OR REPLACE INTO events (seq, data) VALUES (?, ?)`, e.Seq, string(data)); err != nil {
+		return e, fmt.Errorf("failed to write event %d: %w", e.Seq, err)
+	}
+	if limit := s.retention.Events; limit > 0 {
+		if _, err := s.db.Exec(`DELETE FROM events WHERE seq <= ?`, e.Seq-int64(limit)); err != nil {
+			return e, fmt.Errorf("failed to prune events: %w", err)
+		}
+	}
+	return e, upsertRow(s.db, "meta", lastSeqKey, e.Seq)
+}
+
+// Reset deletes all rows, then clears memory.
+func (s *SQLiteStore) Reset() error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	for _, table := range []string{"subscriptions", "consents", "requests", "events"} {
+		if _, err := s.db.Exec(`DELETE FROM ` + table); err != nil {
+			return fmt.Errorf("failed to reset %s: %w", table, err)
+		}
+	}
+	return s.MemoryStore.Reset()
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return s.db.Close()
+}
+
+// load reads persisted state from the database into memory.
+func (s *SQLiteStore) load() error {
+
+	if err := loadRows(s.db, "subscriptions", func(sub Subscription) { s.subscriptions[sub.ID] = sub }); err != nil {
+		return err
+	}
+	if err := loadRows(s.db, "consents", func(c Consent) { s.consents[c.ID] = c }); err != nil {
+		return err
+	}
+	if err := loadRows(s.db, "requests", func(r CapturedRequest) { s.requests.push(r) }); err != nil {
+		return err
+	}
+	if err := loadRows(s.db, "events", s.restoreEvent); err != nil {
+		return err
+	}
+
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM meta WHERE id = ?`, lastSeqKey).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read meta row %s: %w", lastSeqKey, err)
+	}
+	var lastSeq int64
+	if err := json.Unmarshal([]byte(data), &lastSeq); err != nil {
+		return fmt.Errorf("failed to decode meta row %s: %w", lastSeqKey, err)
+	}
+	s.lastSeq = max(s.lastSeq, lastSeq)
+	return nil
+}
+
+// openSQLite opens the database at dsn and creates the tables if needed.
+func openSQLite(dsn string) (*sql.DB, error) {
+
+	if !slices.Contains(sql.Drivers(), sqliteDriverName) {
+		return nil, fmt.Errorf("sqlite driver not compiled in — rebuild with -tags sqlite")
+	}
+
+	if dsn == "" {
+		return nil, fmt.Errorf("STORE_DSN is required for the sqlite store driver")
+	}
+
+	db, err := sql.Open(sqliteDriverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", dsn, err)
+	}
+
+	// SQLite allows a single writer; serialise access through one connection.
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range sqliteSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialise sqlite schema: %w", err)
+		}
+	}
+
+	return db, nil
+}
+
+// upsertRow writes v as JSON into table under id, replacing any existing row.
+func upsertRow(db *sql.DB, table, id string, v any) error {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s row: %w", table, err)
+	}
+
+	if _, err := db.Exec(`INSERT OR REPLACE INTO `+table+` (id, data) VALUES (?, ?)`, id, string(data)); err != nil {
+		return fmt.Errorf("failed to write %s row %s: %w", table, id, err)
+	}
+	return nil
+}
+
+// insertRequest appends a captured request row and deletes the rows beyond the
+// newest limit (0 = keep all).
+func insertRequest(db *sql.DB, req CapturedRequest, limit int) error {
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode captured request: %w", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO requests (data) VALUES (?)`, string(data)); err != nil {
+		return fmt.Errorf("failed to write captured request: %w", err)
+	}
+	if limit > 0 {
+		if _, err := db.Exec(`DELETE FROM requests WHERE id <= (SELECT MAX(id) FROM requests) - ?`, limit); err != nil {
+			return fmt.Errorf("failed to prune captured requests: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadRows decodes every row of table in insertion order and passes it to fn.
+func loadRows[T any](db *sql.DB, table string, fn func(T)) error {
+
+	rows, err := db.Query(`SELECT data FROM ` + table + ` ORDER BY rowid`)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return fmt.Errorf("failed to read %s row: %w", table, err)
+		}
+
+		var v T
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			return fmt.Errorf("failed to decode %s row: %w", table, err)
+		}
+		fn(v)
+	}
+
+	return rows.Err()
+}
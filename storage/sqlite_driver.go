@@ -0,0 +1,9 @@
+//go:build sqlite
+
+package storage
+
+// The pure-Go SQLite driver registers itself as "sqlite". It is kept behind a
+// build tag so the default binary does not link it in:
+//
+//	make build-sqlite
+import _ "modernc.org/sqlite"
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Subscription is a consent subscription recorded via POST /fhir/Subscription.
+type Subscription struct {
+	ID          string     `json:"id"`
+	BSN         string     `json:"bsn"`
+	ProviderID  string     `json:"providerId"`
+	Criteria    string     `json:"criteria"`
+	Categories  [][]string `json:"categories,omitempty"` // gegevenscategorie filter, AND of OR groups; empty = all categories
+	Topic       string     `json:"topic,omitempty"`      // SubscriptionTopic of a topic-based (R4B) subscription
+	Endpoint    string     `json:"endpoint"`
+	PayloadType string     `json:"payloadType"`
+	Status      string     `json:"status"`
+	Created     time.Time  `json:"created"`
+
+	NotificationFormat string `json:"notificationFormat,omitempty"` // stu3-xml (default when empty), r4-xml or r4-json
+}
+
+// Consent is a consent registered through a FHIR Bundle transaction.
+type Consent struct {
+	ID             string    `json:"id"`
+	BSN            string    `json:"bsn"`
+	Status         string    `json:"status"`
+	Decision       string    `json:"decision,omitempty"` // "permit" or "deny"
+	Categories     []string  `json:"categories,omitempty"`
+	ProviderID     string    `json:"providerId,omitempty"`
+	Tenant         string    `json:"tenant,omitempty"`
+	Source         string    `json:"source"` // "migration", "toestemmingsknop", "scenario" or "import"
+	BundleID       string    `json:"bundleId"`
+	Created        time.Time `json:"created"`                 // registration time
+	EffectiveFrom  time.Time `json:"effectiveFrom,omitzero"`  // provision.period.start; zero = from registration
+	EffectiveUntil time.Time `json:"effectiveUntil,omitzero"` // provision.period.end; zero = open-ended
+}
+
+// Effective returns when the consent took effect: its period start, else its
+// registration time. Backdated consents take effect before they were registered.
+func (c Consent) Effective() time.Time {
+	if !c.EffectiveFrom.IsZero() {
+		return c.EffectiveFrom
+	}
+	return c.Created
+}
+
+// InEffect reports whether t lies within the consent's period.
+func (c Consent) InEffect(t time.Time) bool {
+	if !c.EffectiveFrom.IsZero() && t.Before(c.EffectiveFrom) {
+		return false
+	}
+	return c.EffectiveUntil.IsZero() || t.Before(c.EffectiveUntil)
+}
+
+// CapturedRequest is an inbound request recorded for later inspection.
+type CapturedRequest struct {
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	RequestID string    `json:"requestId"`
+	Testcase  string    `json:"testcase,omitempty"` // X-OTV-Testcase
+	Status    int       `json:"status"`
+	Body      string    `json:"body"`
+	Received  time.Time `json:"received"`
+}
+
+// Event types recorded in the event log.
+const (
+	EventConsentCreated        = "consent.created"
+	EventConsentUpdated        = "consent.updated" // supersedes an earlier consent of the same BSN and provider
+	EventSubscriptionCreated   = "subscription.created"
+	EventSubscriptionUpdated   = "subscription.updated"
+	EventSubscriptionCancelled = "subscription.cancelled"
+	EventNotificationSent      = "notification.sent"
+	EventNotificationFailed    = "notification.failed" // delivery attempts exhausted
+)
+
+// Event is an entry in the append-only log of state changes.
+type Event struct {
+	Seq      int64     `json:"seq"` // assigned by the store; starts at 1, increases by 1 and continues after a reset
+	Type     string    `json:"type"`
+	Resource string    `json:"resource"`          // e.g. "Consent/<id>"
+	Related  string    `json:"related,omitempty"` // e.g. the Consent a notification was about
+	BSN      string    `json:"bsn,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Retention bounds the captured requests and events a store keeps; once full, the
+// oldest are dropped. Zero keeps everything.
+type Retention struct {
+	Requests int
+	Events   int
+}
+
+// SubscriptionStore stores consent subscriptions.
+type SubscriptionStore interface {
+	SaveSubscription(sub Subscription) error
+	Subscription(id string) (Subscription, bool)
+	Subscriptions() []Subscription
+}
+
+// ConsentStore stores registered consents.
+type ConsentStore interface {
+	SaveConsent(consent Consent) error
+	Consents() []Consent
+}
+
+// RequestLog stores captured inbound requests.
+type RequestLog interface {
+	CaptureRequest(req CapturedRequest) error
+	Requests() []CapturedRequest
+}
+
+// EventLog stores the append-only log of state changes.
+type EventLog interface {
+	AppendEvent(e Event) (Event, error) // assigns the next sequence number
+	Events(since int64) []Event         // events with a sequence number above since, in order
+}
+
+// Store is the replicator state backend. Implementations must be safe for
+// concurrent use; list methods return records ordered by creation time.
+type Store interface {
+	SubscriptionStore
+	ConsentStore
+	RequestLog
+	EventLog
+	Reset() error
+	Close() error
+}
+
+// Open creates a store for the given driver:
+//   - "memory" keeps state in-process only
+//   - "file" appends every change to a JSON snapshot file at dsn
+//   - "sqlite" writes through to the SQLite database at dsn
+//
+// The file and sqlite drivers reload existing state on startup.
+func Open(driver, dsn string, retention Retention) (Store, error) {
+
+	switch driver {
+	case "", "memory":
+		return NewMemoryStore(retention), nil
+	case "file":
+		s, err := OpenFileStore(dsn, retention)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "sqlite":
+		s, err := OpenSQLiteStore(dsn, retention)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown store driver %q (expected memory, file or sqlite)", driver)
+	}
+}
@@ -0,0 +1,202 @@
+// Package supervisor runs several replicator instances, e.g. "Mitz test", "Mitz
+// acceptance" and "chaos Mitz", from one binary. Handler state is process-wide, so
+// each instance is a child process of the same executable with its own
+// configuration file and environment; instances share nothing.
+package supervisor
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goccy/go-yaml"
+
+	"mitz-replicator/config"
+)
+
+// Restart backoff for instances that exit. An instance that ran for stableAfter
+// starts over at minBackoff.
+const (
+	minBackoff  = time.Second
+	maxBackoff  = 30 * time.Second
+	stableAfter = time.Minute
+)
+
+// stopGrace is how long a stopping instance gets after SIGTERM to finish in-flight
+// requests and deregister before it is killed. It covers the instance's own
+// shutdown timeout plus its deregistration.
+const stopGrace = 20 * time.Second
+
+// Instance is one replicator run by the supervisor.
+type Instance struct {
+	Name   string            `yaml:"name"`
+	Config string            `yaml:"config"` // CONFIG_FILE of the instance (empty = defaults)
+	Port   string            `yaml:"port"`   // PORT of the instance
+	Env    map[string]string `yaml:"env"`    // further environment overrides
+}
+
+// File is the instances file read by Load.
+type File struct {
+	Instances []Instance `yaml:"instances"`
+}
+
+// environ returns the instance's environment: the supervisor's own, then CONFIG_FILE,
+// PORT and Env.
+func (in Instance) environ() []string {
+	env := os.Environ()
+	if in.Config != "" {
+		env = append(env, "CONFIG_FILE="+in.Config)
+	}
+	if in.Port != "" {
+		env = append(env, "PORT="+in.Port)
+	}
+	for k, v := range in.Env {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// lookup reads a variable from the instance's environment.
+func (in Instance) lookup(key string) (string, bool) {
+	if v, ok := in.Env[key]; ok {
+		return v, true
+	}
+	if key == "PORT" && in.Port != "" {
+		return in.Port, true
+	}
+	return os.LookupEnv(key)
+}
+
+// Load reads the instances file at path and validates every instance's configuration
+// the way the instance itself will load it, so a broken instance stops the
+// supervisor before anything starts.
+func Load(path string) ([]Instance, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instances file: %w", err)
+	}
+	var f File
+	if err := yaml.UnmarshalWithOptions(data, &f, yaml.DisallowUnknownField()); err != nil {
+		return nil, fmt.Errorf("invalid instances file %s:\n%s", path, yaml.FormatError(err, false, true))
+	}
+	if len(f.Instances) == 0 {
+		return nil, fmt.Errorf("%s: no instances", path)
+	}
+
+	names := make(map[string]bool)
+	listeners := make(map[string]string) // port or socket → instance
+	stores := make(map[string]string)    // store DSN → instance
+	for i, in := range f.Instances {
+		if in.Name == "" {
+			return nil, fmt.Errorf("instance #%d: name is required", i+1)
+		}
+		if names[in.Name] {
+			return nil, fmt.Errorf("instance %s: duplicate name", in.Name)
+		}
+		names[in.Name] = true
+
+		cfg, err := config.LoadWith(in.Config, in.lookup)
+		if err != nil {
+			return nil, fmt.Errorf("instance %s: %w", in.Name, err)
+		}
+		listener := cmp.Or(cfg.Server.Listen, "port "+cfg.Server.Port)
+		if other, ok := listeners[listener]; ok {
+			return nil, fmt.Errorf("instance %s: %s is already used by %s", in.Name, listener, other)
+		}
+		listeners[listener] = in.Name
+		if cfg.Store.Driver != "memory" {
+			if other, ok := stores[cfg.Store.DSN]; ok {
+				return nil, fmt.Errorf("instance %s: store %s is already used by %s", in.Name, cfg.Store.DSN, other)
+			}
+			stores[cfg.Store.DSN] = in.Name
+		}
+	}
+	return f.Instances, nil
+}
+
+// Run starts every instance with the executable exe and restarts instances that
+// exit, until ctx is cancelled; then it stops them all and returns.
+func Run(ctx context.Context, exe string, instances []Instance) {
+	var wg sync.WaitGroup
+	for _, in := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			supervise(ctx, exe, in)
+		}()
+	}
+	wg.Wait()
+}
+
+// supervise runs one instance, restarting it with backoff when it exits.
+func supervise(ctx context.Context, exe string, in Instance) {
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		err := runOnce(ctx, exe, in)
+		if ctx.Err() != nil {
+			log.Printf("[SUPERVISOR] Instance %s stopped", in.Name)
+			return
+		}
+		if time.Since(started) >= stableAfter {
+			backoff = minBackoff
+		}
+		log.Printf("[SUPERVISOR] Instance %s exited (%v), restarting in %s", in.Name, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// runOnce runs the instance until it exits, prefixing its output with its name. When
+// ctx is cancelled the instance gets SIGTERM, so it shuts down gracefully, and is
+// killed if it is still running after stopGrace.
+func runOnce(ctx context.Context, exe string, in Instance) error {
+	cmd := exec.CommandContext(ctx, exe)
+	cmd.Env = in.environ()
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = stopGrace
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("[SUPERVISOR] Instance %s started (pid %d)", in.Name, cmd.Process.Pid)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); prefixLines(os.Stdout, stdout, in.Name) }()
+	go func() { defer wg.Done(); prefixLines(os.Stderr, stderr, in.Name) }()
+	wg.Wait()
+	return cmd.Wait()
+}
+
+// prefixLines copies r to w line by line, each line prefixed with [name].
+func prefixLines(w io.Writer, r io.Reader, name string) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	prefix := "[" + name + "] "
+	for scanner.Scan() {
+		fmt.Fprintln(w, prefix+strings.TrimRight(scanner.Text(), "\r"))
+	}
+	io.Copy(io.Discard, r) // keep the instance from blocking after an over-long line
+}
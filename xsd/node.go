@@ -0,0 +1,136 @@
+package xsd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// nsXSI is the XML Schema instance namespace; its attributes (xsi:type, xsi:nil,
+// xsi:schemaLocation) are allowed on every element.
+const nsXSI = "http://www.w3.org/2001/XMLSchema-instance"
+
+// node is an element of a parsed document, with the namespace prefixes in scope for
+// resolving QName attribute values.
+type node struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*node
+	text     strings.Builder
+	prefixes map[string]string // prefix → namespace; "" is the default namespace
+}
+
+// attr returns the value of the unqualified attribute local, and whether it is present.
+func (n *node) attr(local string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// qname resolves a QName value ("prefix:local" or "local") in the scope of n.
+func (n *node) qname(value string) (xml.Name, error) {
+	prefix, local, found := strings.Cut(strings.TrimSpace(value), ":")
+	if !found {
+		prefix, local = "", prefix
+	}
+	ns, ok := n.prefixes[prefix]
+	if !ok && prefix != "" {
+		return xml.Name{}, fmt.Errorf("undeclared prefix %q in %q", prefix, value)
+	}
+	return xml.Name{Space: ns, Local: local}, nil
+}
+
+// parseTree parses a document into a node tree, keeping namespace declarations.
+func parseTree(data []byte) (*node, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root *node
+	var stack []*node
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{prefixes: map[string]string{}}
+			if len(stack) > 0 {
+				for k, v := range stack[len(stack)-1].prefixes {
+					n.prefixes[k] = v
+				}
+			}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					n.prefixes[""] = a.Value
+				case a.Name.Space == "xmlns":
+					n.prefixes[a.Name.Local] = a.Value
+				}
+			}
+			n.name, err = n.resolve(t.Name, true)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+					continue
+				}
+				if a.Name, err = n.resolve(a.Name, false); err != nil {
+					return nil, err
+				}
+				n.attrs = append(n.attrs, a)
+			}
+
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no document element")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("unclosed element %s", stack[len(stack)-1].name.Local)
+	}
+	return root, nil
+}
+
+// resolve maps the prefix of a raw name to its namespace. Unprefixed attributes
+// have no namespace; unprefixed elements take the default namespace.
+func (n *node) resolve(name xml.Name, element bool) (xml.Name, error) {
+	if name.Space == "" {
+		if element {
+			return xml.Name{Space: n.prefixes[""], Local: name.Local}, nil
+		}
+		return name, nil
+	}
+	if name.Space == "xml" {
+		return xml.Name{Space: "http://www.w3.org/XML/1998/namespace", Local: name.Local}, nil
+	}
+	ns, ok := n.prefixes[name.Space]
+	if !ok {
+		return xml.Name{}, fmt.Errorf("undeclared prefix %q on %s", name.Space, name.Local)
+	}
+	return xml.Name{Space: ns, Local: name.Local}, nil
+}
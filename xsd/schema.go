@@ -0,0 +1,553 @@
+// Package xsd validates XML documents against W3C XML Schemas. It supports the
+// constructs the replicator's request structure definitions use: global and local
+// elements, sequence/choice/all with occurrence bounds, wildcards, attributes and
+// attribute groups, named groups, simple and complex content derivation, and simple
+// types restricted by enumerations, patterns and lengths. Identity constraints and
+// substitution groups are ignored.
+package xsd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// nsXS is the XML Schema namespace.
+const nsXS = "http://www.w3.org/2001/XMLSchema"
+
+// Schema is a set of loaded schema documents.
+type Schema struct {
+	elements   map[xml.Name]*element
+	types      map[xml.Name]*typeDef
+	groups     map[xml.Name]*particle
+	attrGroups map[xml.Name]*attrSet
+	attributes map[xml.Name]*attribute
+	loaded     map[string]bool
+}
+
+// element is an element declaration.
+type element struct {
+	name     xml.Name
+	typeName xml.Name // named type, or zero for typ/anyType
+	typ      *typeDef // inline type
+	fixed    *string
+}
+
+// typeDef is a simple or complex type definition. Simple types have simple set;
+// complex types with simple content have simple set to the content type.
+type typeDef struct {
+	name    xml.Name
+	simple  *simpleType
+	content *particle // nil for empty or simple content
+	attrs   *attrSet
+	mixed   bool
+	base    xml.Name // complexContent or simpleContent base, resolved on first use
+	extend  bool     // base content is extended (true) or restricted (false)
+	done    bool     // base merged
+}
+
+// attrSet holds attribute uses, attribute group references and an attribute wildcard.
+type attrSet struct {
+	uses     []*attribute
+	groups   []xml.Name
+	wildcard *wildcard
+}
+
+type attribute struct {
+	name     xml.Name
+	ref      xml.Name
+	typeName xml.Name
+	typ      *simpleType
+	required bool
+	fixed    *string
+}
+
+// particle is an element, model group (sequence, choice, all), wildcard or group
+// reference with occurrence bounds. max is -1 for unbounded.
+type particle struct {
+	kind     string // element, sequence, choice, all, any, group
+	elem     *element
+	ref      xml.Name // element ref or group ref
+	children []*particle
+	any      *wildcard
+	min, max int
+}
+
+// wildcard is an xs:any or xs:anyAttribute namespace constraint.
+type wildcard struct {
+	namespaces []string // "##any", "##other", "##local", "##targetNamespace" resolved to the namespace, or URIs
+	target     string
+	process    string // strict, lax or skip
+}
+
+// simpleType is a built-in type or a restriction, list or union of simple types.
+type simpleType struct {
+	builtin  string // local name of an XML Schema built-in type
+	base     xml.Name
+	baseType *simpleType
+	facets   facets
+	list     bool
+	item     xml.Name
+	union    []xml.Name
+}
+
+// New returns an empty schema set.
+func New() *Schema {
+	return &Schema{
+		elements:   map[xml.Name]*element{},
+		types:      map[xml.Name]*typeDef{},
+		groups:     map[xml.Name]*particle{},
+		attrGroups: map[xml.Name]*attrSet{},
+		attributes: map[xml.Name]*attribute{},
+		loaded:     map[string]bool{},
+	}
+}
+
+// Load reads the schema document name from fsys into s, following its xs:include
+// and xs:import schemaLocations relative to name.
+func (s *Schema) Load(fsys fs.FS, name string) error {
+	name = path.Clean(name)
+	if s.loaded[name] {
+		return nil
+	}
+	s.loaded[name] = true
+
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	root, err := parseTree(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if root.name != (xml.Name{Space: nsXS, Local: "schema"}) {
+		return fmt.Errorf("%s: document element is not xs:schema", name)
+	}
+
+	target, _ := root.attr("targetNamespace")
+	qualified := func(attr string) bool {
+		v, _ := root.attr(attr)
+		return v == "qualified"
+	}
+	l := &loader{s: s, file: name, target: target, elemQualified: qualified("elementFormDefault"), attrQualified: qualified("attributeFormDefault")}
+	if err := l.schema(fsys, root); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// loader reads one schema document.
+type loader struct {
+	s             *Schema
+	file          string
+	target        string
+	elemQualified bool
+	attrQualified bool
+}
+
+func (l *loader) schema(fsys fs.FS, root *node) error {
+	for _, n := range xsChildren(root) {
+		global := xml.Name{Space: l.target}
+		global.Local, _ = n.attr("name")
+
+		switch n.name.Local {
+		case "include", "import":
+			if loc, ok := n.attr("schemaLocation"); ok {
+				if err := l.s.Load(fsys, path.Join(path.Dir(l.file), loc)); err != nil {
+					return err
+				}
+			}
+		case "element":
+			e, err := l.element(n, true)
+			if err != nil {
+				return err
+			}
+			l.s.elements[e.name] = e
+		case "complexType":
+			t, err := l.complexType(n)
+			if err != nil {
+				return err
+			}
+			t.name = global
+			l.s.types[global] = t
+		case "simpleType":
+			st, err := l.simpleType(n)
+			if err != nil {
+				return err
+			}
+			l.s.types[global] = &typeDef{name: global, simple: st, done: true}
+		case "group":
+			p, err := l.groupContent(n)
+			if err != nil {
+				return err
+			}
+			l.s.groups[global] = p
+		case "attributeGroup":
+			set, err := l.attrSet(n)
+			if err != nil {
+				return err
+			}
+			l.s.attrGroups[global] = set
+		case "attribute":
+			a, err := l.attribute(n, true)
+			if err != nil {
+				return err
+			}
+			l.s.attributes[a.name] = a
+		case "annotation", "notation", "redefine", "override", "defaultOpenContent":
+		default:
+			return fmt.Errorf("unsupported top-level xs:%s", n.name.Local)
+		}
+	}
+	return nil
+}
+
+// element reads an element declaration; local declarations are qualified per elementFormDefault.
+func (l *loader) element(n *node, global bool) (*element, error) {
+	name, _ := n.attr("name")
+	e := &element{name: xml.Name{Local: name}}
+	if global || l.elemQualified || attrIs(n, "form", "qualified") {
+		e.name.Space = l.target
+	}
+	if v, ok := n.attr("fixed"); ok {
+		e.fixed = &v
+	}
+	if v, ok := n.attr("type"); ok {
+		qn, err := n.qname(v)
+		if err != nil {
+			return nil, err
+		}
+		e.typeName = qn
+	}
+	for _, c := range xsChildren(n) {
+		switch c.name.Local {
+		case "complexType":
+			t, err := l.complexType(c)
+			if err != nil {
+				return nil, err
+			}
+			e.typ = t
+		case "simpleType":
+			st, err := l.simpleType(c)
+			if err != nil {
+				return nil, err
+			}
+			e.typ = &typeDef{simple: st, done: true}
+		}
+	}
+	return e, nil
+}
+
+func (l *loader) complexType(n *node) (*typeDef, error) {
+	t := &typeDef{attrs: &attrSet{}, mixed: attrIs(n, "mixed", "true"), done: true}
+	for _, c := range xsChildren(n) {
+		switch c.name.Local {
+		case "sequence", "choice", "all", "group":
+			p, err := l.particle(c)
+			if err != nil {
+				return nil, err
+			}
+			t.content = p
+		case "attribute", "attributeGroup", "anyAttribute":
+			if err := l.addAttr(t.attrs, c); err != nil {
+				return nil, err
+			}
+		case "simpleContent", "complexContent":
+			if attrIs(c, "mixed", "true") {
+				t.mixed = true
+			}
+			if err := l.derivation(t, c); err != nil {
+				return nil, err
+			}
+		case "annotation":
+		default:
+			return nil, fmt.Errorf("unsupported xs:%s in complexType", c.name.Local)
+		}
+	}
+	return t, nil
+}
+
+// derivation reads the extension or restriction of a simpleContent or complexContent.
+func (l *loader) derivation(t *typeDef, content *node) error {
+	for _, d := range xsChildren(content) {
+		if d.name.Local != "extension" && d.name.Local != "restriction" {
+			continue
+		}
+		baseValue, _ := d.attr("base")
+		base, err := d.qname(baseValue)
+		if err != nil {
+			return err
+		}
+		t.base, t.extend, t.done = base, d.name.Local == "extension", false
+
+		restriction := &simpleType{base: base}
+		for _, c := range xsChildren(d) {
+			switch c.name.Local {
+			case "sequence", "choice", "all", "group":
+				p, err := l.particle(c)
+				if err != nil {
+					return err
+				}
+				t.content = p
+			case "attribute", "attributeGroup", "anyAttribute":
+				if err := l.addAttr(t.attrs, c); err != nil {
+					return err
+				}
+			case "annotation":
+			default:
+				if !restriction.facets.add(c) {
+					return fmt.Errorf("unsupported xs:%s in %s", c.name.Local, d.name.Local)
+				}
+			}
+		}
+		if content.name.Local == "simpleContent" {
+			t.simple = restriction
+		}
+	}
+	return nil
+}
+
+// particle reads a sequence, choice, all, group reference, element or wildcard.
+func (l *loader) particle(n *node) (*particle, error) {
+	p := &particle{kind: n.name.Local, min: 1, max: 1}
+	if v, ok := n.attr("minOccurs"); ok {
+		p.min, _ = strconv.Atoi(v)
+	}
+	if v, ok := n.attr("maxOccurs"); ok {
+		if v == "unbounded" {
+			p.max = -1
+		} else {
+			p.max, _ = strconv.Atoi(v)
+		}
+	}
+
+	switch n.name.Local {
+	case "element":
+		if v, ok := n.attr("ref"); ok {
+			ref, err := n.qname(v)
+			if err != nil {
+				return nil, err
+			}
+			p.ref = ref
+			return p, nil
+		}
+		e, err := l.element(n, false)
+		if err != nil {
+			return nil, err
+		}
+		p.elem = e
+	case "any":
+		p.any = l.wildcard(n)
+	case "group":
+		v, _ := n.attr("ref")
+		ref, err := n.qname(v)
+		if err != nil {
+			return nil, err
+		}
+		p.ref = ref
+	case "sequence", "choice", "all":
+		for _, c := range xsChildren(n) {
+			if c.name.Local == "annotation" {
+				continue
+			}
+			child, err := l.particle(c)
+			if err != nil {
+				return nil, err
+			}
+			p.children = append(p.children, child)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported xs:%s in content model", n.name.Local)
+	}
+	return p, nil
+}
+
+// groupContent returns the model group of a named xs:group definition.
+func (l *loader) groupContent(n *node) (*particle, error) {
+	for _, c := range xsChildren(n) {
+		if c.name.Local == "sequence" || c.name.Local == "choice" || c.name.Local == "all" {
+			return l.particle(c)
+		}
+	}
+	return &particle{kind: "sequence", min: 1, max: 1}, nil
+}
+
+func (l *loader) attrSet(n *node) (*attrSet, error) {
+	set := &attrSet{}
+	for _, c := range xsChildren(n) {
+		if err := l.addAttr(set, c); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+func (l *loader) addAttr(set *attrSet, n *node) error {
+	switch n.name.Local {
+	case "attribute":
+		a, err := l.attribute(n, false)
+		if err != nil {
+			return err
+		}
+		set.uses = append(set.uses, a)
+	case "attributeGroup":
+		v, _ := n.attr("ref")
+		ref, err := n.qname(v)
+		if err != nil {
+			return err
+		}
+		set.groups = append(set.groups, ref)
+	case "anyAttribute":
+		set.wildcard = l.wildcard(n)
+	}
+	return nil
+}
+
+func (l *loader) attribute(n *node, global bool) (*attribute, error) {
+	a := &attribute{required: attrIs(n, "use", "required")}
+	if v, ok := n.attr("ref"); ok {
+		ref, err := n.qname(v)
+		if err != nil {
+			return nil, err
+		}
+		a.ref = ref
+		return a, nil
+	}
+	name, _ := n.attr("name")
+	a.name = xml.Name{Local: name}
+	if global || l.attrQualified || attrIs(n, "form", "qualified") {
+		a.name.Space = l.target
+	}
+	if v, ok := n.attr("fixed"); ok {
+		a.fixed = &v
+	}
+	if v, ok := n.attr("type"); ok {
+		qn, err := n.qname(v)
+		if err != nil {
+			return nil, err
+		}
+		a.typeName = qn
+	}
+	for _, c := range xsChildren(n) {
+		if c.name.Local == "simpleType" {
+			st, err := l.simpleType(c)
+			if err != nil {
+				return nil, err
+			}
+			a.typ = st
+		}
+	}
+	return a, nil
+}
+
+func (l *loader) simpleType(n *node) (*simpleType, error) {
+	for _, c := range xsChildren(n) {
+		switch c.name.Local {
+		case "restriction":
+			st := &simpleType{}
+			if v, ok := c.attr("base"); ok {
+				base, err := c.qname(v)
+				if err != nil {
+					return nil, err
+				}
+				st.base = base
+			}
+			for _, f := range xsChildren(c) {
+				switch f.name.Local {
+				case "simpleType":
+					inner, err := l.simpleType(f)
+					if err != nil {
+						return nil, err
+					}
+					st.baseType = inner
+				case "annotation":
+				default:
+					if !st.facets.add(f) {
+						return nil, fmt.Errorf("unsupported facet xs:%s", f.name.Local)
+					}
+				}
+			}
+			return st, nil
+		case "list":
+			st := &simpleType{list: true, item: xml.Name{Space: nsXS, Local: "string"}}
+			if v, ok := c.attr("itemType"); ok {
+				item, err := c.qname(v)
+				if err != nil {
+					return nil, err
+				}
+				st.item = item
+			}
+			return st, nil
+		case "union":
+			st := &simpleType{}
+			if v, ok := c.attr("memberTypes"); ok {
+				for _, member := range strings.Fields(v) {
+					qn, err := c.qname(member)
+					if err != nil {
+						return nil, err
+					}
+					st.union = append(st.union, qn)
+				}
+			}
+			return st, nil
+		}
+	}
+	return &simpleType{builtin: "anySimpleType"}, nil
+}
+
+func (l *loader) wildcard(n *node) *wildcard {
+	w := &wildcard{namespaces: []string{"##any"}, target: l.target, process: "strict"}
+	if v, ok := n.attr("namespace"); ok {
+		w.namespaces = strings.Fields(v)
+	}
+	if v, ok := n.attr("processContents"); ok {
+		w.process = v
+	}
+	return w
+}
+
+// allows reports whether the wildcard accepts names in namespace ns.
+func (w *wildcard) allows(ns string) bool {
+	for _, c := range w.namespaces {
+		switch c {
+		case "##any":
+			return true
+		case "##other":
+			if ns != w.target && ns != "" {
+				return true
+			}
+		case "##local":
+			if ns == "" {
+				return true
+			}
+		case "##targetNamespace":
+			if ns == w.target {
+				return true
+			}
+		default:
+			if ns == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// xsChildren returns the XML Schema elements among the children of n.
+func xsChildren(n *node) []*node {
+	var out []*node
+	for _, c := range n.children {
+		if c.name.Space == nsXS {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func attrIs(n *node, name, value string) bool {
+	v, ok := n.attr(name)
+	return ok && v == value
+}
@@ -0,0 +1,270 @@
+package xsd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// facets are the constraining facets of a simple type restriction.
+type facets struct {
+	enumeration []string
+	patterns    []*regexp.Regexp
+	length      *int
+	minLength   *int
+	maxLength   *int
+}
+
+// add records the facet n, reporting false for facets that are not supported.
+// Numeric bounds and digit counts are accepted but not checked.
+func (f *facets) add(n *node) bool {
+	value, _ := n.attr("value")
+	intValue := func() *int {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return nil
+		}
+		return &v
+	}
+
+	switch n.name.Local {
+	case "enumeration":
+		f.enumeration = append(f.enumeration, value)
+	case "pattern":
+		re, err := regexp.Compile(`^(?:` + value + `)$`)
+		if err != nil {
+			return false
+		}
+		f.patterns = append(f.patterns, re)
+	case "length":
+		f.length = intValue()
+	case "minLength":
+		f.minLength = intValue()
+	case "maxLength":
+		f.maxLength = intValue()
+	case "whiteSpace", "minInclusive", "maxInclusive", "minExclusive", "maxExclusive", "totalDigits", "fractionDigits":
+	default:
+		return false
+	}
+	return true
+}
+
+// check validates a whitespace-normalised value against the facets.
+func (f *facets) check(value string) error {
+	if len(f.enumeration) > 0 && !slices.Contains(f.enumeration, value) {
+		return fmt.Errorf("must be one of %s", strings.Join(f.enumeration, ", "))
+	}
+	if len(f.patterns) > 0 && !slices.ContainsFunc(f.patterns, func(re *regexp.Regexp) bool { return re.MatchString(value) }) {
+		return fmt.Errorf("does not match the pattern %s", strings.TrimSuffix(strings.TrimPrefix(f.patterns[0].String(), "^(?:"), ")$"))
+	}
+	n := len([]rune(value))
+	switch {
+	case f.length != nil && n != *f.length:
+		return fmt.Errorf("must be %d characters long", *f.length)
+	case f.minLength != nil && n < *f.minLength:
+		return fmt.Errorf("must be at least %d characters long", *f.minLength)
+	case f.maxLength != nil && n > *f.maxLength:
+		return fmt.Errorf("must be at most %d characters long", *f.maxLength)
+	}
+	return nil
+}
+
+// Lexical forms of the built-in types that are checked with a pattern.
+var (
+	tz          = `(Z|[+-]\d{2}:\d{2})?`
+	builtinForm = map[string]*regexp.Regexp{
+		"decimal":  regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`),
+		"integer":  regexp.MustCompile(`^[+-]?\d+$`),
+		"dateTime": regexp.MustCompile(`^-?\d{4,}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?` + tz + `$`),
+		"date":     regexp.MustCompile(`^-?\d{4,}-\d{2}-\d{2}` + tz + `$`),
+		"time":     regexp.MustCompile(`^\d{2}:\d{2}:\d{2}(\.\d+)?` + tz + `$`),
+		"gYear":    regexp.MustCompile(`^-?\d{4,}` + tz + `$`),
+		"duration": regexp.MustCompile(`^-?P(\d+Y)?(\d+M)?(\d+D)?(T(\d+H)?(\d+M)?(\d+(\.\d+)?S)?)?$`),
+		"NCName":   regexp.MustCompile(`^[\pL_][\pL\pN._-]*$`),
+		"Name":     regexp.MustCompile(`^[\pL_:][\pL\pN._:-]*$`),
+		"NMTOKEN":  regexp.MustCompile(`^[\pL\pN._:-]+$`),
+		"language": regexp.MustCompile(`^[a-zA-Z]{1,8}(-[a-zA-Z0-9]{1,8})*$`),
+	}
+	// builtinBase maps derived built-in types to the type whose lexical form they share.
+	builtinBase = map[string]string{
+		"ID": "NCName", "IDREF": "NCName", "ENTITY": "NCName",
+		"long": "integer", "int": "integer", "short": "integer", "byte": "integer",
+		"nonNegativeInteger": "integer", "positiveInteger": "integer", "nonPositiveInteger": "integer", "negativeInteger": "integer",
+		"unsignedLong": "integer", "unsignedInt": "integer", "unsignedShort": "integer", "unsignedByte": "integer",
+	}
+	// integerBits are the bit sizes of the bounded integer types; negative for unsigned.
+	integerBits = map[string]int{
+		"long": 64, "int": 32, "short": 16, "byte": 8,
+		"unsignedLong": -64, "unsignedInt": -32, "unsignedShort": -16, "unsignedByte": -8,
+	}
+)
+
+// preservesSpace reports whether the built-in type keeps whitespace as is.
+func preservesSpace(builtin string) bool {
+	return builtin == "string" || builtin == "anySimpleType" || builtin == "anyType"
+}
+
+// checkBuiltin validates a collapsed value against an XML Schema built-in type.
+// Types without a lexical constraint (string, anyURI, QName, ...) accept any value.
+func checkBuiltin(builtin, value string) error {
+	name := builtin
+	if base, ok := builtinBase[builtin]; ok {
+		name = base
+	}
+
+	switch builtin {
+	case "boolean":
+		if value != "true" && value != "false" && value != "1" && value != "0" {
+			return fmt.Errorf("must be true, false, 1 or 0")
+		}
+		return nil
+	case "double", "float":
+		if value == "INF" || value == "-INF" || value == "NaN" {
+			return nil
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil || strings.ContainsAny(value, "xXpP_") {
+			return fmt.Errorf("is not a valid %s", builtin)
+		}
+		return nil
+	case "base64Binary":
+		if _, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), "")); err != nil {
+			return fmt.Errorf("is not valid base64")
+		}
+		return nil
+	case "hexBinary":
+		if _, err := hex.DecodeString(value); err != nil {
+			return fmt.Errorf("is not valid hexBinary")
+		}
+		return nil
+	}
+
+	if re, ok := builtinForm[name]; ok && !re.MatchString(value) {
+		return fmt.Errorf("is not a valid %s", builtin)
+	}
+	if name == "integer" {
+		return checkIntegerRange(builtin, value)
+	}
+	return nil
+}
+
+func checkIntegerRange(builtin, value string) error {
+	if bits, ok := integerBits[builtin]; ok {
+		var err error
+		if bits < 0 {
+			_, err = strconv.ParseUint(strings.TrimPrefix(value, "+"), 10, -bits)
+		} else {
+			_, err = strconv.ParseInt(value, 10, bits)
+		}
+		if err != nil {
+			return fmt.Errorf("is out of range for %s", builtin)
+		}
+	}
+
+	negative := strings.HasPrefix(value, "-") && strings.Trim(value, "-0") != ""
+	zero := strings.Trim(value, "+-0") == ""
+	switch {
+	case strings.HasPrefix(builtin, "unsigned") || builtin == "nonNegativeInteger":
+		if negative {
+			return fmt.Errorf("must not be negative")
+		}
+	case builtin == "positiveInteger":
+		if negative || zero {
+			return fmt.Errorf("must be positive")
+		}
+	case builtin == "nonPositiveInteger":
+		if !negative && !zero {
+			return fmt.Errorf("must not be positive")
+		}
+	case builtin == "negativeInteger":
+		if !negative {
+			return fmt.Errorf("must be negative")
+		}
+	}
+	return nil
+}
+
+// simpleByName returns the simple type named qn: a built-in, a named simple type,
+// or the content type of a complex type with simple content.
+func (s *Schema) simpleByName(qn xml.Name) (*simpleType, error) {
+	if qn.Space == nsXS {
+		return &simpleType{builtin: qn.Local}, nil
+	}
+	t, ok := s.types[qn]
+	if !ok {
+		return nil, fmt.Errorf("schema has no type %s", qn.Local)
+	}
+	s.complete(t)
+	if t.simple == nil {
+		return nil, fmt.Errorf("type %s is not a simple type", qn.Local)
+	}
+	return t.simple, nil
+}
+
+// rootBuiltin returns the built-in type a simple type is ultimately derived from.
+func (s *Schema) rootBuiltin(st *simpleType) string {
+	for range 32 {
+		switch {
+		case st.builtin != "":
+			return st.builtin
+		case st.list || len(st.union) > 0:
+			return "anySimpleType"
+		case st.baseType != nil:
+			st = st.baseType
+		default:
+			base, err := s.simpleByName(st.base)
+			if err != nil {
+				return "anySimpleType"
+			}
+			st = base
+		}
+	}
+	return "anySimpleType"
+}
+
+// checkSimple validates value against st, normalising whitespace as its built-in
+// root type does.
+func (s *Schema) checkSimple(st *simpleType, value string) error {
+	if !preservesSpace(s.rootBuiltin(st)) {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+
+	switch {
+	case st.builtin != "":
+		return checkBuiltin(st.builtin, value)
+	case st.list:
+		item, err := s.simpleByName(st.item)
+		if err != nil {
+			return err
+		}
+		for _, v := range strings.Fields(value) {
+			if err := s.checkSimple(item, v); err != nil {
+				return fmt.Errorf("item %q %w", v, err)
+			}
+		}
+		return nil
+	case len(st.union) > 0:
+		for _, member := range st.union {
+			if mt, err := s.simpleByName(member); err == nil && s.checkSimple(mt, value) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("matches none of the member types")
+	}
+
+	base := st.baseType
+	if base == nil {
+		var err error
+		if base, err = s.simpleByName(st.base); err != nil {
+			return err
+		}
+	}
+	if err := s.checkSimple(base, value); err != nil {
+		return err
+	}
+	return st.facets.check(value)
+}
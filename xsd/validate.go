@@ -0,0 +1,507 @@
+package xsd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxViolations caps the violations reported for one document.
+const maxViolations = 50
+
+// Violation is one way a document breaks its schema.
+type Violation struct {
+	Path    string // element path, e.g. /Envelope/Body/PRPA_IN201305UV02/sender
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Path + ": " + v.Message
+}
+
+// anyType stands for xs:anyType and for elements declared without a type.
+var anyType = &typeDef{done: true}
+
+// Validate checks the document element of doc against its global declaration and
+// returns the violations found. The error is set when doc is not well-formed.
+func (s *Schema) Validate(doc []byte) ([]Violation, error) {
+	root, err := parseTree(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &validator{s: s}
+	path := "/" + root.name.Local
+	if decl, ok := s.elements[root.name]; ok {
+		v.element(root, decl, path)
+	} else {
+		v.add(path, "no declaration for element %s", display(root.name))
+	}
+	return v.violations, nil
+}
+
+type validator struct {
+	s          *Schema
+	violations []Violation
+}
+
+func (v *validator) add(path, format string, args ...any) {
+	if len(v.violations) < maxViolations {
+		v.violations = append(v.violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+func (v *validator) element(n *node, decl *element, path string) {
+	t := decl.typ
+	if t == nil {
+		t = v.s.typeByName(decl.typeName)
+	}
+	if t == nil {
+		v.add(path, "schema has no type %s", decl.typeName.Local)
+		return
+	}
+	v.typed(n, t, path)
+	if decl.fixed != nil && strings.TrimSpace(n.text.String()) != *decl.fixed {
+		v.add(path, "value must be %q", *decl.fixed)
+	}
+}
+
+// typeByName returns the named type, anyType for zero or xs:anyType, or nil.
+func (s *Schema) typeByName(qn xml.Name) *typeDef {
+	switch {
+	case qn == xml.Name{} || qn == xml.Name{Space: nsXS, Local: "anyType"}:
+		return anyType
+	case qn.Space == nsXS:
+		return &typeDef{simple: &simpleType{builtin: qn.Local}, done: true}
+	}
+	return s.types[qn]
+}
+
+// complete merges the content and attributes of t's base type into t.
+func (s *Schema) complete(t *typeDef) {
+	if t.done {
+		return
+	}
+	t.done = true
+
+	base := s.typeByName(t.base)
+	if base == nil || base == anyType {
+		return
+	}
+	s.complete(base)
+	if t.simple != nil && base.simple != nil && base.content == nil && t.simple.base == t.base {
+		// simple content derived from a complex type with simple content
+		t.simple.baseType = base.simple
+	}
+	if base.attrs != nil {
+		t.attrs.groups = append(t.attrs.groups, base.attrs.groups...)
+		for _, use := range base.attrs.uses {
+			if !hasAttr(t.attrs.uses, use) {
+				t.attrs.uses = append(t.attrs.uses, use)
+			}
+		}
+		if t.attrs.wildcard == nil {
+			t.attrs.wildcard = base.attrs.wildcard
+		}
+	}
+	if t.extend {
+		t.mixed = t.mixed || base.mixed
+		switch {
+		case base.content == nil:
+		case t.content == nil:
+			t.content = base.content
+		default:
+			t.content = &particle{kind: "sequence", min: 1, max: 1, children: []*particle{base.content, t.content}}
+		}
+	}
+}
+
+func hasAttr(uses []*attribute, a *attribute) bool {
+	for _, u := range uses {
+		if u.name == a.name && u.ref == a.ref {
+			return true
+		}
+	}
+	return false
+}
+
+// attrUses flattens the attribute uses of set, resolving references and groups.
+func (s *Schema) attrUses(set *attrSet) ([]*attribute, *wildcard) {
+	if set == nil {
+		return nil, nil
+	}
+	var uses []*attribute
+	for _, a := range set.uses {
+		if a.ref != (xml.Name{}) {
+			if global, ok := s.attributes[a.ref]; ok {
+				resolved := *global
+				resolved.required = a.required
+				a = &resolved
+			} else {
+				a = &attribute{name: a.ref, required: a.required}
+			}
+		}
+		uses = append(uses, a)
+	}
+	wildcard := set.wildcard
+	for _, g := range set.groups {
+		groupUses, groupWildcard := s.attrUses(s.attrGroups[g])
+		uses = append(uses, groupUses...)
+		if wildcard == nil {
+			wildcard = groupWildcard
+		}
+	}
+	return uses, wildcard
+}
+
+// typed validates the attributes and content of n against t.
+func (v *validator) typed(n *node, t *typeDef, path string) {
+	if t == anyType {
+		v.lax(n, path)
+		return
+	}
+	v.s.complete(t)
+	v.attributes(n, t, path)
+
+	if t.simple != nil && t.content == nil {
+		if len(n.children) > 0 {
+			v.add(path, "element %s is not allowed; only text content is", n.children[0].name.Local)
+			return
+		}
+		if err := v.s.checkSimple(t.simple, n.text.String()); err != nil {
+			v.add(path, "value %q %v", strings.TrimSpace(n.text.String()), err)
+		}
+		return
+	}
+
+	if !t.mixed && strings.TrimSpace(n.text.String()) != "" {
+		v.add(path, "text content %q is not allowed", abbreviate(strings.TrimSpace(n.text.String())))
+	}
+	m := &matcher{v: v, kids: n.children, paths: childPaths(n, path), parent: n, path: path, reported: -1}
+	if t.content == nil {
+		if len(n.children) > 0 {
+			v.add(m.paths[0], "element %s is not allowed here", m.name(0))
+		}
+		return
+	}
+
+	i, ok := m.repeat(t.content, 0)
+	switch {
+	case !ok:
+		m.missing(t.content, i)
+	case i < len(n.children) && i != m.reported:
+		v.add(m.paths[i], "unexpected element %s", m.name(i))
+	}
+}
+
+func (v *validator) attributes(n *node, t *typeDef, path string) {
+	uses, wildcard := v.s.attrUses(t.attrs)
+	seen := map[xml.Name]bool{}
+	for _, a := range n.attrs {
+		if a.Name.Space == nsXSI {
+			continue
+		}
+		seen[a.Name] = true
+
+		var use *attribute
+		for _, u := range uses {
+			if u.name == a.Name {
+				use = u
+				break
+			}
+		}
+		if use == nil {
+			if wildcard == nil || !wildcard.allows(a.Name.Space) {
+				v.add(path, "attribute %s is not allowed", display(a.Name))
+			} else if global, ok := v.s.attributes[a.Name]; ok && wildcard.process != "skip" {
+				v.attribute(global, a.Value, path)
+			} else if wildcard.process == "strict" {
+				v.add(path, "no declaration for attribute %s", display(a.Name))
+			}
+			continue
+		}
+		v.attribute(use, a.Value, path)
+	}
+	for _, u := range uses {
+		if u.required && !seen[u.name] {
+			v.add(path, "missing required attribute %s", display(u.name))
+		}
+	}
+}
+
+func (v *validator) attribute(a *attribute, value, path string) {
+	st := a.typ
+	if st == nil && a.typeName != (xml.Name{}) {
+		var err error
+		if st, err = v.s.simpleByName(a.typeName); err != nil {
+			v.add(path, "attribute %s: %v", a.name.Local, err)
+			return
+		}
+	}
+	if st != nil {
+		if err := v.s.checkSimple(st, value); err != nil {
+			v.add(path, "attribute %s value %q %v", a.name.Local, value, err)
+			return
+		}
+	}
+	if a.fixed != nil && strings.TrimSpace(value) != *a.fixed {
+		v.add(path, "attribute %s must be %q, got %q", a.name.Local, *a.fixed, value)
+	}
+}
+
+// lax validates the children of n that have a global declaration.
+func (v *validator) lax(n *node, path string) {
+	paths := childPaths(n, path)
+	for i, c := range n.children {
+		p := paths[i]
+		if decl, ok := v.s.elements[c.name]; ok {
+			v.element(c, decl, p)
+		} else {
+			v.lax(c, p)
+		}
+	}
+}
+
+// matcher matches the child elements of one element against a content model.
+// Schemas satisfy the Unique Particle Attribution constraint, so particles take
+// as many elements as they can.
+type matcher struct {
+	v      *validator
+	kids   []*node
+	paths  []string
+	parent *node
+	path   string
+
+	reported int // index of the last child reported as unexpected, or -1
+}
+
+// repeat matches p between p.min and p.max times from kids[i], returning the index
+// after the last element taken and whether p.min was reached.
+func (m *matcher) repeat(p *particle, i int) (int, bool) {
+	count := 0
+	for p.max == -1 || count < p.max {
+		j, ok := m.once(p, i)
+		if !ok {
+			break
+		}
+		count++
+		if j == i {
+			break
+		}
+		i = j
+	}
+	return i, count >= p.min || m.emptiable(p)
+}
+
+// once matches one occurrence of p from kids[i].
+func (m *matcher) once(p *particle, i int) (int, bool) {
+	switch p.kind {
+	case "element":
+		decl, name := m.decl(p)
+		if i >= len(m.kids) || m.kids[i].name != name {
+			return i, false
+		}
+		if decl != nil {
+			m.v.element(m.kids[i], decl, m.paths[i])
+		}
+		return i + 1, true
+	case "any":
+		if i >= len(m.kids) || !p.any.allows(m.kids[i].name.Space) {
+			return i, false
+		}
+		kid := m.kids[i]
+		decl, declared := m.v.s.elements[kid.name]
+		switch {
+		case p.any.process == "skip":
+		case declared:
+			m.v.element(kid, decl, m.paths[i])
+		case p.any.process == "strict":
+			m.v.add(m.paths[i], "no declaration for element %s", display(kid.name))
+		default:
+			m.v.lax(kid, m.paths[i])
+		}
+		return i + 1, true
+	case "group":
+		group, ok := m.v.s.groups[p.ref]
+		if !ok {
+			m.v.add(m.path, "schema has no group %s", p.ref.Local)
+			return i, false
+		}
+		return m.repeat(group, i)
+	case "sequence":
+		start := i
+		for _, c := range p.children {
+			j, ok := m.repeat(c, i)
+			if !ok {
+				if i == start {
+					return start, false
+				}
+				m.missing(c, i)
+			}
+			i = j
+		}
+		return i, true
+	case "choice":
+		matched := false
+		for _, c := range p.children {
+			if j, ok := m.repeat(c, i); ok {
+				if j > i {
+					return j, true
+				}
+				matched = true
+			}
+		}
+		return i, matched
+	case "all":
+		start := i
+		taken := make([]bool, len(p.children))
+		for progress := true; progress; {
+			progress = false
+			for k, c := range p.children {
+				if taken[k] {
+					continue
+				}
+				if j, ok := m.once(c, i); ok && j > i {
+					taken[k], i, progress = true, j, true
+				}
+			}
+		}
+		for k, c := range p.children {
+			if !taken[k] && c.min > 0 {
+				if i == start {
+					return start, false
+				}
+				m.missing(c, i)
+			}
+		}
+		return i, true
+	}
+	return i, false
+}
+
+// missing reports that c could not be matched at kids[i].
+func (m *matcher) missing(c *particle, i int) {
+	if i < len(m.kids) {
+		if i != m.reported {
+			m.v.add(m.paths[i], "unexpected element %s, expected %s", m.name(i), m.expected(c))
+			m.reported = i
+		}
+		return
+	}
+	m.v.add(m.path, "missing element %s", m.expected(c))
+}
+
+// name names kids[i], with its namespace when that differs from the parent's.
+func (m *matcher) name(i int) string {
+	if m.kids[i].name.Space == m.parent.name.Space {
+		return m.kids[i].name.Local
+	}
+	return display(m.kids[i].name)
+}
+
+// decl returns the declaration and name of an element particle.
+func (m *matcher) decl(p *particle) (*element, xml.Name) {
+	if p.elem != nil {
+		return p.elem, p.elem.name
+	}
+	return m.v.s.elements[p.ref], p.ref
+}
+
+// emptiable reports whether p can match no elements at all.
+func (m *matcher) emptiable(p *particle) bool {
+	if p.min == 0 {
+		return true
+	}
+	switch p.kind {
+	case "sequence", "all":
+		for _, c := range p.children {
+			if !m.emptiable(c) {
+				return false
+			}
+		}
+		return true
+	case "choice":
+		for _, c := range p.children {
+			if m.emptiable(c) {
+				return true
+			}
+		}
+	case "group":
+		if g, ok := m.v.s.groups[p.ref]; ok {
+			return m.emptiable(g)
+		}
+	}
+	return false
+}
+
+// expected describes the elements p can start with.
+func (m *matcher) expected(p *particle) string {
+	names := m.first(p, nil)
+	if len(names) == 0 {
+		return "more content"
+	}
+	if len(names) > 5 {
+		names = append(names[:5], "…")
+	}
+	return strings.Join(names, " or ")
+}
+
+func (m *matcher) first(p *particle, names []string) []string {
+	switch p.kind {
+	case "element":
+		_, name := m.decl(p)
+		return append(names, name.Local)
+	case "any":
+		return append(names, "any element")
+	case "group":
+		if g, ok := m.v.s.groups[p.ref]; ok {
+			return m.first(g, names)
+		}
+	case "sequence":
+		for _, c := range p.children {
+			names = m.first(c, names)
+			if !m.emptiable(c) {
+				break
+			}
+		}
+	case "choice", "all":
+		for _, c := range p.children {
+			names = m.first(c, names)
+		}
+	}
+	return names
+}
+
+// childPaths returns the path of each child of n, indexing repeated names.
+func childPaths(n *node, path string) []string {
+	total := map[string]int{}
+	for _, c := range n.children {
+		total[c.name.Local]++
+	}
+	seen := map[string]int{}
+	paths := make([]string, len(n.children))
+	for i, c := range n.children {
+		seen[c.name.Local]++
+		paths[i] = path + "/" + c.name.Local
+		if total[c.name.Local] > 1 {
+			paths[i] += "[" + strconv.Itoa(seen[c.name.Local]) + "]"
+		}
+	}
+	return paths
+}
+
+// display names an element or attribute, with its namespace when it has one.
+func display(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return "{" + name.Space + "}" + name.Local
+}
+
+func abbreviate(s string) string {
+	if r := []rune(s); len(r) > 40 {
+		return string(r[:40]) + "…"
+	}
+	return s
+}
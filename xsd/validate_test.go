@@ -0,0 +1,389 @@
+package xsd_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"mitz-replicator/xsd"
+)
+
+// testSchemas exercises the supported constructs: an include and an import, global
+// and local elements, element and group references, sequence, choice and all with
+// occurrence bounds, element and attribute wildcards, attribute groups, simple and
+// complex content derivation, and simple types with facets, lists and unions.
+var testSchemas = fstest.MapFS{
+	"order.xsd": {Data: []byte(`<?xml version="1.0"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:t="urn:test" xmlns:o="urn:other"
+           targetNamespace="urn:test" elementFormDefault="qualified">
+  <xs:include schemaLocation="types.xsd"/>
+  <xs:import namespace="urn:other" schemaLocation="other/other.xsd"/>
+
+  <xs:element name="order" type="t:Order"/>
+
+  <xs:complexType name="Order">
+    <xs:sequence>
+      <xs:element name="id" type="t:OrderID"/>
+      <xs:element name="note" type="xs:string" minOccurs="0" maxOccurs="2"/>
+      <xs:choice>
+        <xs:element name="pickup" type="t:Empty"/>
+        <xs:element name="delivery" type="t:DutchAddress"/>
+      </xs:choice>
+      <xs:group ref="t:lines"/>
+      <xs:element name="meta" minOccurs="0">
+        <xs:complexType>
+          <xs:all>
+            <xs:element name="a" type="xs:int"/>
+            <xs:element name="b" type="xs:boolean" minOccurs="0"/>
+          </xs:all>
+        </xs:complexType>
+      </xs:element>
+      <xs:element ref="o:ext" minOccurs="0"/>
+      <xs:any namespace="##other" processContents="lax" minOccurs="0" maxOccurs="unbounded"/>
+    </xs:sequence>
+    <xs:attributeGroup ref="t:versioned"/>
+    <xs:attribute name="status" type="t:Status" use="required"/>
+    <xs:attribute name="tags" type="t:StatusList"/>
+    <xs:attribute name="priority" type="t:Priority"/>
+    <xs:attribute name="created" type="xs:dateTime"/>
+    <xs:anyAttribute namespace="##other" processContents="skip"/>
+  </xs:complexType>
+
+  <xs:group name="lines">
+    <xs:sequence>
+      <xs:element name="line" type="t:Line" maxOccurs="unbounded"/>
+    </xs:sequence>
+  </xs:group>
+
+  <xs:attributeGroup name="versioned">
+    <xs:attribute name="version" type="xs:string" fixed="1.0"/>
+  </xs:attributeGroup>
+
+  <xs:complexType name="Empty"/>
+
+  <xs:complexType name="Address">
+    <xs:sequence>
+      <xs:element name="city" type="xs:string"/>
+    </xs:sequence>
+  </xs:complexType>
+
+  <xs:complexType name="DutchAddress">
+    <xs:complexContent>
+      <xs:extension base="t:Address">
+        <xs:sequence>
+          <xs:element name="postcode" type="t:Postcode"/>
+        </xs:sequence>
+      </xs:extension>
+    </xs:complexContent>
+  </xs:complexType>
+
+  <xs:complexType name="Line">
+    <xs:simpleContent>
+      <xs:extension base="xs:positiveInteger">
+        <xs:attribute name="sku" type="t:Sku" use="required"/>
+      </xs:extension>
+    </xs:simpleContent>
+  </xs:complexType>
+</xs:schema>`)},
+	"types.xsd": {Data: []byte(`<?xml version="1.0"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:t="urn:test" targetNamespace="urn:test">
+  <xs:simpleType name="OrderID">
+    <xs:restriction base="xs:string">
+      <xs:pattern value="[A-Z]{2}\d{4}"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:simpleType name="Status">
+    <xs:restriction base="xs:token">
+      <xs:enumeration value="open"/>
+      <xs:enumeration value="closed"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:simpleType name="StatusList">
+    <xs:list itemType="t:Status"/>
+  </xs:simpleType>
+  <xs:simpleType name="Priority">
+    <xs:union memberTypes="xs:int t:Level"/>
+  </xs:simpleType>
+  <xs:simpleType name="Level">
+    <xs:restriction base="xs:string">
+      <xs:enumeration value="high"/>
+      <xs:enumeration value="low"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:simpleType name="Postcode">
+    <xs:restriction base="xs:string">
+      <xs:length value="6"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:simpleType name="Sku">
+    <xs:restriction base="xs:token">
+      <xs:minLength value="3"/>
+      <xs:maxLength value="5"/>
+    </xs:restriction>
+  </xs:simpleType>
+</xs:schema>`)},
+	"other/other.xsd": {Data: []byte(`<?xml version="1.0"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:other">
+  <xs:element name="ext" type="xs:string"/>
+  <xs:element name="count" type="xs:int"/>
+</xs:schema>`)},
+}
+
+// Parts of a valid order; cases replace one of them.
+const (
+	orderAttrs    = `status="open" version="1.0"`
+	orderID       = `<id>AB1234</id>`
+	orderDelivery = `<delivery><city>Utrecht</city><postcode>1234AB</postcode></delivery>`
+	orderLine     = `<line sku="abc">2</line>`
+)
+
+// order returns an order document with the given attributes and content.
+func order(attrs string, content ...string) string {
+	return `<order xmlns="urn:test" xmlns:o="urn:other" xmlns:x="urn:x" ` + attrs + `>` + strings.Join(content, "") + `</order>`
+}
+
+func TestValidate(t *testing.T) {
+	s := xsd.New()
+	if err := s.Load(testSchemas, "order.xsd"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			name: "valid",
+			doc:  order(orderAttrs, orderID, orderDelivery, orderLine),
+		},
+		{
+			name: "valid with every optional part",
+			doc: order(orderAttrs+` tags="open closed" priority="high" created="2026-03-01T12:00:00Z" x:extra="skipped"`,
+				orderID, `<note>one</note><note>two</note>`, orderDelivery, orderLine, `<line sku=" abcde ">1</line>`,
+				`<meta><b>true</b><a>5</a></meta>`, `<o:ext>x</o:ext>`, `<o:count>3</o:count>`, `<x:free><anything/></x:free>`),
+		},
+		{
+			name: "choice takes the other branch",
+			doc:  order(orderAttrs, orderID, `<pickup/>`, orderLine),
+		},
+		{
+			name: "missing required attribute",
+			doc:  order(`version="1.0"`, orderID, orderDelivery, orderLine),
+			want: []string{"/order: missing required attribute status"},
+		},
+		{
+			name: "enumeration",
+			doc:  order(`status="pending"`, orderID, orderDelivery, orderLine),
+			want: []string{`/order: attribute status value "pending" must be one of open, closed`},
+		},
+		{
+			name: "fixed attribute from an attribute group",
+			doc:  order(`status="open" version="2.0"`, orderID, orderDelivery, orderLine),
+			want: []string{`/order: attribute version must be "1.0", got "2.0"`},
+		},
+		{
+			name: "undeclared attribute",
+			doc:  order(orderAttrs+` colour="red"`, orderID, orderDelivery, orderLine),
+			want: []string{"/order: attribute colour is not allowed"},
+		},
+		{
+			name: "list item",
+			doc:  order(orderAttrs+` tags="open bogus"`, orderID, orderDelivery, orderLine),
+			want: []string{`/order: attribute tags value "open bogus" item "bogus" must be one of open, closed`},
+		},
+		{
+			name: "union",
+			doc:  order(orderAttrs+` priority="urgent"`, orderID, orderDelivery, orderLine),
+			want: []string{`/order: attribute priority value "urgent" matches none of the member types`},
+		},
+		{
+			name: "built-in type",
+			doc:  order(orderAttrs+` created="1 March 2026"`, orderID, orderDelivery, orderLine),
+			want: []string{`/order: attribute created value "1 March 2026" is not a valid dateTime`},
+		},
+		{
+			name: "pattern",
+			doc:  order(orderAttrs, `<id>A1</id>`, orderDelivery, orderLine),
+			want: []string{`/order/id: value "A1" does not match the pattern [A-Z]{2}\d{4}`},
+		},
+		{
+			name: "missing first element",
+			doc:  order(orderAttrs, orderDelivery, orderLine),
+			want: []string{"/order/delivery: unexpected element delivery, expected id"},
+		},
+		{
+			name: "elements out of order",
+			doc:  order(orderAttrs, `<note>early</note>`, orderID, orderDelivery, orderLine),
+			want: []string{"/order/note: unexpected element note, expected id"},
+		},
+		{
+			name: "maxOccurs",
+			doc:  order(orderAttrs, orderID, `<note>1</note><note>2</note><note>3</note>`, orderDelivery, orderLine),
+			want: []string{"/order/note[3]: unexpected element note, expected pickup or delivery"},
+		},
+		{
+			name: "choice without a branch",
+			doc:  order(orderAttrs, orderID, orderLine),
+			want: []string{"/order/line: unexpected element line, expected pickup or delivery"},
+		},
+		{
+			name: "group reference",
+			doc:  order(orderAttrs, orderID, orderDelivery),
+			want: []string{"/order: missing element line"},
+		},
+		{
+			name: "complex content extension",
+			doc:  order(orderAttrs, orderID, `<delivery><city>Utrecht</city></delivery>`, orderLine),
+			want: []string{"/order/delivery: missing element postcode"},
+		},
+		{
+			name: "length facet",
+			doc:  order(orderAttrs, orderID, `<delivery><city>Utrecht</city><postcode>1234</postcode></delivery>`, orderLine),
+			want: []string{`/order/delivery/postcode: value "1234" must be 6 characters long`},
+		},
+		{
+			name: "simple content extension",
+			doc:  order(orderAttrs, orderID, orderDelivery, `<line sku="abc">0</line>`, `<line>1</line>`, `<line sku="abcdef">1</line>`),
+			want: []string{
+				`/order/line[1]: value "0" must be positive`,
+				"/order/line[2]: missing required attribute sku",
+				`/order/line[3]: attribute sku value "abcdef" must be at most 5 characters long`,
+			},
+		},
+		{
+			name: "element in simple content",
+			doc:  order(orderAttrs, orderID, orderDelivery, `<line sku="abc"><qty/></line>`),
+			want: []string{"/order/line: element qty is not allowed; only text content is"},
+		},
+		{
+			name: "all group",
+			doc:  order(orderAttrs, orderID, orderDelivery, orderLine, `<meta><b>maybe</b></meta>`),
+			want: []string{
+				`/order/meta/b: value "maybe" must be true, false, 1 or 0`,
+				"/order/meta: missing element a",
+			},
+		},
+		{
+			name: "lax wildcard validates declared elements",
+			doc:  order(orderAttrs, orderID, orderDelivery, orderLine, `<o:count>many</o:count>`),
+			want: []string{`/order/count: value "many" is not a valid int`},
+		},
+		{
+			name: "wildcard excludes the target namespace",
+			doc:  order(orderAttrs, orderID, orderDelivery, orderLine, `<extra/>`),
+			want: []string{"/order/extra: unexpected element extra"},
+		},
+		{
+			name: "empty content",
+			doc:  order(orderAttrs, orderID, `<pickup>now<when/></pickup>`, orderLine),
+			want: []string{
+				`/order/pickup: text content "now" is not allowed`,
+				"/order/pickup/when: element when is not allowed here",
+			},
+		},
+		{
+			name: "undeclared document element",
+			doc:  `<invoice xmlns="urn:test"/>`,
+			want: []string{"/invoice: no declaration for element {urn:test}invoice"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := s.Validate([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, v := range violations {
+				got = append(got, v.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("violations:\ngot  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateNotWellFormed(t *testing.T) {
+	s := xsd.New()
+	if err := s.Load(testSchemas, "order.xsd"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Validate([]byte(`<order xmlns="urn:test">`)); err == nil {
+		t.Error("Validate accepted a document that is not well-formed")
+	}
+}
+
+func TestValidateViolationLimit(t *testing.T) {
+	s := xsd.New()
+	if err := s.Load(testSchemas, "order.xsd"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Repeat(`<line sku="x">0</line>`, 40)
+	violations, err := s.Validate([]byte(order(orderAttrs, orderID, orderDelivery, lines)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 50 {
+		t.Errorf("got %d violations, want the first 50 of 80", len(violations))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	schema := func(content string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:test">` + content + `</xs:schema>`)}
+	}
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string // error substring; "" for success
+	}{
+		{
+			name: "include and import",
+			fsys: testSchemas,
+		},
+		{
+			name: "missing file",
+			fsys: fstest.MapFS{},
+			want: "file does not exist",
+		},
+		{
+			name: "missing include",
+			fsys: fstest.MapFS{"order.xsd": schema(`<xs:include schemaLocation="gone.xsd"/>`)},
+			want: "gone.xsd",
+		},
+		{
+			name: "not a schema",
+			fsys: fstest.MapFS{"order.xsd": {Data: []byte(`<schema/>`)}},
+			want: "order.xsd: document element is not xs:schema",
+		},
+		{
+			name: "unsupported top-level construct",
+			fsys: fstest.MapFS{"order.xsd": schema(`<xs:assert test="true()"/>`)},
+			want: "unsupported top-level xs:assert",
+		},
+		{
+			name: "unsupported facet",
+			fsys: fstest.MapFS{"order.xsd": schema(`<xs:simpleType name="T"><xs:restriction base="xs:string"><xs:assertion test="true()"/></xs:restriction></xs:simpleType>`)},
+			want: "unsupported facet xs:assertion",
+		},
+		{
+			name: "undeclared prefix",
+			fsys: fstest.MapFS{"order.xsd": schema(`<xs:element name="e" type="u:T"/>`)},
+			want: `undeclared prefix "u"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := xsd.New().Load(tt.fsys, "order.xsd")
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("Load: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Fatalf("Load error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}